@@ -0,0 +1,208 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// maxRequestSize bounds the body of a request, source code included
+const maxRequestSize = 10 << 20 // 10 MiB
+
+// transformRequest is the body of POST /transform
+type transformRequest struct {
+	Source     string `json:"source"`
+	Provider   string `json:"provider"`
+	Capability string `json:"capability,omitempty"` // Checked to have rules loaded for the provider
+	Language   string `json:"language,omitempty"`   // Default: detected from the source
+}
+
+// analyzeRequest is the body of POST /analyze
+type analyzeRequest struct {
+	Source string `json:"source"`
+}
+
+// analyzeResponse lists the Infrar calls and imports found in a source
+type analyzeResponse struct {
+	Imports []types.Import     `json:"imports"`
+	Calls   []types.InfrarCall `json:"calls"`
+}
+
+// ruleInfo is the GET /rules view of a loaded transformation rule
+type ruleInfo struct {
+	Capability   string              `json:"capability"`
+	Pattern      string              `json:"pattern"`
+	Aliases      []string            `json:"aliases,omitempty"`
+	Name         string              `json:"name"`
+	Language     types.Language      `json:"language"`
+	Provider     types.Provider      `json:"provider"`
+	Service      string              `json:"service"`
+	Parameters   []string            `json:"parameters"`
+	Requirements []types.Requirement `json:"requirements,omitempty"`
+}
+
+// errorResponse is the body of every failed request. A transform whose
+// output failed validation carries the result alongside the error.
+type errorResponse struct {
+	Error  *types.TransformationError  `json:"error"`
+	Result *types.TransformationResult `json:"result,omitempty"`
+}
+
+// server exposes one engine, with its rules preloaded, over HTTP
+type server struct {
+	eng *engine.Engine
+}
+
+// newHandler returns the HTTP routes serving eng
+func newHandler(eng *engine.Engine) http.Handler {
+	s := &server{eng: eng}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /transform", s.handleTransform)
+	mux.HandleFunc("POST /analyze", s.handleAnalyze)
+	mux.HandleFunc("GET /rules", s.handleRules)
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	return mux
+}
+
+// handleTransform transforms the posted source for a provider and responds
+// with the TransformationResult
+func (s *server) handleTransform(w http.ResponseWriter, r *http.Request) {
+	var req transformRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	provider, err := types.ParseProvider(req.Provider)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err, nil)
+		return
+	}
+	if req.Capability != "" && len(s.eng.SupportedOperations(provider)[req.Capability]) == 0 {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("no %s rules loaded for %s", req.Capability, provider), nil)
+		return
+	}
+
+	language := s.eng.DetectLanguage(req.Source)
+	if req.Language != "" {
+		if language, err = types.ParseLanguage(req.Language); err != nil {
+			writeError(w, http.StatusBadRequest, err, nil)
+			return
+		}
+	}
+
+	result, err := s.eng.TransformLanguage(r.Context(), req.Source, language, provider)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err, result)
+		return
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// handleAnalyze reports the Infrar calls and imports in the posted source
+// without transforming it
+func (s *server) handleAnalyze(w http.ResponseWriter, r *http.Request) {
+	var req analyzeRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+
+	ast, calls, err := s.eng.Analyze(req.Source)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, err, nil)
+		return
+	}
+	writeJSON(w, http.StatusOK, analyzeResponse{Imports: ast.Imports, Calls: calls})
+}
+
+// handleRules lists the loaded rules, optionally filtered by the provider
+// and capability query parameters
+func (s *server) handleRules(w http.ResponseWriter, r *http.Request) {
+	registry := s.eng.GetRegistry()
+	if name := r.URL.Query().Get("provider"); name != "" {
+		provider, err := types.ParseProvider(name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err, nil)
+			return
+		}
+		registry = registry.ForProvider(provider)
+	}
+	capability := r.URL.Query().Get("capability")
+
+	infos := []ruleInfo{}
+	for _, rule := range registry.AllRules() {
+		if capability != "" && rule.Capability != capability {
+			continue
+		}
+
+		params := make([]string, 0, len(rule.ParameterMapping))
+		for param := range rule.ParameterMapping {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+
+		infos = append(infos, ruleInfo{
+			Capability:   rule.Capability,
+			Pattern:      rule.Pattern,
+			Aliases:      rule.Aliases,
+			Name:         rule.Name,
+			Language:     rule.Language,
+			Provider:     rule.Provider,
+			Service:      rule.Service,
+			Parameters:   params,
+			Requirements: rule.Requirements,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		a, b := infos[i], infos[j]
+		if a.Provider != b.Provider {
+			return a.Provider < b.Provider
+		}
+		if a.Capability != b.Capability {
+			return a.Capability < b.Capability
+		}
+		if a.Pattern != b.Pattern {
+			return a.Pattern < b.Pattern
+		}
+		return a.Language < b.Language
+	})
+
+	writeJSON(w, http.StatusOK, infos)
+}
+
+// decodeRequest reads a JSON request body into v, responding with an error
+// and reporting false when it cannot
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any) bool {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err), nil)
+		return false
+	}
+	return true
+}
+
+// writeError responds with err as a TransformationError, keeping the
+// category, line and suggestion of one raised by the pipeline
+func writeError(w http.ResponseWriter, status int, err error, result *types.TransformationResult) {
+	var terr *types.TransformationError
+	if !errors.As(err, &terr) {
+		terr = &types.TransformationError{Message: err.Error()}
+	}
+	writeJSON(w, status, errorResponse{Error: terr, Result: result})
+}
+
+// writeJSON responds with v encoded as JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
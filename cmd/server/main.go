@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// shutdownTimeout bounds how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	os.Exit(run(ctx, os.Args[1:], os.Stderr))
+}
+
+// run starts the server and blocks until ctx is done, returning the process
+// exit code
+func run(ctx context.Context, args []string, stderr io.Writer) int {
+	flags := flag.NewFlagSet("server", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	addr := flags.String("addr", ":8080", "Address to listen on")
+	pluginDir := flags.String("plugins", "../infrar-plugins/packages", "Path to plugins directory")
+	python := flags.String("python", "", "Python interpreter to use (default: $INFRAR_PYTHON, the active virtualenv, then python3 or python on PATH)")
+	noValidate := flags.Bool("no-validate", false, "Skip the syntax check of generated code")
+
+	if err := flags.Parse(args); err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+		}
+		return 2
+	}
+
+	eng, err := engine.NewWithOptions(
+		engine.WithPythonPath(*python),
+		engine.WithValidation(!*noValidate),
+	)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to create engine: %v\n", err)
+		return 1
+	}
+
+	// Every request is served from the same preloaded rules
+	for _, provider := range types.Providers() {
+		if err := eng.LoadAllRules(*pluginDir, provider); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	ln, err := net.Listen("tcp", *addr)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "Listening on %s (plugins: %s)\n", ln.Addr(), *pluginDir)
+	if err := serve(ctx, ln, newHandler(eng)); err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// serve serves handler on ln until ctx is done, then shuts down gracefully,
+// letting in-flight requests finish
+func serve(ctx context.Context, ln net.Listener, handler http.Handler) error {
+	srv := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	shutdown := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		shutdown <- srv.Shutdown(shutdownCtx)
+	}()
+
+	if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return <-shutdown
+}
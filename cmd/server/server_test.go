@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+const testPluginDir = "../../test-plugins"
+
+const testSource = `from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`
+
+// newTestServer serves an engine with the test plugins loaded for every provider
+func newTestServer(t *testing.T) *httptest.Server {
+	t.Helper()
+
+	eng, err := engine.New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for _, provider := range types.Providers() {
+		if err := eng.LoadAllRules(testPluginDir, provider); err != nil {
+			t.Fatalf("Failed to load rules: %v", err)
+		}
+	}
+
+	srv := httptest.NewServer(newHandler(eng))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// post sends body as JSON to path and decodes the response into v
+func post(t *testing.T, srv *httptest.Server, path string, body, v any) int {
+	t.Helper()
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		t.Fatalf("Failed to encode request: %v", err)
+	}
+	resp, err := http.Post(srv.URL+path, "application/json", bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("POST %s failed: %v", path, err)
+	}
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+func TestServer_Transform(t *testing.T) {
+	srv := newTestServer(t)
+
+	for _, tt := range []struct {
+		provider string
+		want     string
+	}{
+		{"aws", "s3.upload_file"},
+		{"gcp", "storage_client.bucket('data')"},
+	} {
+		var result types.TransformationResult
+		status := post(t, srv, "/transform", transformRequest{Source: testSource, Provider: tt.provider, Capability: "storage"}, &result)
+		if status != http.StatusOK {
+			t.Fatalf("POST /transform status = %d, want 200", status)
+		}
+		if !strings.Contains(result.TransformedCode, tt.want) {
+			t.Errorf("%s TransformedCode =\n%s\nwant it to contain %s", tt.provider, result.TransformedCode, tt.want)
+		}
+		if result.Provider != types.Provider(tt.provider) {
+			t.Errorf("Provider = %q, want %q", result.Provider, tt.provider)
+		}
+	}
+
+	var resp errorResponse
+	if status := post(t, srv, "/transform", transformRequest{Source: testSource, Provider: "ibm"}, &resp); status != http.StatusBadRequest {
+		t.Errorf("POST /transform status = %d, want 400 for an unknown provider", status)
+	}
+	if status := post(t, srv, "/transform", transformRequest{Source: testSource, Provider: "aws", Capability: "queue"}, &resp); status != http.StatusBadRequest {
+		t.Errorf("POST /transform status = %d, want 400 for a capability without rules", status)
+	}
+
+	resp = errorResponse{}
+	source := "from infrar.storage import upload\n\nupload(bucket='data')\n"
+	if status := post(t, srv, "/transform", transformRequest{Source: source, Provider: "aws"}, &resp); status != http.StatusUnprocessableEntity {
+		t.Errorf("POST /transform status = %d, want 422 for a call missing parameters", status)
+	}
+	if resp.Error == nil || resp.Error.Line != 3 || resp.Error.Category != types.ErrorCategoryTransformation {
+		t.Errorf("error = %+v, want a transformation error on line 3", resp.Error)
+	}
+}
+
+func TestServer_AnalyzeAndRules(t *testing.T) {
+	srv := newTestServer(t)
+
+	var analysis analyzeResponse
+	if status := post(t, srv, "/analyze", analyzeRequest{Source: testSource}, &analysis); status != http.StatusOK {
+		t.Fatalf("POST /analyze status = %d, want 200", status)
+	}
+	if len(analysis.Calls) != 1 || analysis.Calls[0].FullName() != "infrar.storage.upload" {
+		t.Errorf("calls = %+v, want the upload call", analysis.Calls)
+	}
+
+	resp, err := http.Get(srv.URL + "/rules?provider=gcp&capability=storage")
+	if err != nil {
+		t.Fatalf("GET /rules failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var rules []ruleInfo
+	if err := json.NewDecoder(resp.Body).Decode(&rules); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(rules) == 0 {
+		t.Fatal("GET /rules returned no rules")
+	}
+	for _, rule := range rules {
+		if rule.Provider != types.ProviderGCP || rule.Capability != "storage" {
+			t.Errorf("rule %s is for %s/%s, want gcp/storage", rule.Pattern, rule.Provider, rule.Capability)
+		}
+	}
+}
+
+func TestServe_GracefulShutdown(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	// A request still running at shutdown is allowed to finish
+	started := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		time.Sleep(50 * time.Millisecond)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- serve(ctx, ln, handler) }()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + ln.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	<-started
+	cancel()
+
+	if err := <-served; err != nil {
+		t.Errorf("serve() error = %v", err)
+	}
+	if got := <-status; got != http.StatusNoContent {
+		t.Errorf("in-flight request status = %d, want 204", got)
+	}
+}
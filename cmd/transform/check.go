@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+)
+
+// runCheck verifies that the -input file or directory (or stdin) has no
+// Infrar SDK calls left, listing the ones it finds per file. Nothing is
+// written. It exits 1 when a call is left or a file cannot be checked.
+func runCheck(eng *engine.Engine, opts *options, stdin io.Reader, stdout, stderr io.Writer) int {
+	files := []string{opts.input}
+	if opts.input != "" && util.DirExists(opts.input) {
+		ignore, err := loadIgnore(opts.input, opts.exclude)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		if files, err = listSourceFiles(opts.input, ignore); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to list files: %v\n", err)
+			return 1
+		}
+	}
+
+	remaining, failed := 0, 0
+	for _, file := range files {
+		var source string
+		var err error
+		name := file
+		switch {
+		case file == "":
+			var data []byte
+			data, err = io.ReadAll(stdin)
+			source, name = string(data), "stdin"
+		case isRemote(file):
+			source, err = fetchSource(context.Background(), file)
+		default:
+			source, err = util.ReadFile(file)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: failed to read input: %v\n", err)
+			failed++
+			continue
+		}
+
+		transformed, calls, err := eng.IsTransformed(source)
+		if err != nil {
+			printError(stderr, "Error: "+name+": ", err)
+			failed++
+			continue
+		}
+		if transformed {
+			continue
+		}
+
+		remaining++
+		for _, call := range calls {
+			fmt.Fprintf(stdout, "%s:%d: %s\n", name, call.LineNumber, call.FullName())
+		}
+	}
+
+	switch {
+	case remaining > 0:
+		fmt.Fprintf(stderr, "✗ %d of %d file(s) still contain Infrar SDK calls\n", remaining, len(files))
+	case failed == 0:
+		fmt.Fprintf(stderr, "✓ %d file(s) have no Infrar SDK calls left\n", len(files))
+	}
+
+	if remaining > 0 || failed > 0 {
+		return 1
+	}
+	return 0
+}
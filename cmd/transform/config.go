@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/QodeSrl/infrar-engine/internal/util"
+	"gopkg.in/yaml.v3"
+)
+
+// configFileNames are the project config files looked up, in order, in each directory
+var configFileNames = []string{"infrar.yaml", ".infrar.yaml"}
+
+// fileConfig is the project configuration read from infrar.yaml. Every field
+// is optional; explicit command line flags always take precedence.
+type fileConfig struct {
+	Plugins      string   `yaml:"plugins"`
+	Provider     string   `yaml:"provider"`
+	Capabilities []string `yaml:"capabilities"`
+	Output       string   `yaml:"output"`
+	Format       string   `yaml:"format"`
+	Diff         bool     `yaml:"diff"`
+}
+
+// findConfig walks up from dir and returns the first config file found
+func findConfig(dir string) (string, bool) {
+	for {
+		for _, name := range configFileNames {
+			path := filepath.Join(dir, name)
+			if util.FileExists(path) {
+				return path, true
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", false
+		}
+		dir = parent
+	}
+}
+
+// loadConfig reads a config file. Relative plugin and output paths are
+// resolved against the config file's directory.
+func loadConfig(path string) (*fileConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	var cfg fileConfig
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	base := filepath.Dir(path)
+	if cfg.Plugins != "" && !filepath.IsAbs(cfg.Plugins) {
+		cfg.Plugins = filepath.Join(base, cfg.Plugins)
+	}
+	if cfg.Output != "" && !filepath.IsAbs(cfg.Output) {
+		cfg.Output = filepath.Join(base, cfg.Output)
+	}
+
+	return &cfg, nil
+}
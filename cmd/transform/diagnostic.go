@@ -0,0 +1,51 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// printError writes err to w after header (such as "Error: "). Transformation errors that carry
+// the offending source line are followed by that line with a caret under the
+// reported column, plus the error's suggestion.
+func printError(w io.Writer, header string, err error) {
+	fmt.Fprintf(w, "%s%v\n", header, err)
+
+	var terr *types.TransformationError
+	if !errors.As(err, &terr) {
+		return
+	}
+
+	if text := strings.TrimRight(terr.SourceCode, "\r\n"); text != "" && terr.Line > 0 {
+		gutter := fmt.Sprintf("%5d | ", terr.Line)
+		fmt.Fprintf(w, "%s%s\n", gutter, text)
+		if terr.Column > 0 {
+			fmt.Fprintf(w, "%s | %s^\n", strings.Repeat(" ", len(gutter)-3), caretPadding(text, terr.Column))
+		}
+	}
+
+	if terr.Suggestion != "" {
+		fmt.Fprintf(w, "  Suggestion: %s\n", terr.Suggestion)
+	}
+}
+
+// caretPadding returns the whitespace that lines a caret up under the
+// 1-based column of text, keeping tabs so the caret aligns in any terminal
+func caretPadding(text string, column int) string {
+	var sb strings.Builder
+	for i, r := range []rune(text) {
+		if i >= column-1 {
+			break
+		}
+		if r == '\t' {
+			sb.WriteRune('\t')
+		} else {
+			sb.WriteRune(' ')
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// runExplain explains how the single call expression given with -explain is
+// transformed. A bare name such as upload(...) is taken from the first
+// -capability, a dotted one such as infrar.storage.upload(...) as written.
+func runExplain(eng *engine.Engine, opts *options, stdout, stderr io.Writer) int {
+	expr := strings.TrimSpace(opts.explain)
+	name, _, ok := strings.Cut(expr, "(")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		fmt.Fprintf(stderr, "Error: -explain needs a call expression, e.g. \"upload(bucket='data', source='a.txt')\"\n")
+		return 2
+	}
+
+	// Import the callee so the detector recognises the call
+	var source string
+	if module, _, dotted := cutLast(name, "."); dotted {
+		source = fmt.Sprintf("import %s\n%s\n", module, expr)
+	} else {
+		source = fmt.Sprintf("from %s.%s import %s\n%s\n", cmp.Or(opts.sdkPrefix, types.SDKPrefix), opts.capabilities[0], name, expr)
+	}
+
+	_, calls, err := eng.Analyze(source)
+	if err != nil {
+		printError(stderr, "Error: ", err)
+		return 1
+	}
+
+	// Nested Infrar calls are detected too; the outer one starts the line
+	for _, call := range calls {
+		if call.LineNumber != 2 || call.ColumnOffset != 0 {
+			continue
+		}
+
+		exp, err := eng.Explain(call, opts.provider)
+		if err != nil {
+			printError(stderr, "Error: ", err)
+			return 1
+		}
+
+		if opts.format == formatJSON {
+			return writeJSON(exp, opts.output, stdout, stderr)
+		}
+
+		var sb strings.Builder
+		fmt.Fprintf(&sb, "%s -> rule %s (%s %s, %s)\n", exp.Pattern, exp.Rule, exp.Provider, exp.Capability, exp.Service)
+		sb.WriteString("parameters:\n")
+		for _, p := range exp.Parameters {
+			value := p.Value
+			switch {
+			case !p.Provided && p.Optional:
+				value = "(optional, not passed)"
+			case !p.Provided:
+				value = "(not passed)"
+			}
+			fmt.Fprintf(&sb, "  %s -> %s = %s\n", p.Name, strings.Join(p.Targets, ", "), value)
+		}
+		statics := make([]string, 0, len(exp.StaticParams))
+		for param := range exp.StaticParams {
+			statics = append(statics, param)
+		}
+		sort.Strings(statics)
+		for _, param := range statics {
+			fmt.Fprintf(&sb, "  %s = %s (static)\n", param, exp.StaticParams[param])
+		}
+		writeSection(&sb, "code", []string{exp.Code})
+		writeSection(&sb, "imports", exp.Imports)
+		if exp.SetupCode != "" {
+			writeSection(&sb, "setup", []string{exp.SetupCode})
+		}
+		reqs := make([]string, 0, len(exp.Requirements))
+		for _, req := range exp.Requirements {
+			reqs = append(reqs, req.Package+req.Version)
+		}
+		writeSection(&sb, "requirements", reqs)
+		writeSection(&sb, "notes", exp.Notes)
+
+		if err := writeOutput(opts.output, sb.String(), stdout); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	fmt.Fprintf(stderr, "Error: %s is not an Infrar call\n", expr)
+	return 1
+}
+
+// writeSection writes a titled, indented list of entries, or nothing when empty
+func writeSection(sb *strings.Builder, title string, entries []string) {
+	if len(entries) == 0 {
+		return
+	}
+	fmt.Fprintf(sb, "%s:\n", title)
+	for _, entry := range entries {
+		for _, line := range strings.Split(entry, "\n") {
+			fmt.Fprintf(sb, "  %s\n", line)
+		}
+	}
+}
+
+// cutLast slices s around the last instance of sep
+func cutLast(s, sep string) (before, after string, found bool) {
+	if i := strings.LastIndex(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
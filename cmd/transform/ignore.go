@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ignoreFileName is the file at the root of a directory input that lists
+// paths to skip, in gitignore syntax
+const ignoreFileName = ".infrarignore"
+
+// ignoreRule is one compiled line of an ignore file
+type ignoreRule struct {
+	re      *regexp.Regexp
+	negate  bool // "!pattern" re-includes a path
+	dirOnly bool // "pattern/" only matches directories
+}
+
+// ignoreList decides which paths of a directory input are skipped. Patterns
+// follow gitignore: the last matching pattern wins, a pattern containing a
+// slash is anchored to the root, and a directory that is ignored is skipped
+// along with everything below it.
+type ignoreList struct {
+	rules []ignoreRule
+}
+
+// loadIgnore reads the .infrarignore file at root, if any, and adds the
+// -exclude patterns after it so they take precedence
+func loadIgnore(root string, exclude []string) (*ignoreList, error) {
+	var patterns []string
+
+	data, err := os.ReadFile(filepath.Join(root, ignoreFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read %s: %w", ignoreFileName, err)
+	}
+	if err == nil {
+		patterns = strings.Split(string(data), "\n")
+	}
+
+	return parseIgnore(append(patterns, exclude...))
+}
+
+// parseIgnore compiles gitignore-style patterns, skipping blank lines and comments
+func parseIgnore(patterns []string) (*ignoreList, error) {
+	list := &ignoreList{}
+	for _, pattern := range patterns {
+		pattern = strings.TrimRight(pattern, " \t\r")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		var rule ignoreRule
+		if strings.HasPrefix(pattern, "!") {
+			rule.negate = true
+			pattern = pattern[1:]
+		}
+		if strings.HasSuffix(pattern, "/") {
+			rule.dirOnly = true
+			pattern = strings.TrimRight(pattern, "/")
+		}
+
+		prefix := "^(?:.*/)?" // Match at any depth
+		if strings.Contains(pattern, "/") {
+			prefix = "^"
+			pattern = strings.TrimPrefix(pattern, "/")
+		}
+
+		re, err := regexp.Compile(prefix + globToRegexp(pattern) + "$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid ignore pattern %q: %w", pattern, err)
+		}
+		rule.re = re
+		list.rules = append(list.rules, rule)
+	}
+	return list, nil
+}
+
+// globToRegexp translates a gitignore glob into a regular expression: *
+// and ? stay within one path segment, ** spans segments
+func globToRegexp(glob string) string {
+	var sb strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[' && strings.IndexByte(glob[i+1:], ']') > 0:
+			end := i + 1 + strings.IndexByte(glob[i+1:], ']')
+			class := glob[i+1 : end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + class + "]")
+			i = end
+		case c == '\\' && i+1 < len(glob):
+			sb.WriteString(regexp.QuoteMeta(glob[i+1 : i+2]))
+			i++
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return sb.String()
+}
+
+// match reports whether the slash-separated path rel is ignored by its own
+// rules, without looking at its parent directories
+func (l *ignoreList) match(rel string, isDir bool) bool {
+	if l == nil {
+		return false
+	}
+
+	ignored := false
+	for _, rule := range l.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.re.MatchString(rel) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Ignored reports whether path, relative to the input root, is skipped
+// either itself or because one of its parent directories is
+func (l *ignoreList) Ignored(rel string, isDir bool) bool {
+	rel = filepath.ToSlash(rel)
+	parts := strings.Split(rel, "/")
+	for i := 1; i < len(parts); i++ {
+		if l.match(strings.Join(parts[:i], "/"), true) {
+			return true
+		}
+	}
+	return l.match(rel, isDir)
+}
+
+// listSourceFiles returns the Python files below root that are not ignored,
+// without descending into ignored directories
+func listSourceFiles(root string, ignore *ignoreList) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel != "." && ignore.match(filepath.ToSlash(rel), d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if !d.IsDir() && filepath.Ext(path) == ".py" {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
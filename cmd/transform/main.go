@@ -0,0 +1,712 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// Output formats supported by the -format flag
+const (
+	formatText = "text"
+	formatJSON = "json"
+)
+
+// options holds the parsed command line settings
+type options struct {
+	provider     types.Provider
+	pluginDir    string
+	capabilities []string
+	input        string
+	output       string
+	format       string
+	inPlace      bool
+	backup       bool
+	diff         bool
+	watch        bool
+	listRules    bool
+	formatCode   bool
+	python       string
+	reportGaps   bool
+	timings      bool
+	noValidate   bool
+	strict       bool
+	noOpCheck    bool
+	shareClients bool
+	keepImports  bool
+	sourceMap    bool
+	check        bool
+	sdkPrefix    string
+	reExports    map[string]string // Re-exported root package function -> capability module
+	style        types.StyleOptions
+	dumpSchema   bool
+	testPlugins  bool
+	lintPlugins  bool
+	explain      string         // Call expression to explain
+	exclude      []string       // Extra ignore patterns for directory input
+	lang         types.Language // Empty to detect from the extension or content
+	patch        string         // File receiving one patch for a directory run
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+// run executes the CLI and returns the process exit code
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	opts, err := parseFlags(args, stderr)
+	if err != nil {
+		if err != flag.ErrHelp {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+		}
+		return 2
+	}
+
+	if opts.dumpSchema {
+		if err := writeOutput(opts.output, string(types.RuleSchema()), stdout); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	eng, err := engine.NewWithOptions(engine.WithPythonPath(opts.python), engine.WithSDKPrefix(opts.sdkPrefix))
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to create engine: %v\n", err)
+		return 1
+	}
+	eng.WithFormatter(opts.formatCode)
+	eng.WithValidation(!opts.noValidate)
+	eng.WithStrict(opts.strict)
+	eng.WithNoOpCheck(opts.noOpCheck)
+	eng.WithSharedClients(opts.shareClients)
+	eng.WithStyle(opts.style)
+	eng.WithImportComments(opts.keepImports)
+	eng.WithSourceMap(opts.sourceMap)
+	eng.WithReExports(opts.reExports)
+	if opts.reportGaps {
+		// Gaps can only be listed if calls without a rule don't abort the run
+		eng.WithLenient(true)
+	}
+
+	if opts.listRules {
+		return runListRules(eng, opts, stdout, stderr)
+	}
+
+	if opts.testPlugins {
+		return runTestPlugins(eng, opts, stdout, stderr)
+	}
+
+	if opts.lintPlugins {
+		return runLintPlugins(eng, opts, stdout, stderr)
+	}
+
+	if opts.check {
+		return runCheck(eng, opts, stdin, stdout, stderr)
+	}
+
+	for _, capability := range opts.capabilities {
+		if err := eng.LoadRules(opts.pluginDir, opts.provider, capability); err != nil {
+			printError(stderr, "Error: ", err)
+			return 1
+		}
+	}
+
+	if opts.explain != "" {
+		return runExplain(eng, opts, stdout, stderr)
+	}
+
+	// Calls marked "# infrar: provider=..." use another provider's rules,
+	// loaded only once a pragma names that provider
+	eng.WithPragmaRules(opts.pluginDir, opts.capabilities)
+
+	if opts.watch {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+		return runWatch(ctx, eng, opts, stdout, stderr)
+	}
+
+	if opts.input != "" && util.DirExists(opts.input) {
+		return runDirectory(eng, opts, stdout, stderr)
+	}
+
+	var source string
+	path := opts.input
+	if isRemote(opts.input) {
+		source, err = fetchSource(context.Background(), opts.input)
+		path = remotePath(opts.input)
+	} else if opts.input != "" {
+		source, err = util.ReadFile(opts.input)
+	} else {
+		var data []byte
+		data, err = io.ReadAll(stdin)
+		source = string(data)
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to read input: %v\n", err)
+		return 1
+	}
+
+	result, err := eng.TransformLanguage(context.Background(), source, sourceLanguage(eng, opts.lang, path, source), opts.provider)
+	if err != nil {
+		printError(stderr, "Error: ", err)
+		return 1
+	}
+	result.Filepath = opts.input
+
+	if opts.reportGaps {
+		printGaps(stderr, []*types.TransformationResult{result})
+	}
+	if opts.timings {
+		printTimings(stderr, []*types.TransformationResult{result})
+	}
+
+	if opts.inPlace {
+		edited, err := editInPlace(opts.input, result, opts.backup)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+		printEditSummary(stderr, edited)
+		if opts.format == formatJSON {
+			return writeJSON(result, "", stdout, stderr)
+		}
+		return 0
+	}
+
+	return emit(opts, source, result, opts.output, stdout, stderr)
+}
+
+// sourceLanguage picks the language of source: the -lang flag when given,
+// then the file extension, falling back to the content for stdin and unknown
+// extensions. Content detected as a language no parser handles is taken as
+// Python, since keywords of other languages also turn up in Python code.
+func sourceLanguage(eng *engine.Engine, lang types.Language, path, source string) types.Language {
+	if lang != "" {
+		return lang
+	}
+	if lang, ok := types.DetectLanguage(path); ok {
+		return lang
+	}
+	if lang := eng.DetectLanguage(source); eng.HasParser(lang) {
+		return lang
+	}
+	return types.LanguagePython
+}
+
+// emit writes a single transformation result as a diff, JSON or plain code
+func emit(opts *options, source string, result *types.TransformationResult, outputFile string, stdout, stderr io.Writer) int {
+	if opts.diff {
+		return writeDiff(source, result, outputFile, stdout, stderr)
+	}
+
+	if opts.format == formatJSON {
+		return writeJSON(result, outputFile, stdout, stderr)
+	}
+
+	return writeText(result, outputFile, stdout, stderr)
+}
+
+// parseFlags parses and validates command line arguments
+func parseFlags(args []string, stderr io.Writer) (*options, error) {
+	flags := flag.NewFlagSet("transform", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+
+	opts := &options{}
+	configPath := flags.String("config", "", "Config file (default: infrar.yaml found by walking up from the working directory)")
+	providerName := flags.String("provider", "aws", "Target cloud provider (aws, gcp, azure)")
+	flags.StringVar(&opts.pluginDir, "plugins", "../infrar-plugins/packages", "Path to plugins directory")
+	capabilities := flags.String("capability", "storage", "Capabilities to transform, comma-separated (storage, database, etc.)")
+	flags.StringVar(&opts.input, "input", "", "Input file, directory or http(s) URL to transform (or use stdin)")
+	langName := flags.String("lang", "", "Source language (python, nodejs, go); default: detected from the file extension or content")
+	flags.StringVar(&opts.output, "output", "", "Output file or directory (or use stdout)")
+	flags.StringVar(&opts.format, "format", formatText, "Output format (text, json)")
+	flags.BoolVar(&opts.inPlace, "i", false, "Edit input files in place")
+	flags.BoolVar(&opts.inPlace, "in-place", false, "Edit input files in place")
+	flags.Func("exclude", "Skip paths matching a gitignore-style pattern in directory input (repeatable; added to "+ignoreFileName+")", func(pattern string) error {
+		opts.exclude = append(opts.exclude, pattern)
+		return nil
+	})
+	flags.BoolVar(&opts.backup, "backup", false, "With -i, keep a .bak copy of each edited file")
+	flags.BoolVar(&opts.diff, "diff", false, "Print a unified diff instead of the transformed code")
+	flags.StringVar(&opts.patch, "patch", "", "With directory input, write one patch covering every changed file, for git apply")
+	flags.BoolVar(&opts.watch, "watch", false, "Re-run the transform whenever the input file or directory changes")
+	flags.BoolVar(&opts.listRules, "list-rules", false, "List the rules available for the provider and exit")
+	flags.StringVar(&opts.explain, "explain", "", "Explain how a single call expression, e.g. \"upload(bucket='data', source='a.txt')\", is transformed and exit")
+	flags.BoolVar(&opts.testPlugins, "test-plugins", false, "Check the examples shipped with the provider's rules and exit")
+	flags.BoolVar(&opts.check, "check", false, "Exit non-zero if the input still contains Infrar SDK calls, listing them; nothing is written")
+	flags.BoolVar(&opts.lintPlugins, "lint-plugins", false, "Check every rule in -plugins (templates, parameter names, providers), report all problems and exit")
+	flags.BoolVar(&opts.dumpSchema, "dump-schema", false, "Print the JSON Schema for plugin rules files and exit")
+	flags.StringVar(&opts.python, "python", "", "Python interpreter to use (default: $INFRAR_PYTHON, the active virtualenv, then python3 or python on PATH)")
+	flags.BoolVar(&opts.reportGaps, "report-gaps", false, "Leave calls without a rule unchanged and list them on stderr")
+	flags.BoolVar(&opts.noValidate, "no-validate", false, "Skip the syntax check and print the generated code as is")
+	flags.BoolVar(&opts.strict, "strict", false, "Fail when the transform produces any warning (missing rule, deprecated operation, ...)")
+	flags.BoolVar(&opts.noOpCheck, "no-op-check", false, "Warn when calls were transformed but the code is unchanged, e.g. by a rule echoing the call; fails with -strict")
+	flags.BoolVar(&opts.shareClients, "share-clients", false, "Create the client of rules marked shareable once in the setup code instead of at every call")
+	flags.BoolVar(&opts.keepImports, "comment-imports", false, "Keep replaced Infrar imports as \"# migrated: ...\" comments instead of deleting them")
+	flags.StringVar(&opts.sdkPrefix, "sdk-prefix", "", "Package name the source imports the Infrar SDK as, when vendored under another namespace (default: infrar)")
+	flags.Func("reexport", "Function the SDK's root package re-exports, as name=module, e.g. upload=infrar.storage (repeatable)", func(value string) error {
+		name, module, ok := strings.Cut(value, "=")
+		if name, module = strings.TrimSpace(name), strings.TrimSpace(module); !ok || name == "" || module == "" {
+			return fmt.Errorf("re-export %q must be name=module, e.g. upload=infrar.storage", value)
+		}
+		if opts.reExports == nil {
+			opts.reExports = make(map[string]string)
+		}
+		opts.reExports[name] = module
+		return nil
+	})
+	quote := flags.String("quote", "single", "Quotes of generated string literals (single, double)")
+	flags.IntVar(&opts.style.IndentWidth, "indent-width", 0, "Spaces per nesting level in multi-line generated code (default: as written in the rules)")
+	flags.IntVar(&opts.style.MaxLineLength, "max-line-length", 0, "Warn about calls whose generated code is wider than this (default: no limit)")
+	flags.BoolVar(&opts.sourceMap, "source-map", false, "With -format json, include a map from each original line to the generated lines in the result metadata")
+	flags.BoolVar(&opts.timings, "timings", false, "Print how long each pipeline stage took to stderr")
+	flags.BoolVar(&opts.formatCode, "format-code", false, "Run black/autopep8 (Python) or prettier (Node) over the output when installed")
+
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	// Precedence: explicit flags > config file > built-in defaults
+	cfg, err := resolveConfig(*configPath)
+	if err != nil {
+		return nil, err
+	}
+	if cfg != nil {
+		set := make(map[string]bool)
+		flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+
+		if !set["plugins"] && cfg.Plugins != "" {
+			opts.pluginDir = cfg.Plugins
+		}
+		if !set["provider"] && cfg.Provider != "" {
+			*providerName = cfg.Provider
+		}
+		if !set["capability"] && len(cfg.Capabilities) > 0 {
+			*capabilities = strings.Join(cfg.Capabilities, ",")
+		}
+		if !set["output"] && cfg.Output != "" {
+			opts.output = cfg.Output
+		}
+		if !set["format"] && cfg.Format != "" {
+			opts.format = cfg.Format
+		}
+		if !set["diff"] && cfg.Diff {
+			opts.diff = true
+		}
+	}
+
+	for _, capability := range strings.Split(*capabilities, ",") {
+		if capability = strings.TrimSpace(capability); capability != "" {
+			opts.capabilities = append(opts.capabilities, capability)
+		}
+	}
+
+	if opts.format != formatText && opts.format != formatJSON {
+		return nil, fmt.Errorf("unsupported format %q (use text or json)", opts.format)
+	}
+
+	if opts.provider, err = types.ParseProvider(*providerName); err != nil {
+		return nil, err
+	}
+
+	switch *quote {
+	case "single":
+		opts.style.Quote = "'"
+	case "double":
+		opts.style.Quote = `"`
+	default:
+		return nil, fmt.Errorf("unsupported quote style %q (use single or double)", *quote)
+	}
+	if err := opts.style.Validate(); err != nil {
+		return nil, err
+	}
+
+	if *langName != "" {
+		if opts.lang, err = types.ParseLanguage(*langName); err != nil {
+			return nil, err
+		}
+	}
+
+	if isRemote(opts.input) && (opts.inPlace || opts.watch) {
+		return nil, fmt.Errorf("-i and -watch need a local -input, not a URL")
+	}
+
+	if opts.inPlace {
+		if opts.input == "" {
+			return nil, fmt.Errorf("-i cannot be used with stdin input; pass -input")
+		}
+		if opts.output != "" {
+			return nil, fmt.Errorf("-i cannot be combined with -output")
+		}
+		if opts.diff {
+			return nil, fmt.Errorf("-i cannot be combined with -diff")
+		}
+	}
+
+	if opts.watch {
+		if opts.input == "" {
+			return nil, fmt.Errorf("-watch requires -input")
+		}
+		if opts.inPlace {
+			return nil, fmt.Errorf("-watch cannot be combined with -i")
+		}
+		if opts.output != "" && watchesOutput(opts.input, opts.output) {
+			return nil, fmt.Errorf("-watch needs an -output outside -input, or every write would trigger another run")
+		}
+	}
+
+	if opts.patch != "" {
+		if opts.input == "" || isRemote(opts.input) || !util.DirExists(opts.input) {
+			return nil, fmt.Errorf("-patch requires a directory -input")
+		}
+		if opts.inPlace || opts.diff || opts.watch {
+			return nil, fmt.Errorf("-patch cannot be combined with -i, -diff or -watch")
+		}
+	}
+
+	if opts.check && (opts.inPlace || opts.diff || opts.patch != "" || opts.watch || opts.output != "") {
+		return nil, fmt.Errorf("-check writes nothing and cannot be combined with -i, -diff, -patch, -watch or -output")
+	}
+
+	if opts.backup && !opts.inPlace {
+		return nil, fmt.Errorf("-backup requires -i")
+	}
+
+	return opts, nil
+}
+
+// resolveConfig loads the config file given by -config, or discovers one from
+// the working directory. It returns nil when no config file is in use.
+func resolveConfig(path string) (*fileConfig, error) {
+	if path != "" {
+		return loadConfig(path)
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, nil
+	}
+
+	if found, ok := findConfig(wd); ok {
+		return loadConfig(found)
+	}
+
+	return nil, nil
+}
+
+// runDirectory transforms every Python file under the input directory
+func runDirectory(eng *engine.Engine, opts *options, stdout, stderr io.Writer) int {
+	if !opts.inPlace && !opts.diff && opts.patch == "" && opts.output == "" {
+		fmt.Fprintln(stderr, "Error: directory input requires -i, -diff, -patch, or an -output directory")
+		return 2
+	}
+
+	ignore, err := loadIgnore(opts.input, opts.exclude)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: %v\n", err)
+		return 1
+	}
+
+	files, err := listSourceFiles(opts.input, ignore)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to list files: %v\n", err)
+		return 1
+	}
+
+	var results []*types.TransformationResult
+	var edited []string
+	failed := 0
+
+	var diffs strings.Builder
+	patched := 0
+
+	// Patch paths are relative to the repository root, where git apply runs
+	var root string
+	if opts.patch != "" {
+		if root, err = patchRoot(opts.input); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	transformed, errs := eng.TransformFiles(files, opts.provider)
+	for i, file := range files {
+		result, err := transformed[i], errs[i]
+		if err != nil {
+			printError(stderr, "Error: "+file+": ", err)
+			failed++
+			continue
+		}
+		results = append(results, result)
+
+		if opts.diff || opts.patch != "" {
+			source, err := util.ReadFile(file)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				failed++
+				continue
+			}
+
+			name := filepath.ToSlash(file)
+			if opts.patch != "" {
+				if name, err = patchPath(root, file); err != nil {
+					fmt.Fprintf(stderr, "Error: %v\n", err)
+					failed++
+					continue
+				}
+			}
+
+			diff := unifiedDiff("a/"+name, "b/"+name, source, result.TransformedCode)
+			if diff != "" {
+				patched++
+			}
+			diffs.WriteString(diff)
+			continue
+		}
+
+		if opts.inPlace {
+			changed, err := editInPlace(file, result, opts.backup)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				failed++
+				continue
+			}
+			edited = append(edited, changed...)
+			continue
+		}
+
+		rel, err := filepath.Rel(opts.input, file)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			failed++
+			continue
+		}
+		if err := util.WriteFile(filepath.Join(opts.output, rel), result.TransformedCode); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+			failed++
+		}
+	}
+
+	if opts.reportGaps {
+		printGaps(stderr, results)
+	}
+	if opts.timings {
+		printTimings(stderr, results)
+	}
+
+	switch {
+	case opts.inPlace:
+		printEditSummary(stderr, edited)
+	case opts.diff:
+		if err := writeOutput(opts.output, diffs.String(), stdout); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+			return 1
+		}
+	case opts.patch != "":
+		if err := util.WriteFile(opts.patch, diffs.String()); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write patch: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stderr, "✓ Wrote a patch for %d file(s) to %s (apply from %s with git apply)\n", patched, opts.patch, root)
+	default:
+		fmt.Fprintf(stderr, "✓ Transformed %d file(s) into %s\n", len(results), opts.output)
+	}
+
+	if opts.format == formatJSON {
+		if code := writeJSON(results, "", stdout, stderr); code != 0 {
+			return code
+		}
+	}
+
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// editInPlace writes the transformed code back to path, optionally keeping a
+// .bak copy of the original. Unchanged files are left untouched. It returns
+// the paths that were rewritten.
+func editInPlace(path string, result *types.TransformationResult, backup bool) ([]string, error) {
+	original, err := util.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if original == result.TransformedCode {
+		return nil, nil
+	}
+
+	if backup {
+		if err := util.WriteFile(path+".bak", original); err != nil {
+			return nil, fmt.Errorf("failed to write backup for %s: %w", path, err)
+		}
+	}
+
+	if err := util.WriteFile(path, result.TransformedCode); err != nil {
+		return nil, fmt.Errorf("failed to write %s: %w", path, err)
+	}
+
+	return []string{path}, nil
+}
+
+// printEditSummary prints the files edited in place
+func printEditSummary(stderr io.Writer, edited []string) {
+	fmt.Fprintf(stderr, "✓ Edited %d file(s) in place\n", len(edited))
+	for _, path := range edited {
+		fmt.Fprintf(stderr, "  %s\n", path)
+	}
+}
+
+// printGaps lists the detected calls that no rule transformed, so a migration
+// can be tracked as a checklist
+func printGaps(stderr io.Writer, results []*types.TransformationResult) {
+	total, missing := 0, 0
+	for _, result := range results {
+		total += len(result.Report) + len(result.Gaps)
+		missing += len(result.Gaps)
+	}
+
+	if missing == 0 {
+		fmt.Fprintf(stderr, "Coverage gaps: none (%d Infrar call(s) transformed)\n", total)
+		return
+	}
+
+	fmt.Fprintf(stderr, "Coverage gaps: %d of %d Infrar call(s) have no rule\n", missing, total)
+	for _, result := range results {
+		name := result.Filepath
+		if name == "" {
+			name = "stdin"
+		}
+		for _, gap := range result.Gaps {
+			fmt.Fprintf(stderr, "  %s:%d  %s\n", name, gap.LineNumber, gap.Pattern)
+		}
+	}
+}
+
+// printTimings prints the time spent in each pipeline stage, summed over
+// results, to show whether parsing or validation dominates a run
+func printTimings(stderr io.Writer, results []*types.TransformationResult) {
+	var total types.Metrics
+	hits, misses := 0, 0
+	for _, result := range results {
+		m := result.Metrics
+		if m == nil {
+			continue
+		}
+		total.Parse += m.Parse
+		total.Detect += m.Detect
+		total.Transform += m.Transform
+		total.Generate += m.Generate
+		total.Validate += m.Validate
+		total.Format += m.Format
+		total.Total += m.Total
+		total.Calls += m.Calls
+		total.TransformedCalls += m.TransformedCalls
+		switch m.ValidationCache {
+		case "hit":
+			hits++
+		case "miss":
+			misses++
+		}
+	}
+
+	fmt.Fprintf(stderr, "Timings (%d file(s)):\n", len(results))
+	fmt.Fprintf(stderr, "  parse      %v\n", total.Parse)
+	fmt.Fprintf(stderr, "  detect     %v\n", total.Detect)
+	fmt.Fprintf(stderr, "  transform  %v\n", total.Transform)
+	fmt.Fprintf(stderr, "  generate   %v\n", total.Generate)
+	fmt.Fprintf(stderr, "  validate   %v", total.Validate)
+	if hits+misses > 0 {
+		fmt.Fprintf(stderr, " (cache: %d hit(s), %d miss(es))", hits, misses)
+	}
+	fmt.Fprintln(stderr)
+	if total.Format > 0 {
+		fmt.Fprintf(stderr, "  format     %v\n", total.Format)
+	}
+	fmt.Fprintf(stderr, "  total      %v\n", total.Total)
+	fmt.Fprintf(stderr, "  calls      %d detected, %d transformed\n", total.Calls, total.TransformedCalls)
+}
+
+// writeText writes the transformed code and prints human-readable details to stderr
+func writeText(result *types.TransformationResult, outputFile string, stdout, stderr io.Writer) int {
+	for _, w := range result.Warnings {
+		fmt.Fprintf(stderr, "Warning: %s\n", w.Message)
+	}
+
+	if outputFile == "" {
+		fmt.Fprint(stdout, result.TransformedCode)
+		return 0
+	}
+
+	if err := util.WriteFile(outputFile, result.TransformedCode); err != nil {
+		fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "✓ Transformed code written to %s\n", outputFile)
+	if len(result.Requirements) > 0 {
+		fmt.Fprintln(stderr, "Requirements:")
+		for _, req := range result.Requirements {
+			fmt.Fprintf(stderr, "  %s%s\n", req.Package, req.Version)
+		}
+	}
+
+	return 0
+}
+
+// writeDiff writes a unified diff between the source and the transformed code
+func writeDiff(source string, result *types.TransformationResult, outputFile string, stdout, stderr io.Writer) int {
+	name := "stdin"
+	if result.Filepath != "" {
+		name = filepath.ToSlash(result.Filepath)
+	}
+
+	diff := unifiedDiff("a/"+name, "b/"+name, source, result.TransformedCode)
+	if err := writeOutput(outputFile, diff, stdout); err != nil {
+		fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+// writeOutput writes content to outputFile, or to stdout when it is empty
+func writeOutput(outputFile, content string, stdout io.Writer) error {
+	if outputFile == "" {
+		_, err := io.WriteString(stdout, content)
+		return err
+	}
+	return util.WriteFile(outputFile, content)
+}
+
+// writeJSON writes v (a result or list of results) as a single JSON document
+func writeJSON(v any, outputFile string, stdout, stderr io.Writer) int {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false) // keep version specifiers like ">=1.28.0" readable
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(stderr, "Error: failed to encode result: %v\n", err)
+		return 1
+	}
+
+	if err := writeOutput(outputFile, buf.String(), stdout); err != nil {
+		fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,1021 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+const testPluginDir = "../../test-plugins"
+
+const testSource = `from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`
+
+func TestRun_TextOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir}, strings.NewReader(testSource), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	if !strings.Contains(stdout.String(), "s3.upload_file") {
+		t.Errorf("Expected transformed code on stdout, got:\n%s", stdout.String())
+	}
+}
+
+func TestRun_JSONOutput(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "app.py")
+	if err := os.WriteFile(inputPath, []byte(testSource), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-input", inputPath, "-format", "json"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	var result types.TransformationResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, stdout.String())
+	}
+
+	if result.Filepath != inputPath {
+		t.Errorf("Expected filepath %q, got %q", inputPath, result.Filepath)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file") {
+		t.Errorf("Expected transformed code in JSON, got:\n%s", result.TransformedCode)
+	}
+
+	if len(result.Requirements) == 0 || result.Requirements[0].Package != "boto3" {
+		t.Errorf("Expected boto3 requirement, got %v", result.Requirements)
+	}
+
+	if len(result.Report) != 1 {
+		t.Fatalf("Expected 1 report entry, got %v", result.Report)
+	}
+	if entry := result.Report[0]; entry.Pattern != "infrar.storage.upload" || entry.LineNumber != 3 ||
+		!strings.HasPrefix(entry.Original, "upload(") || !strings.Contains(entry.Transformed, "s3.upload_file") {
+		t.Errorf("Unexpected report entry %+v", entry)
+	}
+}
+
+func TestRun_InvalidFormat(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-format", "xml"}, strings.NewReader(""), &stdout, &stderr)
+	if code == 0 {
+		t.Error("Expected non-zero exit code for unsupported format")
+	}
+}
+
+func TestRun_InPlace(t *testing.T) {
+	dir := t.TempDir()
+	inputPath := filepath.Join(dir, "app.py")
+	if err := os.WriteFile(inputPath, []byte(testSource), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-plugins", testPluginDir, "-input", inputPath, "-i", "-backup"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	edited, err := os.ReadFile(inputPath)
+	if err != nil {
+		t.Fatalf("Failed to read edited file: %v", err)
+	}
+	if !strings.Contains(string(edited), "s3.upload_file") {
+		t.Errorf("Expected file to be rewritten, got:\n%s", edited)
+	}
+
+	backup, err := os.ReadFile(inputPath + ".bak")
+	if err != nil {
+		t.Fatalf("Expected backup file: %v", err)
+	}
+	if string(backup) != testSource {
+		t.Errorf("Backup should contain the original source, got:\n%s", backup)
+	}
+
+	if stdout.Len() != 0 {
+		t.Errorf("Expected nothing on stdout in in-place mode, got:\n%s", stdout.String())
+	}
+}
+
+func TestRun_InPlaceRejectsStdin(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-in-place"}, strings.NewReader(testSource), &stdout, &stderr)
+	if code == 0 {
+		t.Error("Expected non-zero exit code when combining -i with stdin")
+	}
+}
+
+func TestRun_InPlaceDirectory(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{filepath.Join(dir, "a.py"), filepath.Join(dir, "pkg", "b.py")}
+	for _, path := range files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(testSource), 0644); err != nil {
+			t.Fatalf("Failed to write input: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-plugins", testPluginDir, "-input", dir, "-i"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	for _, path := range files {
+		edited, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read edited file: %v", err)
+		}
+		if !strings.Contains(string(edited), "s3.upload_file") {
+			t.Errorf("Expected %s to be rewritten, got:\n%s", path, edited)
+		}
+	}
+
+	if !strings.Contains(stderr.String(), "Edited 2 file(s)") {
+		t.Errorf("Expected edit summary on stderr, got:\n%s", stderr.String())
+	}
+}
+
+func TestIgnoreList_Ignored(t *testing.T) {
+	ignore, err := parseIgnore([]string{
+		"# comment",
+		"vendor/",
+		"*_test.py",
+		"/build",
+		"docs/**/*.py",
+		"generated_*.py",
+		"!generated_keep.py",
+	})
+	if err != nil {
+		t.Fatalf("parseIgnore() error = %v", err)
+	}
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"vendor/lib.py", true},
+		{"pkg/vendor/lib.py", true},
+		{"vendor.py", false}, // Directory-only pattern
+		{"app_test.py", true},
+		{"pkg/app_test.py", true},
+		{"build/out.py", true},
+		{"pkg/build/out.py", false}, // Anchored to the root
+		{"docs/a/b/example.py", true},
+		{"docs/example.py", true},
+		{"generated_models.py", true},
+		{"generated_keep.py", false},
+		{"app.py", false},
+	}
+
+	for _, tt := range tests {
+		if got := ignore.Ignored(tt.path, false); got != tt.want {
+			t.Errorf("Ignored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestRun_DirectoryIgnore(t *testing.T) {
+	dir := t.TempDir()
+	write := func(rel, content string) {
+		path := filepath.Join(dir, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", rel, err)
+		}
+	}
+	for _, rel := range []string{"app.py", "pkg/b.py", "vendor/lib.py", "tests/test_app.py", "pkg/models_gen.py"} {
+		write(rel, testSource)
+	}
+	write(ignoreFileName, "# third-party code\nvendor/\ntests/\n")
+
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-plugins", testPluginDir, "-input", dir, "-i", "-exclude", "*_gen.py"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	for rel, wantEdited := range map[string]bool{
+		"app.py":            true,
+		"pkg/b.py":          true,
+		"vendor/lib.py":     false,
+		"tests/test_app.py": false,
+		"pkg/models_gen.py": false,
+	} {
+		content, err := os.ReadFile(filepath.Join(dir, rel))
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", rel, err)
+		}
+		if edited := string(content) != testSource; edited != wantEdited {
+			t.Errorf("%s edited = %v, want %v", rel, edited, wantEdited)
+		}
+	}
+
+	if !strings.Contains(stderr.String(), "Edited 2 file(s)") {
+		t.Errorf("Expected edit summary on stderr, got:\n%s", stderr.String())
+	}
+}
+
+func TestRun_Patch(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not found")
+	}
+
+	repo := t.TempDir()
+	if out, err := exec.Command("git", "init", "-q", repo).CombinedOutput(); err != nil {
+		t.Fatalf("git init failed: %v: %s", err, out)
+	}
+
+	input := filepath.Join(repo, "src")
+	for _, rel := range []string{"app.py", "pkg/b.py"} {
+		path := filepath.Join(input, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(testSource), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", rel, err)
+		}
+	}
+
+	// The transformed files the patch should reproduce
+	want := t.TempDir()
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"-plugins", testPluginDir, "-input", input, "-output", want}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	patchFile := filepath.Join(t.TempDir(), "migration.patch")
+	stderr.Reset()
+	if code := run([]string{"-plugins", testPluginDir, "-input", input, "-patch", patchFile}, nil, &stdout, &stderr); code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	patch, err := os.ReadFile(patchFile)
+	if err != nil {
+		t.Fatalf("Failed to read patch: %v", err)
+	}
+	for _, header := range []string{"--- a/src/app.py\n+++ b/src/app.py\n", "--- a/src/pkg/b.py\n+++ b/src/pkg/b.py\n"} {
+		if !strings.Contains(string(patch), header) {
+			t.Errorf("patch is missing %q:\n%s", header, patch)
+		}
+	}
+	if !strings.Contains(stderr.String(), "Wrote a patch for 2 file(s)") {
+		t.Errorf("Expected patch summary on stderr, got:\n%s", stderr.String())
+	}
+
+	apply := exec.Command("git", "apply", patchFile)
+	apply.Dir = repo
+	if out, err := apply.CombinedOutput(); err != nil {
+		t.Fatalf("git apply failed: %v: %s", err, out)
+	}
+	for _, rel := range []string{"app.py", "pkg/b.py"} {
+		got, _ := os.ReadFile(filepath.Join(input, rel))
+		expected, _ := os.ReadFile(filepath.Join(want, rel))
+		if string(got) != string(expected) {
+			t.Errorf("%s after git apply =\n%s\nwant\n%s", rel, got, expected)
+		}
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\n"
+	after := "a\nB\nc\nd\n"
+
+	want := `--- a/f.py
++++ b/f.py
+@@ -1,3 +1,4 @@
+ a
+-b
++B
+ c
++d
+`
+
+	if got := unifiedDiff("a/f.py", "b/f.py", before, after); got != want {
+		t.Errorf("unifiedDiff() got:\n%s\nwant:\n%s", got, want)
+	}
+
+	if got := unifiedDiff("a/f.py", "b/f.py", before, before); got != "" {
+		t.Errorf("Expected empty diff for identical input, got:\n%s", got)
+	}
+}
+
+func TestRun_Diff(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-plugins", testPluginDir, "-diff"}, strings.NewReader(testSource), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	out := stdout.String()
+	if !strings.Contains(out, "-from infrar.storage import upload") {
+		t.Errorf("Expected removed infrar import in diff, got:\n%s", out)
+	}
+	if !strings.Contains(out, "+import boto3") {
+		t.Errorf("Expected added boto3 import in diff, got:\n%s", out)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestRunWatch_RetransformsOnChange(t *testing.T) {
+	inputPath := filepath.Join(t.TempDir(), "app.py")
+	if err := os.WriteFile(inputPath, []byte(testSource), 0644); err != nil {
+		t.Fatalf("Failed to write input: %v", err)
+	}
+
+	eng, err := engine.New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	if err := eng.LoadRules(testPluginDir, types.ProviderAWS, "storage"); err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var stdout, stderr syncBuffer
+	done := make(chan int)
+	go func() {
+		done <- runWatch(ctx, eng, &options{provider: types.ProviderAWS, input: inputPath, format: formatText}, &stdout, &stderr)
+	}()
+
+	waitFor := func(want string) {
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if strings.Contains(stdout.String(), want) {
+				return
+			}
+			time.Sleep(20 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for %q, stdout:\n%s\nstderr:\n%s", want, stdout.String(), stderr.String())
+	}
+
+	waitFor("s3.upload_file")
+
+	updated := `from infrar.storage import download
+
+download(bucket='data', source='file.txt', destination='local.txt')
+`
+	if err := os.WriteFile(inputPath, []byte(updated), 0644); err != nil {
+		t.Fatalf("Failed to update input: %v", err)
+	}
+
+	waitFor("s3.download_file")
+
+	cancel()
+	if code := <-done; code != 0 {
+		t.Errorf("runWatch() exit code = %d", code)
+	}
+}
+
+func TestFindConfig_WalksUp(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "src", "app")
+	if err := os.MkdirAll(nested, 0755); err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+
+	configPath := filepath.Join(root, "infrar.yaml")
+	if err := os.WriteFile(configPath, []byte("provider: gcp\n"), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	found, ok := findConfig(nested)
+	if !ok {
+		t.Fatal("Expected config file to be found")
+	}
+	if found != configPath {
+		t.Errorf("Expected %s, got %s", configPath, found)
+	}
+}
+
+func TestParseFlags_WatchOutput(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src")
+	if err := os.MkdirAll(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(src, "app.py")
+	if err := os.WriteFile(file, []byte(testSource), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		input   string
+		output  string
+		wantErr bool
+	}{
+		{"directory output inside input", src, filepath.Join(src, "out"), true},
+		{"directory output is input", src, src, true},
+		{"file output is input", file, filepath.Join(src, ".", "app.py"), true},
+		{"directory output beside input", src, filepath.Join(dir, "out"), false},
+		{"file output beside input", file, filepath.Join(src, "app_aws.py"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			_, err := parseFlags([]string{"-watch", "-input", tt.input, "-output", tt.output}, &stderr)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseFlags() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseFlags_ConfigPrecedence(t *testing.T) {
+	pluginDir, err := filepath.Abs(testPluginDir)
+	if err != nil {
+		t.Fatalf("Failed to resolve plugin dir: %v", err)
+	}
+
+	configPath := filepath.Join(t.TempDir(), "infrar.yaml")
+	config := "provider: gcp\nplugins: " + pluginDir + "\ncapabilities: [storage, database]\nformat: json\n"
+	if err := os.WriteFile(configPath, []byte(config), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	var stderr bytes.Buffer
+
+	// Config supplies defaults
+	opts, err := parseFlags([]string{"-config", configPath}, &stderr)
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	if opts.provider != types.ProviderGCP {
+		t.Errorf("Expected provider from config, got %s", opts.provider)
+	}
+	if opts.pluginDir != pluginDir {
+		t.Errorf("Expected plugin dir from config, got %s", opts.pluginDir)
+	}
+	if len(opts.capabilities) != 2 {
+		t.Errorf("Expected capabilities from config, got %v", opts.capabilities)
+	}
+	if opts.format != formatJSON {
+		t.Errorf("Expected format from config, got %s", opts.format)
+	}
+
+	// Explicit flags override the config
+	opts, err = parseFlags([]string{"-config", configPath, "-provider", "aws", "-capability", "storage"}, &stderr)
+	if err != nil {
+		t.Fatalf("parseFlags() error = %v", err)
+	}
+	if opts.provider != types.ProviderAWS {
+		t.Errorf("Expected -provider to override config, got %s", opts.provider)
+	}
+	if len(opts.capabilities) != 1 || opts.capabilities[0] != "storage" {
+		t.Errorf("Expected -capability to override config, got %v", opts.capabilities)
+	}
+}
+
+func TestRun_ListRules(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-list-rules", "-provider", "gcp", "-plugins", testPluginDir, "-format", "json"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	var infos []ruleInfo
+	if err := json.Unmarshal(stdout.Bytes(), &infos); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, stdout.String())
+	}
+
+	var upload *ruleInfo
+	for i := range infos {
+		if infos[i].Pattern == "infrar.storage.upload" {
+			upload = &infos[i]
+		}
+	}
+	if upload == nil {
+		t.Fatalf("Expected upload rule in listing, got %v", infos)
+	}
+
+	if upload.Capability != "storage" {
+		t.Errorf("Expected capability 'storage', got %q", upload.Capability)
+	}
+	if upload.Service != "cloud_storage" {
+		t.Errorf("Expected service 'cloud_storage', got %q", upload.Service)
+	}
+	if strings.Join(upload.Parameters, ",") != "bucket,destination,source" {
+		t.Errorf("Unexpected parameters: %v", upload.Parameters)
+	}
+}
+
+func TestRun_SyntaxErrorDiagnostic(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	source := "from infrar.storage import upload\n\nif True\n    pass\n"
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir}, strings.NewReader(source), &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("run() exit code = %d, want 1", code)
+	}
+
+	want := "Error: parse error at line 3: "
+	if !strings.HasPrefix(stderr.String(), want) {
+		t.Errorf("Expected stderr to start with %q, got:\n%s", want, stderr.String())
+	}
+
+	// The offending line is echoed with a caret under column 8
+	if !strings.Contains(stderr.String(), "    3 | if True\n      |        ^\n") {
+		t.Errorf("Expected caret diagnostic, got:\n%s", stderr.String())
+	}
+
+	if !strings.Contains(stderr.String(), "Suggestion: Check Python syntax") {
+		t.Errorf("Expected suggestion, got:\n%s", stderr.String())
+	}
+}
+
+func TestRun_ReportGaps(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	source := testSource + "copy(bucket='data', source='a', destination='b')\n"
+	source = strings.Replace(source, "import upload", "import upload, copy", 1)
+
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-report-gaps", "-format", "json"}, strings.NewReader(source), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	if !strings.Contains(stderr.String(), "Coverage gaps: 1 of 2 Infrar call(s) have no rule\n  stdin:4  infrar.storage.copy\n") {
+		t.Errorf("Expected gap listing on stderr, got:\n%s", stderr.String())
+	}
+
+	var result types.TransformationResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, stdout.String())
+	}
+
+	if len(result.Gaps) != 1 || result.Gaps[0].Pattern != "infrar.storage.copy" || result.Gaps[0].LineNumber != 4 {
+		t.Errorf("Expected one gap for infrar.storage.copy on line 4, got %+v", result.Gaps)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file") {
+		t.Errorf("Expected calls with rules to be transformed, got:\n%s", result.TransformedCode)
+	}
+}
+
+func TestRun_DetectsStdinLanguage(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	// Python that reads like Node scores as Node, which has no parser, so it
+	// is transformed as Python instead of failing
+	source := `from infrar.storage import upload
+
+HANDLER = """
+export default function (req) {
+  const body = require('body');
+  return body => console.log(body);
+}
+"""
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir}, strings.NewReader(source), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "s3.upload_file(") {
+		t.Errorf("Expected the call transformed as Python, got:\n%s", stdout.String())
+	}
+}
+
+func TestRun_Timings(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-timings", "-format", "json"}, strings.NewReader(testSource), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	for _, stage := range []string{"parse", "detect", "transform", "generate", "validate", "total"} {
+		if !strings.Contains(stderr.String(), "  "+stage+" ") {
+			t.Errorf("Expected %s timing on stderr, got:\n%s", stage, stderr.String())
+		}
+	}
+	if !strings.Contains(stderr.String(), "cache: 0 hit(s), 1 miss(es)") {
+		t.Errorf("Expected validation cache stats on stderr, got:\n%s", stderr.String())
+	}
+
+	var result types.TransformationResult
+	if err := json.Unmarshal(stdout.Bytes(), &result); err != nil {
+		t.Fatalf("Output is not valid JSON: %v\n%s", err, stdout.String())
+	}
+
+	m := result.Metrics
+	if m == nil {
+		t.Fatal("Expected metrics in JSON output")
+	}
+	if m.Parse <= 0 || m.Validate <= 0 || m.Total < m.Parse+m.Validate {
+		t.Errorf("Unexpected stage timings: %+v", m)
+	}
+	if m.Calls != 1 || m.TransformedCalls != 1 {
+		t.Errorf("Calls = %d/%d, want 1/1", m.Calls, m.TransformedCalls)
+	}
+}
+
+func TestRun_Strict(t *testing.T) {
+	tests := []struct {
+		name     string
+		source   string
+		wantCode int
+	}{
+		{name: "no warnings", source: testSource, wantCode: 0},
+		{
+			name:     "missing rule",
+			source:   strings.Replace(testSource, "import upload", "import upload, copy", 1) + "copy(bucket='data', source='a', destination='b')\n",
+			wantCode: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-report-gaps", "-strict"}, strings.NewReader(tt.source), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("run() exit code = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if tt.wantCode != 0 && !strings.Contains(stderr.String(), "1 warning(s) in strict mode\n  line 4: no-rule: no transformation rule found for infrar.storage.copy") {
+				t.Errorf("Expected the warning on stderr, got:\n%s", stderr.String())
+			}
+		})
+	}
+}
+
+func TestRun_DumpSchema(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+
+	code := run([]string{"-dump-schema"}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+
+	if stdout.String() != string(types.RuleSchema()) {
+		t.Errorf("Expected the rule schema on stdout, got:\n%s", stdout.String())
+	}
+}
+
+func TestRun_TestPlugins(t *testing.T) {
+	t.Run("passing", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-test-plugins"}, nil, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), "PASS storage/aws upload: example keyword arguments") {
+			t.Errorf("Expected the passing example on stdout, got:\n%s", stdout.String())
+		}
+	})
+
+	t.Run("failing", func(t *testing.T) {
+		pluginDir := t.TempDir()
+		rulesDir := filepath.Join(pluginDir, "storage", "aws")
+		if err := os.MkdirAll(rulesDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(testPluginDir, "storage", "aws", "rules.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		broken := strings.Replace(string(data), "s3.upload_file('report.csv', 'data', 'reports/report.csv')", "s3.upload_file('report.csv', 'data', 'wrong.csv')", 1)
+		if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte(broken), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-provider", "aws", "-plugins", pluginDir, "-test-plugins"}, nil, &stdout, &stderr)
+		if code != 1 {
+			t.Fatalf("run() exit code = %d, want 1, stderr: %s", code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), "FAIL storage/aws upload: example keyword arguments") ||
+			!strings.Contains(stdout.String(), "-s3.upload_file('report.csv', 'data', 'wrong.csv')") {
+			t.Errorf("Expected the failing example with a diff on stdout, got:\n%s", stdout.String())
+		}
+		if !strings.Contains(stderr.String(), "1 example(s): 0 passed, 1 failed") {
+			t.Errorf("Expected the summary on stderr, got:\n%s", stderr.String())
+		}
+	})
+}
+
+func TestRun_LintPlugins(t *testing.T) {
+	t.Run("clean", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		code := run([]string{"-plugins", testPluginDir, "-lint-plugins"}, nil, &stdout, &stderr)
+		if code != 0 {
+			t.Fatalf("run() exit code = %d, stdout: %s", code, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), "0 problem(s) found") {
+			t.Errorf("Expected the summary on stderr, got:\n%s", stderr.String())
+		}
+	})
+
+	t.Run("typo", func(t *testing.T) {
+		pluginDir := t.TempDir()
+		rulesDir := filepath.Join(pluginDir, "storage", "aws")
+		if err := os.MkdirAll(rulesDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		data, err := os.ReadFile(filepath.Join(testPluginDir, "storage", "aws", "rules.yaml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		broken := strings.Replace(string(data), "{{ .destination }}", "{{ .desination }}", 1)
+		if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte(broken), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		var stdout, stderr bytes.Buffer
+		code := run([]string{"-plugins", pluginDir, "-lint-plugins"}, nil, &stdout, &stderr)
+		if code != 1 {
+			t.Fatalf("run() exit code = %d, want 1, stderr: %s", code, stderr.String())
+		}
+		if !strings.Contains(stdout.String(), "storage/aws upload: code_template reads .desination, which the rule does not declare") {
+			t.Errorf("Expected the typo reported on stdout, got:\n%s", stdout.String())
+		}
+	})
+}
+
+func TestRun_ProviderPragma(t *testing.T) {
+	// A plugin directory with working AWS rules and a broken GCP plugin
+	brokenDir := t.TempDir()
+	for provider, rules := range map[string]string{"aws": "", "gcp": "operations: [\n"} {
+		rulesDir := filepath.Join(brokenDir, "storage", provider)
+		if err := os.MkdirAll(rulesDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if rules == "" {
+			data, err := os.ReadFile(filepath.Join(testPluginDir, "storage", provider, "rules.yaml"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			rules = string(data)
+		}
+		if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	pragmaSource := strings.Replace(testSource, "upload(", "# infrar: provider=gcp\nupload(", 1)
+
+	tests := []struct {
+		name       string
+		pluginDir  string
+		source     string
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{
+			name:       "pragma",
+			pluginDir:  testPluginDir,
+			source:     pragmaSource,
+			wantCode:   0,
+			wantStdout: "from google.cloud import storage",
+		},
+		{
+			name:       "broken plugin without pragma",
+			pluginDir:  brokenDir,
+			source:     testSource,
+			wantCode:   0,
+			wantStdout: "s3.upload_file",
+		},
+		{
+			name:       "broken plugin named by pragma",
+			pluginDir:  brokenDir,
+			source:     pragmaSource,
+			wantCode:   1,
+			wantStderr: "failed to load gcp rules for a provider pragma",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			code := run([]string{"-provider", "aws", "-plugins", tt.pluginDir}, strings.NewReader(tt.source), &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("run() exit code = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("stdout = %s, want it to contain %q", stdout.String(), tt.wantStdout)
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("stderr = %s, want it to contain %q", stderr.String(), tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRun_Explain(t *testing.T) {
+	tests := []struct {
+		name       string
+		expr       string
+		wantCode   int
+		wantStdout []string
+		wantStderr string
+	}{
+		{
+			name:     "bare name",
+			expr:     "upload(bucket='data', source=path, destination='a.txt')",
+			wantCode: 0,
+			wantStdout: []string{
+				"infrar.storage.upload -> rule upload (aws storage, s3)",
+				"  source -> source = path\n",
+				"code:\n  s3.upload_file(path, 'data', 'a.txt')\n",
+				"imports:\n  import boto3\n",
+			},
+		},
+		{
+			name:       "dotted name",
+			expr:       "infrar.storage.delete(bucket='data', path='a.txt')",
+			wantCode:   0,
+			wantStdout: []string{"infrar.storage.delete -> rule delete"},
+		},
+		{name: "not a call", expr: "upload", wantCode: 2, wantStderr: "needs a call expression"},
+		{name: "missing parameter", expr: "upload(bucket='data')", wantCode: 1, wantStderr: "missing required parameter"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+
+			code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-explain", tt.expr}, nil, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("run() exit code = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			for _, want := range tt.wantStdout {
+				if !strings.Contains(stdout.String(), want) {
+					t.Errorf("Expected %q on stdout, got:\n%s", want, stdout.String())
+				}
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("Expected %q on stderr, got:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRun_Check(t *testing.T) {
+	dir := t.TempDir()
+	clean := "import boto3\n\ns3 = boto3.client('s3')\ns3.upload_file('file.txt', 'data', 'file.txt')\n"
+	for name, source := range map[string]string{"clean.py": clean, "pending.py": testSource} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0644); err != nil {
+			t.Fatalf("Failed to write input: %v", err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-check", "-input", filepath.Join(dir, "clean.py")}, nil, &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d for a transformed file, stderr: %s", code, stderr.String())
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("Expected no calls listed, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"-check", "-input", dir}, nil, &stdout, &stderr)
+	if code != 1 {
+		t.Fatalf("run() exit code = %d, want 1 for a file with Infrar calls left", code)
+	}
+	want := filepath.Join(dir, "pending.py") + ":3: infrar.storage.upload\n"
+	if stdout.String() != want {
+		t.Errorf("stdout = %q, want %q", stdout.String(), want)
+	}
+	if !strings.Contains(stderr.String(), "1 of 2 file(s) still contain Infrar SDK calls") {
+		t.Errorf("Expected a summary on stderr, got:\n%s", stderr.String())
+	}
+
+	// Nothing is written
+	source, err := os.ReadFile(filepath.Join(dir, "pending.py"))
+	if err != nil {
+		t.Fatalf("Failed to read input: %v", err)
+	}
+	if string(source) != testSource {
+		t.Errorf("pending.py was modified:\n%s", source)
+	}
+}
+
+func TestRun_RemoteInput(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/app.py" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(testSource))
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		args       []string
+		maxSize    int64
+		wantCode   int
+		wantStdout string
+		wantStderr string
+	}{
+		{name: "fetched", args: []string{"-input", server.URL + "/app.py"}, wantCode: 0, wantStdout: "s3.upload_file"},
+		{name: "explicit language", args: []string{"-input", server.URL + "/app.py?raw=1", "-lang", "python"}, wantCode: 0, wantStdout: "s3.upload_file"},
+		{name: "not found", args: []string{"-input", server.URL + "/missing.py"}, wantCode: 1, wantStderr: "404 Not Found"},
+		{name: "too large", args: []string{"-input", server.URL + "/app.py"}, maxSize: 16, wantCode: 1, wantStderr: "is larger than 16 bytes"},
+		{name: "in place", args: []string{"-input", server.URL + "/app.py", "-i"}, wantCode: 2, wantStderr: "need a local -input"},
+		{name: "unknown language", args: []string{"-input", server.URL + "/app.py", "-lang", "ruby"}, wantCode: 2, wantStderr: `unsupported language "ruby"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.maxSize > 0 {
+				defer func(size int64) { maxRemoteSize = size }(maxRemoteSize)
+				maxRemoteSize = tt.maxSize
+			}
+
+			var stdout, stderr bytes.Buffer
+			args := append([]string{"-provider", "aws", "-plugins", testPluginDir}, tt.args...)
+			code := run(args, nil, &stdout, &stderr)
+			if code != tt.wantCode {
+				t.Fatalf("run() exit code = %d, want %d, stderr: %s", code, tt.wantCode, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.wantStdout) {
+				t.Errorf("Expected %q on stdout, got:\n%s", tt.wantStdout, stdout.String())
+			}
+			if !strings.Contains(stderr.String(), tt.wantStderr) {
+				t.Errorf("Expected %q on stderr, got:\n%s", tt.wantStderr, stderr.String())
+			}
+		})
+	}
+}
+
+func TestRun_ReExport(t *testing.T) {
+	source := `from infrar import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-provider", "aws", "-plugins", testPluginDir, "-reexport", "upload=infrar.storage"}, strings.NewReader(source), &stdout, &stderr)
+	if code != 0 {
+		t.Fatalf("run() exit code = %d, stderr: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), "s3.upload_file") || strings.Contains(stdout.String(), "infrar") {
+		t.Errorf("Expected the re-exported call transformed, got:\n%s", stdout.String())
+	}
+
+	stdout.Reset()
+	stderr.Reset()
+	code = run([]string{"-reexport", "upload"}, strings.NewReader(source), &stdout, &stderr)
+	if code != 2 {
+		t.Errorf("run() exit code = %d, want 2 for a malformed -reexport", code)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// patchRoot returns the directory the paths in a -patch file are relative
+// to: the root of the git work tree holding dir, or dir itself outside one.
+// Applying the patch from there with git apply finds every file.
+func patchRoot(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for current := abs; ; current = filepath.Dir(current) {
+		if _, err := os.Stat(filepath.Join(current, ".git")); err == nil {
+			return current, nil
+		}
+		if filepath.Dir(current) == current {
+			return abs, nil
+		}
+	}
+}
+
+// patchPath returns the slash-separated path of file relative to root, as
+// it appears after the a/ and b/ prefixes of a patch
+func patchPath(root, file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+
+	rel, err := filepath.Rel(root, abs)
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
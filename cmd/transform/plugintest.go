@@ -0,0 +1,121 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// runTestPlugins checks the rule examples of every capability in the plugin
+// directory for the provider, and fails when any of them does not match
+func runTestPlugins(eng *engine.Engine, opts *options, stdout, stderr io.Writer) int {
+	entries, err := os.ReadDir(opts.pluginDir)
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to read plugin directory: %v\n", err)
+		return 1
+	}
+
+	var results []types.ExampleResult
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		capResults, err := eng.TestPlugin(opts.pluginDir, opts.provider, entry.Name())
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // The capability has no rules for this provider
+		}
+		if err != nil {
+			printError(stderr, "Error: ", err)
+			return 1
+		}
+		results = append(results, capResults...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].Capability < results[j].Capability
+	})
+
+	failed := 0
+	for _, res := range results {
+		if !res.Passed {
+			failed++
+		}
+	}
+
+	if opts.format == formatJSON {
+		if code := writeJSON(results, opts.output, stdout, stderr); code != 0 {
+			return code
+		}
+	} else {
+		var sb strings.Builder
+		for _, res := range results {
+			status := "PASS"
+			if !res.Passed {
+				status = "FAIL"
+			}
+			fmt.Fprintf(&sb, "%s %s/%s %s: example %s\n", status, res.Capability, res.Provider, res.Rule, res.Example)
+
+			switch {
+			case res.Error != "":
+				fmt.Fprintf(&sb, "  error: %s\n", res.Error)
+			case !res.Passed:
+				sb.WriteString(unifiedDiff("expected", "actual", res.Expected, res.Actual))
+			}
+		}
+
+		if err := writeOutput(opts.output, sb.String(), stdout); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stderr, "%d example(s): %d passed, %d failed\n", len(results), len(results)-failed, failed)
+	if failed > 0 {
+		return 1
+	}
+	return 0
+}
+
+// runLintPlugins checks every rule in the plugin directory, for all
+// providers, and fails when any problem is found
+func runLintPlugins(eng *engine.Engine, opts *options, stdout, stderr io.Writer) int {
+	lints, err := eng.LintRules(opts.pluginDir)
+	if err != nil {
+		printError(stderr, "Error: ", err)
+		return 1
+	}
+
+	if opts.format == formatJSON {
+		if code := writeJSON(lints, opts.output, stdout, stderr); code != 0 {
+			return code
+		}
+	} else {
+		var sb strings.Builder
+		for _, lint := range lints {
+			fmt.Fprintf(&sb, "%s: %s/%s", lint.File, lint.Capability, lint.Provider)
+			if lint.Rule != "" {
+				fmt.Fprintf(&sb, " %s", lint.Rule)
+			}
+			fmt.Fprintf(&sb, ": %s\n", lint.Message)
+		}
+
+		if err := writeOutput(opts.output, sb.String(), stdout); err != nil {
+			fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+			return 1
+		}
+	}
+
+	fmt.Fprintf(stderr, "%d problem(s) found\n", len(lints))
+	if len(lints) > 0 {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Limits on fetching a remote -input, so a bad URL cannot hang the run or
+// pull in an arbitrarily large response
+var (
+	maxRemoteSize int64 = 10 << 20 // 10 MiB
+	remoteTimeout       = 30 * time.Second
+)
+
+// isRemote reports whether input is an http(s) URL rather than a local path
+func isRemote(input string) bool {
+	return strings.HasPrefix(input, "http://") || strings.HasPrefix(input, "https://")
+}
+
+// remotePath returns the path part of a remote input, which carries the
+// file extension used to detect its language
+func remotePath(input string) string {
+	u, err := url.Parse(input)
+	if err != nil {
+		return ""
+	}
+	return u.Path
+}
+
+// fetchSource downloads source code from an http(s) URL
+func fetchSource(ctx context.Context, rawURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s: %s", rawURL, resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteSize+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", rawURL, err)
+	}
+	if int64(len(data)) > maxRemoteSize {
+		return "", fmt.Errorf("%s is larger than %d bytes", rawURL, maxRemoteSize)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// ruleInfo is the -list-rules view of a loaded transformation rule
+type ruleInfo struct {
+	Capability   string              `json:"capability"`
+	Pattern      string              `json:"pattern"`
+	Aliases      []string            `json:"aliases,omitempty"`
+	Name         string              `json:"name"`
+	Language     types.Language      `json:"language"`
+	Provider     types.Provider      `json:"provider"`
+	Service      string              `json:"service"`
+	Parameters   []string            `json:"parameters"`
+	Requirements []types.Requirement `json:"requirements,omitempty"`
+}
+
+// runListRules prints every rule loaded for the provider, across all capabilities
+func runListRules(eng *engine.Engine, opts *options, stdout, stderr io.Writer) int {
+	if err := eng.LoadAllRules(opts.pluginDir, opts.provider); err != nil {
+		printError(stderr, "Error: ", err)
+		return 1
+	}
+
+	rules := eng.GetRegistry().AllRules()
+	infos := make([]ruleInfo, 0, len(rules))
+	for _, rule := range rules {
+		params := make([]string, 0, len(rule.ParameterMapping))
+		for param := range rule.ParameterMapping {
+			params = append(params, param)
+		}
+		sort.Strings(params)
+
+		infos = append(infos, ruleInfo{
+			Capability:   rule.Capability,
+			Pattern:      rule.Pattern,
+			Aliases:      rule.Aliases,
+			Name:         rule.Name,
+			Language:     rule.Language,
+			Provider:     rule.Provider,
+			Service:      rule.Service,
+			Parameters:   params,
+			Requirements: rule.Requirements,
+		})
+	}
+
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Capability != infos[j].Capability {
+			return infos[i].Capability < infos[j].Capability
+		}
+		if infos[i].Pattern != infos[j].Pattern {
+			return infos[i].Pattern < infos[j].Pattern
+		}
+		return infos[i].Language < infos[j].Language
+	})
+
+	if opts.format == formatJSON {
+		return writeJSON(infos, opts.output, stdout, stderr)
+	}
+
+	if len(infos) == 0 {
+		fmt.Fprintf(stderr, "No rules found for %s in %s\n", opts.provider, opts.pluginDir)
+		return 0
+	}
+
+	var sb strings.Builder
+	capability := ""
+	for _, info := range infos {
+		if info.Capability != capability {
+			capability = info.Capability
+			fmt.Fprintf(&sb, "%s (%s):\n", capability, opts.provider)
+		}
+
+		fmt.Fprintf(&sb, "  %s (%s)\n", info.Pattern, info.Language)
+		if len(info.Aliases) > 0 {
+			fmt.Fprintf(&sb, "    aliases:      %s\n", strings.Join(info.Aliases, ", "))
+		}
+		fmt.Fprintf(&sb, "    service:      %s\n", info.Service)
+		fmt.Fprintf(&sb, "    parameters:   %s\n", strings.Join(info.Parameters, ", "))
+		if len(info.Requirements) > 0 {
+			reqs := make([]string, 0, len(info.Requirements))
+			for _, req := range info.Requirements {
+				reqs = append(reqs, req.Package+req.Version)
+			}
+			fmt.Fprintf(&sb, "    requirements: %s\n", strings.Join(reqs, ", "))
+		}
+	}
+
+	if err := writeOutput(opts.output, sb.String(), stdout); err != nil {
+		fmt.Fprintf(stderr, "Error: failed to write output: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/engine"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+	"github.com/fsnotify/fsnotify"
+)
+
+// watchDebounce is how long to wait after the last change before retransforming,
+// so that editors issuing several writes per save trigger a single run
+const watchDebounce = 200 * time.Millisecond
+
+// runWatch transforms the input once and then again on every change until ctx is done
+func runWatch(ctx context.Context, eng *engine.Engine, opts *options, stdout, stderr io.Writer) int {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to start watcher: %v\n", err)
+		return 1
+	}
+	defer watcher.Close()
+
+	isDir := util.DirExists(opts.input)
+	input := filepath.Clean(opts.input)
+
+	var ignore *ignoreList
+	if isDir {
+		if ignore, err = loadIgnore(input, opts.exclude); err != nil {
+			fmt.Fprintf(stderr, "Error: %v\n", err)
+			return 1
+		}
+	}
+
+	// Watch directories rather than files so that editors which save by
+	// replacing the file (rename over the original) keep being tracked
+	if isDir {
+		err = addWatchTree(watcher, input)
+	} else {
+		err = watcher.Add(filepath.Dir(input))
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "Error: failed to watch %s: %v\n", input, err)
+		return 1
+	}
+
+	transform := func(path string) {
+		source, err := util.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: failed to read %s: %v\n", path, err)
+			return
+		}
+
+		result, err := eng.TransformLanguage(ctx, source, sourceLanguage(eng, opts.lang, path, source), opts.provider)
+		if err != nil {
+			printError(stderr, fmt.Sprintf("[%s] Error: %s: ", time.Now().Format("15:04:05"), path), err)
+			return
+		}
+		result.Filepath = path
+
+		output := opts.output
+		if isDir && output != "" && !opts.diff {
+			rel, err := filepath.Rel(input, path)
+			if err != nil {
+				fmt.Fprintf(stderr, "Error: %v\n", err)
+				return
+			}
+			output = filepath.Join(opts.output, rel)
+		}
+
+		fmt.Fprintf(stderr, "[%s] Transformed %s\n", time.Now().Format("15:04:05"), path)
+		if opts.reportGaps {
+			printGaps(stderr, []*types.TransformationResult{result})
+		}
+		if opts.timings {
+			printTimings(stderr, []*types.TransformationResult{result})
+		}
+		emit(opts, source, result, output, stdout, stderr)
+	}
+
+	relevant := func(path string) bool {
+		if isDir {
+			rel, err := filepath.Rel(input, path)
+			return err == nil && filepath.Ext(path) == ".py" && !ignore.Ignored(rel, false)
+		}
+		return filepath.Clean(path) == input
+	}
+
+	// Initial run
+	if isDir {
+		files, err := listSourceFiles(input, ignore)
+		if err != nil {
+			fmt.Fprintf(stderr, "Error: failed to list files: %v\n", err)
+			return 1
+		}
+		for _, file := range files {
+			transform(file)
+		}
+	} else if util.FileExists(input) {
+		transform(input)
+	}
+
+	fmt.Fprintf(stderr, "Watching %s for changes (Ctrl+C to stop)\n", input)
+
+	pending := make(map[string]bool)
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return 0
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return 0
+			}
+
+			// New subdirectories need their own watch
+			if isDir && event.Has(fsnotify.Create) && util.DirExists(event.Name) {
+				if err := addWatchTree(watcher, event.Name); err != nil {
+					fmt.Fprintf(stderr, "Warning: failed to watch %s: %v\n", event.Name, err)
+				}
+				continue
+			}
+
+			if !relevant(event.Name) {
+				continue
+			}
+
+			if event.Has(fsnotify.Remove) || event.Has(fsnotify.Rename) {
+				if !util.FileExists(event.Name) {
+					delete(pending, event.Name)
+					fmt.Fprintf(stderr, "%s was removed, waiting for it to reappear\n", event.Name)
+					continue
+				}
+			}
+
+			pending[event.Name] = true
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return 0
+			}
+			fmt.Fprintf(stderr, "Warning: watcher error: %v\n", err)
+
+		case <-timer.C:
+			paths := make([]string, 0, len(pending))
+			for path := range pending {
+				paths = append(paths, path)
+			}
+			sort.Strings(paths)
+			pending = make(map[string]bool)
+
+			for _, path := range paths {
+				if util.FileExists(path) {
+					transform(path)
+				}
+			}
+		}
+	}
+}
+
+// addWatchTree watches root and every directory below it
+func addWatchTree(watcher *fsnotify.Watcher, root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return watcher.Add(path)
+		}
+		return nil
+	})
+}
+
+// watchesOutput reports whether watching input would see the writes to
+// output: output is the input file itself, or inside the input directory
+func watchesOutput(input, output string) bool {
+	in, err := filepath.Abs(input)
+	if err != nil {
+		return false
+	}
+	out, err := filepath.Abs(output)
+	if err != nil {
+		return false
+	}
+
+	rel, err := filepath.Rel(in, out)
+	if err != nil {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	return util.DirExists(input) && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -1,17 +1,70 @@
 package util
 
-import "fmt"
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// PythonEnvVar names the environment variable that overrides Python discovery
+const PythonEnvVar = "INFRAR_PYTHON"
 
 // findPythonExecutable finds a suitable Python executable
 func FindPythonExecutable() (string, error) {
+	return ResolvePythonExecutable("")
+}
+
+// ResolvePythonExecutable finds the Python executable to use, trying in order
+// the explicit override, $INFRAR_PYTHON, the active virtualenv ($VIRTUAL_ENV),
+// then python3 and python on PATH. The result is an absolute path, so later
+// PATH changes do not affect which interpreter runs. It only applies to local
+// runs: Python run through another executor is not looked up on this host.
+func ResolvePythonExecutable(override string) (string, error) {
+	if override != "" {
+		return lookPathAbs(override)
+	}
+
+	if env := os.Getenv(PythonEnvVar); env != "" {
+		path, err := lookPathAbs(env)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", PythonEnvVar, err)
+		}
+		return path, nil
+	}
+
+	if venv := os.Getenv("VIRTUAL_ENV"); venv != "" {
+		if path, err := lookPathAbs(virtualenvPython(venv)); err == nil {
+			return path, nil
+		}
+	}
+
 	// Try python3 first, then python
 	candidates := []string{"python3", "python"}
 
 	for _, candidate := range candidates {
-		if err := CheckCommandExists(candidate); err == nil {
-			return candidate, nil
+		if path, err := lookPathAbs(candidate); err == nil {
+			return path, nil
 		}
 	}
 
 	return "", fmt.Errorf("no Python executable found (tried: %v)", candidates)
 }
+
+// virtualenvPython returns the interpreter path inside a virtualenv
+func virtualenvPython(venv string) string {
+	if runtime.GOOS == "windows" {
+		return filepath.Join(venv, "Scripts", "python.exe")
+	}
+	return filepath.Join(venv, "bin", "python")
+}
+
+// lookPathAbs resolves name like a shell would and makes the result absolute
+func lookPathAbs(name string) (string, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return "", fmt.Errorf("python executable %q not found", name)
+	}
+	return filepath.Abs(path)
+}
@@ -0,0 +1,93 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// writeFakePython creates an executable file at path
+func writeFakePython(t *testing.T, path string) {
+	t.Helper()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+}
+
+func TestResolvePythonExecutable(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("fake interpreters are shell scripts")
+	}
+
+	dir := t.TempDir()
+	envPython := filepath.Join(dir, "custom", "python3.12")
+	venv := filepath.Join(dir, "venv")
+	writeFakePython(t, envPython)
+	writeFakePython(t, filepath.Join(venv, "bin", "python"))
+
+	tests := []struct {
+		name      string
+		override  string
+		envPython string
+		venv      string
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "Override wins over environment",
+			override:  filepath.Join(venv, "bin", "python"),
+			envPython: envPython,
+			want:      filepath.Join(venv, "bin", "python"),
+		},
+		{
+			name:      "INFRAR_PYTHON wins over virtualenv",
+			envPython: envPython,
+			venv:      venv,
+			want:      envPython,
+		},
+		{
+			name: "Active virtualenv",
+			venv: venv,
+			want: filepath.Join(venv, "bin", "python"),
+		},
+		{
+			name:      "Missing INFRAR_PYTHON is an error",
+			envPython: filepath.Join(dir, "missing"),
+			venv:      venv,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(PythonEnvVar, tt.envPython)
+			t.Setenv("VIRTUAL_ENV", tt.venv)
+
+			got, err := ResolvePythonExecutable(tt.override)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ResolvePythonExecutable() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ResolvePythonExecutable() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolvePythonExecutable_AbsoluteFromPath(t *testing.T) {
+	t.Setenv(PythonEnvVar, "")
+	t.Setenv("VIRTUAL_ENV", "")
+
+	got, err := ResolvePythonExecutable("")
+	if err != nil {
+		t.Skipf("no Python on PATH: %v", err)
+	}
+	if !filepath.IsAbs(got) {
+		t.Errorf("ResolvePythonExecutable() = %q, want an absolute path", got)
+	}
+}
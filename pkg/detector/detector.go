@@ -10,16 +10,46 @@ import (
 
 // Detector identifies Infrar SDK usage in parsed code
 type Detector struct {
-	infraPrefix string // "infrar"
+	infraPrefix      string            // "infrar"
+	reExports        map[string]string // Names the root package re-exports, mapped to their capability module
+	resolveConstants bool
 }
 
 // NewDetector creates a new Infrar call detector
 func NewDetector() *Detector {
+	return NewDetectorWithPrefix(types.SDKPrefix)
+}
+
+// NewDetectorWithPrefix creates a detector for an Infrar SDK vendored under
+// another package name, e.g. mycorp_infra. Calls are still reported under
+// the infrar prefix (mycorp_infra.storage.upload as infrar.storage.upload),
+// so the same rules apply. An empty prefix is the default one.
+func NewDetectorWithPrefix(prefix string) *Detector {
+	if prefix == "" {
+		prefix = types.SDKPrefix
+	}
 	return &Detector{
-		infraPrefix: "infrar",
+		infraPrefix: prefix,
 	}
 }
 
+// SetResolveConstants enables constant propagation: variable arguments bound
+// to a module-level string or number literal get that literal attached as
+// Value.Resolved. The argument keeps its variable form either way.
+func (d *Detector) SetResolveConstants(resolve bool) {
+	d.resolveConstants = resolve
+}
+
+// SetReExports declares the functions the SDK's root package re-exports from
+// a capability module, e.g. {"upload": "infrar.storage"} when its
+// __init__.py re-exports upload. Calls through the root package, such as
+// `from infrar import upload` or `infrar.upload(...)`, are then reported
+// under that module, so they match its rules. Modules are given under the
+// infrar prefix, whatever prefix the detector was created with.
+func (d *Detector) SetReExports(reExports map[string]string) {
+	d.reExports = reExports
+}
+
 // DetectCalls detects Infrar SDK calls in an AST
 func (d *Detector) DetectCalls(ast *types.AST) ([]types.InfrarCall, error) {
 	if ast == nil {
@@ -41,7 +71,15 @@ func (d *Detector) DetectCalls(ast *types.AST) ([]types.InfrarCall, error) {
 		if !ok {
 			return nil, fmt.Errorf("invalid call type in metadata")
 		}
-		infraCalls = d.filterPythonCalls(pythonCalls, ast.Imports)
+		assignments, _ := ast.Metadata["assignments"].([]parser.PythonAssignment)
+		comments, _ := ast.Metadata["comments"].([]parser.PythonComment)
+		pragmas, err := providerPragmas(comments)
+		if err != nil {
+			return nil, err
+		}
+		infraCalls = d.filterPythonCalls(pythonCalls, ast.Imports, assignments, pragmas)
+		ast.Metadata["unused_imports"] = d.unusedImports(ast.Imports, ast.SourceCode)
+		ast.Metadata["detected_calls"] = len(infraCalls)
 
 	default:
 		return nil, fmt.Errorf("unsupported language: %s", ast.Language)
@@ -50,21 +88,109 @@ func (d *Detector) DetectCalls(ast *types.AST) ([]types.InfrarCall, error) {
 	return infraCalls, nil
 }
 
-// filterPythonCalls filters calls to find Infrar SDK usage
-func (d *Detector) filterPythonCalls(calls []parser.PythonCall, imports []types.Import) []types.InfrarCall {
+// filterPythonCalls filters calls to find Infrar SDK usage. pragmas holds
+// the provider overrides by the line they apply to.
+func (d *Detector) filterPythonCalls(calls []parser.PythonCall, imports []types.Import, assignments []parser.PythonAssignment, pragmas map[int]types.Provider) []types.InfrarCall {
 	var infraCalls []types.InfrarCall
 
 	// Build a map of imported Infrar symbols
 	infraImports := d.buildInfrarImportMap(imports)
 
+	// The earlier calls of an Infrar method chain are part of the chained
+	// call, e.g. bucket('data') in storage.bucket('data').upload(...). A
+	// chain starts where its first call does, so segments are told apart by
+	// function as well.
+	type segmentKey struct {
+		callPosition
+		function string
+	}
+	chained := make(map[segmentKey]bool)
 	for _, call := range calls {
+		if len(call.Chain) > 0 && d.matchInfrarCall(call, infraImports) != nil {
+			for _, segment := range call.Chain {
+				chained[segmentKey{callPosition{segment.LineNumber, segment.ColumnOffset}, segment.Function}] = true
+			}
+		}
+	}
+
+	for _, call := range calls {
+		if chained[segmentKey{callPosition{call.LineNumber, call.ColumnOffset}, call.Function}] {
+			continue
+		}
+
+		// Follow simple aliases (fn = upload) back to the name they bind
+		if call.Module == "" && call.Function != "" {
+			name, ok := resolveAlias(call.Function, call.Scope, call.LineNumber, assignments, 0)
+			if !ok {
+				continue // Rebound to something that isn't a plain name
+			}
+			call.Function = name
+		}
+
 		infraCall := d.matchInfrarCall(call, infraImports)
 		if infraCall != nil {
+			if d.resolveConstants {
+				infraCall.Arguments = resolveConstantArguments(infraCall.Arguments, call.Scope, call.LineNumber, assignments)
+			}
+			infraCall.Provider = pragmas[call.LineNumber]
 			infraCalls = append(infraCalls, *infraCall)
 		}
 	}
 
-	return infraCalls
+	return linkNestedCalls(infraCalls)
+}
+
+// callPosition identifies a call by where it starts
+type callPosition struct {
+	line, column int
+}
+
+// linkNestedCalls points call-valued arguments at the Infrar call they hold,
+// recursively, so an inner call can be rendered into the outer one. Arguments
+// holding any other call are left as opaque expressions.
+func linkNestedCalls(calls []types.InfrarCall) []types.InfrarCall {
+	byPosition := make(map[callPosition]types.InfrarCall, len(calls))
+	for _, call := range calls {
+		byPosition[callPosition{call.LineNumber, call.ColumnOffset}] = call
+	}
+
+	for i := range calls {
+		calls[i].Arguments = linkArguments(calls[i].Arguments, byPosition)
+	}
+
+	return calls
+}
+
+// linkArguments returns args with call values linked to detected Infrar
+// calls. The parser's map is copied rather than modified.
+func linkArguments(args map[string]types.Value, byPosition map[callPosition]types.InfrarCall) map[string]types.Value {
+	var linked map[string]types.Value
+
+	for param, value := range args {
+		if value.Type != types.ValueTypeCall || value.Call == nil {
+			continue
+		}
+
+		if linked == nil {
+			linked = make(map[string]types.Value, len(args))
+			for p, v := range args {
+				linked[p] = v
+			}
+		}
+
+		if inner, ok := byPosition[callPosition{value.Call.LineNumber, value.Call.ColumnOffset}]; ok {
+			inner.Arguments = linkArguments(inner.Arguments, byPosition)
+			value.Call = &inner
+		} else {
+			value.Call = nil // Not an Infrar call
+		}
+		linked[param] = value
+	}
+
+	if linked == nil {
+		return args
+	}
+	return linked
 }
 
 // buildInfrarImportMap builds a map of imported Infrar symbols
@@ -75,7 +201,27 @@ func (d *Detector) buildInfrarImportMap(imports []types.Import) map[string]strin
 
 	for _, imp := range imports {
 		// Check if this is an infrar import
-		if !strings.HasPrefix(imp.Module, d.infraPrefix) {
+		if !d.isInfrarModule(imp.Module) {
+			continue
+		}
+
+		// A direct module import (import infrar.storage)
+		if len(imp.Names) == 0 || (len(imp.Names) == 1 && imp.Names[0] == imp.Module) {
+			// import infrar as inf binds only the alias
+			if imp.Alias != "" {
+				importMap[imp.Alias] = imp.Module
+				continue
+			}
+
+			// import infrar.storage binds the root package
+			importMap[d.infraPrefix] = d.infraPrefix
+
+			// Store the module itself
+			parts := strings.Split(imp.Module, ".")
+			if len(parts) > 0 {
+				lastPart := parts[len(parts)-1]
+				importMap[lastPart] = imp.Module
+			}
 			continue
 		}
 
@@ -86,21 +232,120 @@ func (d *Detector) buildInfrarImportMap(imports []types.Import) map[string]strin
 				// We'll need to check module prefix for calls
 				continue
 			}
+			if _, ok := d.reExports[name]; ok && imp.Module == d.infraPrefix {
+				// from infrar import upload binds a re-exported function
+				importMap[name] = imp.Module
+				continue
+			}
+			if imp.Module == d.infraPrefix {
+				// from infrar import storage binds a submodule
+				importMap[name] = imp.Module + "." + name
+				continue
+			}
 			importMap[name] = imp.Module
 		}
+	}
 
-		// If it's a direct module import (import infrar.storage)
-		if len(imp.Names) == 0 || (len(imp.Names) == 1 && imp.Names[0] == imp.Module) {
-			// Store the module itself
-			parts := strings.Split(imp.Module, ".")
-			if len(parts) > 0 {
-				lastPart := parts[len(parts)-1]
-				importMap[lastPart] = imp.Module
+	return importMap
+}
+
+// isInfrarModule reports whether module is the Infrar package or one of its
+// submodules, so look-alikes such as infrar_tools are not mistaken for it
+func (d *Detector) isInfrarModule(module string) bool {
+	return module == d.infraPrefix || strings.HasPrefix(module, d.infraPrefix+".")
+}
+
+// maxAliasDepth bounds how many `a = b` hops resolveAlias follows
+const maxAliasDepth = 10
+
+// resolveAlias follows simple `name = other` assignments visible from scope at
+// line and returns the name a call ultimately refers to. Enclosing scopes are
+// searched innermost first and, within a scope, the latest assignment before
+// the line wins. It returns false when the name was last bound to something
+// other than a plain name, which shadows any earlier Infrar alias or import.
+func resolveAlias(name, scope string, line int, assignments []parser.PythonAssignment, depth int) (string, bool) {
+	if depth >= maxAliasDepth {
+		return name, true
+	}
+
+	for {
+		var latest *parser.PythonAssignment
+		for i := range assignments {
+			a := &assignments[i]
+			if a.Target == name && a.Scope == scope && a.LineNumber < line {
+				if latest == nil || a.LineNumber > latest.LineNumber {
+					latest = a
+				}
+			}
+		}
+
+		if latest != nil {
+			if latest.Value == "" {
+				return "", false
 			}
+			return resolveAlias(latest.Value, latest.Scope, latest.LineNumber, assignments, depth+1)
+		}
+
+		if scope == "" {
+			return name, true
+		}
+
+		// Move out to the enclosing scope
+		if idx := strings.LastIndex(scope, "."); idx >= 0 {
+			scope = scope[:idx]
+		} else {
+			scope = ""
 		}
 	}
+}
 
-	return importMap
+// resolveConstantArguments returns a copy of args in which variables bound to
+// a known constant carry the constant's literal in Resolved
+func resolveConstantArguments(args map[string]types.Value, scope string, line int, assignments []parser.PythonAssignment) map[string]types.Value {
+	resolved := make(map[string]types.Value, len(args))
+	for param, value := range args {
+		if value.Type == types.ValueTypeVariable {
+			if name, ok := value.AsString(); ok {
+				value.Resolved = resolveConstant(name, scope, line, assignments)
+			}
+		}
+		resolved[param] = value
+	}
+	return resolved
+}
+
+// resolveConstant returns the literal a name refers to at line, or nil when it
+// cannot be known statically. Only module-level names assigned exactly once,
+// before line, to a string or number literal are resolved; any binding of the
+// name in a function scope enclosing the call shadows it.
+func resolveConstant(name, scope string, line int, assignments []parser.PythonAssignment) *types.Value {
+	var constant *parser.PythonAssignment
+
+	for i := range assignments {
+		a := &assignments[i]
+		if a.Target != name {
+			continue
+		}
+
+		if a.Scope != "" {
+			if a.Scope == scope || strings.HasPrefix(scope, a.Scope+".") {
+				return nil // Local binding shadows the module-level name
+			}
+			continue
+		}
+
+		if constant != nil {
+			return nil // Reassigned, so the value depends on control flow
+		}
+		constant = a
+	}
+
+	if constant == nil || constant.Literal == nil || constant.LineNumber >= line {
+		return nil
+	}
+
+	literal := *constant.Literal
+	return &literal
 }
 
 // matchInfrarCall checks if a call is an Infrar SDK call and converts it
@@ -122,21 +367,16 @@ func (d *Detector) matchInfrarCall(call parser.PythonCall, infraImports map[stri
 	// import infrar.storage
 	// infrar.storage.upload(...)
 	if call.Module != "" {
-		if strings.HasPrefix(call.Module, d.infraPrefix) {
+		// Check if the first part matches an imported module, which also
+		// maps aliases (import infrar as inf) back to the Infrar package
+		parts := strings.Split(call.Module, ".")
+		if mod, ok := infraImports[parts[0]]; ok {
+			// Reconstruct full module path
+			module = strings.Join(append([]string{mod}, parts[1:]...), ".")
+		} else if d.isInfrarModule(call.Module) {
 			module = call.Module
 		} else {
-			// Check if the first part matches an imported module
-			parts := strings.Split(call.Module, ".")
-			if len(parts) > 0 {
-				if mod, ok := infraImports[parts[0]]; ok {
-					// Reconstruct full module path
-					module = mod + "." + strings.Join(parts[1:], ".")
-				} else {
-					return nil
-				}
-			} else {
-				return nil
-			}
+			return nil
 		}
 	}
 
@@ -145,15 +385,44 @@ func (d *Detector) matchInfrarCall(call parser.PythonCall, infraImports map[stri
 		return nil
 	}
 
+	// Functions re-exported by the root package resolve to their
+	// capability module before any rule is looked up
+	module = types.CanonicalModule(module, d.infraPrefix)
+	if target, ok := d.reExports[call.Function]; ok && module == types.SDKPrefix {
+		module = target
+	}
+
 	// Convert to InfrarCall
 	return &types.InfrarCall{
-		Module:       module,
-		Function:     call.Function,
-		Arguments:    call.Arguments,
-		LineNumber:   call.LineNumber,
-		ColumnOffset: call.ColumnOffset,
-		SourceCode:   call.SourceCode,
+		Module:          module,
+		Function:        call.Function,
+		Language:        types.LanguagePython,
+		Arguments:       call.Arguments,
+		ArgumentOrder:   call.ArgumentOrder,
+		LineNumber:      call.LineNumber,
+		ColumnOffset:    call.ColumnOffset,
+		EndLineNumber:   call.EndLineNumber,
+		EndColumnOffset: call.EndColumnOffset,
+		SourceCode:      call.SourceCode,
+		Scope:           call.Scope,
+		Decorator:       call.Decorator,
+		InExpression:    call.Expression,
+		WithItem:        call.WithItem,
+		Spread:          call.Spread,
+		Chain:           chainFunctions(call.Chain),
+	}
+}
+
+// chainFunctions returns the names of a method chain's earlier calls
+func chainFunctions(chain []parser.PythonChainSegment) []string {
+	if len(chain) == 0 {
+		return nil
+	}
+	names := make([]string, len(chain))
+	for i, segment := range chain {
+		names[i] = segment.Function
 	}
+	return names
 }
 
 // DetectFromSource is a convenience method that parses and detects in one call
@@ -1,8 +1,11 @@
 package detector
 
 import (
+	"os"
+	"reflect"
 	"testing"
 
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
@@ -175,3 +178,594 @@ infrar.storage.upload(bucket='data', source='file.txt', destination='file.txt')
 		t.Errorf("Expected function 'upload', got '%s'", call.Function)
 	}
 }
+
+func TestDetector_AliasedCalls(t *testing.T) {
+	detector := NewDetector()
+
+	tests := []struct {
+		name      string
+		code      string
+		wantCalls int
+	}{
+		{
+			name: "Reassigned to a new name",
+			code: `
+from infrar.storage import upload
+
+fn = upload
+fn(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantCalls: 1,
+		},
+		{
+			name: "Chained aliases",
+			code: `
+from infrar.storage import upload
+
+fn = upload
+send = fn
+send(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantCalls: 1,
+		},
+		{
+			name: "Module-level alias used inside a function",
+			code: `
+from infrar.storage import upload
+
+fn = upload
+
+def backup():
+    fn(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantCalls: 1,
+		},
+		{
+			name: "Shadowed by a later non-Infrar assignment",
+			code: `
+from infrar.storage import upload
+
+fn = upload
+fn(bucket='data', source='file.txt', destination='file.txt')
+fn = print
+fn('hello')
+`,
+			wantCalls: 1,
+		},
+		{
+			name: "Shadowed by a local assignment in a function",
+			code: `
+from infrar.storage import upload
+
+fn = upload
+
+def local():
+    fn = make_uploader()
+    fn(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantCalls: 0,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, err := detector.DetectFromSource(tt.code, types.LanguagePython)
+			if err != nil {
+				t.Fatalf("DetectFromSource() error = %v", err)
+			}
+
+			if len(calls) != tt.wantCalls {
+				t.Fatalf("DetectFromSource() got %d calls, want %d", len(calls), tt.wantCalls)
+			}
+
+			for _, call := range calls {
+				if call.FullName() != "infrar.storage.upload" {
+					t.Errorf("Expected alias to resolve to infrar.storage.upload, got %s", call.FullName())
+				}
+			}
+		})
+	}
+}
+
+func TestDetector_ResolveConstants(t *testing.T) {
+	detector := NewDetector()
+	detector.SetResolveConstants(true)
+
+	tests := []struct {
+		name string
+		code string
+		want *types.Value // Resolved literal for the bucket argument
+	}{
+		{
+			name: "Module-level string constant",
+			code: `
+from infrar.storage import upload
+
+BUCKET = 'data'
+upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+`,
+			want: &types.Value{Type: types.ValueTypeString, Value: "data"},
+		},
+		{
+			name: "Constant used inside a function",
+			code: `
+from infrar.storage import upload
+
+BUCKET: str = 'data'
+
+def backup():
+    upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+`,
+			want: &types.Value{Type: types.ValueTypeString, Value: "data"},
+		},
+		{
+			name: "Number constant",
+			code: `
+from infrar.storage import upload
+
+BUCKET = 42
+upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+`,
+			want: &types.Value{Type: types.ValueTypeNumber, Value: float64(42)},
+		},
+		{
+			name: "Computed value",
+			code: `
+import os
+from infrar.storage import upload
+
+BUCKET = os.environ['BUCKET']
+upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+`,
+		},
+		{
+			name: "Reassigned constant",
+			code: `
+from infrar.storage import upload
+
+BUCKET = 'data'
+BUCKET += '-backup'
+upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+`,
+		},
+		{
+			name: "Shadowed by a local assignment",
+			code: `
+from infrar.storage import upload
+
+BUCKET = 'data'
+
+def backup():
+    BUCKET = pick_bucket()
+    upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+`,
+		},
+		{
+			name: "Assigned after the call",
+			code: `
+from infrar.storage import upload
+
+upload(bucket=BUCKET, source='file.txt', destination='file.txt')
+BUCKET = 'data'
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, err := detector.DetectFromSource(tt.code, types.LanguagePython)
+			if err != nil {
+				t.Fatalf("DetectFromSource() error = %v", err)
+			}
+			if len(calls) != 1 {
+				t.Fatalf("DetectFromSource() got %d calls, want 1", len(calls))
+			}
+
+			bucket := calls[0].Arguments["bucket"]
+			if bucket.Type != types.ValueTypeVariable || bucket.Value != "BUCKET" {
+				t.Errorf("Expected the variable form to be kept, got %+v", bucket)
+			}
+			if !reflect.DeepEqual(bucket.Resolved, tt.want) {
+				t.Errorf("Resolved = %+v, want %+v", bucket.Resolved, tt.want)
+			}
+		})
+	}
+
+	// Resolution is opt-in
+	calls, err := NewDetector().DetectFromSource(tests[0].code, types.LanguagePython)
+	if err != nil {
+		t.Fatalf("DetectFromSource() error = %v", err)
+	}
+	if resolved := calls[0].Arguments["bucket"].Resolved; resolved != nil {
+		t.Errorf("Expected no resolution by default, got %+v", resolved)
+	}
+}
+
+func TestDetector_NestedCalls(t *testing.T) {
+	detector := NewDetector()
+
+	code := `
+import os
+from infrar.storage import upload, download
+
+upload(bucket='data', source=download(bucket='src', path='f'), destination=os.path.basename('f'))
+`
+
+	calls, err := detector.DetectFromSource(code, types.LanguagePython)
+	if err != nil {
+		t.Fatalf("DetectFromSource() error = %v", err)
+	}
+
+	if len(calls) != 2 {
+		t.Fatalf("Expected outer and inner calls, got %d", len(calls))
+	}
+
+	var outer *types.InfrarCall
+	for i := range calls {
+		if calls[i].Function == "upload" {
+			outer = &calls[i]
+		}
+	}
+	if outer == nil {
+		t.Fatal("upload call not detected")
+	}
+
+	source := outer.Arguments["source"]
+	if source.Type != types.ValueTypeCall || source.Call == nil {
+		t.Fatalf("Expected source to hold a linked call, got %+v", source)
+	}
+	if source.Call.FullName() != "infrar.storage.download" {
+		t.Errorf("Nested call = %s, want infrar.storage.download", source.Call.FullName())
+	}
+	if got := source.Call.Arguments["bucket"].String(); got != "src" {
+		t.Errorf("Nested bucket = %q, want %q", got, "src")
+	}
+
+	destination := outer.Arguments["destination"]
+	if destination.Type != types.ValueTypeCall || destination.Call != nil {
+		t.Errorf("Expected destination to stay an opaque call, got %+v", destination)
+	}
+	if destination.String() != "os.path.basename('f')" {
+		t.Errorf("destination = %q, want the call's source text", destination.String())
+	}
+}
+
+func TestDetector_MethodChains(t *testing.T) {
+	detector := NewDetector()
+
+	code := `
+from infrar import storage
+
+storage.bucket('data').folder(path='logs').upload('a.txt', destination='b.txt')
+`
+
+	calls, err := detector.DetectFromSource(code, types.LanguagePython)
+	if err != nil {
+		t.Fatalf("DetectFromSource() error = %v", err)
+	}
+
+	// The earlier calls of the chain are part of the chained call
+	if len(calls) != 1 {
+		t.Fatalf("Expected one chained call, got %d: %+v", len(calls), calls)
+	}
+	call := calls[0]
+	if call.FullName() != "infrar.storage.bucket.folder.upload" {
+		t.Errorf("FullName() = %s, want infrar.storage.bucket.folder.upload", call.FullName())
+	}
+	if !reflect.DeepEqual(call.Chain, []string{"bucket", "folder"}) {
+		t.Errorf("Chain = %v, want [bucket folder]", call.Chain)
+	}
+
+	want := map[string]string{"bucket.arg_0": "data", "folder.path": "logs", "arg_0": "a.txt", "destination": "b.txt"}
+	if len(call.Arguments) != len(want) {
+		t.Errorf("Arguments = %+v, want %v", call.Arguments, want)
+	}
+	for param, value := range want {
+		if got := call.Arguments[param].String(); got != value {
+			t.Errorf("Arguments[%s] = %q, want %q", param, got, value)
+		}
+	}
+}
+
+func TestDetector_CustomPrefix(t *testing.T) {
+	detector := NewDetectorWithPrefix("mycorp_infra")
+
+	code := `
+import infrar.storage
+import mycorp_infra.storage as st
+from mycorp_infra.database import query
+from mycorp_infra_tools import upload
+
+st.upload(bucket='data')
+query(sql='SELECT 1')
+upload(bucket='other')
+infrar.storage.upload(bucket='ignored')
+`
+
+	calls, err := detector.DetectFromSource(code, types.LanguagePython)
+	if err != nil {
+		t.Fatalf("DetectFromSource() error = %v", err)
+	}
+
+	// Calls under the custom prefix are reported under infrar, so the usual
+	// rules match them; look-alikes and the default prefix are not detected
+	var got []string
+	for _, call := range calls {
+		got = append(got, call.FullName())
+	}
+	want := []string{"infrar.storage.upload", "infrar.database.query"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("calls = %v, want %v", got, want)
+	}
+}
+
+func TestDetector_ReExports(t *testing.T) {
+	tests := []struct {
+		name      string
+		prefix    string
+		code      string
+		reExports map[string]string
+		want      []string
+	}{
+		{
+			name: "From the root package",
+			code: `
+from infrar import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			reExports: map[string]string{"upload": "infrar.storage"},
+			want:      []string{"infrar.storage.upload"},
+		},
+		{
+			name: "Through the root package",
+			code: `
+import infrar
+
+infrar.upload(bucket='data', source='file.txt', destination='file.txt')
+infrar.storage.download(bucket='data', source='file.txt', destination='file.txt')
+`,
+			reExports: map[string]string{"upload": "infrar.storage"},
+			want:      []string{"infrar.storage.upload", "infrar.storage.download"},
+		},
+		{
+			name:   "Vendored SDK",
+			prefix: "mycorp_infra",
+			code: `
+from mycorp_infra import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			reExports: map[string]string{"upload": "infrar.storage"},
+			want:      []string{"infrar.storage.upload"},
+		},
+		{
+			name: "Not declared",
+			code: `
+import infrar
+
+infrar.upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			want: []string{"infrar.upload"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			detector := NewDetectorWithPrefix(tt.prefix)
+			detector.SetReExports(tt.reExports)
+
+			calls, err := detector.DetectFromSource(tt.code, types.LanguagePython)
+			if err != nil {
+				t.Fatalf("DetectFromSource() error = %v", err)
+			}
+
+			var got []string
+			for _, call := range calls {
+				got = append(got, call.FullName())
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("calls = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetector_RootPackageImports(t *testing.T) {
+	detector := NewDetector()
+
+	tests := []struct {
+		name       string
+		code       string
+		wantModule string // Empty when no call should be detected
+	}{
+		{
+			name: "Root package import",
+			code: `
+import infrar
+
+infrar.storage.upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantModule: "infrar.storage",
+		},
+		{
+			name: "Aliased root package",
+			code: `
+import infrar as inf
+
+inf.storage.upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantModule: "infrar.storage",
+		},
+		{
+			name: "Aliased submodule",
+			code: `
+import infrar.storage as st
+
+st.upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantModule: "infrar.storage",
+		},
+		{
+			name: "Submodule from the root package",
+			code: `
+from infrar import storage
+
+storage.upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			wantModule: "infrar.storage",
+		},
+		{
+			name: "Look-alike package",
+			code: `
+import infrar_tools
+
+infrar_tools.storage.upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls, err := detector.DetectFromSource(tt.code, types.LanguagePython)
+			if err != nil {
+				t.Fatalf("DetectFromSource() error = %v", err)
+			}
+
+			if tt.wantModule == "" {
+				if len(calls) != 0 {
+					t.Errorf("Expected no calls, got %+v", calls)
+				}
+				return
+			}
+
+			if len(calls) != 1 {
+				t.Fatalf("Expected 1 call, got %d", len(calls))
+			}
+			if calls[0].Module != tt.wantModule || calls[0].Function != "upload" {
+				t.Errorf("Detected %s, want %s.upload", calls[0].FullName(), tt.wantModule)
+			}
+		})
+	}
+}
+
+func BenchmarkDetector_DetectCalls(b *testing.B) {
+	source, err := os.ReadFile("../../testdata/benchmark.py")
+	if err != nil {
+		b.Fatalf("Failed to read fixture: %v", err)
+	}
+	p, err := parser.NewPythonParser()
+	if err != nil {
+		b.Fatalf("Failed to create parser: %v", err)
+	}
+	ast, err := p.Parse(string(source))
+	if err != nil {
+		b.Fatalf("Parse() error = %v", err)
+	}
+	detector := NewDetector()
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := detector.DetectCalls(ast); err != nil {
+			b.Fatalf("DetectCalls() error = %v", err)
+		}
+	}
+}
+
+func TestDetector_Decorators(t *testing.T) {
+	code := `
+from infrar.events import on, handler
+
+class Jobs:
+    @on('upload', retries=3)
+    def uploaded(self, event):
+        pass
+
+    @handler
+    def fallback(self, event):
+        pass
+`
+
+	calls, err := NewDetector().DetectFromSource(code, types.LanguagePython)
+	if err != nil {
+		t.Fatalf("DetectFromSource() error = %v", err)
+	}
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 decorator calls, got %d", len(calls))
+	}
+
+	byFunction := make(map[string]types.InfrarCall)
+	for _, call := range calls {
+		byFunction[call.Function] = call
+	}
+
+	on := byFunction["on"]
+	if !on.Decorator || on.LineNumber != 5 || on.ColumnOffset != 5 || on.Scope != "Jobs" {
+		t.Errorf("Unexpected decorator call: %+v", on)
+	}
+	if on.Arguments["arg_0"].Value != "upload" || on.Arguments["retries"].Value != float64(3) {
+		t.Errorf("Unexpected decorator arguments: %+v", on.Arguments)
+	}
+
+	bare := byFunction["handler"]
+	if !bare.Decorator || bare.SourceCode != "handler" || len(bare.Arguments) != 0 || bare.EndColumnOffset != 12 {
+		t.Errorf("Unexpected bare decorator: %+v", bare)
+	}
+}
+
+func TestDetector_UnusedImports(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   string
+		imp    types.Import
+		unused bool
+	}{
+		{
+			name:   "Imported name never used",
+			code:   "from infrar.storage import upload\n\nprint('hi')\n",
+			imp:    types.Import{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1, TopLevel: true},
+			unused: true,
+		},
+		{
+			name: "Name passed around without a call",
+			code: "from infrar.storage import upload\n\nhandlers = [upload]\n",
+			imp:  types.Import{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1, TopLevel: true},
+		},
+		{
+			name: "Module import used",
+			code: "import infrar.storage\n\nprint(infrar.storage)\n",
+			imp:  types.Import{Module: "infrar.storage", Names: []string{"infrar.storage"}, LineNumber: 1, TopLevel: true},
+		},
+		{
+			name:   "Aliased module import unused",
+			code:   "import infrar.storage as st\n",
+			imp:    types.Import{Module: "infrar.storage", Names: []string{"infrar.storage"}, Alias: "st", LineNumber: 1, TopLevel: true},
+			unused: true,
+		},
+		{
+			name: "Renamed name is left alone",
+			code: "from infrar.storage import upload as up\n",
+			imp:  types.Import{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1, TopLevel: true},
+		},
+		{
+			name: "Import inside a function is left alone",
+			code: "def f():\n    from infrar.storage import upload\n",
+			imp:  types.Import{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 2},
+		},
+		{
+			name: "Other packages are left alone",
+			code: "from os import path\n",
+			imp:  types.Import{Module: "os", Names: []string{"path"}, LineNumber: 1, TopLevel: true},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unused := NewDetector().unusedImports([]types.Import{tt.imp}, tt.code)
+			if got := len(unused) == 1; got != tt.unused {
+				t.Errorf("unusedImports() = %v, want unused %v", unused, tt.unused)
+			}
+		})
+	}
+}
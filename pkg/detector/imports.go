@@ -0,0 +1,96 @@
+package detector
+
+import (
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// unusedImports returns the top-level Infrar imports none of whose bound
+// names appear anywhere else in source, such as a leftover
+// `from infrar.storage import upload` with no upload call. Names are matched
+// against parser.Words, as the generator prunes imports, outside import
+// statements, comments and strings included, so an import is only reported
+// when nothing can rely on it.
+func (d *Detector) unusedImports(imports []types.Import, source string) []types.Import {
+	lines := strings.Split(source, "\n")
+
+	// The rest of the source, without import statements
+	isImport := make(map[int]bool)
+	for _, imp := range imports {
+		for line := imp.LineNumber; line <= max(imp.LineNumber, imp.EndLineNumber); line++ {
+			isImport[line] = true
+		}
+	}
+	var rest strings.Builder
+	for i, line := range lines {
+		if !isImport[i+1] {
+			rest.WriteString(line + "\n")
+		}
+	}
+
+	words := parser.Words(rest.String())
+
+	var unused []types.Import
+	for _, imp := range imports {
+		if !imp.TopLevel || !d.isInfrarModule(imp.Module) {
+			continue
+		}
+
+		names, ok := boundNames(imp, statementText(lines, imp))
+		if !ok {
+			continue
+		}
+
+		used := false
+		for _, name := range names {
+			if words[name] {
+				used = true
+				break
+			}
+		}
+		if !used {
+			unused = append(unused, imp)
+		}
+	}
+	return unused
+}
+
+// statementText returns the source lines of an import statement
+func statementText(lines []string, imp types.Import) string {
+	end := max(imp.LineNumber, imp.EndLineNumber)
+	if imp.LineNumber < 1 || end > len(lines) {
+		return ""
+	}
+	return strings.Join(lines[imp.LineNumber-1:end], "\n")
+}
+
+// boundNames returns the names an import statement binds. It reports false
+// when they cannot be told from the parsed import: star imports, renamed
+// names and statements sharing a line with other code.
+func boundNames(imp types.Import, text string) ([]string, bool) {
+	if text == "" || strings.Contains(text, ";") {
+		return nil, false
+	}
+
+	// import infrar.storage [as st]
+	if len(imp.Names) == 1 && imp.Names[0] == imp.Module {
+		if imp.Alias != "" {
+			return []string{imp.Alias}, true
+		}
+		root, _, _ := strings.Cut(imp.Module, ".")
+		return []string{root}, true
+	}
+
+	// from infrar.storage import upload, download
+	if strings.Contains(text, " as ") {
+		return nil, false
+	}
+	for _, name := range imp.Names {
+		if name == "*" {
+			return nil, false
+		}
+	}
+	return imp.Names, len(imp.Names) > 0
+}
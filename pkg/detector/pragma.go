@@ -0,0 +1,43 @@
+package detector
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// providerPragma matches a comment line such as "# infrar: provider=gcp"
+var providerPragma = regexp.MustCompile(`^#\s*infrar:\s*provider\s*=\s*(\S+)\s*$`)
+
+// providerPragmas maps each line that follows a provider pragma to the
+// provider it names, so the call on that line can be routed to it. Pragmas
+// are taken from the comments the parser reports, so pragma-like text in a
+// string or docstring is not one.
+func providerPragmas(comments []parser.PythonComment) (map[int]types.Provider, error) {
+	pragmas := make(map[int]types.Provider)
+	for _, comment := range comments {
+		if !comment.OwnLine {
+			continue
+		}
+		m := providerPragma.FindStringSubmatch(strings.TrimSpace(comment.Text))
+		if m == nil {
+			continue
+		}
+
+		provider, err := types.ParseProvider(m[1])
+		if err != nil {
+			return nil, &types.TransformationError{
+				Category:   types.ErrorCategoryDetection,
+				Message:    fmt.Sprintf("invalid provider pragma: %v", err),
+				Line:       comment.LineNumber,
+				SourceCode: strings.TrimSpace(comment.Text),
+				Suggestion: "Use one of the supported providers, e.g. # infrar: provider=gcp",
+			}
+		}
+		pragmas[comment.LineNumber+1] = provider // The pragma applies to the next line
+	}
+	return pragmas, nil
+}
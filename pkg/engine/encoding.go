@@ -0,0 +1,158 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// utf8BOM is the byte order mark some editors put at the start of UTF-8 files
+const utf8BOM = "\ufeff"
+
+// codingDeclaration matches a PEP 263 encoding declaration, e.g.
+// `# -*- coding: latin-1 -*-`, which must be on the first or second line
+var codingDeclaration = regexp.MustCompile(`^[ \t\f]*#.*?coding[:=][ \t]*([-\w.]+)`)
+
+// encodingKey is the AST metadata key holding the sourceEncoding of a source
+// that was not plain UTF-8
+const encodingKey = "source_encoding"
+
+// latin1Names are the names Python accepts for the latin-1 codec
+var latin1Names = map[string]bool{
+	"latin-1": true, "latin1": true, "latin": true, "l1": true,
+	"iso-8859-1": true, "iso8859-1": true, "8859": true, "cp819": true, "iso-latin-1": true,
+}
+
+// sourceEncoding records how a source was encoded, so the generated code can
+// be written back the same way
+type sourceEncoding struct {
+	bom    bool // Source started with a UTF-8 byte order mark
+	latin1 bool // Source declared latin-1 and was transcoded to UTF-8
+}
+
+// name returns the Python codec name of the encoding, or "" for plain UTF-8
+func (enc sourceEncoding) name() string {
+	switch {
+	case enc.latin1:
+		return "latin-1"
+	case enc.bom:
+		return "utf-8-sig"
+	default:
+		return ""
+	}
+}
+
+// decodeSource returns source as UTF-8 without a byte order mark, the form
+// the parsers and generator work on, with how it was encoded. Line numbers
+// are unchanged. A source declaring an encoding other than UTF-8 or latin-1
+// is only accepted when it is valid UTF-8 anyway, e.g. plain ASCII.
+func decodeSource(source string) (string, sourceEncoding, error) {
+	var enc sourceEncoding
+	if strings.HasPrefix(source, utf8BOM) {
+		source = strings.TrimPrefix(source, utf8BOM)
+		enc.bom = true
+	}
+
+	declared, line := declaredEncoding(source)
+	switch {
+	case declared == "" || declared == "utf-8" || declared == "utf8" || declared == "utf-8-sig":
+		return source, enc, nil
+
+	case latin1Names[declared] && !enc.bom:
+		var sb strings.Builder
+		sb.Grow(len(source))
+		for i := 0; i < len(source); i++ {
+			sb.WriteRune(rune(source[i]))
+		}
+		enc.latin1 = true
+		return sb.String(), enc, nil
+
+	case utf8.ValidString(source):
+		return source, enc, nil
+
+	default:
+		return "", enc, &types.TransformationError{
+			Category:   types.ErrorCategoryParse,
+			Message:    fmt.Sprintf("unsupported source encoding %s", declared),
+			Line:       line,
+			Suggestion: "Convert the file to UTF-8 or latin-1 and update its coding declaration",
+		}
+	}
+}
+
+// declaredEncoding returns the normalized encoding a PEP 263 declaration on
+// the first two lines names, and its line
+func declaredEncoding(source string) (string, int) {
+	lines := strings.SplitN(source, "\n", 3)
+	for i, line := range lines[:min(2, len(lines))] {
+		if match := codingDeclaration.FindStringSubmatch(line); match != nil {
+			return strings.ReplaceAll(strings.ToLower(match[1]), "_", "-"), i + 1
+		}
+		// The declaration may only follow a comment or blank line
+		if trimmed := strings.TrimSpace(line); trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			break
+		}
+	}
+	return "", 0
+}
+
+// recordEncoding keeps the encoding of the source ast was parsed from in its
+// metadata, for encodeResult, unless it was plain UTF-8
+func recordEncoding(ast *types.AST, enc sourceEncoding) {
+	if enc == (sourceEncoding{}) {
+		return
+	}
+	if ast.Metadata == nil {
+		ast.Metadata = make(map[string]any)
+	}
+	ast.Metadata[encodingKey] = enc
+}
+
+// encodeSource converts generated UTF-8 code back to the source's encoding.
+// Code the encoding cannot represent, such as non-latin-1 characters from a
+// rule template, is an error.
+func encodeSource(code string, enc sourceEncoding) (string, error) {
+	if enc.latin1 {
+		buf := make([]byte, 0, len(code))
+		for i, r := range code {
+			if r > 0xff {
+				line := strings.Count(code[:i], "\n") + 1
+				return "", &types.TransformationError{
+					Category:   types.ErrorCategoryGeneration,
+					Message:    fmt.Sprintf("generated code has %q on line %d, which latin-1 cannot encode", r, line),
+					Line:       line,
+					Suggestion: "Convert the file to UTF-8, or keep rule templates to latin-1 characters",
+				}
+			}
+			buf = append(buf, byte(r))
+		}
+		code = string(buf)
+	}
+	if enc.bom {
+		code = utf8BOM + code
+	}
+	return code, nil
+}
+
+// encodeResult converts a result's code back to the encoding of the source
+// ast was parsed from, recording the encoding in the result's metadata
+func encodeResult(ast *types.AST, result *types.TransformationResult) error {
+	enc, ok := ast.Metadata[encodingKey].(sourceEncoding)
+	if !ok {
+		return nil
+	}
+
+	code, err := encodeSource(result.TransformedCode, enc)
+	if err != nil {
+		return err
+	}
+	result.TransformedCode = code
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]any)
+	}
+	result.Metadata["encoding"] = enc.name()
+	return nil
+}
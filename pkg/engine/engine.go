@@ -1,9 +1,18 @@
 package engine
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/QodeSrl/infrar-engine/pkg/detector"
+	"github.com/QodeSrl/infrar-engine/pkg/formatter"
 	"github.com/QodeSrl/infrar-engine/pkg/generator"
 	"github.com/QodeSrl/infrar-engine/pkg/parser"
 	"github.com/QodeSrl/infrar-engine/pkg/plugin"
@@ -12,40 +21,203 @@ import (
 	"github.com/QodeSrl/infrar-engine/pkg/validator"
 )
 
+// validationCacheSize bounds how many validation outcomes the engine keeps,
+// so re-transforming unchanged code (as in watch mode) skips the Python check
+const validationCacheSize = 128
+
 // Engine is the main transformation engine
 type Engine struct {
-	parser    parser.Parser
-	detector  *detector.Detector
-	registry  *plugin.Registry
-	validator *validator.Validator
+	parsers    map[types.Language]parser.Parser
+	detector   *detector.Detector
+	registry   *plugin.Registry
+	named      *namedRegistries // Rule sets TransformWith selects by name
+	pragmas    *pragmaRules     // Rules loaded for provider pragmas, nil when off
+	validator  *validator.Validator
+	formatter  *formatter.Formatter
+	lenient    bool
+	checkNames bool
+	prune      bool
+	share      bool
+	style      types.StyleOptions
+	keepImport bool
+	sourceMap  bool
+	sdkPrefix  string
+	noValidate bool
+	bestEffort bool
+	strict     bool
+	noOpCheck  bool
+	hooks      []Hook
+	fallbacks  []types.Provider
 }
 
-// New creates a new transformation engine
+// Hook is a post-processing step run on each generated result, see Engine.Use
+type Hook func(*types.TransformationResult) error
+
+// New creates a new transformation engine with the default configuration
 func New() (*Engine, error) {
-	// Create Python parser
-	pythonParser, err := parser.NewPythonParser()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create parser: %w", err)
-	}
+	return NewWithOptions()
+}
 
-	// Create detector
-	det := detector.NewDetector()
+// NewWithPython creates a transformation engine whose parser and validator
+// run the given Python interpreter. An empty python falls back to
+// $INFRAR_PYTHON, the active virtualenv, then python3 or python on PATH.
+func NewWithPython(python string) (*Engine, error) {
+	return NewWithOptions(WithPythonPath(python))
+}
 
-	// Create registry
-	reg := plugin.NewRegistry()
+// WithLenient enables lenient mode: Infrar calls without a matching rule are
+// left unchanged and reported in TransformationResult.Warnings instead of
+// failing the transformation
+func (e *Engine) WithLenient(lenient bool) *Engine {
+	e.lenient = lenient
+	return e
+}
 
-	// Create validator
-	val, err := validator.NewValidator()
-	if err != nil {
-		return nil, fmt.Errorf("failed to create validator: %w", err)
+// WithNameCheck enables an extra validation pass that warns when a
+// transformed call reads a name no import, setup code or assignment defines,
+// which usually points at a rule with missing setup_code
+func (e *Engine) WithNameCheck(check bool) *Engine {
+	e.checkNames = check
+	return e
+}
+
+// WithFormatter enables running an installed code formatter (black or
+// autopep8 for Python, prettier for Node) over the generated code. When no
+// formatter is installed, or formatting would produce invalid code, the
+// unformatted output is kept and a warning is added.
+func (e *Engine) WithFormatter(enabled bool) *Engine {
+	if enabled {
+		e.formatter = formatter.NewFormatter()
+	} else {
+		e.formatter = nil
 	}
+	return e
+}
+
+// WithConstantResolution attaches the literal value of module-level
+// constants to variable arguments (Value.Resolved) so rules can inspect it.
+// Generated code still references the variable.
+func (e *Engine) WithConstantResolution(resolve bool) *Engine {
+	e.detector.SetResolveConstants(resolve)
+	return e
+}
+
+// WithReExports declares the functions the SDK's root package re-exports,
+// mapped to the capability module they come from, e.g.
+// {"upload": "infrar.storage"}, so `from infrar import upload` matches the
+// infrar.storage.upload rule
+func (e *Engine) WithReExports(reExports map[string]string) *Engine {
+	e.detector.SetReExports(reExports)
+	return e
+}
+
+// WithValidation controls whether generated code is syntax-checked (on by
+// default). With validation off the generated code is returned as is, which
+// helps when debugging a rule or emitting code Python cannot compile. The
+// name check also depends on valid code, so it is skipped too.
+func (e *Engine) WithValidation(validate bool) *Engine {
+	e.noValidate = !validate
+	return e
+}
+
+// WithBestEffort records a failed validation as a warning (category
+// "validation") instead of returning an error, so the generated code can be
+// inspected and the rest of a batch keeps going
+func (e *Engine) WithBestEffort(bestEffort bool) *Engine {
+	e.bestEffort = bestEffort
+	return e
+}
+
+// WithStrict enables strict mode: a transform that produces any warning
+// (a call without a rule, a deprecated operation, ...) fails with a
+// *types.WarningsError listing them, for CI gates that demand full coverage.
+// The result is still returned with the error. Informational notices, such
+// as a file having no Infrar calls, do not count.
+func (e *Engine) WithStrict(strict bool) *Engine {
+	e.strict = strict
+	return e
+}
+
+// WithProviderFallback sets providers whose rules are used, in order, for
+// calls the target provider has no rule for, e.g. an S3-compatible AWS rule
+// for a partially supported target. Each such call is reported with a
+// "fallback" warning; the rule's imports and setup code come along with it.
+func (e *Engine) WithProviderFallback(providers []types.Provider) *Engine {
+	e.fallbacks = providers
+	return e
+}
+
+// WithImportPruning drops rule imports whose names the generated code never
+// uses, such as imports only needed by template branches that did not fire.
+// Imports already in the source are kept.
+func (e *Engine) WithImportPruning(prune bool) *Engine {
+	e.prune = prune
+	return e
+}
+
+// WithSharedClients hoists the clients of rules marked shareable into their
+// setup code, bound once and used by every call, instead of creating one at
+// each call site
+func (e *Engine) WithSharedClients(share bool) *Engine {
+	e.share = share
+	return e
+}
+
+// WithStyle sets the style of the generated code: the quote used for string
+// literals, the indentation of multi-line templates and the line length
+// beyond which a call gets a style warning. The zero StyleOptions is the
+// default single-quote style.
+func (e *Engine) WithStyle(style types.StyleOptions) *Engine {
+	e.style = style
+	return e
+}
+
+// WithImportComments keeps the Infrar imports a transform replaces as
+// `# migrated: from infrar.storage import upload` comments, for reviewers
+// to trace the migration, instead of deleting them
+func (e *Engine) WithImportComments(keep bool) *Engine {
+	e.keepImport = keep
+	return e
+}
+
+// WithSourceMap adds a source map to each result's metadata, linking every
+// original line to the generated lines it became, for debugging generated
+// code (see generator.SourceMapKey). It describes the generated code before
+// formatting, so it is dropped when the formatter rewrites the code.
+func (e *Engine) WithSourceMap(enabled bool) *Engine {
+	e.sourceMap = enabled
+	return e
+}
+
+// Use adds a hook run on every result after code generation and before
+// validation, in the order added. Hooks may change the result, e.g. add a
+// license header to TransformedCode; an error from a hook aborts the
+// transform. TransformStream writes the code before any hook could see it,
+// so it fails on an engine with hooks rather than skip them.
+func (e *Engine) Use(hook Hook) *Engine {
+	e.hooks = append(e.hooks, hook)
+	return e
+}
+
+// RegisterParser registers the parser used for source code in lang,
+// replacing any parser previously registered for it
+func (e *Engine) RegisterParser(lang types.Language, p parser.Parser) {
+	e.parsers[lang] = p
+}
+
+// HasParser reports whether a parser is registered for lang
+func (e *Engine) HasParser(lang types.Language) bool {
+	_, ok := e.parsers[lang]
+	return ok
+}
 
-	return &Engine{
-		parser:    pythonParser,
-		detector:  det,
-		registry:  reg,
-		validator: val,
-	}, nil
+// parserFor returns the parser registered for lang
+func (e *Engine) parserFor(lang types.Language) (parser.Parser, error) {
+	p, ok := e.parsers[lang]
+	if !ok {
+		return nil, fmt.Errorf("no parser registered for language %s", lang)
+	}
+	return p, nil
 }
 
 // LoadRules loads transformation rules from a plugin directory
@@ -62,50 +234,794 @@ func (e *Engine) LoadRules(pluginDir string, provider types.Provider, capability
 	return nil
 }
 
+// LoadAllRules loads transformation rules for every capability in a plugin directory
+func (e *Engine) LoadAllRules(pluginDir string, provider types.Provider) error {
+	loader := plugin.NewLoader(pluginDir)
+
+	allRules, err := loader.LoadAllRules(provider)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	for _, rules := range allRules {
+		e.registry.RegisterMultiple(rules)
+	}
+
+	return nil
+}
+
+// LoadBundle loads the rules of a bundle written by plugin.Loader.CompileBundle,
+// a JSON snapshot of validated rules, skipping YAML parsing. When a rules file
+// changed since the bundle was built, nothing is loaded and the error wraps plugin.ErrStaleBundle, so
+// the caller can load the plugin directory with LoadAllRules instead.
+func (e *Engine) LoadBundle(path string) error {
+	rules, err := plugin.LoadBundle(path)
+	if err != nil {
+		return fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	e.registry.RegisterMultiple(rules)
+
+	return nil
+}
+
 // Transform transforms source code from Infrar SDK to provider SDK
 func (e *Engine) Transform(sourceCode string, targetProvider types.Provider) (*types.TransformationResult, error) {
-	// Step 1: Parse source code
-	ast, err := e.parser.Parse(sourceCode)
+	return e.TransformContext(context.Background(), sourceCode, targetProvider)
+}
+
+// TransformContext transforms source code from Infrar SDK to provider SDK.
+// Cancelling ctx or reaching its deadline aborts the parser and validator
+// subprocesses and returns ctx's error.
+func (e *Engine) TransformContext(ctx context.Context, sourceCode string, targetProvider types.Provider) (*types.TransformationResult, error) {
+	return e.TransformLanguage(ctx, sourceCode, types.LanguagePython, targetProvider)
+}
+
+// TransformLanguage transforms source code written in the given language,
+// using the parser registered for it. When the generated code fails
+// validation the result is returned along with the error, so the code that
+// failed to compile can be inspected.
+func (e *Engine) TransformLanguage(ctx context.Context, sourceCode string, language types.Language, targetProvider types.Provider) (*types.TransformationResult, error) {
+	var metrics types.Metrics
+	ast, calls, err := e.parseAndDetect(ctx, sourceCode, language, &metrics)
 	if err != nil {
 		return nil, err
 	}
 
+	return e.generate(ctx, ast, calls, targetProvider, e.lenient, metrics)
+}
+
+// TransformStream transforms Python source read from r and writes the result
+// to w as it is generated, without building the transformed code in memory.
+// The source itself is still read whole, since the parser needs all of it.
+// Because the output never exists as a single string it is not validated,
+// formatted or name-checked; the returned result carries everything else
+// (imports, requirements, warnings, report) with an empty TransformedCode.
+// The code is written as UTF-8, whatever the source's encoding.
+func (e *Engine) TransformStream(r io.Reader, w io.Writer, targetProvider types.Provider) (*types.TransformationResult, error) {
+	if len(e.hooks) > 0 {
+		return nil, fmt.Errorf("cannot stream with %d hook(s) added by Use, which need the whole generated code; use Transform instead", len(e.hooks))
+	}
+
+	var source strings.Builder
+	if _, err := io.Copy(&source, r); err != nil {
+		return nil, fmt.Errorf("failed to read source: %w", err)
+	}
+
+	var metrics types.Metrics
+	ast, calls, err := e.parseAndDetect(context.Background(), source.String(), types.LanguagePython, &metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	start := time.Now()
+	gen, trans, transformedCalls, err := e.transformCalls(calls, targetProvider, e.lenient)
+	if err != nil {
+		return nil, err
+	}
+	metrics.Transform = time.Since(start)
+
+	start = time.Now()
+	bw := bufio.NewWriter(w)
+	result, err := gen.GenerateTo(ast, transformedCalls, bw)
+	if err != nil {
+		return nil, err
+	}
+	if err := bw.Flush(); err != nil {
+		return nil, fmt.Errorf("failed to write generated code: %w", err)
+	}
+	metrics.Generate = time.Since(start)
+
+	result.Warnings = append(result.Warnings, trans.Warnings()...)
+	result.Warnings = append(result.Warnings, syntaxWarnings(ast)...)
+	result.Gaps = findGaps(calls, transformedCalls)
+	if e.noOpCheck {
+		result.Warnings = append(result.Warnings, echoWarnings(result.Report)...)
+	}
+	result.Metrics = finishMetrics(metrics, transformedCalls)
+
+	return result, e.strictError(result)
+}
+
+// TransformAll transforms source code for several providers at once, reusing a
+// single parse and detection pass. Calls a provider has no rule for are left
+// unchanged and reported in that provider's warnings, so coverage gaps show
+// up side by side instead of failing the comparison, in strict mode too. All
+// providers see the rules loaded when the call starts.
+func (e *Engine) TransformAll(sourceCode string, providers []types.Provider) (map[types.Provider]*types.TransformationResult, error) {
+	var metrics types.Metrics
+	ast, calls, err := e.parseAndDetect(context.Background(), sourceCode, types.LanguagePython, &metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	batch := e.snapshot()
+	results := make(map[types.Provider]*types.TransformationResult, len(providers))
+	for _, provider := range providers {
+		result, err := batch.generate(context.Background(), ast, calls, provider, true, metrics)
+		var warningsErr *types.WarningsError
+		if err != nil && !errors.As(err, &warningsErr) {
+			return nil, fmt.Errorf("%s: %w", provider, err)
+		}
+		results[provider] = result
+	}
+
+	return results, nil
+}
+
+// Analyze parses Python source and detects its Infrar calls without
+// transforming anything, so no rules need to be loaded. The returned AST
+// carries the file's imports.
+func (e *Engine) Analyze(sourceCode string) (*types.AST, []types.InfrarCall, error) {
+	var metrics types.Metrics
+	return e.parseAndDetect(context.Background(), sourceCode, types.LanguagePython, &metrics)
+}
+
+// IsTransformed reports whether Python source is free of Infrar SDK calls,
+// i.e. fully migrated, and returns the calls still left otherwise. Like
+// Analyze it needs no rules.
+func (e *Engine) IsTransformed(sourceCode string) (bool, []types.InfrarCall, error) {
+	_, calls, err := e.Analyze(sourceCode)
+	if err != nil {
+		return false, nil, err
+	}
+	return len(calls) == 0, calls, nil
+}
+
+// parseAndDetect runs the parse and detect stages, recording their timings in metrics
+func (e *Engine) parseAndDetect(ctx context.Context, sourceCode string, language types.Language, metrics *types.Metrics) (*types.AST, []types.InfrarCall, error) {
+	p, err := e.parserFor(language)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Step 1: Parse source code, as UTF-8 without a byte order mark
+	start := time.Now()
+	source, enc, err := decodeSource(sourceCode)
+	if err != nil {
+		return nil, nil, err
+	}
+	ast, err := p.ParseContext(ctx, source)
+	if err != nil {
+		return nil, nil, err
+	}
+	recordEncoding(ast, enc)
+	metrics.Parse = time.Since(start)
+
+	calls, err := e.detect(ast, metrics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return ast, calls, nil
+}
+
+// detect runs the detect stage, recording its timing in metrics
+func (e *Engine) detect(ast *types.AST, metrics *types.Metrics) ([]types.InfrarCall, error) {
 	// Step 2: Detect Infrar calls
+	start := time.Now()
 	calls, err := e.detector.DetectCalls(ast)
 	if err != nil {
 		return nil, err
 	}
+	metrics.Detect = time.Since(start)
+	metrics.Calls = len(calls)
+
+	return calls, nil
+}
 
+// finishMetrics completes metrics once every stage has run
+func finishMetrics(metrics types.Metrics, transformedCalls []types.TransformedCall) *types.Metrics {
+	metrics.TransformedCalls = len(transformedCalls)
+	metrics.Total = metrics.Parse + metrics.Detect + metrics.Transform + metrics.Generate + metrics.Validate + metrics.Format
+	return &metrics
+}
+
+// generate runs the transform, generate and validate stages for one
+// provider. metrics holds the timings of the stages already run.
+func (e *Engine) generate(ctx context.Context, ast *types.AST, calls []types.InfrarCall, targetProvider types.Provider, lenient bool, metrics types.Metrics) (*types.TransformationResult, error) {
 	// Step 3: Transform calls
-	trans := transformer.New(e.registry)
-	transformedCalls, err := trans.TransformMultiple(calls)
+	start := time.Now()
+	gen, trans, transformedCalls, err := e.transformCalls(calls, targetProvider, lenient)
 	if err != nil {
 		return nil, err
 	}
+	metrics.Transform = time.Since(start)
 
 	// Step 4: Generate final code
-	gen := generator.New(targetProvider, e.registry)
+	start = time.Now()
 	result, err := gen.Generate(ast, transformedCalls)
 	if err != nil {
 		return nil, err
 	}
+	result.Warnings = append(result.Warnings, trans.Warnings()...)
+	result.Warnings = append(result.Warnings, syntaxWarnings(ast)...)
+	result.Gaps = findGaps(calls, transformedCalls)
+	if e.noOpCheck {
+		result.Warnings = append(result.Warnings, noOpWarnings(ast.SourceCode, result)...)
+	}
+	if err := e.runHooks(result); err != nil {
+		return nil, err
+	}
+	metrics.Generate = time.Since(start)
 
 	// Step 5: Validate generated code
-	if err := e.validator.Validate(result.TransformedCode); err != nil {
+	valid := !e.noValidate
+	if !e.noValidate {
+		start = time.Now()
+		cached, err := e.validator.ValidateCached(ctx, result.TransformedCode)
+		if err != nil {
+			var validationErr *types.TransformationError
+			if ctx.Err() != nil || !errors.As(err, &validationErr) {
+				return nil, err
+			}
+
+			metrics.Validate = time.Since(start)
+			if !e.bestEffort {
+				result.Metrics = finishMetrics(metrics, transformedCalls)
+				if encErr := encodeResult(ast, result); encErr != nil {
+					return nil, encErr
+				}
+				return result, err
+			}
+
+			valid = false
+			result.Warnings = append(result.Warnings, types.Warning{
+				Message:  "generated code is invalid: " + validationErr.Message,
+				Category: "validation",
+			})
+		}
+		if e.validator.Caching() {
+			metrics.ValidationCache = "miss"
+			if cached {
+				metrics.ValidationCache = "hit"
+			}
+		}
+		metrics.Validate = time.Since(start)
+	}
+
+	// Step 6: Format generated code
+	if e.formatter != nil {
+		start = time.Now()
+		if err := e.formatResult(ctx, ast.Language, result); err != nil {
+			return nil, err
+		}
+		metrics.Format = time.Since(start)
+	}
+
+	if e.checkNames && valid && len(result.Report) > 0 {
+		start = time.Now()
+		if err := e.checkUndefinedNames(ctx, result); err != nil {
+			return nil, err
+		}
+		metrics.Validate += time.Since(start)
+	}
+
+	// Write the code back in the source's encoding
+	if err := encodeResult(ast, result); err != nil {
 		return nil, err
 	}
 
-	return result, nil
+	result.Metrics = finishMetrics(metrics, transformedCalls)
+	return result, e.strictError(result)
+}
+
+// syntaxWarnings returns the warnings Python emitted about the source, e.g.
+// for invalid escape sequences, which often point at latent bugs
+func syntaxWarnings(ast *types.AST) []types.Warning {
+	warnings, _ := ast.Metadata[parser.SyntaxWarningsKey].([]types.Warning)
+	return warnings
+}
+
+// runHooks runs the hooks added with Use on result
+func (e *Engine) runHooks(result *types.TransformationResult) error {
+	for i, hook := range e.hooks {
+		if err := hook(result); err != nil {
+			return &types.TransformationError{
+				Category: types.ErrorCategoryGeneration,
+				Message:  fmt.Sprintf("post-processing hook %d failed: %v", i+1, err),
+				Cause:    err,
+			}
+		}
+	}
+	return nil
+}
+
+// strictError returns the warnings of result as an error in strict mode
+func (e *Engine) strictError(result *types.TransformationResult) error {
+	if !e.strict {
+		return nil
+	}
+
+	var warnings []types.Warning
+	for _, w := range result.Warnings {
+		if w.Category != "info" {
+			warnings = append(warnings, w)
+		}
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+	return &types.WarningsError{Warnings: warnings}
+}
+
+// snapshot returns a copy of the engine working from a snapshot of its
+// rules, so every transform of a batch sees the same rules even if they are
+// reloaded while it runs
+func (e *Engine) snapshot() *Engine {
+	batch := *e
+	batch.registry = e.registry.Snapshot()
+	return &batch
+}
+
+// transformCalls runs the transform stage for one provider and returns a
+// generator set up for its output
+func (e *Engine) transformCalls(calls []types.InfrarCall, targetProvider types.Provider, lenient bool) (*generator.Generator, *transformer.Transformer, []types.TransformedCall, error) {
+	// Both stages read one snapshot, so rules reloaded meanwhile cannot
+	// change between them
+	snapshot := e.registry.Snapshot()
+	if err := e.addPragmaRules(snapshot, calls, targetProvider); err != nil {
+		return nil, nil, nil, err
+	}
+	registry := snapshot.ForProvider(targetProvider).WithFallback(e.fallbacks...)
+	trans := transformer.New(registry)
+	trans.SetLenient(lenient)
+	trans.SetShareClients(e.share)
+	trans.SetStyle(e.style)
+	transformedCalls, err := trans.TransformMultiple(calls)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	gen := generator.New(targetProvider, registry)
+	gen.PreserveImportsFor(trans.Unmatched())
+	gen.SetPruneImports(e.prune)
+	gen.SetShareClients(e.share)
+	gen.SetStyle(e.style)
+	gen.SetCommentOutImports(e.keepImport)
+	gen.SetSDKPrefix(e.sdkPrefix)
+	gen.SetSourceMap(e.sourceMap)
+
+	return gen, trans, transformedCalls, nil
 }
 
-// TransformFile transforms a file
+// findGaps returns the detected calls that did not come out of the
+// transformer, which in lenient mode are the calls without a rule
+func findGaps(calls []types.InfrarCall, transformedCalls []types.TransformedCall) []types.CallGap {
+	type position struct {
+		line, column int
+		name         string
+	}
+
+	done := make(map[position]bool, len(transformedCalls))
+	for _, tc := range transformedCalls {
+		call := tc.OriginalCall
+		done[position{call.LineNumber, call.ColumnOffset, call.FullName()}] = true
+	}
+
+	var gaps []types.CallGap
+	for _, call := range calls {
+		if !done[position{call.LineNumber, call.ColumnOffset, call.FullName()}] {
+			gaps = append(gaps, types.CallGap{
+				Pattern:    call.FullName(),
+				LineNumber: call.LineNumber,
+				SourceCode: call.SourceCode,
+			})
+		}
+	}
+
+	return gaps
+}
+
+// formatResult runs the formatter over the generated code, keeping the
+// unformatted code when formatting fails or breaks the syntax
+func (e *Engine) formatResult(ctx context.Context, lang types.Language, result *types.TransformationResult) error {
+	formatted, tool, err := e.formatter.Format(ctx, result.TransformedCode, lang)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+
+	switch {
+	case err != nil:
+		result.Warnings = append(result.Warnings, types.Warning{
+			Message:  fmt.Sprintf("formatting skipped: %v", err),
+			Category: "format",
+		})
+	case tool == "":
+		result.Warnings = append(result.Warnings, types.Warning{
+			Message:  fmt.Sprintf("no formatter installed for %s - output left unformatted", lang),
+			Category: "format",
+		})
+	default:
+		if err := e.validator.ValidateContext(ctx, formatted); err != nil {
+			if ctxErr := ctx.Err(); ctxErr != nil {
+				return ctxErr
+			}
+			result.Warnings = append(result.Warnings, types.Warning{
+				Message:  fmt.Sprintf("%s produced invalid code - output left unformatted", tool),
+				Category: "format",
+			})
+			return nil
+		}
+		if _, ok := result.Metadata[generator.SourceMapKey]; ok && formatted != result.TransformedCode {
+			delete(result.Metadata, generator.SourceMapKey)
+			result.Warnings = append(result.Warnings, types.Warning{
+				Message:  fmt.Sprintf("%s reformatted the code - source map dropped", tool),
+				Category: "format",
+			})
+		}
+		result.TransformedCode = formatted
+	}
+
+	return nil
+}
+
+// checkUndefinedNames adds a warning for every name a transformed call reads
+// that the generated code never defines
+func (e *Engine) checkUndefinedNames(ctx context.Context, result *types.TransformationResult) error {
+	snippets := make([]string, len(result.Report))
+	for i, entry := range result.Report {
+		snippets[i] = entry.Transformed
+	}
+
+	undefined, err := e.validator.UndefinedNames(ctx, result.TransformedCode, snippets)
+	if err != nil {
+		return err
+	}
+
+	for i, names := range undefined {
+		for _, name := range names {
+			result.Warnings = append(result.Warnings, types.Warning{
+				Message:    fmt.Sprintf("%s uses undefined name %q - check the %s rule's imports and setup_code", result.Report[i].Pattern, name, result.Report[i].Rule),
+				LineNumber: result.Report[i].LineNumber,
+				Category:   "undefined-name",
+			})
+		}
+	}
+
+	return nil
+}
+
+// TransformFile transforms a file, selecting the parser from the file's
+// extension
 func (e *Engine) TransformFile(filepath string, targetProvider types.Provider) (*types.TransformationResult, error) {
-	content, err := e.parser.ParseFile(filepath)
+	return e.TransformFileAs(filepath, "", targetProvider)
+}
+
+// TransformFileAs transforms a file written in the given language. An empty
+// language falls back to detecting it from the file's extension.
+func (e *Engine) TransformFileAs(filepath string, language types.Language, targetProvider types.Provider) (*types.TransformationResult, error) {
+	if language == "" {
+		detected, ok := types.DetectLanguage(filepath)
+		if !ok {
+			return nil, fmt.Errorf("cannot detect the language of %s from its extension; specify it explicitly", filepath)
+		}
+		language = detected
+	}
+
+	p, err := e.parserFor(language)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath, err)
+	}
+
+	var metrics types.Metrics
+	start := time.Now()
+	content, err := os.ReadFile(filepath)
+	if err != nil {
+		return nil, &types.TransformationError{
+			Category: types.ErrorCategoryParse,
+			Message:  fmt.Sprintf("failed to read file %s: %v", filepath, err),
+			Cause:    err,
+		}
+	}
+	source, enc, err := decodeSource(string(content))
+	if err != nil {
+		return nil, err
+	}
+	ast, err := p.ParseContext(context.Background(), source)
+	if err != nil {
+		return nil, err
+	}
+	ast.Filepath = filepath
+	recordEncoding(ast, enc)
+	metrics.Parse = time.Since(start)
+
+	calls, err := e.detect(ast, &metrics)
 	if err != nil {
 		return nil, err
 	}
 
-	return e.Transform(content.SourceCode, targetProvider)
+	result, err := e.generate(context.Background(), ast, calls, targetProvider, e.lenient, metrics)
+	if result != nil {
+		result.Filepath = filepath
+	}
+	return result, err
+}
+
+// batchParser is implemented by parsers that can parse several sources in a
+// single invocation
+type batchParser interface {
+	ParseBatchContext(ctx context.Context, sources []string) ([]*types.AST, error)
+}
+
+// TransformFiles transforms several files, selecting each parser from the
+// file's extension. Files whose parser supports batching are parsed together,
+// paying interpreter startup once. results[i] and errs[i] belong to paths[i];
+// a failure in one file does not stop the others. Every file is transformed
+// with the rules loaded when the call starts.
+func (e *Engine) TransformFiles(paths []string, targetProvider types.Provider) ([]*types.TransformationResult, []error) {
+	batch := e.snapshot()
+	results := make([]*types.TransformationResult, len(paths))
+	errs := make([]error, len(paths))
+
+	batches := make(map[types.Language][]int)
+	for i, path := range paths {
+		if lang, ok := types.DetectLanguage(path); ok {
+			if p, err := e.parserFor(lang); err == nil {
+				if _, ok := p.(batchParser); ok {
+					batches[lang] = append(batches[lang], i)
+					continue
+				}
+			}
+		}
+		results[i], errs[i] = batch.TransformFile(path, targetProvider)
+	}
+
+	for lang, indices := range batches {
+		batch.transformBatch(lang, paths, indices, targetProvider, results, errs)
+	}
+
+	return results, errs
+}
+
+// transformBatch transforms the files at indices with one batched parse,
+// filling in their results and errors. Each file's parse timing is its share
+// of the batch.
+func (e *Engine) transformBatch(lang types.Language, paths []string, indices []int, targetProvider types.Provider, results []*types.TransformationResult, errs []error) {
+	p, _ := e.parserFor(lang)
+
+	var sources []string
+	var encs []sourceEncoding
+	var parsed []int
+	for _, i := range indices {
+		content, err := os.ReadFile(paths[i])
+		if err != nil {
+			errs[i] = &types.TransformationError{
+				Category: types.ErrorCategoryParse,
+				Message:  fmt.Sprintf("failed to read file %s: %v", paths[i], err),
+				Cause:    err,
+			}
+			continue
+		}
+		source, enc, err := decodeSource(string(content))
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		sources = append(sources, source)
+		encs = append(encs, enc)
+		parsed = append(parsed, i)
+	}
+	if len(sources) == 0 {
+		return
+	}
+
+	start := time.Now()
+	asts, err := p.(batchParser).ParseBatchContext(context.Background(), sources)
+	elapsed := time.Since(start) / time.Duration(len(sources))
+
+	var batchErr *parser.BatchError
+	if err != nil && !errors.As(err, &batchErr) {
+		for _, i := range parsed {
+			errs[i] = err
+		}
+		return
+	}
+
+	for j, i := range parsed {
+		if batchErr != nil && batchErr.Errors[j] != nil {
+			errs[i] = batchErr.Errors[j]
+			continue
+		}
+
+		ast := asts[j]
+		ast.Filepath = paths[i]
+		recordEncoding(ast, encs[j])
+		metrics := types.Metrics{Parse: elapsed}
+
+		calls, err := e.detect(ast, &metrics)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		results[i], errs[i] = e.generate(context.Background(), ast, calls, targetProvider, e.lenient, metrics)
+		if results[i] != nil {
+			results[i].Filepath = paths[i]
+		}
+	}
+}
+
+// SupportedOperations returns the operation patterns loaded for provider,
+// grouped by capability and sorted. Rules for several languages count once.
+func (e *Engine) SupportedOperations(provider types.Provider) map[string][]string {
+	seen := make(map[string]map[string]bool)
+	for _, rule := range e.registry.ForProvider(provider).AllRules() {
+		if rule.Provider != provider {
+			continue
+		}
+		if seen[rule.Capability] == nil {
+			seen[rule.Capability] = make(map[string]bool)
+		}
+		seen[rule.Capability][rule.Pattern] = true
+	}
+
+	ops := make(map[string][]string, len(seen))
+	for capability, patterns := range seen {
+		for pattern := range patterns {
+			ops[capability] = append(ops[capability], pattern)
+		}
+		sort.Strings(ops[capability])
+	}
+	return ops
+}
+
+// CapabilityMatrix returns the operations loaded for each of providers, for
+// comparing their coverage
+func (e *Engine) CapabilityMatrix(providers []types.Provider) types.CapabilityMatrix {
+	matrix := make(types.CapabilityMatrix, len(providers))
+	for _, provider := range providers {
+		matrix[provider] = e.SupportedOperations(provider)
+	}
+	return matrix
+}
+
+// CompatibleProviders reports which of the providers with rules loaded can
+// transform every Infrar call in sourceCode, and for each of the others the
+// call patterns it has no rule for, sorted. The source is parsed and its calls
+// detected once; no code is generated. Provider fallbacks count as coverage.
+func (e *Engine) CompatibleProviders(sourceCode string) ([]types.Provider, map[types.Provider][]string, error) {
+	var metrics types.Metrics
+	_, calls, err := e.parseAndDetect(context.Background(), sourceCode, types.LanguagePython, &metrics)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var compatible []types.Provider
+	unsupported := make(map[types.Provider][]string)
+	for _, provider := range types.Providers() {
+		if len(e.SupportedOperations(provider)) == 0 {
+			continue // No rules loaded for the provider
+		}
+
+		registry := e.registry.ForProvider(provider).WithFallback(e.fallbacks...)
+		missing := make(map[string]bool)
+		for _, call := range calls {
+			if _, err := registry.GetRuleByCall(call); err != nil {
+				missing[call.FullName()] = true
+			}
+		}
+
+		if len(missing) == 0 {
+			compatible = append(compatible, provider)
+			continue
+		}
+		for pattern := range missing {
+			unsupported[provider] = append(unsupported[provider], pattern)
+		}
+		sort.Strings(unsupported[provider])
+	}
+
+	return compatible, unsupported, nil
+}
+
+// LintRules checks every rules file in pluginDir, for all capabilities and
+// providers, without loading them into the engine, and returns all the
+// problems found: unsupported providers, files that do not load, duplicate
+// patterns, templates that do not parse, and templates reading names the
+// rule does not declare. An empty result means the plugins are ready to
+// publish; the error is for a plugin directory that cannot be read.
+func (e *Engine) LintRules(pluginDir string) ([]types.RuleLint, error) {
+	return plugin.NewLoader(pluginDir).Lint()
+}
+
+// TestPlugin checks the examples shipped with a plugin's rules for provider
+// and capability. Each example input is transformed with only that plugin's
+// rules loaded, and its output compared with the expected output. A failing
+// example is reported in its ExampleResult; the error is for rules that
+// cannot be loaded.
+func (e *Engine) TestPlugin(pluginDir string, provider types.Provider, capability string) ([]types.ExampleResult, error) {
+	rules, err := plugin.NewLoader(pluginDir).LoadRules(provider, capability)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load rules: %w", err)
+	}
+
+	// Run the examples on a copy of the engine, so rules already loaded
+	// neither leak into them nor get replaced
+	isolated := *e
+	isolated.registry = plugin.NewRegistry()
+	isolated.registry.RegisterMultiple(rules)
+
+	var results []types.ExampleResult
+	for _, rule := range rules {
+		for i, example := range rule.Examples {
+			name := example.Name
+			if name == "" {
+				name = fmt.Sprintf("%d", i+1)
+			}
+
+			res := types.ExampleResult{
+				Capability: capability,
+				Provider:   provider,
+				Rule:       rule.Name,
+				Example:    name,
+				Expected:   example.Output,
+			}
+
+			result, err := isolated.TransformLanguage(context.Background(), example.Input, rule.Language, provider)
+			if err != nil {
+				res.Error = err.Error()
+			} else {
+				res.Actual = result.TransformedCode
+				res.Passed = strings.TrimRight(res.Actual, "\n") == strings.TrimRight(res.Expected, "\n")
+			}
+			results = append(results, res)
+		}
+	}
+
+	return results, nil
+}
+
+// Explain describes how a single call would be transformed and why, without
+// generating a file: the matched rule, its parameter mapping applied to the
+// call, the rendered code and the imports, setup code and requirements the
+// rule contributes. Unless validation is off, the rendered code is checked
+// too, with any problem reported in the notes. The rule is looked up as
+// Transform would for targetProvider: a call whose pragma names another
+// provider uses that provider's rules, and fallback providers apply.
+func (e *Engine) Explain(call types.InfrarCall, targetProvider types.Provider) (types.Explanation, error) {
+	snapshot := e.registry.Snapshot()
+	if err := e.addPragmaRules(snapshot, []types.InfrarCall{call}, targetProvider); err != nil {
+		return types.Explanation{}, err
+	}
+	trans := transformer.New(snapshot.ForProvider(targetProvider).WithFallback(e.fallbacks...))
+	trans.SetStyle(e.style)
+	exp, err := trans.Explain(call)
+	if err != nil {
+		return exp, err
+	}
+
+	if !e.noValidate {
+		if err := e.validator.ValidateContext(context.Background(), exp.Code); err != nil {
+			var validationErr *types.TransformationError
+			if !errors.As(err, &validationErr) {
+				return exp, err
+			}
+			exp.Notes = append(exp.Notes, "rendered code is invalid on its own: "+validationErr.Message)
+		}
+	}
+
+	return exp, nil
 }
 
 // GetRegistry returns the rule registry (for advanced usage)
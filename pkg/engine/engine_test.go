@@ -1,11 +1,23 @@
 package engine
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/executor"
+	"github.com/QodeSrl/infrar-engine/pkg/plugin"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
@@ -109,3 +121,2941 @@ def hello():
 		t.Error("Expected warning about no Infrar calls")
 	}
 }
+
+func TestEngine_Transform_LenientMode(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	sourceCode := `from infrar.storage import upload, archive
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+archive(bucket='my-bucket', path='old/')
+`
+
+	// Strict mode fails on the unmatched call
+	if _, err := eng.Transform(sourceCode, types.ProviderAWS); err == nil {
+		t.Fatal("Expected error for call without a rule")
+	}
+
+	result, err := eng.WithLenient(true).Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file") {
+		t.Error("Expected upload to be transformed")
+	}
+
+	if !strings.Contains(result.TransformedCode, "archive(bucket='my-bucket', path='old/')") {
+		t.Error("Expected unmatched archive call to be left unchanged")
+	}
+
+	if !strings.Contains(result.TransformedCode, "from infrar.storage import upload, archive") {
+		t.Error("Expected infrar import to be kept for the untransformed call")
+	}
+
+	found := false
+	for _, w := range result.Warnings {
+		if w.LineNumber == 4 && strings.Contains(w.Message, "infrar.storage.archive") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected warning for archive call on line 4, got %v", result.Warnings)
+	}
+}
+
+func TestEngine_Transform_LenientNoneMatched(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule).WithLenient(true)
+
+	sourceCode := `from infrar.storage import archive
+
+archive(bucket='my-bucket', path='old/')
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	// The summary counts the untransformed call instead of claiming none exist
+	var infos []string
+	for _, w := range result.Warnings {
+		if w.Category == "info" {
+			infos = append(infos, w.Message)
+		}
+	}
+	if want := []string{"1 Infrar call(s) left untransformed - returning original code"}; !reflect.DeepEqual(infos, want) {
+		t.Errorf("info warnings = %q, want %q", infos, want)
+	}
+}
+
+// testUploadRule is an AWS storage upload rule shared by engine tests
+const testUploadRule = `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`
+
+// newTestEngine creates an engine with the given AWS storage operations loaded
+func newTestEngine(t *testing.T, operations string) *Engine {
+	t.Helper()
+
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	writeTestRules(t, eng, types.ProviderAWS, operations)
+	return eng
+}
+
+// writeTestRules writes storage operations for a provider to a temporary plugin directory and loads them
+func writeTestRules(t *testing.T, eng *Engine, provider types.Provider, operations string) {
+	t.Helper()
+
+	pluginDir := t.TempDir()
+	rulesDir := filepath.Join(pluginDir, "storage", provider.String())
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create plugin directory: %v", err)
+	}
+
+	rulesYAML := "operations:\n" + operations
+	if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+
+	if err := eng.LoadRules(pluginDir, provider, "storage"); err != nil {
+		t.Fatalf("Failed to load rules: %v", err)
+	}
+}
+
+func TestEngine_TransformAll(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+	writeTestRules(t, eng, types.ProviderGCP, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      imports:
+        - "from google.cloud import storage"
+      setup_code: "storage_client = storage.Client()"
+      code_template: "storage_client.bucket({{ .bucket }}).blob({{ .destination }}).upload_from_filename({{ .source }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	results, err := eng.TransformAll(sourceCode, []types.Provider{types.ProviderAWS, types.ProviderGCP, types.ProviderAzure})
+	if err != nil {
+		t.Fatalf("TransformAll() error = %v", err)
+	}
+
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+
+	if !strings.Contains(results[types.ProviderAWS].TransformedCode, "s3.upload_file('file.txt', 'my-bucket', 'backup/file.txt')") {
+		t.Errorf("Unexpected AWS output:\n%s", results[types.ProviderAWS].TransformedCode)
+	}
+
+	if !strings.Contains(results[types.ProviderGCP].TransformedCode, "storage_client.bucket('my-bucket').blob('backup/file.txt')") {
+		t.Errorf("Unexpected GCP output:\n%s", results[types.ProviderGCP].TransformedCode)
+	}
+
+	azure := results[types.ProviderAzure]
+	if !strings.Contains(azure.TransformedCode, "upload(bucket='my-bucket'") {
+		t.Errorf("Expected Azure output to keep the unsupported call, got:\n%s", azure.TransformedCode)
+	}
+
+	found := false
+	for _, w := range azure.Warnings {
+		if strings.Contains(w.Message, "infrar.storage.upload") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected Azure warning about unsupported upload, got %v", azure.Warnings)
+	}
+}
+
+func TestEngine_TransformAll_Strict(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule).WithStrict(true)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	// A provider without a rule reports its gap in its warnings rather than
+	// discarding every provider's result
+	results, err := eng.TransformAll(sourceCode, []types.Provider{types.ProviderAWS, types.ProviderAzure})
+	if err != nil {
+		t.Fatalf("TransformAll() error = %v", err)
+	}
+	if !strings.Contains(results[types.ProviderAWS].TransformedCode, "s3.upload_file('file.txt', 'my-bucket', 'backup/file.txt')") {
+		t.Errorf("Unexpected AWS output:\n%s", results[types.ProviderAWS].TransformedCode)
+	}
+	if azure := results[types.ProviderAzure]; azure == nil || len(azure.Warnings) == 0 {
+		t.Errorf("Expected an Azure result with warnings, got %+v", azure)
+	}
+}
+
+func TestEngine_TransformContext_Cancelled(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	_, err := eng.TransformContext(ctx, sourceCode, types.ProviderAWS)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("TransformContext() error = %v, want %v", err, context.Canceled)
+	}
+}
+
+// stubParser is a parser.Parser that returns a fixed AST
+type stubParser struct {
+	lang types.Language
+	ast  *types.AST
+}
+
+func (p *stubParser) Parse(sourceCode string) (*types.AST, error) {
+	return p.ParseContext(context.Background(), sourceCode)
+}
+
+func (p *stubParser) ParseContext(ctx context.Context, sourceCode string) (*types.AST, error) {
+	return p.ast, nil
+}
+
+func (p *stubParser) ParseFile(filepath string) (*types.AST, error) {
+	return p.ast, nil
+}
+
+func (p *stubParser) Language() types.Language {
+	return p.lang
+}
+
+func TestEngine_RegisterParser(t *testing.T) {
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	if _, err := eng.TransformLanguage(context.Background(), "", types.LanguageGo, types.ProviderAWS); err == nil ||
+		!strings.Contains(err.Error(), "no parser registered for language go") {
+		t.Fatalf("TransformLanguage() error = %v, want missing parser error", err)
+	}
+
+	// The stub's AST reaches the detector, proving the registered parser was used
+	eng.RegisterParser(types.LanguageGo, &stubParser{
+		lang: types.LanguageGo,
+		ast:  &types.AST{Language: types.LanguageGo, Metadata: map[string]any{"calls": nil}},
+	})
+
+	_, err = eng.TransformLanguage(context.Background(), "", types.LanguageGo, types.ProviderAWS)
+	if err == nil || !strings.Contains(err.Error(), "unsupported language: go") {
+		t.Fatalf("TransformLanguage() error = %v, want unsupported language from detector", err)
+	}
+}
+
+func TestEngine_TransformFile_LanguageFromExtension(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	dir := t.TempDir()
+	write := func(name string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(sourceCode), 0644); err != nil {
+			t.Fatalf("WriteFile() error = %v", err)
+		}
+		return path
+	}
+
+	pyFile := write("app.py")
+	result, err := eng.TransformFile(pyFile, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("TransformFile() error = %v", err)
+	}
+	if !strings.Contains(result.TransformedCode, "s3.upload_file") {
+		t.Errorf("Expected transformed code, got:\n%s", result.TransformedCode)
+	}
+
+	goFile := write("main.go")
+	if _, err := eng.TransformFile(goFile, types.ProviderAWS); err == nil ||
+		!strings.Contains(err.Error(), "no parser registered for language go") {
+		t.Errorf("TransformFile(%q) error = %v, want missing parser error", goFile, err)
+	}
+
+	txtFile := write("script.txt")
+	if _, err := eng.TransformFile(txtFile, types.ProviderAWS); err == nil ||
+		!strings.Contains(err.Error(), "cannot detect the language") {
+		t.Errorf("TransformFile(%q) error = %v, want detection error", txtFile, err)
+	}
+
+	// An explicit language overrides the extension
+	result, err = eng.TransformFileAs(txtFile, types.LanguagePython, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("TransformFileAs() error = %v", err)
+	}
+	if result.Filepath != txtFile {
+		t.Errorf("Filepath = %q, want %q", result.Filepath, txtFile)
+	}
+}
+
+func TestEngine_Transform_NameCheck(t *testing.T) {
+	// The rule forgets its setup_code, so s3 is never defined
+	eng := newTestEngine(t, strings.Replace(testUploadRule, `      setup_code: "s3 = boto3.client('s3')"`+"\n", "", 1))
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	for _, w := range result.Warnings {
+		if w.Category == "undefined-name" {
+			t.Fatalf("Name check should be opt-in, got warning %q", w.Message)
+		}
+	}
+
+	result, err = eng.WithNameCheck(true).Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	var found []types.Warning
+	for _, w := range result.Warnings {
+		if w.Category == "undefined-name" {
+			found = append(found, w)
+		}
+	}
+	if len(found) != 1 || !strings.Contains(found[0].Message, `"s3"`) || found[0].LineNumber != 3 {
+		t.Errorf("Expected one undefined-name warning for s3 on line 3, got %v", found)
+	}
+}
+
+func TestEngine_Transform_WithFormatter(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+	eng.WithFormatter(true)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "upload_file(") {
+		t.Errorf("Expected transformed call in formatted output, got:\n%s", result.TransformedCode)
+	}
+
+	_, blackErr := exec.LookPath("black")
+	_, autopep8Err := exec.LookPath("autopep8")
+	if blackErr != nil && autopep8Err != nil {
+		found := false
+		for _, w := range result.Warnings {
+			if w.Category == "format" && strings.Contains(w.Message, "no formatter installed") {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected missing formatter warning, got %v", result.Warnings)
+		}
+	}
+}
+
+func TestEngine_Transform_BoolAndNoneLiterals(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }}, ExtraArgs={'Public': {{ .public }}, 'Metadata': {{ .metadata }}})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+	writeTestRules(t, eng, types.ProviderGCP, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      imports:
+        - "from google.cloud import storage"
+      setup_code: "storage_client = storage.Client()"
+      code_template: "storage_client.bucket({{ .bucket }}).blob({{ .destination }}).upload_from_filename({{ .source }}, if_generation_match={{ .metadata }}, checksum={{ .public }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt', public=False, metadata=None)
+`
+
+	// The source and target language are both Python, so literals must be
+	// Python's True/False/None rather than another language's spelling
+	tests := []struct {
+		provider types.Provider
+		want     string
+	}{
+		{
+			provider: types.ProviderAWS,
+			want:     "s3.upload_file('file.txt', 'my-bucket', 'backup/file.txt', ExtraArgs={'Public': False, 'Metadata': None})",
+		},
+		{
+			provider: types.ProviderGCP,
+			want:     "storage_client.bucket('my-bucket').blob('backup/file.txt').upload_from_filename('file.txt', if_generation_match=None, checksum=False)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.provider), func(t *testing.T) {
+			result, err := eng.Transform(sourceCode, tt.provider)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+
+			if !strings.Contains(result.TransformedCode, tt.want) {
+				t.Errorf("Expected %q in output, got:\n%s", tt.want, result.TransformedCode)
+			}
+
+			for _, literal := range []string{"false", "null", "nil"} {
+				if strings.Contains(result.TransformedCode, literal) {
+					t.Errorf("Unexpected non-Python literal %q in output:\n%s", literal, result.TransformedCode)
+				}
+			}
+		})
+	}
+}
+
+func TestEngine_Transform_WrapperTemplate(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+        - "from botocore.exceptions import ClientError"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+      wrapper_template: |
+        try:
+            {{ .call }}
+        except ClientError as e:
+            raise RuntimeError(f"upload to {{ .bucket }} failed: {e}")
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+def backup():
+    upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+    return True
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `def backup():
+    try:
+        s3.upload_file('file.txt', 'my-bucket', 'backup/file.txt')
+    except ClientError as e:
+        raise RuntimeError(f"upload to 'my-bucket' failed: {e}")
+    return True
+`
+	if !strings.Contains(result.TransformedCode, want) {
+		t.Errorf("Expected wrapped call with original indentation, got:\n%s", result.TransformedCode)
+	}
+
+	if !strings.Contains(result.TransformedCode, "from botocore.exceptions import ClientError") {
+		t.Errorf("Expected ClientError import, got:\n%s", result.TransformedCode)
+	}
+}
+
+func TestEngine_DetectLanguage(t *testing.T) {
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   types.Language
+	}{
+		{
+			name: "Python module",
+			source: `from infrar.storage import upload
+
+def backup(path):
+    if path:
+        upload(bucket='data', source=path, destination='backup')
+`,
+			want: types.LanguagePython,
+		},
+		{
+			name: "Python script with plain imports",
+			source: `import os
+import infrar.storage as storage
+
+storage.upload(bucket=os.environ['BUCKET'], source='a', destination='b')
+`,
+			want: types.LanguagePython,
+		},
+		{
+			name: "CommonJS",
+			source: `const storage = require('@infrar/storage');
+
+const backup = async (path) => {
+  await storage.upload({ bucket: 'data', source: path, destination: 'backup' });
+};
+`,
+			want: types.LanguageNodeJS,
+		},
+		{
+			name: "ES module",
+			source: `import { upload } from '@infrar/storage';
+
+export function backup(path) {
+  return upload({ bucket: 'data', source: path });
+}
+`,
+			want: types.LanguageNodeJS,
+		},
+		{
+			name: "Go",
+			source: `package main
+
+import "github.com/QodeSrl/infrar-sdk-go/storage"
+
+func main() {
+	err := storage.Upload("data", "file.txt", "backup")
+	if err != nil {
+		panic(err)
+	}
+}
+`,
+			want: types.LanguageGo,
+		},
+		{
+			name:   "Nothing recognisable defaults to Python",
+			source: "upload(bucket='data')\n",
+			want:   types.LanguagePython,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := eng.DetectLanguage(tt.source); got != tt.want {
+				t.Errorf("DetectLanguage() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_TransformStream(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	sourceCode := `"""Backups."""
+from infrar.storage import upload
+
+def backup():
+    upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')
+`
+
+	want, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	var out strings.Builder
+	result, err := eng.TransformStream(strings.NewReader(sourceCode), &out, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("TransformStream() error = %v", err)
+	}
+
+	if out.String() != want.TransformedCode {
+		t.Errorf("TransformStream() wrote:\n%s\nwant:\n%s", out.String(), want.TransformedCode)
+	}
+
+	if result.TransformedCode != "" {
+		t.Errorf("Expected streamed result to leave TransformedCode empty")
+	}
+
+	if len(result.Report) != 1 || len(result.Requirements) != len(want.Requirements) {
+		t.Errorf("Expected the same metadata as Transform, got report %v requirements %v", result.Report, result.Requirements)
+	}
+}
+
+// largeSource builds a synthetic module with the given number of upload calls
+// surrounded by ordinary code
+func largeSource(calls int) string {
+	var sb strings.Builder
+	sb.WriteString("from infrar.storage import upload\n\n")
+	for i := 0; i < calls; i++ {
+		fmt.Fprintf(&sb, "def backup_%d(path):\n", i)
+		sb.WriteString("    # Copy the file to the backup bucket\n")
+		sb.WriteString("    name = path.rsplit('/', 1)[-1]\n")
+		sb.WriteString("    upload(bucket='my-bucket', source='file.txt', destination='backup/file.txt')\n")
+		sb.WriteString("    return name\n\n")
+	}
+	return sb.String()
+}
+
+// benchmarkEngine creates an engine with the upload rule loaded and validation
+// off, since TransformStream never validates: the benchmarks then compare only
+// building the code in memory with streaming it
+func benchmarkEngine(b *testing.B) *Engine {
+	b.Helper()
+
+	eng, err := New()
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+
+	dir := b.TempDir()
+	rulesDir := filepath.Join(dir, "storage", "aws")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		b.Fatalf("MkdirAll() error = %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte("operations:\n"+testUploadRule), 0644); err != nil {
+		b.Fatalf("WriteFile() error = %v", err)
+	}
+	if err := eng.LoadRules(dir, types.ProviderAWS, "storage"); err != nil {
+		b.Fatalf("LoadRules() error = %v", err)
+	}
+
+	return eng.WithValidation(false)
+}
+
+// BenchmarkTransform and BenchmarkTransformStream compare allocations for a
+// large file; run with -benchmem
+func BenchmarkTransform(b *testing.B) {
+	eng := benchmarkEngine(b)
+	source := largeSource(5000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := eng.Transform(source, types.ProviderAWS)
+		if err != nil {
+			b.Fatalf("Transform() error = %v", err)
+		}
+		io.WriteString(io.Discard, result.TransformedCode)
+	}
+}
+
+func BenchmarkTransformStream(b *testing.B) {
+	eng := benchmarkEngine(b)
+	source := largeSource(5000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := eng.TransformStream(strings.NewReader(source), io.Discard, types.ProviderAWS); err != nil {
+			b.Fatalf("TransformStream() error = %v", err)
+		}
+	}
+}
+
+// BenchmarkTransform_Fixture runs every stage over the shared benchmark
+// fixture. A distinct trailing comment per iteration keeps the validation
+// cache from hiding the cost of the Python syntax check.
+func BenchmarkTransform_Fixture(b *testing.B) {
+	eng, err := New()
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	if err := eng.LoadRules("../../test-plugins", types.ProviderAWS, "storage"); err != nil {
+		b.Fatalf("LoadRules() error = %v", err)
+	}
+	source, err := os.ReadFile("../../testdata/benchmark.py")
+	if err != nil {
+		b.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := eng.Transform(fmt.Sprintf("%s# run %d\n", source, i), types.ProviderAWS); err != nil {
+			b.Fatalf("Transform() error = %v", err)
+		}
+	}
+}
+
+func TestEngine_Transform_SameLineCalls(t *testing.T) {
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := eng.LoadRules("../../test-plugins", types.ProviderAWS, "storage"); err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	sourceCode := `from infrar.storage import upload, download
+
+upload(bucket='data', source='a.txt', destination='a.txt'); download(bucket='data', source='b.txt', destination='b.txt')
+result = upload(
+    bucket='data',
+    source='c.txt',
+    destination='c.txt',
+)
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	for _, want := range []string{
+		"s3.upload_file('a.txt', 'data', 'a.txt'); s3.download_file('data', 'b.txt', 'b.txt')\n",
+		"result = s3.upload_file('c.txt', 'data', 'c.txt')\n",
+	} {
+		if !strings.Contains(result.TransformedCode, want) {
+			t.Errorf("Expected %q in output, got:\n%s", want, result.TransformedCode)
+		}
+	}
+
+	if len(result.Report) != 3 {
+		t.Errorf("Expected 3 transformed calls, got %d", len(result.Report))
+	}
+}
+
+func TestEngine_Transform_PreservesComments(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	tests := []struct {
+		name string
+		line string
+		want string
+	}{
+		{
+			name: "Trailing comment",
+			line: "upload(bucket='data', source='a.txt', destination='a.txt')  # nightly backup",
+			want: "s3.upload_file('a.txt', 'data', 'a.txt')  # nightly backup",
+		},
+		{
+			name: "noqa marker",
+			line: "upload(bucket='data', source='a.txt', destination='a.txt')  # noqa: E501",
+			want: "s3.upload_file('a.txt', 'data', 'a.txt')  # noqa: E501",
+		},
+		{
+			name: "Type comment",
+			line: "result = upload(bucket='data', source='a.txt', destination='a.txt')  # type: None",
+			want: "result = s3.upload_file('a.txt', 'data', 'a.txt')  # type: None",
+		},
+		{
+			name: "Comment containing a call-like string",
+			line: "upload(bucket='data', source='a.txt', destination='a.txt')  # was upload(bucket='x')",
+			want: "s3.upload_file('a.txt', 'data', 'a.txt')  # was upload(bucket='x')",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sourceCode := "from infrar.storage import upload\n\n# Leading comment stays put\n" + tt.line + "\n"
+
+			result, err := eng.Transform(sourceCode, types.ProviderAWS)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+
+			if !strings.Contains(result.TransformedCode, "# Leading comment stays put\n"+tt.want+"\n") {
+				t.Errorf("Expected %q in output, got:\n%s", tt.want, result.TransformedCode)
+			}
+		})
+	}
+}
+
+func TestEngine_Transform_DeprecatedRule(t *testing.T) {
+	eng := newTestEngine(t, `  - name: put
+    pattern: "infrar.storage.put"
+    deprecated: true
+    replacement: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+`)
+
+	sourceCode := `from infrar.storage import put
+
+put(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file('a.txt', 'data', 'a.txt')") {
+		t.Errorf("Deprecated call was not transformed:\n%s", result.TransformedCode)
+	}
+
+	var found bool
+	for _, w := range result.Warnings {
+		if w.Category == "deprecated" {
+			found = true
+			if w.LineNumber != 3 {
+				t.Errorf("Expected warning on line 3, got %d", w.LineNumber)
+			}
+			if !strings.Contains(w.Message, "infrar.storage.upload") {
+				t.Errorf("Expected warning to name the replacement, got %q", w.Message)
+			}
+		}
+	}
+	if !found {
+		t.Errorf("Expected a deprecation warning, got %+v", result.Warnings)
+	}
+}
+
+func TestEngine_Transform_NestedCall(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule+`  - name: download
+    pattern: "infrar.storage.download"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.download_file({{ .bucket }}, {{ .source }}, {{ .destination }})"
+`)
+
+	sourceCode := `import os
+from infrar.storage import upload, download
+
+upload(bucket='data', source=download(bucket='src', source='f', destination='/tmp/f'), destination=os.path.basename('f'))
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file(s3.download_file('src', 'f', '/tmp/f'), 'data', os.path.basename('f'))"
+	if !strings.Contains(result.TransformedCode, want+"\n") {
+		t.Errorf("Expected %q in output, got:\n%s", want, result.TransformedCode)
+	}
+
+	if len(result.Report) != 2 {
+		t.Errorf("Expected both calls in the report, got %+v", result.Report)
+	}
+}
+
+func TestEngine_Transform_Metrics(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	first, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	second, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if first.Metrics == nil || second.Metrics == nil {
+		t.Fatal("Expected metrics on every result")
+	}
+	if first.Metrics.Parse <= 0 || first.Metrics.Total < first.Metrics.Parse {
+		t.Errorf("Unexpected timings: %+v", first.Metrics)
+	}
+	if first.Metrics.Calls != 1 || first.Metrics.TransformedCalls != 1 {
+		t.Errorf("Calls = %d/%d, want 1/1", first.Metrics.Calls, first.Metrics.TransformedCalls)
+	}
+
+	// Identical output is validated once, then served from the cache
+	if first.Metrics.ValidationCache != "miss" || second.Metrics.ValidationCache != "hit" {
+		t.Errorf("ValidationCache = %q then %q, want miss then hit", first.Metrics.ValidationCache, second.Metrics.ValidationCache)
+	}
+}
+
+func TestEngine_WithValidation(t *testing.T) {
+	// The template leaves a parenthesis open, so the output does not compile
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}"
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	if _, err := eng.Transform(sourceCode, types.ProviderAWS); err == nil {
+		t.Fatal("Expected a validation error with validation enabled")
+	}
+
+	result, err := eng.WithValidation(false).Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file('a.txt', 'data'\n") {
+		t.Errorf("Expected the unvalidated output, got:\n%s", result.TransformedCode)
+	}
+	if result.Metrics.Validate != 0 {
+		t.Errorf("Expected no validation time, got %v", result.Metrics.Validate)
+	}
+}
+
+func TestEngine_ValidationFailureKeepsResult(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}"
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+	broken := "s3.upload_file('a.txt', 'data'\n"
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	var validationErr *types.TransformationError
+	if !errors.As(err, &validationErr) || validationErr.Category != types.ErrorCategoryValidation {
+		t.Fatalf("Expected a validation error, got %v", err)
+	}
+	if result == nil || !strings.Contains(result.TransformedCode, broken) {
+		t.Fatalf("Expected the generated code alongside the error, got %+v", result)
+	}
+
+	result, err = eng.WithBestEffort(true).Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if result == nil || !strings.Contains(result.TransformedCode, broken) {
+		t.Fatalf("Expected the generated code, got %+v", result)
+	}
+
+	var found bool
+	for _, w := range result.Warnings {
+		if w.Category == "validation" {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Expected a validation warning, got %+v", result.Warnings)
+	}
+}
+
+func TestEngine_Analyze(t *testing.T) {
+	// No rules are loaded: analysis stops before transformation
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	sourceCode := `import os
+from infrar.storage import upload, download
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+download(bucket='data', source='a.txt', destination=os.path.join('tmp', 'a.txt'))
+`
+
+	ast, calls, err := eng.Analyze(sourceCode)
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+
+	if len(ast.Imports) != 2 {
+		t.Errorf("Imports = %d, want 2", len(ast.Imports))
+	}
+	if len(calls) != 2 {
+		t.Fatalf("Calls = %d, want 2", len(calls))
+	}
+	if calls[0].FullName() != "infrar.storage.upload" || calls[1].FullName() != "infrar.storage.download" {
+		t.Errorf("Calls = %s, %s", calls[0].FullName(), calls[1].FullName())
+	}
+	if calls[1].LineNumber != 5 {
+		t.Errorf("LineNumber = %d, want 5", calls[1].LineNumber)
+	}
+}
+
+func TestEngine_Transform_FunctionScopeSetup(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import aioboto3"
+      setup_scope: function
+      setup_code: |
+        session = aioboto3.Session()
+        s3 = await session.client('s3').__aenter__()
+      code_template: "await s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+async def publish(names):
+    """Upload every report."""
+    for name in names:
+        upload(bucket='reports', source=name, destination=name)
+    upload(bucket='reports', source='index.txt', destination='index.txt')
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `import aioboto3
+
+
+async def publish(names):
+    """Upload every report."""
+    session = aioboto3.Session()
+    s3 = await session.client('s3').__aenter__()
+    for name in names:
+        await s3.upload_file(name, 'reports', name)
+    await s3.upload_file('index.txt', 'reports', 'index.txt')
+`
+	if result.TransformedCode != want {
+		t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, want)
+	}
+}
+
+func TestEngine_CapabilityMatrix(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule+`  - name: download
+    pattern: "infrar.storage.download"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      code_template: "s3.download_file({{ .bucket }}, {{ .source }}, {{ .destination }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+	writeTestRules(t, eng, types.ProviderGCP, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      code_template: "bucket.blob({{ .destination }}).upload_from_filename({{ .source }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	ops := eng.SupportedOperations(types.ProviderAWS)
+	want := []string{"infrar.storage.download", "infrar.storage.upload"}
+	if fmt.Sprint(ops["storage"]) != fmt.Sprint(want) || len(ops) != 1 {
+		t.Errorf("SupportedOperations(aws) = %v, want storage: %v", ops, want)
+	}
+
+	matrix := eng.CapabilityMatrix([]types.Provider{types.ProviderAWS, types.ProviderGCP})
+	if !matrix.Supports(types.ProviderGCP, "infrar.storage.upload") {
+		t.Error("Expected gcp to support infrar.storage.upload")
+	}
+	if missing := matrix.Missing(types.ProviderGCP); fmt.Sprint(missing) != "[infrar.storage.download]" {
+		t.Errorf("Missing(gcp) = %v, want [infrar.storage.download]", missing)
+	}
+	if missing := matrix.Missing(types.ProviderAWS); len(missing) != 0 {
+		t.Errorf("Missing(aws) = %v, want none", missing)
+	}
+}
+
+func TestEngine_Transform_ExistingProviderImport(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name: "aliased",
+			source: `import boto3 as b3
+from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`,
+			want: `import boto3 as b3
+
+s3 = b3.client('s3')
+
+s3.upload_file('a.txt', 'data', 'a.txt')
+`,
+		},
+		{
+			name: "aliased, module named in a string",
+			source: `import boto3 as b3
+from infrar.storage import upload
+
+upload(bucket='data', source='see boto3.upload', destination='a.txt')
+`,
+			want: `import boto3 as b3
+
+s3 = b3.client('s3')
+
+s3.upload_file('see boto3.upload', 'data', 'a.txt')
+`,
+		},
+		{
+			name: "plain",
+			source: `import boto3
+from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`,
+			want: `import boto3
+
+s3 = boto3.client('s3')
+
+s3.upload_file('a.txt', 'data', 'a.txt')
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := eng.Transform(tt.source, types.ProviderAWS)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if result.TransformedCode != tt.want {
+				t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_Transform_ShebangAndCoding(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	tests := []struct {
+		name   string
+		source string
+		want   string
+	}{
+		{
+			name: "imports follow the header",
+			source: `#!/usr/bin/env python
+# -*- coding: utf-8 -*-
+from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`,
+			want: `#!/usr/bin/env python
+# -*- coding: utf-8 -*-
+import boto3
+
+s3 = boto3.client('s3')
+
+s3.upload_file('a.txt', 'data', 'a.txt')
+`,
+		},
+		{
+			name: "header and docstring",
+			source: `#!/usr/bin/env python
+# -*- coding: utf-8 -*-
+"""Uploads a file."""
+from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`,
+			want: `#!/usr/bin/env python
+# -*- coding: utf-8 -*-
+"""Uploads a file."""
+import boto3
+
+s3 = boto3.client('s3')
+
+s3.upload_file('a.txt', 'data', 'a.txt')
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := eng.Transform(tt.source, types.ProviderAWS)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if result.TransformedCode != tt.want {
+				t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_TestPlugin(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	pluginDir := t.TempDir()
+	rulesDir := filepath.Join(pluginDir, "storage", "aws")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create plugin directory: %v", err)
+	}
+	rulesYAML := "operations:\n" + testUploadRule + `    examples:
+      - name: passing
+        input: |
+          from infrar.storage import upload
+
+          upload(bucket='data', source='a.txt', destination='a.txt')
+        output: |
+          import boto3
+
+          s3 = boto3.client('s3')
+
+          s3.upload_file('a.txt', 'data', 'a.txt')
+      - input: |
+          from infrar.storage import upload
+
+          upload(bucket='data', source='a.txt', destination='a.txt')
+        output: |
+          s3.upload_file('a.txt', 'data', 'wrong.txt')
+`
+	if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+
+	results, err := eng.TestPlugin(pluginDir, types.ProviderAWS, "storage")
+	if err != nil {
+		t.Fatalf("TestPlugin() error = %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("TestPlugin() returned %d results, want 2", len(results))
+	}
+
+	if !results[0].Passed || results[0].Example != "passing" || results[0].Rule != "upload" {
+		t.Errorf("results[0] = %+v, want a pass for example passing of rule upload", results[0])
+	}
+	if results[1].Passed || results[1].Example != "2" {
+		t.Errorf("results[1] = %+v, want a failure for example 2", results[1])
+	}
+	if !strings.Contains(results[1].Actual, "s3.upload_file('a.txt', 'data', 'a.txt')") {
+		t.Errorf("results[1].Actual = %q, want the transformed input", results[1].Actual)
+	}
+
+	if _, err := eng.TestPlugin(pluginDir, types.ProviderGCP, "storage"); err == nil {
+		t.Error("TestPlugin() error = nil, want an error for a provider without rules")
+	}
+}
+
+func TestEngine_Use(t *testing.T) {
+	source := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	t.Run("hooks run in order", func(t *testing.T) {
+		eng := newTestEngine(t, testUploadRule)
+		eng.Use(func(result *types.TransformationResult) error {
+			result.TransformedCode = "# Copyright Example Ltd.\n" + result.TransformedCode
+			return nil
+		}).Use(func(result *types.TransformationResult) error {
+			result.TransformedCode = "# Generated by infrar\n" + result.TransformedCode
+			return nil
+		})
+
+		result, err := eng.Transform(source, types.ProviderAWS)
+		if err != nil {
+			t.Fatalf("Transform() error = %v", err)
+		}
+		if !strings.HasPrefix(result.TransformedCode, "# Generated by infrar\n# Copyright Example Ltd.\nimport boto3\n") {
+			t.Errorf("TransformedCode =\n%s\nwant the hook headers first", result.TransformedCode)
+		}
+	})
+
+	t.Run("hook error aborts", func(t *testing.T) {
+		errSecret := errors.New("secret found")
+		eng := newTestEngine(t, testUploadRule)
+		eng.Use(func(*types.TransformationResult) error { return errSecret })
+
+		result, err := eng.Transform(source, types.ProviderAWS)
+		if !errors.Is(err, errSecret) || !errors.Is(err, types.ErrGeneration) {
+			t.Fatalf("Transform() error = %v, want the hook error as a generation error", err)
+		}
+		if result != nil {
+			t.Errorf("Transform() result = %+v, want nil", result)
+		}
+	})
+
+	t.Run("streaming refuses hooks", func(t *testing.T) {
+		eng := newTestEngine(t, testUploadRule)
+		eng.Use(func(*types.TransformationResult) error { return nil })
+
+		var out strings.Builder
+		if _, err := eng.TransformStream(strings.NewReader(source), &out, types.ProviderAWS); err == nil || !strings.Contains(err.Error(), "hook") {
+			t.Errorf("TransformStream() error = %v, want an error about the hooks", err)
+		}
+		if out.Len() != 0 {
+			t.Errorf("TransformStream() wrote %q, want nothing", out.String())
+		}
+	})
+}
+
+func TestEngine_Transform_MultiLineTemplateIndentation(t *testing.T) {
+	// The template carries its own base indentation of four spaces
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "    s3.upload_file(\n        {{ .source }},\n        {{ .bucket }},\n        {{ .destination }},\n        ExtraArgs={\n            'Metadata': {'origin': 'infrar'},\n        },\n    )"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	source := `from infrar.storage import upload
+
+def publish(path):
+    if path:
+        upload(bucket='data', source=path, destination='out.txt')
+`
+
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `import boto3
+
+s3 = boto3.client('s3')
+
+def publish(path):
+    if path:
+        s3.upload_file(
+            path,
+            'data',
+            'out.txt',
+            ExtraArgs={
+                'Metadata': {'origin': 'infrar'},
+            },
+        )
+`
+	if result.TransformedCode != want {
+		t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, want)
+	}
+}
+
+func TestEngine_WithProviderFallback(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	source := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	if _, err := eng.Transform(source, types.ProviderGCP); err == nil {
+		t.Fatal("Transform() error = nil, want an error without a GCP rule or fallback")
+	}
+
+	eng.WithProviderFallback([]types.Provider{types.ProviderAzure, types.ProviderAWS})
+	result, err := eng.Transform(source, types.ProviderGCP)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `import boto3
+
+s3 = boto3.client('s3')
+
+s3.upload_file('a.txt', 'data', 'a.txt')
+`
+	if result.TransformedCode != want {
+		t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, want)
+	}
+
+	wantWarning := types.Warning{
+		Message:    "no gcp rule for infrar.storage.upload - used the aws rule instead",
+		LineNumber: 3,
+		Category:   "fallback",
+	}
+	if len(result.Warnings) != 1 || result.Warnings[0] != wantWarning {
+		t.Errorf("Warnings = %+v, want [%+v]", result.Warnings, wantWarning)
+	}
+}
+
+func TestEngine_Transform_ProviderPragma(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+	writeTestRules(t, eng, types.ProviderGCP, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      imports:
+        - "from google.cloud import storage"
+      setup_code: "storage_client = storage.Client()"
+      code_template: "storage_client.bucket({{ .bucket }}).blob({{ .destination }}).upload_from_filename({{ .source }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	source := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+# infrar: provider=gcp
+upload(bucket='archive', source='b.txt', destination='b.txt')
+`
+
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `from google.cloud import storage
+import boto3
+
+s3 = boto3.client('s3')
+storage_client = storage.Client()
+
+s3.upload_file('a.txt', 'data', 'a.txt')
+# infrar: provider=gcp
+storage_client.bucket('archive').blob('b.txt').upload_from_filename('b.txt')
+`
+	if result.TransformedCode != want {
+		t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, want)
+	}
+	if len(result.Warnings) != 0 {
+		t.Errorf("Warnings = %+v, want none", result.Warnings)
+	}
+
+	_, err = eng.Transform("from infrar.storage import upload\n\n# infrar: provider=ibm\nupload(bucket='data', source='a.txt', destination='a.txt')\n", types.ProviderAWS)
+	var transformErr *types.TransformationError
+	if !errors.As(err, &transformErr) || transformErr.Category != types.ErrorCategoryDetection || transformErr.Line != 3 {
+		t.Errorf("Transform() error = %v, want a detection error on line 3 for an unknown provider", err)
+	}
+
+	// Pragma-like text in a string is not a pragma
+	source = "from infrar.storage import upload\n\nNOTES = \"\"\"\n# infrar: provider=ibm\n\"\"\"\nupload(bucket='data', source='a.txt', destination='a.txt')\n"
+	result, err = eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() with a pragma in a string error = %v", err)
+	}
+	if !strings.Contains(result.TransformedCode, "\n# infrar: provider=ibm\n\"\"\"\ns3.upload_file('a.txt', 'data', 'a.txt')\n") {
+		t.Errorf("TransformedCode =\n%s\nwant the string kept and the call sent to AWS", result.TransformedCode)
+	}
+}
+
+func TestEngine_Transform_ImportSources(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule+`  - name: delete
+    pattern: "infrar.storage.delete"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+        - "from botocore.exceptions import ClientError"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.delete_object(Bucket={{ .bucket }}, Key={{ .path }})"
+      parameter_mapping:
+        bucket: bucket
+        path: path
+`)
+
+	source := `from infrar.storage import upload, delete
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+delete(bucket='data', path='a.txt')
+`
+
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := map[string][]string{
+		"import boto3": {"infrar.storage.upload", "infrar.storage.delete"},
+		"from botocore.exceptions import ClientError": {"infrar.storage.delete"},
+	}
+	if !reflect.DeepEqual(result.ImportSources, want) {
+		t.Errorf("ImportSources = %v, want %v", result.ImportSources, want)
+	}
+}
+
+func TestEngine_Transform_FString(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+	eng.WithNameCheck(true)
+
+	source := `from infrar.storage import upload
+
+def backup(name):
+    upload(bucket='data', source=f"{name}.txt", destination=f'backup/{name}-{version:03d}.txt')
+`
+
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file(f'{name}.txt', 'data', f'backup/{name}-{version:03d}.txt')") {
+		t.Errorf("Expected the f-strings to be re-emitted, got:\n%s", result.TransformedCode)
+	}
+
+	// Names read inside an f-string are checked like variable arguments
+	found := false
+	for _, w := range result.Warnings {
+		if strings.Contains(w.Message, `undefined name "version"`) {
+			found = true
+		}
+		if strings.Contains(w.Message, `undefined name "name"`) {
+			t.Errorf("Unexpected warning for the defined parameter: %s", w.Message)
+		}
+	}
+	if !found {
+		t.Errorf("Expected a warning for the undefined name version, got %+v", result.Warnings)
+	}
+}
+
+func TestEngine_Transform_SpreadArguments(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	source := `from infrar.storage import upload
+
+opts = {'bucket': 'data', 'source': 'a.txt', 'destination': 'a.txt'}
+upload(**opts)
+`
+
+	_, err := eng.Transform(source, types.ProviderAWS)
+	var transformErr *types.TransformationError
+	if !errors.As(err, &transformErr) {
+		t.Fatalf("Transform() error = %v, want a *TransformationError", err)
+	}
+	if transformErr.Line != 4 || transformErr.Message != "infrar.storage.upload unpacks **opts, so its arguments cannot be resolved statically" {
+		t.Errorf("Transform() error = line %d: %s", transformErr.Line, transformErr.Message)
+	}
+	if transformErr.Suggestion != "Pass the arguments by name instead, e.g. upload(bucket=..., destination=..., source=...)" {
+		t.Errorf("Suggestion = %q", transformErr.Suggestion)
+	}
+
+	// Lenient mode leaves the call as written
+	eng.WithLenient(true)
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() lenient error = %v", err)
+	}
+	if result.TransformedCode != source {
+		t.Errorf("TransformedCode =\n%s\nwant the source unchanged", result.TransformedCode)
+	}
+	found := false
+	for _, w := range result.Warnings {
+		if w.Category == "spread" && w.LineNumber == 4 {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Warnings = %+v, want a spread warning for line 4", result.Warnings)
+	}
+}
+
+func TestEngine_Explain(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .Filename }}, {{ .Bucket }}, {{ .Key }}{{ if .acl }}, ExtraArgs={'ACL': {{ .acl }}}{{ end }})"
+      parameter_mapping:
+        bucket: Bucket
+        source: Filename
+        destination: Key
+        acl: ACL
+      optional_params: [acl]
+      static_params:
+        ServerSideEncryption: AES256
+    requirements:
+      - package: boto3
+        version: ">=1.28.0"
+`)
+
+	_, calls, err := eng.Analyze(`from infrar.storage import upload
+
+upload(bucket='data', source=path, destination='a.txt', retries=3)
+`)
+	if err != nil || len(calls) != 1 {
+		t.Fatalf("Analyze() = %d calls, error %v", len(calls), err)
+	}
+
+	exp, err := eng.Explain(calls[0], types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Explain() error = %v", err)
+	}
+
+	wantParams := []types.ParameterBinding{
+		{Name: "acl", Targets: []string{"ACL"}, Optional: true},
+		{Name: "bucket", Targets: []string{"Bucket"}, Value: "'data'", Provided: true},
+		{Name: "destination", Targets: []string{"Key"}, Value: "'a.txt'", Provided: true},
+		{Name: "source", Targets: []string{"Filename"}, Value: "path", Provided: true},
+	}
+	if !reflect.DeepEqual(exp.Parameters, wantParams) {
+		t.Errorf("Parameters = %+v, want %+v", exp.Parameters, wantParams)
+	}
+
+	if exp.Rule != "upload" || exp.Provider != types.ProviderAWS || exp.Service != "s3" {
+		t.Errorf("Explain() rule = %s (%s, %s)", exp.Rule, exp.Provider, exp.Service)
+	}
+	if exp.Code != "s3.upload_file(path, 'data', 'a.txt')" {
+		t.Errorf("Code = %q", exp.Code)
+	}
+	if exp.StaticParams["ServerSideEncryption"] != "'AES256'" {
+		t.Errorf("StaticParams = %v", exp.StaticParams)
+	}
+	if exp.SetupCode != "s3 = boto3.client('s3')" || len(exp.Imports) != 1 || len(exp.Requirements) != 1 {
+		t.Errorf("Explain() contributions = %q, %v, %v", exp.SetupCode, exp.Imports, exp.Requirements)
+	}
+	if len(exp.Notes) != 1 || !strings.Contains(exp.Notes[0], "argument retries is not mapped") {
+		t.Errorf("Notes = %v, want the unmapped retries argument", exp.Notes)
+	}
+
+	// With another provider's rules loaded, the target provider's rule is explained
+	writeTestRules(t, eng, types.ProviderGCP, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      code_template: "storage_client.bucket({{ .bucket }}).blob({{ .destination }}).upload_from_filename({{ .source }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+	for _, provider := range []types.Provider{types.ProviderAWS, types.ProviderGCP} {
+		exp, err := eng.Explain(calls[0], provider)
+		if err != nil {
+			t.Fatalf("Explain(%s) error = %v", provider, err)
+		}
+		if exp.Provider != provider {
+			t.Errorf("Explain(%s) explained the %s rule", provider, exp.Provider)
+		}
+	}
+}
+
+func TestNewWithOptions(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+
+	// The executor sees the interpreter named by WithPythonPath and runs
+	// the real one in its place
+	var names []string
+	recorder := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+		names = append(names, name)
+		return executor.CommandExecutor{}.Run(ctx, stdin, python, args...)
+	})
+
+	eng, err := NewWithOptions(
+		WithPythonPath("sandbox-python"),
+		WithExecutor(recorder),
+		WithCache(0),
+		WithValidation(false),
+	)
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	writeTestRules(t, eng, types.ProviderAWS, testUploadRule)
+
+	if eng.validator.Caching() {
+		t.Error("validator caches outcomes, want the cache disabled by WithCache(0)")
+	}
+	if !eng.noValidate {
+		t.Error("noValidate = false, want validation disabled by WithValidation(false)")
+	}
+
+	if _, err := eng.Transform("from infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n", types.ProviderAWS); err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if len(names) == 0 {
+		t.Fatal("executor was not used")
+	}
+	for _, name := range names {
+		if name != "sandbox-python" {
+			t.Errorf("executor ran %q, want sandbox-python", name)
+		}
+	}
+}
+
+func TestNewWithOptions_ExecutorDefaultPython(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+	host, err := util.FindPythonExecutable()
+	if err != nil {
+		t.Skip("no host Python found")
+	}
+
+	// Without WithPythonPath the executor is asked for python3 on its own
+	// PATH, not for an interpreter path resolved on this host
+	var commands []string
+	recorder := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+		commands = append(commands, strings.Join(append([]string{name}, args...), " "))
+		return executor.CommandExecutor{}.Run(ctx, stdin, python, args...)
+	})
+
+	eng, err := NewWithOptions(WithExecutor(recorder))
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	writeTestRules(t, eng, types.ProviderAWS, testUploadRule)
+
+	if _, err := eng.Transform("from infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n", types.ProviderAWS); err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if len(commands) < 2 {
+		t.Fatalf("executor ran %d command(s), want the parser and the validator", len(commands))
+	}
+	for _, command := range commands {
+		if !strings.HasPrefix(command, executor.DefaultPython+" ") || strings.Contains(command, host) {
+			t.Errorf("executor ran %q, want %s without the host path %s", command, executor.DefaultPython, host)
+		}
+	}
+}
+
+func TestNewWithOptions_ParserAndTimeout(t *testing.T) {
+	// The stub's Go AST reaches the detector, proving it replaced the
+	// built-in Python parser
+	eng, err := NewWithOptions(WithParser(&stubParser{
+		lang: types.LanguagePython,
+		ast:  &types.AST{Language: types.LanguageGo, Metadata: map[string]any{"calls": nil}},
+	}))
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	if _, err := eng.Transform("x = 1\n", types.ProviderAWS); err == nil || !strings.Contains(err.Error(), "unsupported language: go") {
+		t.Errorf("Transform() error = %v, want unsupported language from detector", err)
+	}
+
+	eng, err = NewWithOptions(WithTimeout(time.Nanosecond))
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	if _, err := eng.Transform("x = 1\n", types.ProviderAWS); err == nil {
+		t.Error("Transform() error = nil, want the parser to time out")
+	}
+}
+
+func TestEngine_CompatibleProviders(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule+`  - name: delete
+    pattern: "infrar.storage.delete"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.delete_object(Bucket={{ .bucket }}, Key={{ .path }})"
+      parameter_mapping:
+        bucket: bucket
+        path: path
+`)
+	writeTestRules(t, eng, types.ProviderGCP, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      imports:
+        - "from google.cloud import storage"
+      setup_code: "storage_client = storage.Client()"
+      code_template: "storage_client.bucket({{ .bucket }}).blob({{ .destination }}).upload_from_filename({{ .source }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	source := `from infrar.storage import upload, delete
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+delete(bucket='data', path='a.txt')
+delete(bucket='data', path='b.txt')
+`
+
+	compatible, unsupported, err := eng.CompatibleProviders(source)
+	if err != nil {
+		t.Fatalf("CompatibleProviders() error = %v", err)
+	}
+
+	if want := []types.Provider{types.ProviderAWS}; !reflect.DeepEqual(compatible, want) {
+		t.Errorf("compatible = %v, want %v", compatible, want)
+	}
+	want := map[types.Provider][]string{types.ProviderGCP: {"infrar.storage.delete"}}
+	if !reflect.DeepEqual(unsupported, want) {
+		t.Errorf("unsupported = %v, want %v", unsupported, want)
+	}
+}
+
+func TestEngine_ConcurrentTransformDuringReload(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+	eng.WithValidation(false)
+
+	pluginDir := t.TempDir()
+	rulesDir := filepath.Join(pluginDir, "storage", "aws")
+	if err := os.MkdirAll(rulesDir, 0755); err != nil {
+		t.Fatalf("Failed to create plugin directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(rulesDir, "rules.yaml"), []byte("operations:\n"+testUploadRule), 0644); err != nil {
+		t.Fatalf("Failed to write rules: %v", err)
+	}
+
+	source := `from infrar.storage import upload
+
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	// Reload the rules until the transforms are done
+	stop := make(chan struct{})
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				if err := eng.LoadRules(pluginDir, types.ProviderAWS, "storage"); err != nil {
+					t.Errorf("LoadRules() error = %v", err)
+					return
+				}
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 3; j++ {
+				result, err := eng.Transform(source, types.ProviderAWS)
+				if err != nil {
+					t.Errorf("Transform() error = %v", err)
+					return
+				}
+				if !strings.Contains(result.TransformedCode, "s3.upload_file('a.txt', 'data', 'a.txt')") {
+					t.Errorf("TransformedCode =\n%s\nwant the upload transformed", result.TransformedCode)
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-reloaded
+}
+
+func TestEngine_Transform_UnusedInfrarImport(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	source := `import os
+from infrar.storage import upload
+
+print(os.getcwd())
+`
+
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `import os
+
+print(os.getcwd())
+`
+	if result.TransformedCode != want {
+		t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, want)
+	}
+
+	wantWarning := types.Warning{
+		Message:    "unused Infrar import of infrar.storage removed",
+		LineNumber: 2,
+		Category:   "unused-import",
+	}
+	found := false
+	for _, w := range result.Warnings {
+		found = found || w == wantWarning
+	}
+	if !found {
+		t.Errorf("Warnings = %+v, want %+v", result.Warnings, wantWarning)
+	}
+}
+
+func TestEngine_Transform_EnvTemplates(t *testing.T) {
+	t.Setenv("INFRAR_TEST_REGION", "eu-west-1")
+
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3', region_name={{ env \"INFRAR_TEST_REGION\" }})"
+      code_template: "s3.upload_file({{ .source }}, {{ runtimeEnv \"BUCKET\" \"data\" }}, {{ .destination }})"
+      parameter_mapping:
+        source: source
+        destination: destination
+`)
+
+	source := "from infrar.storage import upload\n\nupload(source='a.txt', destination='a.txt')\n"
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	// env is resolved now, runtimeEnv when the code runs, with os imported
+	for _, want := range []string{
+		"import os\n",
+		"s3 = boto3.client('s3', region_name='eu-west-1')",
+		"s3.upload_file('a.txt', os.environ.get('BUCKET', 'data'), 'a.txt')",
+	} {
+		if !strings.Contains(result.TransformedCode, want) {
+			t.Errorf("TransformedCode =\n%s\nwant it to contain %q", result.TransformedCode, want)
+		}
+	}
+
+	// An unset variable without a default fails generation
+	os.Unsetenv("INFRAR_TEST_REGION")
+	_, err = eng.Transform(source, types.ProviderAWS)
+	if !errors.Is(err, types.ErrGeneration) || !strings.Contains(err.Error(), "INFRAR_TEST_REGION is not set") {
+		t.Errorf("Transform() error = %v, want a generation error naming the unset variable", err)
+	}
+}
+
+func TestEngine_WithSharedClients(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      client:
+        name: s3
+        code: "boto3.client('s3')"
+        shareable: true
+      code_template: "{{ .client }}.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	call := "upload(bucket='data', source='a.txt', destination='a.txt')\n"
+	source := "from infrar.storage import upload\n\n" + strings.Repeat(call, 3)
+
+	// Without sharing, each call creates its own client
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if got := strings.Count(result.TransformedCode, "boto3.client('s3').upload_file("); got != 3 {
+		t.Errorf("TransformedCode =\n%s\nwant 3 inline clients, got %d", result.TransformedCode, got)
+	}
+
+	result, err = eng.WithSharedClients(true).Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if got := strings.Count(result.TransformedCode, "boto3.client("); got != 1 {
+		t.Errorf("TransformedCode =\n%s\nwant 1 client, got %d", result.TransformedCode, got)
+	}
+	if !strings.Contains(result.TransformedCode, "s3 = boto3.client('s3')\n") {
+		t.Errorf("TransformedCode =\n%s\nwant the shared client in the setup code", result.TransformedCode)
+	}
+	if got := strings.Count(result.TransformedCode, "\ns3.upload_file('a.txt', 'data', 'a.txt')"); got != 3 {
+		t.Errorf("TransformedCode =\n%s\nwant 3 calls on the shared client, got %d", result.TransformedCode, got)
+	}
+}
+
+func TestEngine_WithStyle(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: |
+        s3.upload_file(
+            {{ .source }},
+            {{ .bucket }},
+            {{ .destination }},
+        )
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	source := "from infrar.storage import upload\n\nupload(bucket='data', source=\"it's.txt\", destination=f'backup/{name}')\n"
+	result, err := eng.WithStyle(types.StyleOptions{Quote: `"`, IndentWidth: 2, MaxLineLength: 15}).Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file(\n  \"it's.txt\",\n  \"data\",\n  f\"backup/{name}\",\n)"
+	if !strings.Contains(result.TransformedCode, want) {
+		t.Errorf("TransformedCode =\n%s\nwant it to contain\n%s", result.TransformedCode, want)
+	}
+	var found bool
+	for _, w := range result.Warnings {
+		if w.Category == "style" && w.LineNumber == 3 && strings.Contains(w.Message, "over the maximum line length of 15") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Warnings = %+v, want a style warning on line 3", result.Warnings)
+	}
+
+	// The default style quotes with single quotes, escaping them
+	result, err = eng.WithStyle(types.StyleOptions{}).Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if !strings.Contains(result.TransformedCode, `    'it\'s.txt',`) {
+		t.Errorf("TransformedCode =\n%s\nwant single-quoted literals", result.TransformedCode)
+	}
+}
+
+func TestEngine_Transform_SourceEncodings(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	tests := []struct {
+		name     string
+		source   string
+		want     string
+		encoding string
+	}{
+		{
+			name:     "UTF-8 with BOM",
+			source:   "\ufefffrom infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n",
+			want:     "\ufeffimport boto3\n",
+			encoding: "utf-8-sig",
+		},
+		{
+			name:     "latin-1",
+			source:   "# -*- coding: latin-1 -*-\nfrom infrar.storage import upload\n\n# caf\xe9\nupload(bucket='data', source='caf\xe9.txt', destination='a.txt')\n",
+			want:     "# caf\xe9\ns3.upload_file('caf\xe9.txt', 'data', 'a.txt')\n",
+			encoding: "latin-1",
+		},
+	}
+
+	// The file APIs read the source themselves, TransformFiles in a batch
+	transforms := map[string]func(source string) (*types.TransformationResult, error){
+		"Transform": func(source string) (*types.TransformationResult, error) {
+			return eng.Transform(source, types.ProviderAWS)
+		},
+		"TransformFile": func(source string) (*types.TransformationResult, error) {
+			path := filepath.Join(t.TempDir(), "app.py")
+			if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+				t.Fatalf("Failed to write source: %v", err)
+			}
+			return eng.TransformFile(path, types.ProviderAWS)
+		},
+		"TransformFiles": func(source string) (*types.TransformationResult, error) {
+			path := filepath.Join(t.TempDir(), "app.py")
+			if err := os.WriteFile(path, []byte(source), 0644); err != nil {
+				t.Fatalf("Failed to write source: %v", err)
+			}
+			results, errs := eng.TransformFiles([]string{path}, types.ProviderAWS)
+			return results[0], errs[0]
+		},
+	}
+
+	for _, tt := range tests {
+		for api, transform := range transforms {
+			t.Run(tt.name+"/"+api, func(t *testing.T) {
+				result, err := transform(tt.source)
+				if err != nil {
+					t.Fatalf("%s() error = %v", api, err)
+				}
+				if !strings.Contains(result.TransformedCode, tt.want) {
+					t.Errorf("TransformedCode = %q, want it to contain %q", result.TransformedCode, tt.want)
+				}
+				if got := result.Metadata["encoding"]; got != tt.encoding {
+					t.Errorf("Metadata[encoding] = %v, want %s", got, tt.encoding)
+				}
+
+				// Line numbers count from the original first line
+				if len(result.Report) != 1 || result.Report[0].LineNumber != strings.Count(tt.source[:strings.Index(tt.source, "upload(")], "\n")+1 {
+					t.Errorf("Report = %+v, want the call on its original line", result.Report)
+				}
+			})
+		}
+	}
+}
+
+func TestEngine_WithImportComments(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule).WithImportComments(true)
+
+	source := "from infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n"
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.HasPrefix(result.TransformedCode, "# migrated: from infrar.storage import upload\nimport boto3\n") {
+		t.Errorf("TransformedCode =\n%s\nwant the original import commented out above the new one", result.TransformedCode)
+	}
+	for _, line := range strings.Split(result.TransformedCode, "\n") {
+		if strings.HasPrefix(line, "from infrar") {
+			t.Errorf("TransformedCode =\n%s\nstill imports infrar", result.TransformedCode)
+		}
+	}
+}
+
+func TestEngine_Transform_MethodChain(t *testing.T) {
+	eng := newTestEngine(t, `  - name: bucket upload
+    pattern: "infrar.storage.bucket.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+      parameter_mapping:
+        bucket.arg_0: bucket
+        source: source
+        destination: destination
+`)
+
+	source := "from infrar import storage\n\nstorage.bucket('data').upload(source='a.txt', destination='b.txt')\n"
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "\ns3.upload_file('a.txt', 'data', 'b.txt')\n") {
+		t.Errorf("TransformedCode =\n%s\nwant the whole chain replaced", result.TransformedCode)
+	}
+	if len(result.Report) != 1 || result.Report[0].Pattern != "infrar.storage.bucket.upload" {
+		t.Errorf("Report = %+v, want one call matched by the chained pattern", result.Report)
+	}
+}
+
+func TestEngine_LoadBundle(t *testing.T) {
+	pluginDir := t.TempDir()
+	if err := os.CopyFS(pluginDir, os.DirFS("../../test-plugins")); err != nil {
+		t.Fatalf("Failed to copy plugins: %v", err)
+	}
+	bundlePath := filepath.Join(t.TempDir(), "rules.bundle")
+	if err := plugin.NewLoader(pluginDir).CompileBundle(bundlePath); err != nil {
+		t.Fatalf("CompileBundle() error = %v", err)
+	}
+
+	fromYAML, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	fromBundle, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+	for _, provider := range []types.Provider{types.ProviderAWS, types.ProviderGCP} {
+		if err := fromYAML.LoadAllRules(pluginDir, provider); err != nil {
+			t.Fatalf("LoadAllRules() error = %v", err)
+		}
+	}
+	if err := fromBundle.LoadBundle(bundlePath); err != nil {
+		t.Fatalf("LoadBundle() error = %v", err)
+	}
+
+	// Both engines hold the same rules and transform identically
+	if got, want := len(fromBundle.GetRegistry().AllRules()), len(fromYAML.GetRegistry().AllRules()); got != want {
+		t.Errorf("bundle holds %d rules, want %d", got, want)
+	}
+	source := "from infrar.storage import upload, download\n\nupload(bucket='data', source='a.txt', destination='a.txt')\ndownload(bucket='data', source='a.txt', destination='b.txt')\n"
+	for _, provider := range []types.Provider{types.ProviderAWS, types.ProviderGCP} {
+		want, err := fromYAML.Transform(source, provider)
+		if err != nil {
+			t.Fatalf("Transform() error = %v", err)
+		}
+		got, err := fromBundle.Transform(source, provider)
+		if err != nil {
+			t.Fatalf("Transform() from bundle error = %v", err)
+		}
+		if got.TransformedCode != want.TransformedCode || !reflect.DeepEqual(got.Imports, want.Imports) {
+			t.Errorf("%s from bundle =\n%s\nwant\n%s", provider, got.TransformedCode, want.TransformedCode)
+		}
+	}
+
+	// Editing a rules file invalidates the bundle
+	rulesPath := filepath.Join(pluginDir, "storage", "aws", "rules.yaml")
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(rulesPath, later, later); err != nil {
+		t.Fatalf("Failed to touch rules: %v", err)
+	}
+	if err := fromBundle.LoadBundle(bundlePath); !errors.Is(err, plugin.ErrStaleBundle) {
+		t.Errorf("LoadBundle() error = %v, want ErrStaleBundle", err)
+	}
+}
+
+func TestEngine_Transform_ArgumentOrder(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ range $i, $arg := ._meta.args }}{{ if $i }}, {{ end }}{{ $arg.name }}={{ $arg.value }}{{ end }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	source := "from infrar.storage import upload\n\nupload(destination='b.txt', bucket='data', source='a.txt')\n"
+	first, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file(destination='b.txt', bucket='data', source='a.txt')"
+	if !strings.Contains(first.TransformedCode, want) {
+		t.Errorf("TransformedCode =\n%s\nwant arguments in source order: %s", first.TransformedCode, want)
+	}
+
+	// The same input always generates the same bytes
+	for i := 0; i < 5; i++ {
+		again, err := eng.Transform(source, types.ProviderAWS)
+		if err != nil {
+			t.Fatalf("Transform() error = %v", err)
+		}
+		if again.TransformedCode != first.TransformedCode {
+			t.Fatalf("TransformedCode differs between runs:\n%s\n---\n%s", first.TransformedCode, again.TransformedCode)
+		}
+	}
+}
+
+func TestEngine_WithSDKPrefix(t *testing.T) {
+	eng, err := NewWithOptions(WithSDKPrefix("mycorp_infra"))
+	if err != nil {
+		t.Fatalf("NewWithOptions() error = %v", err)
+	}
+	writeTestRules(t, eng, types.ProviderAWS, testUploadRule)
+
+	source := "from mycorp_infra.storage import upload\n\nupload(bucket='data', source='a.txt', destination='b.txt')\n"
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if !strings.Contains(result.TransformedCode, "s3.upload_file") {
+		t.Errorf("TransformedCode =\n%s\nwant the upload transformed", result.TransformedCode)
+	}
+	if strings.Contains(result.TransformedCode, "mycorp_infra") {
+		t.Errorf("TransformedCode =\n%s\nwant the vendored import removed", result.TransformedCode)
+	}
+}
+
+func TestEngine_IsTransformed(t *testing.T) {
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		source string
+		want   bool
+		calls  int
+	}{
+		{"Migrated file", "import boto3\n\ns3 = boto3.client('s3')\ns3.upload_file('a.txt', 'data', 'a.txt')\n", true, 0},
+		{"Infrar calls left", "from infrar.storage import upload, download\n\nupload(bucket='data')\ndownload(bucket='data')\n", false, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transformed, calls, err := eng.IsTransformed(tt.source)
+			if err != nil {
+				t.Fatalf("IsTransformed() error = %v", err)
+			}
+			if transformed != tt.want || len(calls) != tt.calls {
+				t.Errorf("IsTransformed() = %v, %d calls, want %v, %d calls", transformed, len(calls), tt.want, tt.calls)
+			}
+		})
+	}
+}
+
+func TestEngine_Transform_SyntaxWarnings(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	source := "from infrar.storage import upload\n\npattern = '\\d+'\nupload(bucket='data', source='file.txt', destination='file.txt')\n"
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	var found bool
+	for _, w := range result.Warnings {
+		if w.Category == "syntax" && w.LineNumber == 3 && strings.Contains(w.Message, `invalid escape sequence '\d'`) {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("Warnings = %+v, want a syntax warning for the escape on line 3", result.Warnings)
+	}
+}
+
+func TestEngine_Transform_WithStatement(t *testing.T) {
+	eng := newTestEngine(t, `  - name: open
+    pattern: "infrar.storage.open"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.get_object(Bucket={{ .bucket }}, Key={{ .key }})"
+      context_template: "s3.get_object(Bucket={{ .bucket }}, Key={{ .key }})['Body']"
+      wrapper_template: |
+        try:
+            {{ .call }}
+        except Exception:
+            pass
+      parameter_mapping:
+        bucket: bucket
+        key: key
+`)
+
+	source := `from infrar import storage
+
+
+def load():
+    with storage.open(bucket='data', key='a.txt') as fh, open('b.txt') as out:  # streamed
+        out.write(fh.read())
+`
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	// Only the with item is replaced, by the context template, unwrapped
+	want := `    with s3.get_object(Bucket='data', Key='a.txt')['Body'] as fh, open('b.txt') as out:  # streamed
+        out.write(fh.read())
+`
+	if !strings.Contains(result.TransformedCode, want) {
+		t.Errorf("TransformedCode =\n%s\nwant it to contain\n%s", result.TransformedCode, want)
+	}
+	if strings.Contains(result.TransformedCode, "try:") {
+		t.Errorf("TransformedCode =\n%s\nwant no wrapper around the with item", result.TransformedCode)
+	}
+}
+
+func TestEngine_TransformFragment(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	tests := []struct {
+		name        string
+		fragment    string
+		indentLevel int
+		want        string
+	}{
+		{
+			name:        "Indented single call",
+			fragment:    "        infrar.storage.upload(bucket='data', source='file.txt', destination='file.txt')\n",
+			indentLevel: 8,
+			want:        "        import boto3\n\n        s3 = boto3.client('s3')\n\n        s3.upload_file('file.txt', 'data', 'file.txt')\n",
+		},
+		{
+			name:        "Nested block moved out one level",
+			fragment:    "        if ready:\n            infrar.storage.upload(bucket='data', source='file.txt', destination='file.txt')\n",
+			indentLevel: 4,
+			want:        "    import boto3\n\n    s3 = boto3.client('s3')\n\n    if ready:\n        s3.upload_file('file.txt', 'data', 'file.txt')\n",
+		},
+		{
+			name:        "Multi-line string argument",
+			fragment:    "        notes = '''first\nsecond\n            third'''\n        infrar.storage.upload(bucket='data', source=\"\"\"a\n  b.txt\"\"\", destination=notes)\n",
+			indentLevel: 4,
+			want:        "    import boto3\n\n    s3 = boto3.client('s3')\n\n    notes = '''first\nsecond\n            third'''\n    s3.upload_file('a\\n  b.txt', 'data', notes)\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := eng.TransformFragment(tt.fragment, tt.indentLevel, types.ProviderAWS)
+			if err != nil {
+				t.Fatalf("TransformFragment() error = %v", err)
+			}
+			if result.TransformedCode != tt.want {
+				t.Errorf("TransformFragment() got:\n%s\nwant:\n%s", result.TransformedCode, tt.want)
+			}
+		})
+	}
+
+	if _, err := eng.TransformFragment("upload()", -1, types.ProviderAWS); err == nil {
+		t.Error("TransformFragment() with a negative indent level succeeded, want an error")
+	}
+}
+
+func TestEngine_ParseTimeout(t *testing.T) {
+	python, err := exec.LookPath("python3")
+	if err != nil {
+		t.Skip("python3 not found")
+	}
+
+	// The executor hangs until the parser gives up on its first slowRuns runs
+	newSlowEngine := func(slowRuns int) (*Engine, *int) {
+		runs := 0
+		slow := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+			runs++
+			if runs <= slowRuns {
+				<-ctx.Done()
+				return "", "", errors.New("signal: killed")
+			}
+			// Without the deadline, which a cold interpreter start may exceed
+			return executor.CommandExecutor{}.Run(context.Background(), stdin, python, args...)
+		})
+
+		eng, err := NewWithOptions(WithExecutor(slow), WithTimeout(50*time.Millisecond), WithValidation(false))
+		if err != nil {
+			t.Fatalf("NewWithOptions() error = %v", err)
+		}
+		writeTestRules(t, eng, types.ProviderAWS, testUploadRule)
+		return eng, &runs
+	}
+	source := "from infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n"
+
+	// A single slow run is retried
+	eng, runs := newSlowEngine(1)
+	if _, err := eng.Transform(source, types.ProviderAWS); err != nil {
+		t.Fatalf("Transform() error = %v, want the timed out parse retried", err)
+	}
+	if *runs != 2 {
+		t.Errorf("parser ran %d times, want 2", *runs)
+	}
+
+	// Timing out again is a parse error naming the timeout
+	eng, runs = newSlowEngine(2)
+	_, err = eng.Transform(source, types.ProviderAWS)
+	var transformErr *types.TransformationError
+	if !errors.As(err, &transformErr) {
+		t.Fatalf("Transform() error = %v, want a TransformationError", err)
+	}
+	if !errors.Is(err, types.ErrParse) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Transform() error = %v, want a parse error caused by the deadline", err)
+	}
+	wantSuggestion := "Raise the timeout with engine.NewWithOptions(engine.WithTimeout(...)), or split the file if it is very large"
+	if !strings.Contains(transformErr.Message, "timed out after 50ms") || transformErr.Suggestion != wantSuggestion {
+		t.Errorf("Transform() error = %q (suggestion %q), want the timeout and how to raise it", transformErr.Message, transformErr.Suggestion)
+	}
+	if *runs != 2 {
+		t.Errorf("parser ran %d times, want 2", *runs)
+	}
+
+	// A context cancelled by the caller is not retried or reported as a timeout
+	eng, runs = newSlowEngine(2)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := eng.TransformContext(ctx, source, types.ProviderAWS); err != context.DeadlineExceeded {
+		t.Errorf("TransformContext() error = %v, want the context's error", err)
+	}
+	if *runs != 1 {
+		t.Errorf("parser ran %d times, want 1", *runs)
+	}
+}
+
+func TestEngine_TransformWith(t *testing.T) {
+	eng, err := New()
+	if err != nil {
+		t.Fatalf("Failed to create engine: %v", err)
+	}
+
+	newRegistry := func(codeTemplate string) *plugin.Registry {
+		registry := plugin.NewRegistry()
+		registry.Register(types.TransformationRule{
+			Pattern:      "infrar.storage.upload",
+			Provider:     types.ProviderAWS,
+			Imports:      []string{"import boto3"},
+			CodeTemplate: codeTemplate,
+			ParameterMapping: map[string]types.ParameterTargets{
+				"bucket": {"bucket"},
+				"source": {"source"},
+			},
+		})
+		return registry
+	}
+	eng.WithRegistry("tenant-a", newRegistry("boto3.client('s3').upload_file({{ .source }}, {{ .bucket }}, {{ .source }})"))
+	eng.WithRegistry("tenant-b", newRegistry("boto3.resource('s3').Bucket({{ .bucket }}).upload_file({{ .source }}, {{ .source }})"))
+
+	source := "from infrar.storage import upload\n\nupload(bucket='data', source='a.txt')\n"
+	want := map[string]string{
+		"tenant-a": "boto3.client('s3').upload_file('a.txt', 'data', 'a.txt')",
+		"tenant-b": "boto3.resource('s3').Bucket('data').upload_file('a.txt', 'a.txt')",
+	}
+	for name, code := range want {
+		result, err := eng.TransformWith(name, source, types.ProviderAWS)
+		if err != nil {
+			t.Fatalf("TransformWith(%q) error = %v", name, err)
+		}
+		if !strings.Contains(result.TransformedCode, code) {
+			t.Errorf("TransformWith(%q) =\n%s\nwant it to contain %s", name, result.TransformedCode, code)
+		}
+	}
+
+	// The engine's own rules are untouched, and unknown names are an error
+	if _, err := eng.Transform(source, types.ProviderAWS); err == nil {
+		t.Error("Transform() succeeded with no rules loaded, want the named registries kept separate")
+	}
+	if _, err := eng.TransformWith("tenant-c", source, types.ProviderAWS); err == nil || !strings.Contains(err.Error(), "tenant-a, tenant-b") {
+		t.Errorf("TransformWith(\"tenant-c\") error = %v, want the registries listed", err)
+	}
+}
+
+func TestEngine_Transform_AttributeArguments(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	source := `from infrar.storage import upload
+
+upload(bucket=config.bucket_name, source=self.settings.paths.report, destination='report.csv')
+`
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file(self.settings.paths.report, config.bucket_name, 'report.csv')"
+	if !strings.Contains(result.TransformedCode, want) {
+		t.Errorf("TransformedCode =\n%s\nwant it to contain %s", result.TransformedCode, want)
+	}
+}
+
+func TestEngine_NoOpCheck(t *testing.T) {
+	echoRule := func(codeTemplate string) string {
+		return `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      code_template: "` + codeTemplate + `"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+`
+	}
+
+	tests := []struct {
+		name        string
+		rule        string
+		source      string
+		wantMessage string // Empty for no no-op warning
+	}{
+		{
+			name:        "identical code",
+			rule:        echoRule("infrar.storage.upload(bucket={{ .bucket }}, source={{ .source }})"),
+			source:      "\ninfrar.storage.upload(bucket='data', source='a.txt')\n",
+			wantMessage: "generated code is identical to the source",
+		},
+		{
+			name:        "echoed call",
+			rule:        echoRule("upload(bucket={{ .bucket }}, source={{ .source }})"),
+			source:      "from infrar.storage import upload\nupload(bucket='data', source='a.txt')\n",
+			wantMessage: "rule upload rendered infrar.storage.upload unchanged",
+		},
+		{
+			name:   "real transform",
+			rule:   testUploadRule,
+			source: "from infrar.storage import upload\nupload(bucket='data', source='a.txt', destination='b.txt')\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eng := newTestEngine(t, tt.rule)
+			eng.WithNoOpCheck(true)
+
+			result, err := eng.Transform(tt.source, types.ProviderAWS)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			var noOps []types.Warning
+			for _, w := range result.Warnings {
+				if w.Category == "no-op" {
+					noOps = append(noOps, w)
+				}
+			}
+			if tt.wantMessage == "" {
+				if len(noOps) > 0 {
+					t.Errorf("Transform() no-op warnings = %+v, want none", noOps)
+				}
+				return
+			}
+			if len(noOps) != 1 || !strings.Contains(noOps[0].Message, tt.wantMessage) || noOps[0].LineNumber != 2 {
+				t.Errorf("Transform() no-op warnings = %+v, want one on line 2 containing %q", noOps, tt.wantMessage)
+			}
+
+			// Strict mode turns the warning into a failure
+			eng.WithStrict(true)
+			if _, err := eng.Transform(tt.source, types.ProviderAWS); err == nil {
+				t.Error("Transform() in strict mode succeeded, want the no-op transform to fail")
+			}
+		})
+	}
+}
+
+func TestEngine_WithPragmaRules(t *testing.T) {
+	source := `from infrar.storage import upload
+
+# infrar: provider=gcp
+upload(bucket='data', source='a.txt', destination='a.txt')
+`
+
+	eng := newTestEngine(t, testUploadRule)
+	if _, err := eng.Transform(source, types.ProviderAWS); err == nil {
+		t.Fatal("Transform() succeeded without GCP rules, want the pragma call to fail")
+	}
+
+	eng.WithPragmaRules("../../test-plugins", []string{"storage"})
+	result, err := eng.Transform(source, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if !strings.Contains(result.TransformedCode, "from google.cloud import storage") {
+		t.Errorf("TransformedCode =\n%s\nwant the GCP rule loaded for the pragma", result.TransformedCode)
+	}
+
+	// The rules are added to the transform, not to the engine's registry
+	if ops := eng.SupportedOperations(types.ProviderGCP); len(ops) != 0 {
+		t.Errorf("SupportedOperations(gcp) = %v, want none", ops)
+	}
+}
+
+func TestEngine_ErrorChain(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	_, err := eng.Transform("def broken(:\n", types.ProviderAWS)
+	if !errors.Is(err, types.ErrParse) {
+		t.Errorf("Transform() error = %v, want errors.Is ErrParse", err)
+	}
+	var transformErr *types.TransformationError
+	if !errors.As(err, &transformErr) || transformErr.Line != 1 {
+		t.Errorf("Transform() error = %v, want a *TransformationError at line 1", err)
+	}
+
+	// Missing rules are wrapped by LoadRules but keep their category and cause
+	err = eng.LoadRules(t.TempDir(), types.ProviderGCP, "storage")
+	if !errors.Is(err, types.ErrValidation) || !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("LoadRules() error = %v, want ErrValidation wrapping fs.ErrNotExist", err)
+	}
+}
+
+func TestEngine_TransformFiles(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule)
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.py":      "from infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n",
+		"broken.py": "def broken(:\n",
+		"plain.py":  "print('hello')\n",
+	}
+	var paths []string
+	for _, name := range []string{"a.py", "broken.py", "plain.py"} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(files[name]), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		paths = append(paths, path)
+	}
+
+	results, errs := eng.TransformFiles(paths, types.ProviderAWS)
+
+	if errs[0] != nil || !strings.Contains(results[0].TransformedCode, "s3.upload_file('a.txt', 'data', 'a.txt')") {
+		t.Errorf("a.py: result = %v, error = %v", results[0], errs[0])
+	}
+	if results[0] != nil && results[0].Filepath != paths[0] {
+		t.Errorf("a.py: Filepath = %q, want %q", results[0].Filepath, paths[0])
+	}
+	if !errors.Is(errs[1], types.ErrParse) || results[1] != nil {
+		t.Errorf("broken.py: result = %v, error = %v, want a parse error", results[1], errs[1])
+	}
+	if errs[2] != nil || results[2].TransformedCode != files["plain.py"] {
+		t.Errorf("plain.py: result = %+v, error = %v", results[2], errs[2])
+	}
+}
+
+func TestEngine_Transform_OptionalParameter(t *testing.T) {
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }}{{ if .acl }}, ExtraArgs={'ACL': {{ .acl }}}{{ end }})"
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+        acl: ACL
+      optional_params: [acl]
+`)
+
+	tests := []struct {
+		name string
+		call string
+		want string
+	}{
+		{
+			name: "with acl",
+			call: "upload(bucket='data', source='a.txt', destination='a.txt', acl='public-read')",
+			want: "s3.upload_file('a.txt', 'data', 'a.txt', ExtraArgs={'ACL': 'public-read'})",
+		},
+		{
+			name: "without acl",
+			call: "upload(bucket='data', source='a.txt', destination='a.txt')",
+			want: "s3.upload_file('a.txt', 'data', 'a.txt')",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := eng.Transform("from infrar.storage import upload\n\n"+tt.call+"\n", types.ProviderAWS)
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if len(result.Report) != 1 || result.Report[0].Transformed != tt.want {
+				t.Errorf("Transformed = %v, want %s", result.Report, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngine_WithStrict(t *testing.T) {
+	eng := newTestEngine(t, testUploadRule+`  - name: put
+    pattern: "infrar.storage.put"
+    deprecated: true
+    replacement: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      code_template: "s3.put_object(Bucket={{ .bucket }})"
+      parameter_mapping:
+        bucket: bucket
+`).WithStrict(true)
+
+	clean := "from infrar.storage import upload\n\nupload(bucket='data', source='a.txt', destination='a.txt')\n"
+	if _, err := eng.Transform(clean, types.ProviderAWS); err != nil {
+		t.Errorf("Transform() error = %v, want none without warnings", err)
+	}
+
+	// A file without Infrar calls only gets an informational notice
+	if _, err := eng.Transform("print('hello')\n", types.ProviderAWS); err != nil {
+		t.Errorf("Transform() error = %v, want none for a file without calls", err)
+	}
+
+	deprecated := "from infrar.storage import put\n\nput(bucket='data')\n"
+	result, err := eng.Transform(deprecated, types.ProviderAWS)
+	var warningsErr *types.WarningsError
+	if !errors.As(err, &warningsErr) {
+		t.Fatalf("Transform() error = %v, want *types.WarningsError", err)
+	}
+	if len(warningsErr.Warnings) != 1 || warningsErr.Warnings[0].Category != "deprecated" {
+		t.Errorf("Warnings = %+v, want one deprecation", warningsErr.Warnings)
+	}
+	if result == nil || !strings.Contains(result.TransformedCode, "s3.put_object") {
+		t.Errorf("Expected the result alongside the error, got %+v", result)
+	}
+}
+
+func TestEngine_Transform_Decorators(t *testing.T) {
+	eng := newTestEngine(t, `  - name: on
+    pattern: "infrar.events.on"
+    target:
+      provider: aws
+      service: eventbridge
+    transformation:
+      imports:
+        - "from app import router"
+      code_template: "router.on_event({{ .arg_0 }})"
+      parameter_mapping:
+        arg_0: event
+  - name: handler
+    pattern: "infrar.events.handler"
+    target:
+      provider: aws
+      service: eventbridge
+    transformation:
+      imports:
+        - "from app import router"
+      code_template: "router.default_handler"
+`)
+
+	sourceCode := `import infrar.events
+
+@infrar.events.on('upload')
+def handle(event):
+    return event
+
+
+@infrar.events.handler
+async def fallback(event):
+    return None
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `from app import router
+
+
+@router.on_event('upload')
+def handle(event):
+    return event
+
+
+@router.default_handler
+async def fallback(event):
+    return None
+`
+	if result.TransformedCode != want {
+		t.Errorf("TransformedCode =\n%s\nwant\n%s", result.TransformedCode, want)
+	}
+}
+
+func TestEngine_Transform_ComprehensionsAndLambdas(t *testing.T) {
+	// The wrapper's try/except cannot go inside an expression, so calls in a
+	// comprehension or lambda are replaced by the bare call
+	eng := newTestEngine(t, `  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      setup_code: "s3 = boto3.client('s3')"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+      wrapper_template: |
+        try:
+            {{ .call }}
+        except Exception:
+            pass
+      parameter_mapping:
+        bucket: bucket
+        source: source
+        destination: destination
+`)
+
+	sourceCode := `from infrar.storage import upload
+
+buckets = ['a', 'b']
+results = [upload(bucket=b, source='x.txt', destination='x.txt') for b in buckets]
+by_bucket = {b: upload(bucket=b, source='y.txt', destination='y.txt') for b in buckets if b}
+push = lambda b: upload(bucket=b, source='z.txt', destination='z.txt')
+`
+
+	result, err := eng.Transform(sourceCode, types.ProviderAWS)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := `buckets = ['a', 'b']
+results = [s3.upload_file('x.txt', b, 'x.txt') for b in buckets]
+by_bucket = {b: s3.upload_file('y.txt', b, 'y.txt') for b in buckets if b}
+push = lambda b: s3.upload_file('z.txt', b, 'z.txt')
+`
+	if !strings.HasSuffix(result.TransformedCode, want) {
+		t.Errorf("TransformedCode =\n%s\nwant suffix\n%s", result.TransformedCode, want)
+	}
+}
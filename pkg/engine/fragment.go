@@ -0,0 +1,85 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// TransformFragment transforms a piece of Python code that is not a whole
+// module, such as a selection in an editor, which is usually indented as
+// part of a function. The fragment's common indentation is removed so
+// Python can parse it, and the transformed code is indented by indentLevel
+// spaces, for pasting back where the fragment came from.
+//
+// The fragment is parsed on its own: its calls are only recognized through
+// imports the fragment contains, or written out in full, as in
+// infrar.storage.upload(...). The imports and setup code the rules need go
+// at the top of the fragment, which is valid inside a function too; they are
+// also listed in the result for callers that prefer adding them at module
+// level.
+func (e *Engine) TransformFragment(code string, indentLevel int, targetProvider types.Provider) (*types.TransformationResult, error) {
+	if indentLevel < 0 {
+		return nil, fmt.Errorf("indent level must not be negative, got %d", indentLevel)
+	}
+
+	result, err := e.TransformLanguage(context.Background(), dedentFragment(code), types.LanguagePython, targetProvider)
+	if result != nil {
+		result.TransformedCode = indentFragment(result.TransformedCode, strings.Repeat(" ", indentLevel))
+	}
+	return result, err
+}
+
+// dedentFragment removes the leading whitespace every non-blank line of
+// code shares. Blank lines do not count, so they may be empty, and neither
+// do lines continuing a multi-line string, which are left as they are.
+func dedentFragment(code string) string {
+	lines := strings.Split(code, "\n")
+	inString := parser.StringLines(code)
+
+	var common string
+	first := true
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" || inString[i] {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if first {
+			common, first = indent, false
+			continue
+		}
+		for !strings.HasPrefix(indent, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if common == "" {
+		return code
+	}
+
+	for i, line := range lines {
+		if !inString[i] {
+			lines[i] = strings.TrimPrefix(line, common)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// indentFragment prefixes every non-blank line of code with indent, except
+// lines continuing a multi-line string, whose contents must not change
+func indentFragment(code, indent string) string {
+	if indent == "" {
+		return code
+	}
+
+	lines := strings.Split(code, "\n")
+	inString := parser.StringLines(code)
+	for i, line := range lines {
+		if strings.TrimSpace(line) != "" && !inString[i] {
+			lines[i] = indent + line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
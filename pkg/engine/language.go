@@ -0,0 +1,71 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// languageMarker is a per-line pattern hinting at a source language
+type languageMarker struct {
+	pattern *regexp.Regexp
+	weight  int
+}
+
+// languageMarkers lists the patterns DetectLanguage scores each line against.
+// Statements only one language accepts carry more weight.
+var languageMarkers = map[types.Language][]languageMarker{
+	types.LanguagePython: {
+		{regexp.MustCompile(`^\s*(async\s+)?def \w+\(.*\)(\s*->.*)?:\s*$`), 3},
+		{regexp.MustCompile(`^\s*from [\w.]+ import `), 3},
+		{regexp.MustCompile(`^\s*import [\w.]+(\s+as \w+)?(,\s*[\w.]+(\s+as \w+)?)*\s*$`), 2},
+		{regexp.MustCompile(`^\s*(if|elif|else|for|while|try|except|finally|with|class)\b.*:\s*$`), 2},
+		{regexp.MustCompile(`\bself\.`), 1},
+	},
+	types.LanguageNodeJS: {
+		{regexp.MustCompile(`\brequire\(\s*['"]`), 3},
+		{regexp.MustCompile(`^\s*(const|let|var) [\w{}\[\], ]+\s*=`), 2},
+		{regexp.MustCompile(`^\s*import .* from ['"]`), 3},
+		{regexp.MustCompile(`^\s*(export|module\.exports)\b`), 2},
+		{regexp.MustCompile(`=>`), 1},
+		{regexp.MustCompile(`\bfunction\s*\w*\s*\(`), 1},
+		{regexp.MustCompile(`\bconsole\.\w+\(`), 1},
+	},
+	types.LanguageGo: {
+		{regexp.MustCompile(`^package \w+\s*$`), 5},
+		{regexp.MustCompile(`^func (\(\w+ \*?\w+\) )?\w+\(`), 3},
+		{regexp.MustCompile(`^import (\(|")`), 3},
+		{regexp.MustCompile(`:=`), 1},
+		{regexp.MustCompile(`\berr != nil\b`), 2},
+	},
+}
+
+// detectionOrder breaks ties between equally scored languages
+var detectionOrder = []types.Language{types.LanguagePython, types.LanguageNodeJS, types.LanguageGo}
+
+// DetectLanguage guesses the language of source code from characteristic
+// statements, for input such as stdin that has no file extension. It
+// defaults to Python when nothing points elsewhere.
+func (e *Engine) DetectLanguage(source string) types.Language {
+	scores := make(map[types.Language]int)
+
+	for _, line := range strings.Split(source, "\n") {
+		for lang, markers := range languageMarkers {
+			for _, m := range markers {
+				if m.pattern.MatchString(line) {
+					scores[lang] += m.weight
+				}
+			}
+		}
+	}
+
+	best := types.LanguagePython
+	for _, lang := range detectionOrder {
+		if scores[lang] > scores[best] {
+			best = lang
+		}
+	}
+
+	return best
+}
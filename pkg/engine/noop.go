@@ -0,0 +1,52 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// WithNoOpCheck adds a "no-op" warning when calls were transformed but the
+// generated code is identical to the source, or when a rule rendered a call
+// back to its own source text, as a code template that just echoes the call
+// does. Such a transform silently changes nothing; with WithStrict it fails.
+func (e *Engine) WithNoOpCheck(check bool) *Engine {
+	e.noOpCheck = check
+	return e
+}
+
+// noOpWarnings reports a transform of source that changed nothing, or the
+// calls in its report that were rendered unchanged
+func noOpWarnings(source string, result *types.TransformationResult) []types.Warning {
+	if len(result.Report) == 0 {
+		return nil
+	}
+
+	if result.TransformedCode == source {
+		return []types.Warning{{
+			Message:    fmt.Sprintf("%d call(s) were transformed, but the generated code is identical to the source - check the rules' code templates", len(result.Report)),
+			LineNumber: result.Report[0].LineNumber,
+			Category:   "no-op",
+		}}
+	}
+	return echoWarnings(result.Report)
+}
+
+// echoWarnings reports the calls a rule rendered back to their own source
+// text. TransformStream has no generated code to compare, so it only gets
+// these.
+func echoWarnings(report []types.CallReport) []types.Warning {
+	var warnings []types.Warning
+	for _, r := range report {
+		if strings.TrimSpace(r.Transformed) != strings.TrimSpace(r.Original) {
+			continue
+		}
+		warnings = append(warnings, types.Warning{
+			Message:    fmt.Sprintf("rule %s rendered %s unchanged", r.Rule, r.Pattern),
+			LineNumber: r.LineNumber,
+			Category:   "no-op",
+		})
+	}
+	return warnings
+}
@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/detector"
+	"github.com/QodeSrl/infrar-engine/pkg/executor"
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
+	"github.com/QodeSrl/infrar-engine/pkg/plugin"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+	"github.com/QodeSrl/infrar-engine/pkg/validator"
+)
+
+// Option configures an engine created by NewWithOptions
+type Option func(*options)
+
+// options collects the settings NewWithOptions builds an engine from
+type options struct {
+	python    string
+	timeout   time.Duration // Zero keeps the parser's and validator's defaults
+	validate  bool
+	cacheSize int
+	executor  executor.Executor // nil runs Python as a local process
+	parsers   []parser.Parser
+	sdkPrefix string // "" for infrar
+}
+
+// WithPythonPath sets the Python interpreter the parser and validator run.
+// Unset, it falls back to $INFRAR_PYTHON, the active virtualenv, then
+// python3 or python on PATH.
+func WithPythonPath(python string) Option {
+	return func(o *options) {
+		o.python = python
+	}
+}
+
+// WithTimeout bounds each run of the Python parser and validator
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *options) {
+		o.timeout = timeout
+	}
+}
+
+// WithValidation sets whether generated code is checked with Python; it is
+// on by default
+func WithValidation(validate bool) Option {
+	return func(o *options) {
+		o.validate = validate
+	}
+}
+
+// WithCache sets how many validation outcomes are remembered; 0 disables
+// the cache
+func WithCache(size int) Option {
+	return func(o *options) {
+		o.cacheSize = size
+	}
+}
+
+// WithExecutor runs the Python parser and validator through exec, e.g. in a
+// sandbox or container. Combined with WithPythonPath, exec is asked to run
+// that interpreter; otherwise it runs executor.DefaultPython from its own PATH.
+func WithExecutor(exec executor.Executor) Option {
+	return func(o *options) {
+		o.executor = exec
+	}
+}
+
+// WithParser registers p for the language it parses, replacing the built-in
+// parser for that language
+func WithParser(p parser.Parser) Option {
+	return func(o *options) {
+		o.parsers = append(o.parsers, p)
+	}
+}
+
+// WithSDKPrefix sets the package name the source imports the Infrar SDK as,
+// for organizations vendoring it under their own namespace, e.g.
+// mycorp_infra. Calls under it match the usual infrar.* rules.
+func WithSDKPrefix(prefix string) Option {
+	return func(o *options) {
+		o.sdkPrefix = prefix
+	}
+}
+
+// NewWithOptions creates a transformation engine configured by opts. With
+// no options it is the same as New.
+func NewWithOptions(opts ...Option) (*Engine, error) {
+	o := options{
+		validate:  true,
+		cacheSize: validationCacheSize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	parsers := make(map[types.Language]parser.Parser)
+	for _, p := range o.parsers {
+		parsers[p.Language()] = p
+	}
+
+	// Create the Python parser unless one was supplied
+	if _, ok := parsers[types.LanguagePython]; !ok {
+		pythonParser, err := newPythonParser(o)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create parser: %w", err)
+		}
+		parsers[types.LanguagePython] = pythonParser
+	}
+
+	// Create validator
+	val, err := newValidator(o)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create validator: %w", err)
+	}
+
+	return &Engine{
+		parsers:    parsers,
+		detector:   detector.NewDetectorWithPrefix(o.sdkPrefix),
+		sdkPrefix:  o.sdkPrefix,
+		registry:   plugin.NewRegistry(),
+		named:      newNamedRegistries(),
+		validator:  val,
+		noValidate: !o.validate,
+	}, nil
+}
+
+// pythonExecutor returns the executor to run Python through, or nil for a
+// local process
+func (o options) pythonExecutor() executor.Executor {
+	if o.executor == nil || o.python == "" {
+		return o.executor
+	}
+
+	exec, python := o.executor, o.python
+	return executor.Func(func(ctx context.Context, stdin string, _ string, args ...string) (string, string, error) {
+		return exec.Run(ctx, stdin, python, args...)
+	})
+}
+
+// newPythonParser creates the built-in Python parser for o
+func newPythonParser(o options) (*parser.PythonParser, error) {
+	var p *parser.PythonParser
+	var err error
+	if exec := o.pythonExecutor(); exec != nil {
+		p, err = parser.NewPythonParserWithExecutor(exec)
+	} else {
+		p, err = parser.NewPythonParserWithPython(o.python)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.timeout > 0 {
+		p.SetTimeout(o.timeout)
+	}
+	return p, nil
+}
+
+// newValidator creates the code validator for o
+func newValidator(o options) (*validator.Validator, error) {
+	var v *validator.Validator
+	var err error
+	if exec := o.pythonExecutor(); exec != nil {
+		v, err = validator.NewValidatorWithExecutor(exec, o.cacheSize)
+	} else {
+		v, err = validator.NewValidatorWithPython(o.python, o.cacheSize)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if o.timeout > 0 {
+		v.SetTimeout(o.timeout)
+	}
+	return v, nil
+}
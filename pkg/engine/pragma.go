@@ -0,0 +1,105 @@
+package engine
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+
+	"github.com/QodeSrl/infrar-engine/pkg/plugin"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// pragmaRules loads the rules of providers named by "# infrar: provider=..."
+// pragmas on first use. It is shared by the engine and its batch snapshots,
+// so each provider's rules are read at most once.
+type pragmaRules struct {
+	pluginDir    string
+	capabilities []string
+
+	mu     sync.Mutex
+	loaded map[types.Provider]pragmaLoad
+}
+
+// pragmaLoad is the outcome of loading one provider's rules
+type pragmaLoad struct {
+	rules []types.TransformationRule
+	err   error
+}
+
+// WithPragmaRules has calls whose pragma names a provider with no rules
+// loaded use that provider's rules for the given capabilities, read from
+// pluginDir the first time a pragma names it. Providers no pragma names are
+// never read, so a broken plugin for one of them cannot fail the transform;
+// a capability the provider has no plugin for is skipped.
+func (e *Engine) WithPragmaRules(pluginDir string, capabilities []string) *Engine {
+	e.pragmas = &pragmaRules{
+		pluginDir:    pluginDir,
+		capabilities: capabilities,
+		loaded:       make(map[types.Provider]pragmaLoad),
+	}
+	return e
+}
+
+// get returns the rules of provider, loading them on first use
+func (p *pragmaRules) get(provider types.Provider) ([]types.TransformationRule, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if load, ok := p.loaded[provider]; ok {
+		return load.rules, load.err
+	}
+
+	var load pragmaLoad
+	loader := plugin.NewLoader(p.pluginDir)
+	for _, capability := range p.capabilities {
+		rules, err := loader.LoadRules(provider, capability)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			load = pragmaLoad{err: fmt.Errorf("failed to load %s rules for a provider pragma: %w", provider, err)}
+			break
+		}
+		load.rules = append(load.rules, rules...)
+	}
+	p.loaded[provider] = load
+	return load.rules, load.err
+}
+
+// addPragmaRules registers in registry the rules of each provider a call's
+// pragma names that registry has no rules for
+func (e *Engine) addPragmaRules(registry *plugin.Registry, calls []types.InfrarCall, targetProvider types.Provider) error {
+	if e.pragmas == nil {
+		return nil
+	}
+
+	seen := map[types.Provider]bool{targetProvider: true}
+	for _, call := range calls {
+		if call.Provider == "" || seen[call.Provider] {
+			continue
+		}
+		seen[call.Provider] = true
+		if hasProviderRules(registry, call.Provider) {
+			continue
+		}
+
+		rules, err := e.pragmas.get(call.Provider)
+		if err != nil {
+			return err
+		}
+		registry.RegisterMultiple(rules)
+	}
+	return nil
+}
+
+// hasProviderRules reports whether registry holds rules of provider itself,
+// not counting rules registered without a provider
+func hasProviderRules(registry *plugin.Registry, provider types.Provider) bool {
+	for _, rule := range registry.ForProvider(provider).AllRules() {
+		if rule.Provider == provider {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/QodeSrl/infrar-engine/pkg/plugin"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// namedRegistries holds the registries added with Engine.WithRegistry. It
+// is shared by the engine and its batch snapshots, and may be added to
+// while transforms run.
+type namedRegistries struct {
+	mu         sync.RWMutex
+	registries map[string]*plugin.Registry
+}
+
+func newNamedRegistries() *namedRegistries {
+	return &namedRegistries{registries: make(map[string]*plugin.Registry)}
+}
+
+// get returns the registry added under name
+func (n *namedRegistries) get(name string) (*plugin.Registry, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	registry, ok := n.registries[name]
+	return registry, ok
+}
+
+// names returns the names of the added registries in order
+func (n *namedRegistries) names() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	names := make([]string, 0, len(n.registries))
+	for name := range n.registries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// WithRegistry adds a rule set under name, isolated from the engine's own
+// rules, for TransformWith to select per call. A server handling several
+// tenants can keep one registry each and transform with any of them without
+// reloading rules. Adding a registry under a name already used replaces it;
+// a nil registry removes the name.
+func (e *Engine) WithRegistry(name string, registry *plugin.Registry) *Engine {
+	e.named.mu.Lock()
+	defer e.named.mu.Unlock()
+	if registry == nil {
+		delete(e.named.registries, name)
+	} else {
+		e.named.registries[name] = registry
+	}
+	return e
+}
+
+// TransformWith transforms source code like Transform, using the rules of
+// the registry added under registryName instead of the engine's own
+func (e *Engine) TransformWith(registryName string, sourceCode string, targetProvider types.Provider) (*types.TransformationResult, error) {
+	return e.TransformWithContext(context.Background(), registryName, sourceCode, targetProvider)
+}
+
+// TransformWithContext is TransformWith, aborting when ctx is done
+func (e *Engine) TransformWithContext(ctx context.Context, registryName string, sourceCode string, targetProvider types.Provider) (*types.TransformationResult, error) {
+	registry, ok := e.named.get(registryName)
+	if !ok {
+		return nil, fmt.Errorf("no registry named %q (have: %s)", registryName, strings.Join(e.named.names(), ", "))
+	}
+
+	scoped := *e
+	scoped.registry = registry
+	return scoped.TransformContext(ctx, sourceCode, targetProvider)
+}
@@ -0,0 +1,39 @@
+package executor
+
+import (
+	"context"
+
+	"github.com/QodeSrl/infrar-engine/internal/util"
+)
+
+// Executor runs an external command with input on stdin. The parser and
+// validator run Python through an Executor, so tests can substitute canned
+// output and deployments can run Python inside a sandbox or container.
+type Executor interface {
+	// Run runs name with args, feeding stdin, and returns what the command
+	// wrote to stdout and stderr
+	Run(ctx context.Context, stdin string, name string, args ...string) (stdout, stderr string, err error)
+}
+
+// DefaultPython is the interpreter the parser and validator ask an Executor
+// to run unless one is set explicitly. It is looked up on the executor's own
+// PATH: a path resolved on this host usually does not exist in a sandbox,
+// so $INFRAR_PYTHON and $VIRTUAL_ENV, which name host interpreters, do not
+// apply.
+const DefaultPython = "python3"
+
+// CommandExecutor runs commands as local processes
+type CommandExecutor struct{}
+
+// Run implements the Executor interface
+func (CommandExecutor) Run(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+	return util.ExecuteCommandWithStdin(ctx, stdin, name, args...)
+}
+
+// Func adapts an ordinary function to the Executor interface
+type Func func(ctx context.Context, stdin string, name string, args ...string) (string, string, error)
+
+// Run implements the Executor interface
+func (f Func) Run(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+	return f(ctx, stdin, name, args...)
+}
@@ -0,0 +1,80 @@
+package formatter
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// command is a formatter invocation that reads code on stdin and writes the
+// formatted code to stdout
+type command struct {
+	name string
+	args []string
+}
+
+// defaultCommands lists the formatters tried for each language, in order of
+// preference
+var defaultCommands = map[types.Language][]command{
+	types.LanguagePython: {
+		{name: "black", args: []string{"--quiet", "-"}},
+		{name: "autopep8", args: []string{"-"}},
+	},
+	types.LanguageNodeJS: {
+		{name: "prettier", args: []string{"--stdin-filepath", "generated.js"}},
+	},
+}
+
+// Formatter runs an external code formatter over generated code
+type Formatter struct {
+	commands map[types.Language][]command
+	timeout  time.Duration
+}
+
+// NewFormatter creates a formatter using the first installed tool for each language
+func NewFormatter() *Formatter {
+	return &Formatter{
+		commands: defaultCommands,
+		timeout:  30 * time.Second,
+	}
+}
+
+// Format formats code written in lang. It returns the formatted code and the
+// name of the tool used; when no formatter is installed for lang the code is
+// returned unchanged with an empty tool name.
+func (f *Formatter) Format(ctx context.Context, code string, lang types.Language) (string, string, error) {
+	for _, cmd := range f.commands[lang] {
+		path, err := exec.LookPath(cmd.name)
+		if err != nil {
+			continue
+		}
+
+		formatted, err := f.run(ctx, code, path, cmd.args)
+		if err != nil {
+			return code, cmd.name, err
+		}
+		return formatted, cmd.name, nil
+	}
+
+	return code, "", nil
+}
+
+// run pipes code through a single formatter executable
+func (f *Formatter) run(ctx context.Context, code, path string, args []string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, f.timeout)
+	defer cancel()
+
+	stdout, stderr, err := util.ExecuteCommandWithStdin(ctx, code, path, args...)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", fmt.Errorf("%s failed: %v: %s", path, err, stderr)
+	}
+
+	return stdout, nil
+}
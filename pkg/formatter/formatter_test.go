@@ -0,0 +1,67 @@
+package formatter
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+func TestFormatter_Format(t *testing.T) {
+	const code = "x   =  1\n\n\n"
+
+	tests := []struct {
+		name     string
+		commands []command
+		want     string
+		wantTool string
+		wantErr  bool
+	}{
+		{
+			name:     "No formatter installed",
+			commands: []command{{name: "infrar-missing-formatter"}},
+			want:     code,
+		},
+		{
+			name: "First installed formatter wins",
+			commands: []command{
+				{name: "infrar-missing-formatter"},
+				{name: "python3", args: []string{"-c", "import sys; print(' '.join(sys.stdin.read().split()))"}},
+			},
+			want:     "x = 1\n",
+			wantTool: "python3",
+		},
+		{
+			name:     "Formatter failure keeps code",
+			commands: []command{{name: "python3", args: []string{"-c", "import sys; sys.exit('boom')"}}},
+			want:     code,
+			wantTool: "python3",
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &Formatter{
+				commands: map[types.Language][]command{types.LanguagePython: tt.commands},
+				timeout:  10 * time.Second,
+			}
+
+			got, tool, err := f.Format(context.Background(), code, types.LanguagePython)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Format() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), "boom") {
+				t.Errorf("Format() error = %v, want formatter stderr", err)
+			}
+			if got != tt.want {
+				t.Errorf("Format() = %q, want %q", got, tt.want)
+			}
+			if tool != tt.wantTool {
+				t.Errorf("Format() tool = %q, want %q", tool, tt.wantTool)
+			}
+		})
+	}
+}
@@ -2,19 +2,34 @@ package generator
 
 import (
 	"fmt"
+	"io"
+	"regexp"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
 	"github.com/QodeSrl/infrar-engine/pkg/plugin"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
 // Generator generates final provider-specific code
 type Generator struct {
-	provider types.Provider
-	registry *plugin.Registry
+	provider     types.Provider
+	registry     *plugin.Registry
+	preserved    []types.InfrarCall
+	pruneImports bool
+	shareClients bool
+	style        types.StyleOptions
+	keepImports  bool
+	sdkPrefix    string // Package name the Infrar SDK is imported as; "" for infrar
+	sourceMap    bool
 }
 
+// SourceMapKey is the result metadata key holding the source map, a
+// []types.LineMapping, when SetSourceMap is on
+const SourceMapKey = "source_map"
+
 // New creates a new code generator
 func New(provider types.Provider, registry *plugin.Registry) *Generator {
 	return &Generator{
@@ -23,40 +38,238 @@ func New(provider types.Provider, registry *plugin.Registry) *Generator {
 	}
 }
 
+// PreserveImportsFor keeps the Infrar imports that the given calls rely on.
+// It is used for calls left untransformed so the output still resolves them.
+func (g *Generator) PreserveImportsFor(calls []types.InfrarCall) {
+	g.preserved = calls
+}
+
+// SetPruneImports controls whether rule imports whose names never appear in
+// the generated code are dropped. Imports already in the source are never
+// touched.
+func (g *Generator) SetPruneImports(prune bool) {
+	g.pruneImports = prune
+}
+
+// SetShareClients controls whether rules with a shareable client get it
+// bound once in their setup code, for the transformer's {{ .client }}
+// references to use, see Transformer.SetShareClients
+func (g *Generator) SetShareClients(share bool) {
+	g.shareClients = share
+}
+
+// SetStyle sets the style of the generated code. String literals in setup
+// code are quoted in it, and generated lines longer than its maximum line
+// length get a style warning.
+func (g *Generator) SetStyle(style types.StyleOptions) {
+	g.style = style
+}
+
+// SetCommentOutImports controls what happens to the Infrar imports a
+// transform replaces: by default they are deleted, with comment set they are
+// kept as `# migrated: ...` comments for traceability, above the new imports
+// for module-level imports and in place otherwise
+func (g *Generator) SetCommentOutImports(comment bool) {
+	g.keepImports = comment
+}
+
+// SetSDKPrefix sets the package name the source imports the Infrar SDK as,
+// for an SDK vendored under another name, so its imports are replaced. See
+// detector.NewDetectorWithPrefix.
+func (g *Generator) SetSDKPrefix(prefix string) {
+	g.sdkPrefix = prefix
+}
+
+// SetSourceMap controls whether results carry a source map linking each
+// original line to the generated lines it became, under SourceMapKey in
+// their metadata. Lines that were removed, such as replaced imports, have
+// no entry; inserted imports and setup code belong to no original line.
+func (g *Generator) SetSourceMap(enabled bool) {
+	g.sourceMap = enabled
+}
+
 // Generate generates final code from AST and transformed calls
 func (g *Generator) Generate(ast *types.AST, transformedCalls []types.TransformedCall) (*types.TransformationResult, error) {
+	src, result, err := g.plan(ast, transformedCalls)
+	if err != nil {
+		return nil, err
+	}
+
+	if src == nil {
+		result.TransformedCode = ast.SourceCode
+	} else {
+		result.TransformedCode = src.String()
+	}
+	g.addSourceMap(result, src, ast)
+
+	return result, nil
+}
+
+// GenerateTo writes the final code to w as it is produced instead of
+// building it in memory. The returned result has no TransformedCode.
+func (g *Generator) GenerateTo(ast *types.AST, transformedCalls []types.TransformedCall, w io.Writer) (*types.TransformationResult, error) {
+	src, result, err := g.plan(ast, transformedCalls)
+	if err != nil {
+		return nil, err
+	}
+
+	if src == nil {
+		_, err = io.WriteString(w, ast.SourceCode)
+	} else {
+		_, err = src.WriteTo(w)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to write generated code: %w", err)
+	}
+	g.addSourceMap(result, src, ast)
+
+	return result, nil
+}
+
+// addSourceMap records the source map of the edited source in result's
+// metadata when source maps are on. A nil source is the original unchanged.
+func (g *Generator) addSourceMap(result *types.TransformationResult, src *sourceLines, ast *types.AST) {
+	if !g.sourceMap {
+		return
+	}
+	if src == nil {
+		src = newSourceLines(ast.SourceCode)
+	}
+	if result.Metadata == nil {
+		result.Metadata = make(map[string]any)
+	}
+	result.Metadata[SourceMapKey] = src.lineMappings()
+}
+
+// plan records every edit against the source and returns the edited source
+// with the result metadata. The source is nil when no call is transformed.
+func (g *Generator) plan(ast *types.AST, transformedCalls []types.TransformedCall) (*sourceLines, *types.TransformationResult, error) {
 	if len(transformedCalls) == 0 {
-		// No transformations needed, return original code
-		return &types.TransformationResult{
-			Provider:        g.provider,
-			TransformedCode: ast.SourceCode,
-			Warnings: []types.Warning{
-				{
-					Message:  "No Infrar SDK calls found - returning original code",
-					Category: "info",
+		// No transformations needed, return original code. Calls may have
+		// been detected and left unmatched, which their own warnings report.
+		summary := "No Infrar SDK calls found"
+		if detected, _ := ast.Metadata["detected_calls"].(int); detected > 0 {
+			summary = fmt.Sprintf("%d Infrar call(s) left untransformed", detected)
+		}
+
+		unused, _ := ast.Metadata["unused_imports"].([]types.Import)
+		if len(unused) == 0 {
+			return nil, &types.TransformationResult{
+				Provider: g.provider,
+				Warnings: []types.Warning{
+					{
+						Message:  summary + " - returning original code",
+						Category: "info",
+					},
 				},
+			}, nil
+		}
+
+		// Infrar imports with nothing left to use them are dropped all the same
+		src := newSourceLines(ast.SourceCode)
+		warnings := []types.Warning{
+			{
+				Message:  summary + " - removing unused Infrar imports",
+				Category: "info",
 			},
+		}
+		for _, imp := range unused {
+			if g.keepImports {
+				commentOutImport(src, imp)
+			} else {
+				removeImport(src, imp)
+			}
+			warnings = append(warnings, types.Warning{
+				Message:    fmt.Sprintf("unused Infrar import of %s removed", imp.Module),
+				LineNumber: imp.LineNumber,
+				Category:   "unused-import",
+			})
+		}
+		return src, &types.TransformationResult{
+			Provider: g.provider,
+			Warnings: warnings,
 		}, nil
 	}
 
 	// Collect all imports and requirements
-	imports := make(map[string]bool)
+	imports := make(importSources)
 	var requirements []types.Requirement
 	var setupCodes []string
+	var report []types.CallReport
+	var warnings []types.Warning
+	functions, _ := ast.Metadata["functions"].([]parser.PythonFunction)
+	functionSetups := make(map[string][]string) // Setup code keyed by enclosing function
 
+	// Rule imports the source already makes are not repeated; where the
+	// source aliased the module, generated references use the alias
+	bound := importedModules(ast)
+	renames := moduleRenames(g.rulesFor(transformedCalls), bound)
+	if len(renames) > 0 {
+		renamed := make([]types.TransformedCall, len(transformedCalls))
+		for i, tc := range transformedCalls {
+			tc.TransformedCode = renameModules(tc.TransformedCode, renames)
+			renamed[i] = tc
+		}
+		transformedCalls = renamed
+	}
+
+	sourceLines := strings.Split(ast.SourceCode, "\n")
 	for _, tc := range transformedCalls {
 		rule, err := g.registry.GetRuleByCall(tc.OriginalCall)
 		if err != nil {
 			continue
 		}
+		setup, err := g.setupCode(rule)
+		if err != nil {
+			return nil, nil, &types.TransformationError{
+				Category:   types.ErrorCategoryGeneration,
+				Message:    fmt.Sprintf("setup code of %s: %v", rule.Pattern, err),
+				Line:       tc.OriginalCall.LineNumber,
+				SourceCode: tc.OriginalCall.SourceCode,
+				Cause:      err,
+			}
+		}
+		rule.SetupCode = renameModules(setup, renames)
+
+		if w, ok := g.lineLengthWarning(tc, sourceLines); ok {
+			warnings = append(warnings, w)
+		}
+
+		report = append(report, types.CallReport{
+			LineNumber:   tc.OriginalCall.LineNumber,
+			ColumnOffset: tc.OriginalCall.ColumnOffset,
+			Original:     tc.OriginalCall.SourceCode,
+			Transformed:  tc.TransformedCode,
+			Rule:         rule.Name,
+			Pattern:      rule.Pattern,
+		})
 
 		// Collect imports
 		for _, imp := range rule.Imports {
-			imports[imp] = true
+			if module, ok := plainImport(imp); ok && bound[module] != "" {
+				continue
+			}
+			imports.add(imp, rule.Pattern)
 		}
 
-		// Collect setup code (deduplicated)
-		if rule.SetupCode != "" && !contains(setupCodes, rule.SetupCode) {
+		// Collect setup code (deduplicated), per function when the rule
+		// needs it inside one, e.g. for an `async with` client
+		if rule.SetupCode != "" && rule.SetupScope == types.SetupScopeFunction {
+			if fn, ok := enclosingFunction(functions, tc.OriginalCall.Scope); ok {
+				if !contains(functionSetups[fn.Name], rule.SetupCode) {
+					functionSetups[fn.Name] = append(functionSetups[fn.Name], rule.SetupCode)
+				}
+			} else {
+				warnings = append(warnings, types.Warning{
+					Message:    fmt.Sprintf("%s needs its setup code inside a function - placed at module level instead", rule.Pattern),
+					LineNumber: tc.OriginalCall.LineNumber,
+					Category:   "setup",
+				})
+				if !contains(setupCodes, rule.SetupCode) {
+					setupCodes = append(setupCodes, rule.SetupCode)
+				}
+			}
+		} else if rule.SetupCode != "" && !contains(setupCodes, rule.SetupCode) {
 			setupCodes = append(setupCodes, rule.SetupCode)
 		}
 
@@ -64,45 +277,210 @@ func (g *Generator) Generate(ast *types.AST, transformedCalls []types.Transforme
 		requirements = append(requirements, rule.Requirements...)
 	}
 
+	// Edits are recorded against the original line numbers reported by the
+	// parser and applied together, so positions stay valid throughout
+	src := newSourceLines(ast.SourceCode)
+
 	// Generate the transformed code
-	code, err := g.replaceCallsInSource(ast.SourceCode, transformedCalls)
-	if err != nil {
-		return nil, &types.TransformationError{
+	if err := g.replaceCallsInSource(src, transformedCalls); err != nil {
+		return nil, nil, &types.TransformationError{
 			Category: types.ErrorCategoryGeneration,
 			Message:  fmt.Sprintf("failed to replace calls: %v", err),
+			Cause:    err,
 		}
 	}
 
+	if g.pruneImports {
+		allSetupCodes := setupCodes
+		for _, codes := range functionSetups {
+			allSetupCodes = append(allSetupCodes, codes...)
+		}
+		pruneUnusedImports(src, imports, allSetupCodes)
+	}
+
+	// Added imports that rebind a name the source uses are kept, with a warning
+	warnings = append(warnings, g.importConflicts(ast, imports)...)
+
 	// Remove old infrar imports and add new provider imports
-	code = g.replaceImports(code, ast.Imports, imports)
+	g.replaceImports(src, ast, imports)
 
 	// Add setup code after imports
 	if len(setupCodes) > 0 {
-		code = g.addSetupCode(code, setupCodes)
+		g.addSetupCode(src, ast, setupCodes)
 	}
+	addFunctionSetupCode(src, functions, functionSetups)
 
-	return &types.TransformationResult{
-		Provider:        g.provider,
-		TransformedCode: code,
-		Imports:         mapKeysToSlice(imports),
-		Requirements:    requirements,
+	return src, &types.TransformationResult{
+		Provider:      g.provider,
+		Imports:       mapKeysToSlice(imports),
+		ImportSources: imports,
+		Requirements:  requirements,
+		Report:        report,
+		Warnings:      warnings,
 		Metadata: map[string]any{
 			"transformed_calls": len(transformedCalls),
 		},
 	}, nil
 }
 
-// replaceCallsInSource replaces Infrar calls with transformed code
-func (g *Generator) replaceCallsInSource(sourceCode string, transformedCalls []types.TransformedCall) (string, error) {
-	lines := strings.Split(sourceCode, "\n")
+// importSources maps each import the generated code needs to the patterns
+// of the rules that contributed it, in the order first seen
+type importSources map[string][]string
 
-	// Sort by line number in reverse order (bottom to top)
-	// This prevents line number shifts when replacing
-	sort.Slice(transformedCalls, func(i, j int) bool {
-		return transformedCalls[i].LineNumber > transformedCalls[j].LineNumber
-	})
+// add records that the rule for pattern needs imp
+func (s importSources) add(imp, pattern string) {
+	if !contains(s[imp], pattern) {
+		s[imp] = append(s[imp], pattern)
+	}
+}
+
+// sourceLines holds source code split into lines and records edits against
+// the original line indices, so parser positions remain valid until the code
+// is rebuilt with String
+type sourceLines struct {
+	lines   []string
+	removed map[int]bool
+	inserts map[int][]string // lines inserted before the given index
+	merged  map[int]int      // removed lines of a multi-line call, mapped to the line it was replaced on
+}
 
+func newSourceLines(code string) *sourceLines {
+	return &sourceLines{
+		lines:   strings.Split(code, "\n"),
+		removed: make(map[int]bool),
+		inserts: make(map[int][]string),
+		merged:  make(map[int]int),
+	}
+}
+
+// lineMappings returns where each original line ends up once the edits are
+// applied. An edited line may hold several lines of code, and the lines of
+// a multi-line call share the range of its replacement.
+func (s *sourceLines) lineMappings() []types.LineMapping {
+	var mappings []types.LineMapping
+	mapped := make(map[int]types.LineMapping)
+	next := 1
+	for i, line := range s.lines {
+		for _, inserted := range s.inserts[i] {
+			next += strings.Count(inserted, "\n") + 1
+		}
+
+		if s.removed[i] {
+			if into, ok := s.merged[i]; ok {
+				m := mapped[into]
+				m.Original = i + 1
+				mappings = append(mappings, m)
+			}
+			continue
+		}
+
+		m := types.LineMapping{
+			Original:       i + 1,
+			GeneratedStart: next,
+			GeneratedEnd:   next + strings.Count(line, "\n"),
+		}
+		mapped[i] = m
+		mappings = append(mappings, m)
+		next = m.GeneratedEnd + 1
+	}
+	return mappings
+}
+
+// insertBefore inserts lines before the original line at idx
+func (s *sourceLines) insertBefore(idx int, lines ...string) {
+	s.inserts[idx] = append(s.inserts[idx], lines...)
+}
+
+// lineBefore returns the last line that will be emitted before idx
+func (s *sourceLines) lineBefore(idx int) (string, bool) {
+	if inserted := s.inserts[idx]; len(inserted) > 0 {
+		return inserted[len(inserted)-1], true
+	}
+	for i := idx - 1; i >= 0; i-- {
+		if !s.removed[i] {
+			return s.lines[i], true
+		}
+		if inserted := s.inserts[i]; len(inserted) > 0 {
+			return inserted[len(inserted)-1], true
+		}
+	}
+	return "", false
+}
+
+// String rebuilds the source with all edits applied
+func (s *sourceLines) String() string {
+	var sb strings.Builder
+	s.WriteTo(&sb)
+	return sb.String()
+}
+
+// WriteTo writes the source with all edits applied to w, one line at a time
+func (s *sourceLines) WriteTo(w io.Writer) (int64, error) {
+	var written int64
+	first := true
+
+	emit := func(line string) error {
+		if !first {
+			n, err := io.WriteString(w, "\n")
+			written += int64(n)
+			if err != nil {
+				return err
+			}
+		}
+		first = false
+		n, err := io.WriteString(w, line)
+		written += int64(n)
+		return err
+	}
+
+	for i, line := range s.lines {
+		for _, inserted := range s.inserts[i] {
+			if err := emit(inserted); err != nil {
+				return written, err
+			}
+		}
+		if !s.removed[i] {
+			if err := emit(line); err != nil {
+				return written, err
+			}
+		}
+	}
+	for _, inserted := range s.inserts[len(s.lines)] {
+		if err := emit(inserted); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// replaceCallsInSource replaces Infrar calls with transformed code. Calls
+// with an end position have just their expression span replaced, so code
+// around them (assignments, other calls, trailing comments such as
+// `# noqa` or `# type:` annotations) survives; calls without
+// one replace their whole line. Spans are spliced bottom-up and right-to-left
+// so earlier positions stay valid while later ones are edited.
+func (g *Generator) replaceCallsInSource(src *sourceLines, transformedCalls []types.TransformedCall) error {
+	lines := src.lines
+
+	// Calls nested in another call's arguments are already rendered into
+	// the enclosing call's code
+	ordered := make([]types.TransformedCall, 0, len(transformedCalls))
 	for _, tc := range transformedCalls {
+		if !isNested(tc.OriginalCall, transformedCalls, lines) {
+			ordered = append(ordered, tc)
+		}
+	}
+	sort.SliceStable(ordered, func(i, j int) bool {
+		a, b := ordered[i], ordered[j]
+		if a.LineNumber != b.LineNumber {
+			return a.LineNumber > b.LineNumber
+		}
+		return a.ColumnOffset > b.ColumnOffset
+	})
+
+	var prev *types.InfrarCall
+	for _, tc := range ordered {
 		lineIdx := tc.LineNumber - 1 // Convert to 0-indexed
 
 		if lineIdx < 0 || lineIdx >= len(lines) {
@@ -113,97 +491,641 @@ func (g *Generator) replaceCallsInSource(sourceCode string, transformedCalls []t
 		originalLine := lines[lineIdx]
 		indent := getIndentation(originalLine)
 
-		// Apply indentation to transformed code
+		// Indent every line after the first relative to the call site. The
+		// transformer has already removed the template's own base
+		// indentation, so nested lines keep their shape.
 		transformedLines := strings.Split(tc.TransformedCode, "\n")
-		for i, line := range transformedLines {
-			if i == 0 {
-				transformedLines[i] = indent + line
-			} else {
-				transformedLines[i] = indent + line
+		for i := 1; i < len(transformedLines); i++ {
+			if strings.TrimSpace(transformedLines[i]) != "" {
+				transformedLines[i] = indent + transformedLines[i]
 			}
 		}
+		code := strings.Join(transformedLines, "\n")
 
-		// Replace the line
-		lines[lineIdx] = strings.Join(transformedLines, "\n")
+		call := tc.OriginalCall
+		if call.LineNumber != tc.LineNumber || !hasSpan(call, lines) {
+			// Replace the line
+			lines[lineIdx] = indent + code
+			continue
+		}
+
+		if prev != nil && overlaps(call, *prev) {
+			return fmt.Errorf("line %d: overlapping Infrar calls are not supported", call.LineNumber)
+		}
+		prev = &tc.OriginalCall
+
+		endIdx := call.EndLineNumber - 1
+		suffix := lines[endIdx][call.EndColumnOffset:]
+
+		// A trailing comment describes the statement, so keep it on the
+		// first line of a multi-line replacement rather than after the last
+		if len(transformedLines) > 1 && strings.HasPrefix(strings.TrimSpace(suffix), "#") {
+			transformedLines[0] += suffix
+			code = strings.Join(transformedLines, "\n")
+			suffix = ""
+		}
+
+		lines[lineIdx] = originalLine[:call.ColumnOffset] + code + suffix
+		for i := lineIdx + 1; i <= endIdx; i++ {
+			src.removed[i] = true
+			src.merged[i] = lineIdx
+		}
 	}
 
-	return strings.Join(lines, "\n"), nil
+	return nil
+}
+
+// hasSpan reports whether a call's start and end positions fall within lines
+func hasSpan(call types.InfrarCall, lines []string) bool {
+	if call.LineNumber < 1 || call.EndLineNumber < call.LineNumber || call.EndLineNumber > len(lines) {
+		return false
+	}
+	if call.ColumnOffset > len(lines[call.LineNumber-1]) {
+		return false
+	}
+	if call.EndColumnOffset > len(lines[call.EndLineNumber-1]) {
+		return false
+	}
+	return call.EndLineNumber > call.LineNumber || call.EndColumnOffset > call.ColumnOffset
+}
+
+// isNested reports whether call lies inside the span of another transformed call
+func isNested(call types.InfrarCall, transformedCalls []types.TransformedCall, lines []string) bool {
+	if !hasSpan(call, lines) {
+		return false
+	}
+
+	for _, tc := range transformedCalls {
+		outer := tc.OriginalCall
+		if outer.LineNumber == call.LineNumber && outer.ColumnOffset == call.ColumnOffset {
+			continue
+		}
+		if hasSpan(outer, lines) && encloses(outer, call) {
+			return true
+		}
+	}
+	return false
+}
+
+// encloses reports whether inner starts and ends within outer
+func encloses(outer, inner types.InfrarCall) bool {
+	startsAfter := inner.LineNumber > outer.LineNumber ||
+		(inner.LineNumber == outer.LineNumber && inner.ColumnOffset >= outer.ColumnOffset)
+	endsBefore := inner.EndLineNumber < outer.EndLineNumber ||
+		(inner.EndLineNumber == outer.EndLineNumber && inner.EndColumnOffset <= outer.EndColumnOffset)
+	return startsAfter && endsBefore
+}
+
+// overlaps reports whether call ends after later starts, given that later
+// does not start before call
+func overlaps(call, later types.InfrarCall) bool {
+	if call.EndLineNumber != later.LineNumber {
+		return call.EndLineNumber > later.LineNumber
+	}
+	return call.EndColumnOffset > later.ColumnOffset
 }
 
 // replaceImports removes Infrar imports and adds provider imports
-func (g *Generator) replaceImports(code string, oldImports []types.Import, newImports map[string]bool) string {
-	lines := strings.Split(code, "\n")
-	var result []string
+func (g *Generator) replaceImports(src *sourceLines, ast *types.AST, newImports importSources) {
+	// Remove old infrar imports, including every line of multi-line imports.
+	// Kept as comments, module-level ones move above the new imports.
+	var migrated []string
+	for _, imp := range ast.Imports {
+		if !g.isSDKImport(imp) || g.isPreserved(imp) {
+			continue
+		}
+		if g.keepImports && !imp.TopLevel {
+			commentOutImport(src, imp)
+			continue
+		}
+		for line := imp.LineNumber; line <= max(imp.LineNumber, imp.EndLineNumber); line++ {
+			if g.keepImports {
+				migrated = append(migrated, migratedPrefix+strings.TrimSpace(src.lines[line-1]))
+			}
+			src.removed[line-1] = true
+		}
+	}
 
-	// Track which lines to skip (old infrar imports)
-	skipLines := make(map[int]bool)
+	if len(newImports) == 0 && len(migrated) == 0 {
+		return
+	}
+
+	// Insert imports
+	importLines := mapKeysToSlice(newImports)
+
+	src.insertBefore(importInsertIndex(src, ast), append(append(migrated, importLines...), "")...)
+}
+
+// migratedPrefix starts the comments replaced Infrar imports are kept as
+const migratedPrefix = "# migrated: "
+
+// commentOutImport turns every line of an import statement into a
+// `# migrated: ...` comment, in place and at the same indentation
+func commentOutImport(src *sourceLines, imp types.Import) {
+	for line := imp.LineNumber; line <= max(imp.LineNumber, imp.EndLineNumber); line++ {
+		text := src.lines[line-1]
+		indent := getIndentation(text)
+		src.lines[line-1] = indent + migratedPrefix + strings.TrimPrefix(text, indent)
+	}
+}
+
+// removeImport removes an import statement, along with the blank lines after
+// it when nothing but blank lines comes before, so the file does not start
+// with a gap
+func removeImport(src *sourceLines, imp types.Import) {
+	end := max(imp.LineNumber, imp.EndLineNumber)
+	for line := imp.LineNumber; line <= end; line++ {
+		src.removed[line-1] = true
+	}
 
-	for _, imp := range oldImports {
-		if strings.HasPrefix(imp.Module, "infrar") {
-			skipLines[imp.LineNumber-1] = true // Mark for removal
+	if before, ok := src.lineBefore(imp.LineNumber - 1); ok && strings.TrimSpace(before) != "" {
+		return
+	}
+	for i := end; i < len(src.lines)-1 && strings.TrimSpace(src.lines[i]) == ""; i++ {
+		src.removed[i] = true
+	}
+}
+
+// importedName matches the names an import statement binds
+var importedName = regexp.MustCompile(`^\s*(?:import\s+([\w.]+)(?:\s+as\s+(\w+))?|from\s+[\w.]+\s+import\s+(.+))\s*$`)
+
+// importedModules maps each module the source imports at top level with a
+// plain `import` to the name it is bound to: the module itself, or its alias
+// for `import boto3 as b3`
+func importedModules(ast *types.AST) map[string]string {
+	bound := make(map[string]string)
+	for _, imp := range ast.Imports {
+		if !imp.TopLevel || len(imp.Names) != 1 || imp.Names[0] != imp.Module {
+			continue
+		}
+		if imp.Alias != "" {
+			bound[imp.Module] = imp.Alias
+		} else if _, ok := bound[imp.Module]; !ok {
+			bound[imp.Module] = imp.Module
 		}
 	}
+	return bound
+}
 
-	// Remove old imports
-	for i, line := range lines {
-		if !skipLines[i] {
-			result = append(result, line)
+// sourceBinding is a top-level name the source defines: what it refers to
+// and where
+type sourceBinding struct {
+	target string // Imported module or module.name; "" for an assignment or def
+	line   int
+}
+
+// sourceBindings returns the top-level names the source binds, other than
+// by the Infrar imports the transform removes. The first binding of a name
+// is kept.
+func (g *Generator) sourceBindings(ast *types.AST) map[string]sourceBinding {
+	bindings := make(map[string]sourceBinding)
+	bind := func(name, target string, line int) {
+		if _, ok := bindings[name]; !ok {
+			bindings[name] = sourceBinding{target, line}
 		}
 	}
 
-	// Add new imports at the top
-	if len(newImports) > 0 {
-		// Find where to insert imports (after any docstrings/comments at the top)
-		insertIdx := 0
-		for i, line := range result {
-			trimmed := strings.TrimSpace(line)
-			if trimmed == "" || strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "\"\"\"") || strings.HasPrefix(trimmed, "'''") {
-				insertIdx = i + 1
-			} else {
+	for _, imp := range ast.Imports {
+		if !imp.TopLevel || (g.isSDKImport(imp) && !g.isPreserved(imp)) {
+			continue
+		}
+		switch {
+		case imp.Alias != "":
+			bind(imp.Alias, imp.Module, imp.LineNumber)
+		case len(imp.Names) == 1 && imp.Names[0] == imp.Module:
+			root := strings.Split(imp.Module, ".")[0]
+			bind(root, root, imp.LineNumber)
+		default:
+			for _, name := range imp.Names {
+				if name != "*" {
+					bind(name, imp.Module+"."+name, imp.LineNumber)
+				}
+			}
+		}
+	}
+
+	assignments, _ := ast.Metadata["assignments"].([]parser.PythonAssignment)
+	for _, a := range assignments {
+		if a.Scope == "" {
+			bind(a.Target, "", a.LineNumber)
+		}
+	}
+	functions, _ := ast.Metadata["functions"].([]parser.PythonFunction)
+	for _, fn := range functions {
+		if !strings.Contains(fn.Name, ".") {
+			bind(fn.Name, "", fn.LineNumber)
+		}
+	}
+	return bindings
+}
+
+// importConflicts warns about added imports binding a name the source
+// already binds to something else, e.g. `from google.cloud import storage`
+// in a file with its own `storage` variable. Either the generated code or
+// the source's own references would then use the wrong object.
+func (g *Generator) importConflicts(ast *types.AST, imports importSources) []types.Warning {
+	bindings := g.sourceBindings(ast)
+
+	added := mapKeysToSlice(imports)
+
+	var warnings []types.Warning
+	for _, imp := range added {
+		targets := importTargets(imp)
+		names := mapKeysToSlice(targets)
+		for _, name := range names {
+			target := targets[name]
+			existing, ok := bindings[name]
+			if !ok || existing.target == target {
+				continue
+			}
+			what := "defines it"
+			if existing.target != "" {
+				what = "imports it as " + existing.target
+			}
+			warnings = append(warnings, types.Warning{
+				Message:    fmt.Sprintf("added import %q for %s rebinds %s, which line %d already %s", imp, strings.Join(imports[imp], ", "), name, existing.line, what),
+				LineNumber: existing.line,
+				Category:   "import-conflict",
+			})
+		}
+	}
+	return warnings
+}
+
+// importTargets maps the names an import statement binds to what they refer
+// to, the same way as sourceBindings, or returns nil when it is not a
+// simple import
+func importTargets(imp string) map[string]string {
+	m := importedName.FindStringSubmatch(imp)
+	if m == nil {
+		return nil
+	}
+
+	switch {
+	case m[2] != "":
+		return map[string]string{m[2]: m[1]}
+	case m[1] != "":
+		root := strings.Split(m[1], ".")[0]
+		return map[string]string{root: root}
+	}
+
+	module := strings.Fields(imp)[1]
+	list := strings.Trim(strings.TrimSpace(m[3]), "()")
+	targets := make(map[string]string)
+	for _, part := range strings.Split(list, ",") {
+		fields := strings.Fields(part)
+		switch {
+		case len(fields) == 1 && fields[0] != "*":
+			targets[fields[0]] = module + "." + fields[0]
+		case len(fields) == 3 && fields[1] == "as":
+			targets[fields[2]] = module + "." + fields[0]
+		default:
+			return nil
+		}
+	}
+	return targets
+}
+
+// plainImport returns the module of an `import module` statement without an
+// alias
+func plainImport(imp string) (string, bool) {
+	m := importedName.FindStringSubmatch(imp)
+	if m == nil || m[1] == "" || m[2] != "" {
+		return "", false
+	}
+	return m[1], true
+}
+
+// rulesFor returns the rules behind transformed calls
+func (g *Generator) rulesFor(transformedCalls []types.TransformedCall) []types.TransformationRule {
+	var rules []types.TransformationRule
+	for _, tc := range transformedCalls {
+		if rule, err := g.registry.GetRuleByCall(tc.OriginalCall); err == nil {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// moduleRename rewrites `module.` references to the alias the source
+// already binds the module to
+type moduleRename struct {
+	ref   *regexp.Regexp
+	alias string
+}
+
+// moduleRenames returns a rename for each module the rules import that the
+// source already binds to an alias, in module order
+func moduleRenames(rules []types.TransformationRule, bound map[string]string) []moduleRename {
+	aliases := make(map[string]string)
+	for _, rule := range rules {
+		for _, imp := range rule.Imports {
+			module, ok := plainImport(imp)
+			if !ok {
+				continue
+			}
+			if name := bound[module]; name != "" && name != module {
+				aliases[module] = name
+			}
+		}
+	}
+
+	var renames []moduleRename
+	for _, module := range mapKeysToSlice(aliases) {
+		renames = append(renames, moduleRename{
+			ref:   regexp.MustCompile(`(^|[^\w.])` + regexp.QuoteMeta(module) + `\.`),
+			alias: aliases[module],
+		})
+	}
+	return renames
+}
+
+// renameModules rewrites `module.` references in code to use the module's
+// alias. Attribute accesses such as `x.boto3.` and text inside string
+// literals and comments are left alone.
+func renameModules(code string, renames []moduleRename) string {
+	if len(renames) == 0 {
+		return code
+	}
+
+	var sb strings.Builder
+	for _, span := range parser.SplitCode(code) {
+		text := code[span.Start:span.End]
+		if span.Code {
+			for _, rename := range renames {
+				text = rename.ref.ReplaceAllString(text, "${1}"+rename.alias+".")
+			}
+		}
+		sb.WriteString(text)
+	}
+	return sb.String()
+}
+
+// pruneUnusedImports drops imports none of whose bound names appear in the
+// code or setup code. Imports it cannot parse are kept.
+func pruneUnusedImports(src *sourceLines, imports importSources, setupCodes []string) {
+	var code strings.Builder
+	for i, line := range src.lines {
+		if !src.removed[i] {
+			code.WriteString(line)
+			code.WriteString("\n")
+		}
+	}
+	for _, setup := range setupCodes {
+		code.WriteString(setup)
+		code.WriteString("\n")
+	}
+	words := parser.Words(code.String())
+
+	for imp := range imports {
+		names := importNames(imp)
+		if len(names) == 0 {
+			continue
+		}
+
+		used := false
+		for _, name := range names {
+			if words[name] {
+				used = true
 				break
 			}
 		}
+		if !used {
+			delete(imports, imp)
+		}
+	}
+}
 
-		// Insert imports
-		importLines := mapKeysToSlice(newImports)
-		sort.Strings(importLines) // Sort for consistency
+// importNames returns the names an import statement binds, or nil when it
+// is not a simple import
+func importNames(imp string) []string {
+	m := importedName.FindStringSubmatch(imp)
+	if m == nil {
+		return nil
+	}
 
-		var newResult []string
-		newResult = append(newResult, result[:insertIdx]...)
-		newResult = append(newResult, importLines...)
-		newResult = append(newResult, "")
-		newResult = append(newResult, result[insertIdx:]...)
+	switch {
+	case m[2] != "":
+		return []string{m[2]}
+	case m[1] != "":
+		// `import a.b` binds a
+		return []string{strings.Split(m[1], ".")[0]}
+	}
+
+	list := strings.Trim(strings.TrimSpace(m[3]), "()")
+	if list == "*" {
+		return nil
+	}
+
+	var names []string
+	for _, part := range strings.Split(list, ",") {
+		fields := strings.Fields(part)
+		switch {
+		case len(fields) == 1:
+			names = append(names, fields[0])
+		case len(fields) == 3 && fields[1] == "as":
+			names = append(names, fields[2])
+		default:
+			return nil
+		}
+	}
+	return names
+}
+
+// isPreserved reports whether an Infrar import is still needed by an untransformed call
+func (g *Generator) isPreserved(imp types.Import) bool {
+	module := types.CanonicalModule(imp.Module, g.sdkPrefix)
+	for _, call := range g.preserved {
+		if call.Module == module || strings.HasPrefix(call.Module, module+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// isSDKImport reports whether imp imports the Infrar SDK
+func (g *Generator) isSDKImport(imp types.Import) bool {
+	if g.sdkPrefix == "" {
+		return strings.HasPrefix(imp.Module, types.SDKPrefix)
+	}
+	return imp.Module == g.sdkPrefix || strings.HasPrefix(imp.Module, g.sdkPrefix+".")
+}
+
+// setupCode renders a rule's setup code, preceded by the binding of its
+// client when that is shared
+func (g *Generator) setupCode(rule types.TransformationRule) (string, error) {
+	setup, err := plugin.RenderSetupCode(rule.SetupCode, g.style)
+	if err != nil || !g.shareClients || !rule.Client.Shareable || rule.Client.Code == "" {
+		return setup, err
+	}
+
+	client, err := plugin.RenderSetupCode(rule.Client.Code, g.style)
+	if err != nil {
+		return "", err
+	}
+	binding := rule.Client.Name + " = " + client
+	if setup == "" {
+		return binding, nil
+	}
+	return binding + "\n" + setup, nil
+}
+
+// addSetupCode adds setup code after the module's top-level imports
+func (g *Generator) addSetupCode(src *sourceLines, ast *types.AST, setupCodes []string) {
+	insertIdx := importInsertIndex(src, ast)
+	for _, imp := range ast.Imports {
+		end := max(imp.LineNumber, imp.EndLineNumber)
+		if imp.TopLevel && end > insertIdx {
+			insertIdx = end // 1-based end line is the 0-based index of the next line
+		}
+	}
 
-		return strings.Join(newResult, "\n")
+	var block []string
+	if prev, ok := src.lineBefore(insertIdx); ok && strings.TrimSpace(prev) != "" {
+		block = append(block, "")
+	}
+	block = append(block, setupCodes...)
+	if insertIdx >= len(src.lines) || strings.TrimSpace(src.lines[insertIdx]) != "" {
+		block = append(block, "")
 	}
 
-	return strings.Join(result, "\n")
+	src.insertBefore(insertIdx, block...)
 }
 
-// addSetupCode adds setup code after imports
-func (g *Generator) addSetupCode(code string, setupCodes []string) string {
-	lines := strings.Split(code, "\n")
+// addFunctionSetupCode adds setup code at the top of each function body that
+// needs it, past any docstring, indented to match the body. The code is
+// otherwise copied verbatim, so `await` and `async with` survive.
+func addFunctionSetupCode(src *sourceLines, functions []parser.PythonFunction, setups map[string][]string) {
+	for _, fn := range functions {
+		codes := setups[fn.Name]
+		if len(codes) == 0 {
+			continue
+		}
+
+		var block []string
+		for _, code := range codes {
+			for _, line := range strings.Split(strings.TrimRight(code, "\n"), "\n") {
+				if strings.TrimSpace(line) == "" {
+					block = append(block, "")
+					continue
+				}
+				block = append(block, fn.BodyIndent+line)
+			}
+		}
+
+		src.insertBefore(fn.BodyLineNumber-1, block...)
+	}
+}
 
-	// Find where to insert setup code (after imports)
-	insertIdx := 0
-	for i, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		if trimmed == "" || strings.HasPrefix(trimmed, "import ") || strings.HasPrefix(trimmed, "from ") {
-			insertIdx = i + 1
+// enclosingFunction returns the innermost function around scope whose body
+// can take new statements. Class bodies and lambdas are skipped in favour of
+// the function around them, whose names the inner scope still sees.
+func enclosingFunction(functions []parser.PythonFunction, scope string) (parser.PythonFunction, bool) {
+	for scope != "" {
+		for _, fn := range functions {
+			if fn.Name == scope && !fn.Inline {
+				return fn, true
+			}
+		}
+
+		if idx := strings.LastIndex(scope, "."); idx >= 0 {
+			scope = scope[:idx]
 		} else {
-			break
+			scope = ""
 		}
 	}
+	return parser.PythonFunction{}, false
+}
+
+// importInsertIndex returns the line index new imports are inserted before:
+// after the module docstring, any `from __future__` imports, which Python
+// requires to come first, and the comment banner at the top of the file. The
+// banner is the first block of contiguous comment lines and is kept in one
+// piece; comments after it belong to the code that follows, so the imports
+// go above them. The docstring position comes from the parser, so
+// import-like text inside it is never mistaken for code. Imports never go
+// above the shebang and coding declaration, which only take effect on the
+// first two lines.
+func importInsertIndex(src *sourceLines, ast *types.AST) int {
+	insertIdx, _ := ast.Metadata["docstring_end_lineno"].(int)
+	insertIdx = max(insertIdx, headerLineCount(src))
 
-	// Insert setup code
-	var newResult []string
-	newResult = append(newResult, lines[:insertIdx]...)
-	newResult = append(newResult, "")
-	newResult = append(newResult, setupCodes...)
-	newResult = append(newResult, "")
-	newResult = append(newResult, lines[insertIdx:]...)
+	for _, imp := range ast.Imports {
+		if imp.TopLevel && imp.Module == "__future__" {
+			insertIdx = max(insertIdx, imp.LineNumber, imp.EndLineNumber) // 1-based end line is the 0-based index of the next line
+		}
+	}
+
+	insertIdx = skipLines(src, insertIdx, isBlankLine)
+	insertIdx = skipLines(src, insertIdx, isCommentLine)
+	return skipLines(src, insertIdx, isBlankLine)
+}
+
+// skipLines returns the index of the first line from idx on that skip does
+// not match
+func skipLines(src *sourceLines, idx int, skip func(string) bool) int {
+	for idx < len(src.lines) && skip(src.lines[idx]) {
+		idx++
+	}
+	return idx
+}
+
+// isBlankLine reports whether a line is empty or only whitespace
+func isBlankLine(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+// isCommentLine reports whether a line holds only a comment
+func isCommentLine(line string) bool {
+	return strings.HasPrefix(strings.TrimSpace(line), "#")
+}
+
+// codingDeclaration matches a PEP 263 source encoding declaration
+var codingDeclaration = regexp.MustCompile(`^[ \t\f]*#.*?coding[:=][ \t]*[-\w.]+`)
+
+// headerLineCount returns how many leading lines are a shebang or coding
+// declaration, which must stay on lines 1-2
+func headerLineCount(src *sourceLines) int {
+	count := 0
+	for i, line := range src.lines[:min(2, len(src.lines))] {
+		switch {
+		case i == 0 && strings.HasPrefix(line, "#!"):
+			count = 1
+		case codingDeclaration.MatchString(line):
+			count = i + 1
+		case !strings.HasPrefix(strings.TrimSpace(line), "#"):
+			// A coding declaration on line 2 only counts after a comment line
+			return count
+		}
+	}
+	return count
+}
+
+// lineLengthWarning reports a transformed call whose widest generated line,
+// placed at the call site, exceeds the style's maximum line length
+func (g *Generator) lineLengthWarning(tc types.TransformedCall, sourceLines []string) (types.Warning, bool) {
+	if g.style.MaxLineLength == 0 || tc.LineNumber < 1 || tc.LineNumber > len(sourceLines) {
+		return types.Warning{}, false
+	}
+
+	indent := utf8.RuneCountInString(getIndentation(sourceLines[tc.LineNumber-1]))
+	width := 0
+	for i, line := range strings.Split(tc.TransformedCode, "\n") {
+		offset := indent
+		if i == 0 {
+			offset = max(indent, tc.OriginalCall.ColumnOffset)
+		}
+		width = max(width, offset+utf8.RuneCountInString(line))
+	}
+	if width <= g.style.MaxLineLength {
+		return types.Warning{}, false
+	}
 
-	return strings.Join(newResult, "\n")
+	return types.Warning{
+		Message:    fmt.Sprintf("generated code for %s is %d characters wide, over the maximum line length of %d", tc.OriginalCall.FullName(), width, g.style.MaxLineLength),
+		LineNumber: tc.LineNumber,
+		Category:   "style",
+	}, true
 }
 
 // Helper functions
@@ -226,10 +1148,13 @@ func contains(slice []string, item string) bool {
 	return false
 }
 
-func mapKeysToSlice(m map[string]bool) []string {
+// mapKeysToSlice returns the keys of m in sorted order, so output built from
+// them is stable from run to run
+func mapKeysToSlice[V any](m map[string]V) []string {
 	keys := make([]string, 0, len(m))
 	for k := range m {
 		keys = append(keys, k)
 	}
+	sort.Strings(keys)
 	return keys
 }
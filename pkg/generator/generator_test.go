@@ -1,10 +1,15 @@
 package generator
 
 import (
+	"os"
+	"reflect"
 	"strings"
 	"testing"
 
+	"github.com/QodeSrl/infrar-engine/pkg/detector"
+	"github.com/QodeSrl/infrar-engine/pkg/parser"
 	"github.com/QodeSrl/infrar-engine/pkg/plugin"
+	"github.com/QodeSrl/infrar-engine/pkg/transformer"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
@@ -73,6 +78,45 @@ upload(bucket='data', source='file.txt', destination='file.txt')
 	}
 }
 
+func TestGenerator_SortedImports(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:  "infrar.storage.upload",
+		Provider: types.ProviderAWS,
+		Imports:  []string{"import os", "import boto3", "from botocore.config import Config"},
+	})
+
+	ast := &types.AST{
+		Language: types.LanguagePython,
+		SourceCode: `from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+		Imports: []types.Import{
+			{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1},
+		},
+	}
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall:    types.InfrarCall{Module: "infrar.storage", Function: "upload"},
+			TransformedCode: "s3.upload_file('file.txt', 'data', 'file.txt')",
+			LineNumber:      3,
+		},
+	}
+
+	// Imports come out in the same order on every run
+	want := []string{"from botocore.config import Config", "import boto3", "import os"}
+	for range 10 {
+		result, err := New(types.ProviderAWS, registry).Generate(ast, transformedCalls)
+		if err != nil {
+			t.Fatalf("Generate() error = %v", err)
+		}
+		if !reflect.DeepEqual(result.Imports, want) {
+			t.Fatalf("Imports = %v, want %v", result.Imports, want)
+		}
+	}
+}
+
 func TestGenerator_NoTransformations(t *testing.T) {
 	registry := plugin.NewRegistry()
 	generator := New(types.ProviderAWS, registry)
@@ -113,3 +157,578 @@ func TestGetIndentation(t *testing.T) {
 		}
 	}
 }
+
+func TestRenameModules(t *testing.T) {
+	renames := moduleRenames([]types.TransformationRule{{Imports: []string{"import boto3"}}}, map[string]string{"boto3": "b3"})
+
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"s3 = boto3.client('s3')", "s3 = b3.client('s3')"},
+		{"x.boto3.client()", "x.boto3.client()"},
+		{"log('see boto3.client') # boto3.client", "log('see boto3.client') # boto3.client"},
+		{`f(boto3.x, "it's boto3.y", boto3.z)`, `f(b3.x, "it's boto3.y", b3.z)`},
+		{"f(\"\"\"\nboto3.x\n\"\"\", boto3.y)", "f(\"\"\"\nboto3.x\n\"\"\", b3.y)"},
+		{`f('a\'boto3.x', boto3.y)`, `f('a\'boto3.x', b3.y)`},
+	}
+
+	for _, tt := range tests {
+		if got := renameModules(tt.code, renames); got != tt.want {
+			t.Errorf("renameModules(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestHeaderLineCount(t *testing.T) {
+	tests := []struct {
+		code string
+		want int
+	}{
+		{"#!/usr/bin/env python\n# -*- coding: utf-8 -*-\nimport os", 2},
+		{"#!/usr/bin/env python\nimport os", 1},
+		{"# vim: set fileencoding=latin-1 :\nimport os", 1},
+		{"# Copyright\n# coding: utf-8\nimport os", 2},
+		{"import os\n# coding: utf-8", 0},
+		{"# Copyright\nimport os", 0},
+		{"", 0},
+	}
+
+	for _, tt := range tests {
+		got := headerLineCount(newSourceLines(tt.code))
+		if got != tt.want {
+			t.Errorf("headerLineCount(%q) = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
+
+func TestGenerator_ImportPlacement(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:  "infrar.storage.upload",
+		Provider: types.ProviderAWS,
+		Imports:  []string{"import boto3"},
+	})
+
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall: types.InfrarCall{
+				Module:   "infrar.storage",
+				Function: "upload",
+			},
+			TransformedCode: "boto3.client('s3').upload_file('file.txt', 'data', 'file.txt')",
+		},
+	}
+
+	tests := []struct {
+		name    string
+		source  string
+		imports []types.Import
+		want    string
+	}{
+		{
+			name: "future import stays first",
+			source: `from __future__ import annotations
+
+import os
+from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			imports: []types.Import{
+				{Module: "__future__", Names: []string{"annotations"}, LineNumber: 1, EndLineNumber: 1, TopLevel: true},
+				{Module: "os", LineNumber: 3, EndLineNumber: 3, TopLevel: true},
+				{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 4, EndLineNumber: 4, TopLevel: true},
+			},
+			want: `from __future__ import annotations
+
+import boto3
+
+import os
+
+boto3.client('s3').upload_file('file.txt', 'data', 'file.txt')
+`,
+		},
+		{
+			name: "multi-line future import before a banner",
+			source: `from __future__ import (
+    annotations,
+)
+# Storage helpers
+import os
+from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			imports: []types.Import{
+				{Module: "__future__", Names: []string{"annotations"}, LineNumber: 1, EndLineNumber: 3, TopLevel: true},
+				{Module: "os", LineNumber: 5, EndLineNumber: 5, TopLevel: true},
+				{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 6, EndLineNumber: 6, TopLevel: true},
+			},
+			want: `from __future__ import (
+    annotations,
+)
+# Storage helpers
+import boto3
+
+import os
+
+boto3.client('s3').upload_file('file.txt', 'data', 'file.txt')
+`,
+		},
+		{
+			name: "banner kept in one piece",
+			source: `# ==========================
+# Data export job
+#
+# Copyright (c) Example Ltd.
+# ==========================
+
+# Standard library
+import os
+from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+			imports: []types.Import{
+				{Module: "os", LineNumber: 8, EndLineNumber: 8, TopLevel: true},
+				{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 9, EndLineNumber: 9, TopLevel: true},
+			},
+			want: `# ==========================
+# Data export job
+#
+# Copyright (c) Example Ltd.
+# ==========================
+
+import boto3
+
+# Standard library
+import os
+
+boto3.client('s3').upload_file('file.txt', 'data', 'file.txt')
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ast := &types.AST{
+				Language:   types.LanguagePython,
+				SourceCode: tt.source,
+				Imports:    tt.imports,
+			}
+			calls := append([]types.TransformedCall(nil), transformedCalls...)
+			calls[0].LineNumber = strings.Count(tt.source, "\n")
+
+			result, err := New(types.ProviderAWS, registry).Generate(ast, calls)
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+			if result.TransformedCode != tt.want {
+				t.Errorf("Generate() got:\n%s\nwant:\n%s", result.TransformedCode, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerator_SourceMap(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:   "infrar.storage.upload",
+		Provider:  types.ProviderAWS,
+		Imports:   []string{"import boto3"},
+		SetupCode: "s3 = boto3.client('s3')",
+	})
+
+	ast := &types.AST{
+		Language: types.LanguagePython,
+		SourceCode: `from infrar.storage import upload
+
+print('start')
+upload(
+    bucket='data',
+    source='file.txt',
+    destination='file.txt',
+)
+print('done')
+`,
+		Imports: []types.Import{
+			{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1, EndLineNumber: 1, TopLevel: true},
+		},
+	}
+
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall: types.InfrarCall{
+				Module:          "infrar.storage",
+				Function:        "upload",
+				LineNumber:      4,
+				EndLineNumber:   8,
+				EndColumnOffset: 1,
+			},
+			TransformedCode: "s3.upload_file(\n    'file.txt', 'data', 'file.txt'\n)",
+			LineNumber:      4,
+		},
+	}
+
+	gen := New(types.ProviderAWS, registry)
+	gen.SetSourceMap(true)
+	result, err := gen.Generate(ast, transformedCalls)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// The import and setup code shift the code down: the generated file is
+	// import boto3, blank, s3 = ..., blank, print('start'), s3.upload_file(
+	// over three lines, print('done')
+	sourceMap, ok := result.Metadata[SourceMapKey].([]types.LineMapping)
+	if !ok {
+		t.Fatalf("Metadata[%q] = %#v, want a source map", SourceMapKey, result.Metadata[SourceMapKey])
+	}
+	generated := strings.Split(result.TransformedCode, "\n")
+	want := map[int][2]int{
+		3: {5, 5},
+		4: {6, 8},
+		7: {6, 8},
+		9: {9, 9},
+	}
+	for _, m := range sourceMap {
+		if m.Original == 1 {
+			t.Errorf("source map has the removed import: %+v", m)
+		}
+		lines, ok := want[m.Original]
+		if !ok {
+			continue
+		}
+		if m.GeneratedStart != lines[0] || m.GeneratedEnd != lines[1] {
+			t.Errorf("line %d maps to %d-%d, want %d-%d in:\n%s", m.Original, m.GeneratedStart, m.GeneratedEnd, lines[0], lines[1], result.TransformedCode)
+		}
+		delete(want, m.Original)
+	}
+	if len(want) > 0 {
+		t.Errorf("source map %+v has no entry for lines %v", sourceMap, want)
+	}
+	if got := generated[5]; got != "s3.upload_file(" {
+		t.Errorf("generated line 6 = %q, want the transformed call", got)
+	}
+}
+
+func TestGenerator_DocstringWithImportText(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:   "infrar.storage.upload",
+		Provider:  types.ProviderAWS,
+		Imports:   []string{"import boto3"},
+		SetupCode: "s3 = boto3.client('s3')",
+	})
+
+	ast := &types.AST{
+		Language: types.LanguagePython,
+		SourceCode: `"""
+Usage:
+    from infrar.storage import upload
+    import infrar
+"""
+from infrar.storage import upload
+
+upload(bucket='data', source='file.txt', destination='file.txt')
+`,
+		Imports: []types.Import{
+			{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 6, EndLineNumber: 6, TopLevel: true},
+		},
+		Metadata: map[string]any{
+			"docstring_end_lineno": 5,
+		},
+	}
+
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall: types.InfrarCall{
+				Module:   "infrar.storage",
+				Function: "upload",
+			},
+			TransformedCode: "s3.upload_file('file.txt', 'data', 'file.txt')",
+			LineNumber:      8,
+		},
+	}
+
+	result, err := New(types.ProviderAWS, registry).Generate(ast, transformedCalls)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	want := `"""
+Usage:
+    from infrar.storage import upload
+    import infrar
+"""
+import boto3
+
+s3 = boto3.client('s3')
+
+s3.upload_file('file.txt', 'data', 'file.txt')
+`
+
+	if result.TransformedCode != want {
+		t.Errorf("Generate() got:\n%s\nwant:\n%s", result.TransformedCode, want)
+	}
+}
+
+func TestGenerator_Report(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Name:     "upload",
+		Pattern:  "infrar.storage.upload",
+		Provider: types.ProviderAWS,
+		Imports:  []string{"import boto3"},
+	})
+
+	original := "upload(bucket='data', source='file.txt', destination='file.txt')"
+	ast := &types.AST{
+		Language:   types.LanguagePython,
+		SourceCode: "from infrar.storage import upload\n\nif True:\n    " + original + "\n",
+		Imports: []types.Import{
+			{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1},
+		},
+	}
+
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall: types.InfrarCall{
+				Module:       "infrar.storage",
+				Function:     "upload",
+				LineNumber:   4,
+				ColumnOffset: 4,
+				SourceCode:   original,
+			},
+			TransformedCode: "s3.upload_file('file.txt', 'data', 'file.txt')",
+			LineNumber:      4,
+		},
+	}
+
+	result, err := New(types.ProviderAWS, registry).Generate(ast, transformedCalls)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	want := types.CallReport{
+		LineNumber:   4,
+		ColumnOffset: 4,
+		Original:     original,
+		Transformed:  "s3.upload_file('file.txt', 'data', 'file.txt')",
+		Rule:         "upload",
+		Pattern:      "infrar.storage.upload",
+	}
+	if len(result.Report) != 1 || result.Report[0] != want {
+		t.Errorf("Report = %+v, want [%+v]", result.Report, want)
+	}
+}
+
+func TestGenerator_PruneImports(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Name:     "upload",
+		Pattern:  "infrar.storage.upload",
+		Provider: types.ProviderAWS,
+		Imports: []string{
+			"import boto3",
+			"from botocore.exceptions import ClientError",
+			"from boto3.s3.transfer import TransferConfig as Config",
+		},
+		SetupCode: "s3 = boto3.client('s3')",
+	})
+
+	original := "upload(bucket='data', source='file.txt', destination='file.txt')"
+	ast := &types.AST{
+		Language:   types.LanguagePython,
+		SourceCode: "import json\nfrom infrar.storage import upload\n\n" + original + "\n",
+		Imports: []types.Import{
+			{Module: "json", Names: []string{"json"}, LineNumber: 1, TopLevel: true},
+			{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 2, TopLevel: true},
+		},
+	}
+
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall: types.InfrarCall{
+				Module:          "infrar.storage",
+				Function:        "upload",
+				LineNumber:      4,
+				EndLineNumber:   4,
+				EndColumnOffset: len(original),
+				SourceCode:      original,
+			},
+			TransformedCode: "s3.upload_file('file.txt', 'data', 'file.txt', Config=Config())",
+			LineNumber:      4,
+		},
+	}
+
+	tests := []struct {
+		name    string
+		prune   bool
+		imports int
+		want    []string
+		notWant []string
+	}{
+		{
+			name:    "Pruning disabled keeps every rule import",
+			prune:   false,
+			imports: 3,
+			want: []string{
+				"import boto3",
+				"from botocore.exceptions import ClientError",
+				"from boto3.s3.transfer import TransferConfig as Config",
+			},
+		},
+		{
+			name:    "Pruning drops the unused import",
+			prune:   true,
+			imports: 2,
+			want: []string{
+				"import boto3",
+				"from boto3.s3.transfer import TransferConfig as Config",
+				"import json",
+			},
+			notWant: []string{"ClientError"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gen := New(types.ProviderAWS, registry)
+			gen.SetPruneImports(tt.prune)
+
+			result, err := gen.Generate(ast, transformedCalls)
+			if err != nil {
+				t.Fatalf("Generate() error = %v", err)
+			}
+
+			for _, want := range tt.want {
+				if !strings.Contains(result.TransformedCode, want) {
+					t.Errorf("Expected %q in output, got:\n%s", want, result.TransformedCode)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(result.TransformedCode, notWant) {
+					t.Errorf("Did not expect %q in output, got:\n%s", notWant, result.TransformedCode)
+				}
+			}
+			if len(result.Imports) != tt.imports {
+				t.Errorf("Imports = %v, want %d entries", result.Imports, tt.imports)
+			}
+			if len(result.ImportSources) != tt.imports {
+				t.Errorf("ImportSources = %v, want %d entries", result.ImportSources, tt.imports)
+			}
+		})
+	}
+}
+
+func BenchmarkGenerator_Generate(b *testing.B) {
+	source, err := os.ReadFile("../../testdata/benchmark.py")
+	if err != nil {
+		b.Fatalf("Failed to read fixture: %v", err)
+	}
+	p, err := parser.NewPythonParser()
+	if err != nil {
+		b.Fatalf("Failed to create parser: %v", err)
+	}
+	ast, err := p.Parse(string(source))
+	if err != nil {
+		b.Fatalf("Parse() error = %v", err)
+	}
+	calls, err := detector.NewDetector().DetectCalls(ast)
+	if err != nil {
+		b.Fatalf("DetectCalls() error = %v", err)
+	}
+
+	rules, err := plugin.NewLoader("../../test-plugins").LoadRules(types.ProviderAWS, "storage")
+	if err != nil {
+		b.Fatalf("LoadRules() error = %v", err)
+	}
+	registry := plugin.NewProviderRegistry(types.ProviderAWS)
+	registry.RegisterMultiple(rules)
+
+	transformedCalls, err := transformer.New(registry).TransformMultiple(calls)
+	if err != nil {
+		b.Fatalf("TransformMultiple() error = %v", err)
+	}
+	gen := New(types.ProviderAWS, registry)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := gen.Generate(ast, transformedCalls); err != nil {
+			b.Fatalf("Generate() error = %v", err)
+		}
+	}
+}
+
+func TestGenerator_ImportConflicts(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Name:     "upload",
+		Pattern:  "infrar.storage.upload",
+		Provider: types.ProviderGCP,
+		Imports: []string{
+			"from google.cloud import storage",
+			"import json",
+			"import boto3",
+		},
+	})
+
+	original := "upload(bucket='data', source='file.txt')"
+	ast := &types.AST{
+		Language:   types.LanguagePython,
+		SourceCode: "from infrar.storage import upload\nimport json\nimport mylib as boto3\n\nstorage = 'local'\n" + original + "\n",
+		Imports: []types.Import{
+			{Module: "infrar.storage", Names: []string{"upload"}, LineNumber: 1, TopLevel: true},
+			{Module: "json", Names: []string{"json"}, LineNumber: 2, TopLevel: true},
+			{Module: "mylib", Names: []string{"mylib"}, Alias: "boto3", LineNumber: 3, TopLevel: true},
+		},
+		Metadata: map[string]any{
+			"assignments": []parser.PythonAssignment{{Target: "storage", Value: "'local'", LineNumber: 5}},
+		},
+	}
+
+	transformedCalls := []types.TransformedCall{
+		{
+			OriginalCall: types.InfrarCall{
+				Module:          "infrar.storage",
+				Function:        "upload",
+				LineNumber:      6,
+				EndLineNumber:   6,
+				EndColumnOffset: len(original),
+				SourceCode:      original,
+			},
+			TransformedCode: "storage.Client().bucket('data').blob('file.txt').upload_from_filename('file.txt')",
+			LineNumber:      6,
+		},
+	}
+
+	result, err := New(types.ProviderGCP, registry).Generate(ast, transformedCalls)
+	if err != nil {
+		t.Fatalf("Generate() error = %v", err)
+	}
+
+	// The replaced Infrar import and the identical json import are no conflict
+	conflicts := make(map[int]string)
+	for _, w := range result.Warnings {
+		if w.Category == "import-conflict" {
+			conflicts[w.LineNumber] = w.Message
+		}
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("import conflicts = %v, want one for boto3 and one for storage", conflicts)
+	}
+	if !strings.Contains(conflicts[3], "rebinds boto3, which line 3 already imports it as mylib") {
+		t.Errorf("conflict on line 3 = %q, want the boto3 alias", conflicts[3])
+	}
+	if !strings.Contains(conflicts[5], `"from google.cloud import storage" for infrar.storage.upload rebinds storage, which line 5 already defines it`) {
+		t.Errorf("conflict on line 5 = %q, want the storage variable", conflicts[5])
+	}
+
+	// The warning is advisory: the import is still added
+	if !strings.Contains(result.TransformedCode, "from google.cloud import storage") {
+		t.Errorf("TransformedCode =\n%s\nwant the conflicting import added", result.TransformedCode)
+	}
+}
@@ -7,8 +7,11 @@ of the AST for consumption by the Go-based transformation engine.
 """
 
 import ast
+import io
 import json
 import sys
+import tokenize
+import warnings
 from typing import Any, Dict, List, Optional
 
 
@@ -34,9 +37,28 @@ def get_value_type(value: Any) -> str:
         return "unknown"
 
 
-def extract_value(node: ast.AST) -> Dict[str, Any]:
-    """Extract value from an AST node."""
-    if isinstance(node, ast.Constant):
+def extract_value(node: ast.AST, source_lines: Optional[List[str]] = None) -> Dict[str, Any]:
+    """
+    Extract value from an AST node.
+
+    Calls are kept structurally: the value is the call's source text and
+    "call" describes it like a top-level call, so nested Infrar calls can be
+    transformed into the enclosing one.
+    """
+    if isinstance(node, ast.Call):
+        text = source_segment(source_lines, node) if source_lines else None
+        return {
+            "type": "call",
+            "value": text,
+            "call": call_info(node, source_lines or [], {})
+        }
+    elif isinstance(node, ast.JoinedStr):
+        return {
+            "type": "fstring",
+            "value": source_segment(source_lines, node) if source_lines else None,
+            "parts": fstring_parts(node, source_lines),
+        }
+    elif isinstance(node, ast.Constant):
         # Python 3.8+
         value_type = get_value_type(node.value)
         return {
@@ -54,16 +76,19 @@ def extract_value(node: ast.AST) -> Dict[str, Any]:
         return {"type": get_value_type(node.value), "value": node.value}
     elif isinstance(node, ast.Name):
         return {"type": "variable", "value": node.id}
+    elif isinstance(node, ast.Attribute):
+        # Attribute access such as config.bucket_name, kept as written
+        return {"type": "variable", "value": expression_source(node, source_lines)}
     elif isinstance(node, ast.List):
         return {
             "type": "list",
-            "value": [extract_value(elt) for elt in node.elts]
+            "value": [extract_value(elt, source_lines) for elt in node.elts]
         }
     elif isinstance(node, ast.Dict):
         return {
             "type": "dict",
             "value": {
-                extract_value(k)["value"]: extract_value(v)
+                extract_value(k)["value"]: extract_value(v, source_lines)
                 for k, v in zip(node.keys, node.values)
             }
         }
@@ -71,9 +96,42 @@ def extract_value(node: ast.AST) -> Dict[str, Any]:
         return {"type": "unknown", "value": None}
 
 
+def fstring_parts(node: ast.JoinedStr, source_lines: Optional[List[str]]) -> List[Dict[str, Any]]:
+    """
+    Split an f-string into literal text and interpolated expressions.
+
+    Each part is {"literal": text} or {"expression": source} with an optional
+    "conversion" (r, s or a) and "format_spec" as written after the colon.
+    """
+    parts = []
+    for value in node.values:
+        if isinstance(value, ast.FormattedValue):
+            part = {"expression": expression_source(value.value, source_lines)}
+            if value.conversion != -1:
+                part["conversion"] = chr(value.conversion)
+            if value.format_spec is not None:
+                part["format_spec"] = "".join(
+                    p["literal"] if "literal" in p else "{" + p["expression"] + "}"
+                    for p in fstring_parts(value.format_spec, source_lines)
+                )
+            parts.append(part)
+        else:
+            parts.append({"literal": value.value if isinstance(value, ast.Constant) else value.s})
+    return parts
+
+
+def expression_source(node: ast.AST, source_lines: Optional[List[str]]) -> str:
+    """Return the source text of an expression."""
+    if hasattr(ast, "unparse"):
+        # Python 3.9+; positions inside f-strings are unreliable before 3.12
+        return ast.unparse(node)
+    return source_segment(source_lines or [], node)
+
+
 def extract_imports(tree: ast.Module) -> List[Dict[str, Any]]:
     """Extract import statements from the AST."""
     imports = []
+    top_level = {id(node) for node in tree.body}
 
     for node in ast.walk(tree):
         if isinstance(node, ast.Import):
@@ -82,7 +140,9 @@ def extract_imports(tree: ast.Module) -> List[Dict[str, Any]]:
                     "module": alias.name,
                     "names": [alias.name],
                     "alias": alias.asname or "",
-                    "lineno": node.lineno
+                    "lineno": node.lineno,
+                    "end_lineno": getattr(node, "end_lineno", node.lineno),
+                    "top_level": id(node) in top_level
                 })
 
         elif isinstance(node, ast.ImportFrom):
@@ -92,64 +152,379 @@ def extract_imports(tree: ast.Module) -> List[Dict[str, Any]]:
                 "module": module,
                 "names": names,
                 "alias": "",
-                "lineno": node.lineno
+                "lineno": node.lineno,
+                "end_lineno": getattr(node, "end_lineno", node.lineno),
+                "top_level": id(node) in top_level
             })
 
     return imports
 
 
-def extract_calls(tree: ast.Module, source_lines: List[str]) -> List[Dict[str, Any]]:
-    """Extract function calls from the AST, focusing on potential Infrar SDK calls."""
+def extract_comments(source_code: str) -> List[Dict[str, Any]]:
+    """
+    Return the comments of the source with their positions.
+
+    Comments are not part of the AST, so the source is tokenized; text that
+    only looks like a comment, inside a string or docstring, is not reported.
+    """
+    comments = []
+    try:
+        for tok in tokenize.generate_tokens(io.StringIO(source_code).readline):
+            if tok.type == tokenize.COMMENT:
+                comments.append({
+                    "lineno": tok.start[0],
+                    "col_offset": tok.start[1],
+                    "text": tok.string,
+                    "own_line": not tok.line[:tok.start[1]].strip()
+                })
+    except (tokenize.TokenError, SyntaxError):
+        pass
+    return comments
+
+
+def docstring_end_lineno(tree: ast.Module) -> int:
+    """Return the last line of the module docstring, or 0 if there is none."""
+    if tree.body:
+        first = tree.body[0]
+        if isinstance(first, ast.Expr) and isinstance(first.value, ast.Constant) \
+                and isinstance(first.value.value, str):
+            return getattr(first, "end_lineno", first.lineno)
+    return 0
+
+
+def build_scope_map(tree: ast.Module) -> Dict[int, str]:
+    """
+    Map each node (by id) to the dotted name of its enclosing scope.
+
+    Module-level nodes map to "", nodes inside `def f` map to "f",
+    and nodes inside a method map to "Class.method".
+    """
+    scopes = {}
+
+    def visit(node: ast.AST, scope: str) -> None:
+        for child in ast.iter_child_nodes(node):
+            scopes[id(child)] = scope
+            child_scope = scope
+            if isinstance(child, (ast.FunctionDef, ast.AsyncFunctionDef, ast.ClassDef)):
+                child_scope = f"{scope}.{child.name}" if scope else child.name
+            elif isinstance(child, ast.Lambda):
+                child_scope = f"{scope}.<lambda>" if scope else "<lambda>"
+            visit(child, child_scope)
+
+    visit(tree, "")
+    return scopes
+
+
+def extract_functions(tree: ast.Module, source_lines: List[str], scopes: Dict[int, str]) -> List[Dict[str, Any]]:
+    """
+    Extract function spans, so code can be placed at the top of a body.
+
+    `name` is the function's dotted scope, matching the `scope` of the calls
+    inside it. `body_lineno` is the line statements can be inserted before
+    (after any docstring) and `body_indent` the body's indentation. `inline`
+    marks bodies that start on the `def` line, where nothing can be inserted.
+    """
+    functions = []
+
+    for node in ast.walk(tree):
+        if not isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef)):
+            continue
+
+        scope = scopes.get(id(node), "")
+        first = node.body[0]
+        line = source_lines[first.lineno - 1] if first.lineno <= len(source_lines) else ""
+        indent = line[:first.col_offset]
+        body_lineno = first.lineno
+        if isinstance(first, ast.Expr) and isinstance(first.value, ast.Constant) \
+                and isinstance(first.value.value, str):
+            body_lineno = getattr(first, "end_lineno", first.lineno) + 1
+
+        functions.append({
+            "name": f"{scope}.{node.name}" if scope else node.name,
+            "lineno": node.lineno,
+            "end_lineno": getattr(node, "end_lineno", node.lineno),
+            "body_lineno": body_lineno,
+            "body_indent": indent,
+            "inline": indent.strip() != "",
+            "async": isinstance(node, ast.AsyncFunctionDef),
+        })
+
+    return functions
+
+
+def extract_assignments(tree: ast.Module, scopes: Dict[int, str]) -> List[Dict[str, Any]]:
+    """
+    Extract simple name bindings (`target = value`).
+
+    `value` holds the bound name for `fn = upload` style aliases and is empty
+    for any other expression, which lets the detector notice shadowing.
+    `literal` holds the string or number for `NAME = 'data'` style constants.
+    Augmented assignments and loop variables are recorded as rebinding the
+    name to an unknown value.
+    """
+    assignments = []
+
+    for node in ast.walk(tree):
+        if isinstance(node, ast.Assign):
+            targets = node.targets
+            value = node.value
+        elif isinstance(node, ast.AnnAssign) and node.value is not None:
+            targets = [node.target]
+            value = node.value
+        elif isinstance(node, ast.AugAssign):
+            targets = [node.target]
+            value = None
+        elif isinstance(node, (ast.For, ast.AsyncFor)):
+            targets = [n for n in ast.walk(node.target) if isinstance(n, ast.Name)]
+            value = None
+        else:
+            continue
+
+        bound = value.id if isinstance(value, ast.Name) else ""
+        literal = None
+        if value is not None:
+            extracted = extract_value(value)
+            if extracted["type"] in ("string", "number"):
+                literal = extracted
+        for target in targets:
+            if isinstance(target, ast.Name):
+                assignments.append({
+                    "target": target.id,
+                    "value": bound,
+                    "literal": literal,
+                    "lineno": node.lineno,
+                    "scope": scopes.get(id(node), ""),
+                })
+
+    return assignments
+
+
+def source_segment(source_lines: List[str], node: ast.AST) -> str:
+    """
+    Return the exact source text of a node, falling back to its whole first
+    line when end positions are unavailable. Column offsets count UTF-8 bytes.
+    """
+    end_lineno = getattr(node, "end_lineno", None)
+    end_col_offset = getattr(node, "end_col_offset", None)
+    if end_lineno is None or end_col_offset is None:
+        return source_lines[node.lineno - 1].strip()
+
+    lines = [line.encode("utf-8") for line in source_lines[node.lineno - 1:end_lineno]]
+    if len(lines) == 1:
+        return lines[0][node.col_offset:end_col_offset].decode("utf-8")
+    lines[0] = lines[0][node.col_offset:]
+    lines[-1] = lines[-1][:end_col_offset]
+    return b"\n".join(lines).decode("utf-8")
+
+
+def describe_callee(info: Dict[str, Any], func: ast.AST, source_lines: Optional[List[str]] = None) -> None:
+    """
+    Fill in the function and module of info from a callee expression.
+
+    A method chain such as `infrar.storage.bucket('data').upload(...)` is
+    described like the call `infrar.storage.bucket.upload(...)`, with the
+    earlier calls listed in "chain", innermost first. Their arguments are
+    added to info's, prefixed with the segment's name (`bucket.arg_0`).
+    Chains are only followed when source_lines is given.
+    """
+    if isinstance(func, ast.Name):
+        # Direct function call: upload(...)
+        info["function"] = func.id
+
+    elif isinstance(func, ast.Attribute):
+        # Attribute call: infrar.storage.upload(...)
+        # or storage.upload(...)
+        info["function"] = func.attr
+
+        # Try to extract the full module path
+        parts = []
+        current = func.value
+        while isinstance(current, ast.Attribute):
+            parts.insert(0, current.attr)
+            current = current.value
+        if isinstance(current, ast.Name):
+            parts.insert(0, current.id)
+            info["module"] = ".".join(parts)
+        elif isinstance(current, ast.Call) and source_lines is not None:
+            inner = call_info(current, source_lines, {})
+            if inner["function"]:
+                base = [inner["module"], inner["function"]] if inner["module"] else [inner["function"]]
+                info["module"] = ".".join(base + parts)
+                info["chain"] = inner.get("chain", []) + [{
+                    "function": inner["function"],
+                    "lineno": current.lineno,
+                    "col_offset": current.col_offset,
+                }]
+                for param, value in inner["arguments"].items():
+                    # Arguments of deeper segments are already prefixed
+                    key = param if "." in param else f"{inner['function']}.{param}"
+                    info["arguments"][key] = value
+                info.setdefault("spread", []).extend(inner.get("spread", []))
+
+
+def decorator_info(node: ast.AST, source_lines: List[str], scope: str) -> Dict[str, Any]:
+    """
+    Describe a bare decorator (`@infrar.events.handler`) like a call without
+    arguments, so it can be detected and replaced like one.
+    """
+    info = {
+        "lineno": node.lineno,
+        "col_offset": node.col_offset,
+        "end_lineno": getattr(node, "end_lineno", None),
+        "end_col_offset": getattr(node, "end_col_offset", None),
+        "function": None,
+        "module": None,
+        "arguments": {},
+        "scope": scope,
+        "decorator": True,
+    }
+    describe_callee(info, node)
+    if 0 <= node.lineno - 1 < len(source_lines):
+        info["source_code"] = source_segment(source_lines, node)
+    return info
+
+
+def call_info(node: ast.Call, source_lines: List[str], scopes: Dict[int, str]) -> Dict[str, Any]:
+    """Describe a call: its position, callee, arguments and source text."""
+    info = {
+        "lineno": node.lineno,
+        "col_offset": node.col_offset,
+        "end_lineno": getattr(node, "end_lineno", None),
+        "end_col_offset": getattr(node, "end_col_offset", None),
+        "function": None,
+        "module": None,
+        "arguments": {},
+        "scope": scopes.get(id(node), ""),
+    }
+
+    # Determine the function being called
+    describe_callee(info, node.func, source_lines)
+    spread = info.pop("spread", [])
+
+    # Extract arguments
+    # Positional arguments
+    for i, arg in enumerate(node.args):
+        if isinstance(arg, ast.Starred):
+            spread.append("*" + expression_source(arg.value, source_lines))
+            continue
+        info["arguments"][f"arg_{i}"] = extract_value(arg, source_lines)
+
+    # Keyword arguments
+    for keyword in node.keywords:
+        if keyword.arg is None:
+            spread.append("**" + expression_source(keyword.value, source_lines))
+            continue
+        info["arguments"][keyword.arg] = extract_value(keyword.value, source_lines)
+
+    # *args and **kwargs hide which arguments the call passes
+    if spread:
+        info["spread"] = spread
+
+    # JSON objects are unordered for the reader, so keep the source order
+    info["argument_order"] = list(info["arguments"])
+
+    # Extract source code snippet
+    if 0 <= node.lineno - 1 < len(source_lines):
+        info["source_code"] = source_segment(source_lines, node)
+
+    return info
+
+
+def extract_calls(tree: ast.Module, source_lines: List[str], scopes: Dict[int, str]) -> List[Dict[str, Any]]:
+    """
+    Extract function calls from the AST, focusing on potential Infrar SDK calls.
+
+    Every call is listed, including calls nested in another call's arguments.
+    Calls inside a lambda or comprehension are flagged with `expression`.
+    Decorators are flagged with `decorator`; bare ones (`@infrar.events.handler`)
+    are listed as calls without arguments. Their scope is the one the decorated
+    definition sits in, where decorators are evaluated. The context expression
+    of a `with` item (`with infrar.storage.open('f') as fh:`) is flagged with
+    `with_item`.
+    """
     calls = []
 
+    decorators = {}
     for node in ast.walk(tree):
-        if isinstance(node, ast.Call):
-            call_info = {
-                "lineno": node.lineno,
-                "col_offset": node.col_offset,
-                "function": None,
-                "module": None,
-                "arguments": {},
-            }
+        if isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef, ast.ClassDef)):
+            for decorator in node.decorator_list:
+                decorators[id(decorator)] = (decorator, scopes.get(id(node), ""))
 
-            # Determine the function being called
-            if isinstance(node.func, ast.Name):
-                # Direct function call: upload(...)
-                call_info["function"] = node.func.id
-
-            elif isinstance(node.func, ast.Attribute):
-                # Attribute call: infrar.storage.upload(...)
-                # or storage.upload(...)
-                call_info["function"] = node.func.attr
-
-                # Try to extract the full module path
-                parts = []
-                current = node.func.value
-                while isinstance(current, ast.Attribute):
-                    parts.insert(0, current.attr)
-                    current = current.value
-                if isinstance(current, ast.Name):
-                    parts.insert(0, current.id)
-                    call_info["module"] = ".".join(parts)
-
-            # Extract arguments
-            # Positional arguments
-            for i, arg in enumerate(node.args):
-                call_info["arguments"][f"arg_{i}"] = extract_value(arg)
-
-            # Keyword arguments
-            for keyword in node.keywords:
-                call_info["arguments"][keyword.arg] = extract_value(keyword.value)
-
-            # Extract source code snippet
-            if 0 <= node.lineno - 1 < len(source_lines):
-                call_info["source_code"] = source_lines[node.lineno - 1].strip()
-
-            calls.append(call_info)
+    # Calls in lambdas and comprehensions can only be replaced by an expression
+    expression_only = set()
+    for node in ast.walk(tree):
+        if isinstance(node, (ast.Lambda, ast.ListComp, ast.SetComp, ast.DictComp, ast.GeneratorExp)):
+            for child in ast.walk(node):
+                if child is not node and isinstance(child, ast.Call):
+                    expression_only.add(id(child))
+
+    # Calls used as context managers, whose replacement must stay an expression
+    with_items = set()
+    for node in ast.walk(tree):
+        if isinstance(node, (ast.With, ast.AsyncWith)):
+            for item in node.items:
+                if isinstance(item.context_expr, ast.Call):
+                    with_items.add(id(item.context_expr))
+
+    for node in ast.walk(tree):
+        if isinstance(node, ast.Call):
+            info = call_info(node, source_lines, scopes)
+            if id(node) in expression_only:
+                info["expression"] = True
+            if id(node) in with_items:
+                info["with_item"] = True
+            if id(node) in decorators:
+                info["decorator"] = True
+                info["scope"] = decorators[id(node)][1]
+            calls.append(info)
+
+    for decorator, scope in decorators.values():
+        if isinstance(decorator, (ast.Name, ast.Attribute)):
+            calls.append(decorator_info(decorator, source_lines, scope))
 
     return calls
 
 
+# Filename compile warnings about the parsed source are reported under
+SOURCE_FILENAME = "<source>"
+
+
+def parse_with_warnings(source_code: str):
+    """
+    Parse source code, collecting the warnings Python emits for it.
+
+    Invalid escape sequences are reported while parsing (as a
+    DeprecationWarning before Python 3.12), others such as `x is 1` only
+    when compiling, so the tree is compiled too. Compile errors the parser
+    lets through, e.g. `return` outside a function, are not reported here.
+    """
+    with warnings.catch_warnings(record=True) as caught:
+        warnings.simplefilter("always")
+        tree = ast.parse(source_code, SOURCE_FILENAME)
+        try:
+            compile(tree, SOURCE_FILENAME, "exec")
+        except (SyntaxError, ValueError):
+            pass
+
+    syntax_warnings = []
+    seen = set()
+    for w in caught:
+        if w.filename != SOURCE_FILENAME:
+            continue
+        if not issubclass(w.category, (SyntaxWarning, DeprecationWarning)):
+            continue
+        key = (w.lineno, str(w.message))
+        if key in seen:
+            continue
+        seen.add(key)
+        syntax_warnings.append({
+            "type": w.category.__name__,
+            "message": str(w.message),
+            "lineno": w.lineno,
+        })
+    return tree, syntax_warnings
+
+
 def parse_python_code(source_code: str) -> Dict[str, Any]:
     """
     Parse Python source code and return JSON representation.
@@ -161,13 +536,19 @@ def parse_python_code(source_code: str) -> Dict[str, Any]:
         Dictionary with AST information
     """
     try:
-        tree = ast.parse(source_code)
+        tree, syntax_warnings = parse_with_warnings(source_code)
         source_lines = source_code.split('\n')
+        scopes = build_scope_map(tree)
 
         result = {
             "language": "python",
             "imports": extract_imports(tree),
-            "calls": extract_calls(tree, source_lines),
+            "calls": extract_calls(tree, source_lines, scopes),
+            "assignments": extract_assignments(tree, scopes),
+            "functions": extract_functions(tree, source_lines, scopes),
+            "comments": extract_comments(source_code),
+            "docstring_end_lineno": docstring_end_lineno(tree),
+            "warnings": syntax_warnings,
             "source_code": source_code,
             "success": True,
             "error": None
@@ -196,8 +577,36 @@ def parse_python_code(source_code: str) -> Dict[str, Any]:
         }
 
 
+def parse_batch(lines: List[str]) -> List[Dict[str, Any]]:
+    """
+    Parse several snippets, one JSON request ({"source": ...}) per line.
+
+    Each snippet gets its own result, so a syntax error in one does not
+    affect the others.
+    """
+    results = []
+    for line in lines:
+        if not line.strip():
+            continue
+        try:
+            source_code = json.loads(line)["source"]
+        except (ValueError, KeyError, TypeError) as e:
+            results.append({
+                "success": False,
+                "error": {"type": type(e).__name__, "message": f"invalid batch request: {e}"}
+            })
+            continue
+        results.append(parse_python_code(source_code))
+    return results
+
+
 def main():
     """Main entry point - reads from stdin, outputs JSON to stdout."""
+    if len(sys.argv) > 1 and sys.argv[1] == "--batch":
+        # Newline-delimited requests in, a JSON array of results out
+        print(json.dumps(parse_batch(sys.stdin.read().split("\n"))))
+        return
+
     if len(sys.argv) > 1:
         # Read from file if provided
         with open(sys.argv[1], 'r') as f:
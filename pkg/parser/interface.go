@@ -1,12 +1,19 @@
 package parser
 
-import "github.com/QodeSrl/infrar-engine/pkg/types"
+import (
+	"context"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
 
 // Parser is the interface for language parsers
 type Parser interface {
 	// Parse parses source code and returns an AST
 	Parse(sourceCode string) (*types.AST, error)
 
+	// ParseContext parses source code, aborting when ctx is done
+	ParseContext(ctx context.Context, sourceCode string) (*types.AST, error)
+
 	// ParseFile parses a file and returns an AST
 	ParseFile(filepath string) (*types.AST, error)
 
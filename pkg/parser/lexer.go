@@ -0,0 +1,97 @@
+package parser
+
+import (
+	"strings"
+	"unicode"
+)
+
+// CodeSpan is a run of Python code, or of a string literal or comment when
+// Code is false. Start and End are byte offsets into the source.
+type CodeSpan struct {
+	Start, End int
+	Code       bool
+}
+
+// SplitCode splits Python code into runs of code and runs of string
+// literals and comments, without parsing it, so it also works on code that
+// does not parse as it stands, such as an indented fragment. An unterminated
+// literal runs to the end of code.
+func SplitCode(code string) []CodeSpan {
+	var spans []CodeSpan
+	last := 0
+	split := func(start, end int) {
+		if start > last {
+			spans = append(spans, CodeSpan{Start: last, End: start, Code: true})
+		}
+		spans = append(spans, CodeSpan{Start: start, End: end})
+		last = end
+	}
+
+	for i := 0; i < len(code); {
+		switch c := code[i]; {
+		case c == '#':
+			end := strings.IndexByte(code[i:], '\n')
+			if end < 0 {
+				end = len(code) - i
+			}
+			split(i, i+end)
+			i += end
+		case c == '\'' || c == '"':
+			quote := code[i : i+1]
+			if strings.HasPrefix(code[i:], strings.Repeat(quote, 3)) {
+				quote = strings.Repeat(quote, 3)
+			}
+			end := len(code)
+			for j := i + len(quote); j < len(code); j++ {
+				if code[j] == '\\' {
+					j++
+					continue
+				}
+				if strings.HasPrefix(code[j:], quote) {
+					end = j + len(quote)
+					break
+				}
+			}
+			split(i, end)
+			i = end
+		default:
+			i++
+		}
+	}
+	if last < len(code) {
+		spans = append(spans, CodeSpan{Start: last, End: len(code), Code: true})
+	}
+	return spans
+}
+
+// StringLines reports, for each line of code, whether it starts inside a
+// string literal, i.e. continues a multi-line string
+func StringLines(code string) []bool {
+	inString := make([]bool, strings.Count(code, "\n")+1)
+	spans := SplitCode(code)
+	line, offset := 0, 0
+	for _, text := range strings.SplitAfter(code, "\n") {
+		for len(spans) > 0 && spans[0].End <= offset {
+			spans = spans[1:]
+		}
+		if len(spans) > 0 && !spans[0].Code && spans[0].Start < offset {
+			inString[line] = true
+		}
+		line++
+		offset += len(text)
+	}
+	return inString
+}
+
+// Words returns the set of words in code, the maximal runs of letters, digits
+// and underscores, so a name is used in code when it is one of them. Strings
+// and comments are not skipped.
+func Words(code string) map[string]bool {
+	words := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(code, func(r rune) bool {
+		return r != '_' && !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		words[word] = true
+	}
+	return words
+}
@@ -7,9 +7,12 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/executor"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
@@ -18,16 +21,22 @@ type PythonParser struct {
 	pythonExecutable string
 	parserScriptPath string
 	timeout          time.Duration
+	executor         executor.Executor
 }
 
 // pythonParseResult represents the JSON output from the Python parser
 type pythonParseResult struct {
-	Language   string                   `json:"language"`
-	Imports    []types.Import           `json:"imports"`
-	Calls      []pythonCall             `json:"calls"`
-	SourceCode string                   `json:"source_code"`
-	Success    bool                     `json:"success"`
-	Error      *pythonError             `json:"error,omitempty"`
+	Language     string             `json:"language"`
+	Imports      []types.Import     `json:"imports"`
+	Calls        []pythonCall       `json:"calls"`
+	Assignments  []PythonAssignment `json:"assignments"`
+	Functions    []PythonFunction   `json:"functions"`
+	Comments     []PythonComment    `json:"comments"`
+	DocstringEnd int                `json:"docstring_end_lineno"`
+	Warnings     []pythonError      `json:"warnings"`
+	SourceCode   string             `json:"source_code"`
+	Success      bool               `json:"success"`
+	Error        *pythonError       `json:"error,omitempty"`
 }
 
 // pythonCall is an alias for the exported PythonCall type
@@ -44,12 +53,29 @@ type pythonError struct {
 
 // NewPythonParser creates a new Python parser
 func NewPythonParser() (*PythonParser, error) {
+	return NewPythonParserWithPython("")
+}
+
+// NewPythonParserWithPython creates a Python parser that runs the given
+// interpreter; an empty python discovers one with util.ResolvePythonExecutable
+func NewPythonParserWithPython(python string) (*PythonParser, error) {
 	// Find Python executable
-	pythonExec, err := util.FindPythonExecutable()
+	pythonExec, err := util.ResolvePythonExecutable(python)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find Python executable: %w", err)
 	}
 
+	return newPythonParser(pythonExec, executor.CommandExecutor{})
+}
+
+// NewPythonParserWithExecutor creates a Python parser that runs the parser
+// script through exec, which is asked to run executor.DefaultPython
+func NewPythonParserWithExecutor(exec executor.Executor) (*PythonParser, error) {
+	return newPythonParser(executor.DefaultPython, exec)
+}
+
+// newPythonParser creates a Python parser running pythonExec through exec
+func newPythonParser(pythonExec string, exec executor.Executor) (*PythonParser, error) {
 	// Get the parser script path
 	_, currentFile, _, ok := runtime.Caller(0)
 	if !ok {
@@ -66,27 +92,67 @@ func NewPythonParser() (*PythonParser, error) {
 		pythonExecutable: pythonExec,
 		parserScriptPath: parserScriptPath,
 		timeout:          30 * time.Second,
+		executor:         exec,
 	}, nil
 }
 
+// SetTimeout sets how long one run of the parser script may take
+func (p *PythonParser) SetTimeout(timeout time.Duration) {
+	p.timeout = timeout
+}
+
+// parseRetries is how many more times a parser run that timed out is tried.
+// The first run can be slow while Python compiles the parser script and
+// loads its modules, which one retry absorbs.
+const parseRetries = 1
+
+// run runs the parser script on stdin, each attempt bounded by the parser's
+// timeout, and returns its output. Running out of time on every attempt is
+// a parse error naming the timeout; cancellation or a deadline of ctx itself
+// returns ctx's error.
+func (p *PythonParser) run(ctx context.Context, stdin string, args ...string) (string, error) {
+	args = append([]string{p.parserScriptPath}, args...)
+	for attempt := 1; ; attempt++ {
+		runCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		stdout, stderr, err := p.executor.Run(runCtx, stdin, p.pythonExecutable, args...)
+		timedOut := runCtx.Err() == context.DeadlineExceeded
+		cancel()
+
+		switch {
+		case err == nil:
+			return stdout, nil
+		case ctx.Err() != nil:
+			return "", ctx.Err()
+		case !timedOut:
+			return "", &types.TransformationError{
+				Category: types.ErrorCategoryParse,
+				Message:  fmt.Sprintf("failed to execute Python parser: %v\nstderr: %s", err, stderr),
+				Cause:    err,
+			}
+		case attempt <= parseRetries:
+			continue
+		default:
+			return "", &types.TransformationError{
+				Category:   types.ErrorCategoryParse,
+				Message:    fmt.Sprintf("Python parser timed out after %s (%d attempts)", p.timeout, attempt),
+				Suggestion: "Raise the timeout with engine.NewWithOptions(engine.WithTimeout(...)), or split the file if it is very large",
+				Cause:      context.DeadlineExceeded,
+			}
+		}
+	}
+}
+
 // Parse implements the Parser interface
 func (p *PythonParser) Parse(sourceCode string) (*types.AST, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
-	defer cancel()
+	return p.ParseContext(context.Background(), sourceCode)
+}
 
+// ParseContext implements the Parser interface
+func (p *PythonParser) ParseContext(ctx context.Context, sourceCode string) (*types.AST, error) {
 	// Execute Python parser script
-	stdout, stderr, err := util.ExecuteCommandWithStdin(
-		ctx,
-		sourceCode,
-		p.pythonExecutable,
-		p.parserScriptPath,
-	)
-
+	stdout, err := p.run(ctx, sourceCode)
 	if err != nil {
-		return nil, &types.TransformationError{
-			Category: types.ErrorCategoryParse,
-			Message:  fmt.Sprintf("failed to execute Python parser: %v\nstderr: %s", err, stderr),
-		}
+		return nil, err
 	}
 
 	// Parse JSON output
@@ -95,9 +161,113 @@ func (p *PythonParser) Parse(sourceCode string) (*types.AST, error) {
 		return nil, &types.TransformationError{
 			Category: types.ErrorCategoryParse,
 			Message:  fmt.Sprintf("failed to parse JSON output: %v\noutput: %s", err, stdout),
+			Cause:    err,
 		}
 	}
 
+	return result.toAST(sourceCode)
+}
+
+// ParseBatch parses several snippets in a single Python invocation, which
+// amortizes interpreter startup across many small files. The returned ASTs
+// line up with sources; a snippet that fails to parse leaves a nil entry and
+// is reported in the returned *BatchError, without affecting the others.
+func (p *PythonParser) ParseBatch(sources []string) ([]*types.AST, error) {
+	return p.ParseBatchContext(context.Background(), sources)
+}
+
+// ParseBatchContext is ParseBatch, aborting when ctx is done
+func (p *PythonParser) ParseBatchContext(ctx context.Context, sources []string) ([]*types.AST, error) {
+	if len(sources) == 0 {
+		return nil, nil
+	}
+
+	// One JSON request per line; encoding escapes any newlines in the source
+	var input strings.Builder
+	for _, source := range sources {
+		line, err := json.Marshal(map[string]string{"source": source})
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode batch request: %w", err)
+		}
+		input.Write(line)
+		input.WriteString("\n")
+	}
+
+	stdout, err := p.run(ctx, input.String(), "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	var results []pythonParseResult
+	if err := json.Unmarshal([]byte(stdout), &results); err != nil {
+		return nil, &types.TransformationError{
+			Category: types.ErrorCategoryParse,
+			Message:  fmt.Sprintf("failed to parse JSON output: %v\noutput: %s", err, stdout),
+			Cause:    err,
+		}
+	}
+	if len(results) != len(sources) {
+		return nil, &types.TransformationError{
+			Category: types.ErrorCategoryParse,
+			Message:  fmt.Sprintf("parser returned %d results for %d snippets", len(results), len(sources)),
+		}
+	}
+
+	asts := make([]*types.AST, len(sources))
+	var batchErr *BatchError
+	for i, result := range results {
+		ast, err := result.toAST(sources[i])
+		if err != nil {
+			if batchErr == nil {
+				batchErr = &BatchError{Errors: make(map[int]error)}
+			}
+			batchErr.Errors[i] = err
+			continue
+		}
+		asts[i] = ast
+	}
+
+	if batchErr != nil {
+		return asts, batchErr
+	}
+	return asts, nil
+}
+
+// BatchError reports the snippets of a batch that failed to parse, keyed by
+// their index in the batch
+type BatchError struct {
+	Errors map[int]error
+}
+
+// Error implements the error interface
+func (e *BatchError) Error() string {
+	indices := e.indices()
+	return fmt.Sprintf("%d snippet(s) failed to parse; snippet %d: %v", len(indices), indices[0], e.Errors[indices[0]])
+}
+
+// Unwrap returns the per-snippet errors in batch order, so errors.Is and
+// errors.As see through the batch
+func (e *BatchError) Unwrap() []error {
+	var errs []error
+	for _, i := range e.indices() {
+		errs = append(errs, e.Errors[i])
+	}
+	return errs
+}
+
+// indices returns the failed snippet indices in ascending order
+func (e *BatchError) indices() []int {
+	indices := make([]int, 0, len(e.Errors))
+	for i := range e.Errors {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices
+}
+
+// toAST converts a parser result for sourceCode into an AST, or the syntax
+// error it reports
+func (result pythonParseResult) toAST(sourceCode string) (*types.AST, error) {
 	// Check for parsing errors
 	if !result.Success {
 		if result.Error != nil {
@@ -122,13 +292,39 @@ func (p *PythonParser) Parse(sourceCode string) (*types.AST, error) {
 		Imports:    result.Imports,
 		SourceCode: sourceCode,
 		Metadata: map[string]any{
-			"calls": result.Calls,
+			"calls":                result.Calls,
+			"assignments":          result.Assignments,
+			"functions":            result.Functions,
+			"comments":             result.Comments,
+			"docstring_end_lineno": result.DocstringEnd,
 		},
 	}
+	if len(result.Warnings) > 0 {
+		ast.Metadata[SyntaxWarningsKey] = result.syntaxWarnings()
+	}
 
 	return ast, nil
 }
 
+// SyntaxWarningsKey is the AST metadata key holding the []types.Warning
+// Python emitted for the source, such as invalid escape sequences
+const SyntaxWarningsKey = "syntax_warnings"
+
+// syntaxWarnings converts the warnings Python emitted into warnings of
+// category "syntax". Which warning class Python uses varies by version, so
+// only the message is kept.
+func (result pythonParseResult) syntaxWarnings() []types.Warning {
+	warnings := make([]types.Warning, len(result.Warnings))
+	for i, w := range result.Warnings {
+		warnings[i] = types.Warning{
+			Message:    w.Message,
+			LineNumber: w.LineNumber,
+			Category:   "syntax",
+		}
+	}
+	return warnings
+}
+
 // ParseFile implements the Parser interface
 func (p *PythonParser) ParseFile(filepath string) (*types.AST, error) {
 	content, err := os.ReadFile(filepath)
@@ -136,6 +332,7 @@ func (p *PythonParser) ParseFile(filepath string) (*types.AST, error) {
 		return nil, &types.TransformationError{
 			Category: types.ErrorCategoryParse,
 			Message:  fmt.Sprintf("failed to read file %s: %v", filepath, err),
+			Cause:    err,
 		}
 	}
 
@@ -1,8 +1,12 @@
 package parser
 
 import (
+	"context"
+	"errors"
+	"os"
 	"testing"
 
+	"github.com/QodeSrl/infrar-engine/pkg/executor"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
@@ -133,3 +137,210 @@ upload(bucket='my-bucket', source='file.txt', destination='remote.txt')
 		t.Error("Did not find upload() call")
 	}
 }
+
+func TestPythonParser_ExtractFunctions(t *testing.T) {
+	parser, err := NewPythonParser()
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	code := `class Uploader:
+    async def run(self):
+        """Upload."""
+        pass
+
+def inline(): pass
+`
+
+	ast, err := parser.Parse(code)
+	if err != nil {
+		t.Fatalf("Failed to parse code: %v", err)
+	}
+
+	functions, ok := ast.Metadata["functions"].([]PythonFunction)
+	if !ok || len(functions) != 2 {
+		t.Fatalf("Expected 2 functions in metadata, got %v", ast.Metadata["functions"])
+	}
+
+	byName := make(map[string]PythonFunction)
+	for _, fn := range functions {
+		byName[fn.Name] = fn
+	}
+
+	run := byName["Uploader.run"]
+	if !run.Async || run.BodyLineNumber != 4 || run.BodyIndent != "        " || run.Inline {
+		t.Errorf("Unexpected span for Uploader.run: %+v", run)
+	}
+	if !byName["inline"].Inline {
+		t.Errorf("Expected inline body for inline(): %+v", byName["inline"])
+	}
+}
+
+func TestPythonParser_ExtractComments(t *testing.T) {
+	parser, err := NewPythonParser()
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	code := `# header
+x = """
+# not a comment
+"""  # trailing
+`
+
+	ast, err := parser.Parse(code)
+	if err != nil {
+		t.Fatalf("Failed to parse code: %v", err)
+	}
+
+	comments, ok := ast.Metadata["comments"].([]PythonComment)
+	if !ok || len(comments) != 2 {
+		t.Fatalf("Expected 2 comments in metadata, got %v", ast.Metadata["comments"])
+	}
+	if c := comments[0]; c.LineNumber != 1 || c.Text != "# header" || !c.OwnLine {
+		t.Errorf("Unexpected header comment: %+v", c)
+	}
+	if c := comments[1]; c.LineNumber != 4 || c.Text != "# trailing" || c.OwnLine {
+		t.Errorf("Unexpected trailing comment: %+v", c)
+	}
+}
+
+func TestPythonParser_ParseBatch(t *testing.T) {
+	parser, err := NewPythonParser()
+	if err != nil {
+		t.Fatalf("Failed to create parser: %v", err)
+	}
+
+	sources := []string{
+		"from infrar.storage import upload\n\nupload(bucket='a', source='x', destination='y')\n",
+		"def broken(:\n",
+		"import os\nprint(os.getcwd())\n",
+	}
+
+	asts, err := parser.ParseBatch(sources)
+
+	var batchErr *BatchError
+	if !errors.As(err, &batchErr) {
+		t.Fatalf("ParseBatch() error = %v, want *BatchError", err)
+	}
+	if len(batchErr.Errors) != 1 || batchErr.Errors[1] == nil {
+		t.Fatalf("Errors = %v, want only snippet 1 to fail", batchErr.Errors)
+	}
+	if !errors.Is(err, types.ErrParse) {
+		t.Errorf("errors.Is(err, ErrParse) = false, want true")
+	}
+
+	if len(asts) != 3 || asts[1] != nil {
+		t.Fatalf("ParseBatch() = %v, want 3 entries with a nil for the failed snippet", asts)
+	}
+	if calls, _ := asts[0].Metadata["calls"].([]pythonCall); len(calls) != 1 || calls[0].Function != "upload" {
+		t.Errorf("Snippet 0 calls = %v, want one upload call", calls)
+	}
+	if len(asts[2].Imports) != 1 || asts[2].Imports[0].Module != "os" {
+		t.Errorf("Snippet 2 imports = %v, want os", asts[2].Imports)
+	}
+	if asts[2].SourceCode != sources[2] {
+		t.Errorf("Snippet 2 SourceCode = %q, want %q", asts[2].SourceCode, sources[2])
+	}
+}
+
+func TestPythonParser_WithExecutor(t *testing.T) {
+	canned := `{
+  "language": "python",
+  "imports": [{"module": "infrar.storage", "names": ["upload"], "lineno": 1}],
+  "calls": [{"function": "upload", "lineno": 3, "scope": ""}],
+  "assignments": [],
+  "docstring_end_lineno": 0,
+  "success": true
+}`
+
+	var gotStdin string
+	fake := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+		gotStdin = stdin
+		return canned, "", nil
+	})
+
+	parser, err := NewPythonParserWithExecutor(fake)
+	if err != nil {
+		t.Fatalf("NewPythonParserWithExecutor() error = %v", err)
+	}
+
+	code := "from infrar.storage import upload\n"
+	ast, err := parser.Parse(code)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	if gotStdin != code {
+		t.Errorf("Executor stdin = %q, want %q", gotStdin, code)
+	}
+
+	if len(ast.Imports) != 1 || ast.Imports[0].Module != "infrar.storage" {
+		t.Errorf("Unexpected imports %v", ast.Imports)
+	}
+
+	calls, ok := ast.Metadata["calls"].([]PythonCall)
+	if !ok || len(calls) != 1 || calls[0].Function != "upload" || calls[0].LineNumber != 3 {
+		t.Errorf("Unexpected calls %v", ast.Metadata["calls"])
+	}
+}
+
+// benchmarkFixture is a module with many Infrar calls shared by the
+// benchmarks across packages
+const benchmarkFixture = "../../testdata/benchmark.py"
+
+func BenchmarkPythonParser_Parse(b *testing.B) {
+	parser, err := NewPythonParser()
+	if err != nil {
+		b.Fatalf("Failed to create parser: %v", err)
+	}
+	source, err := os.ReadFile(benchmarkFixture)
+	if err != nil {
+		b.Fatalf("Failed to read fixture: %v", err)
+	}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := parser.Parse(string(source)); err != nil {
+			b.Fatalf("Parse() error = %v", err)
+		}
+	}
+}
+
+func TestPythonParser_WithExecutorIgnoresPythonEnv(t *testing.T) {
+	// $INFRAR_PYTHON names a host interpreter, which the executor cannot run
+	t.Setenv("INFRAR_PYTHON", "/host/only/python")
+	t.Setenv("VIRTUAL_ENV", "/host/only/venv")
+
+	var gotName string
+	fake := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+		gotName = name
+		return `{"language": "python", "success": true}`, "", nil
+	})
+
+	parser, err := NewPythonParserWithExecutor(fake)
+	if err != nil {
+		t.Fatalf("NewPythonParserWithExecutor() error = %v", err)
+	}
+	if _, err := parser.Parse("x = 1\n"); err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if gotName != executor.DefaultPython {
+		t.Errorf("executor ran %q, want %s", gotName, executor.DefaultPython)
+	}
+}
+
+func TestWords(t *testing.T) {
+	words := Words("st.upload(bucket='b')  # see upload_file\nx2 = 1\n")
+	for _, want := range []string{"st", "upload", "bucket", "b", "see", "upload_file", "x2", "1"} {
+		if !words[want] {
+			t.Errorf("Words() is missing %q", want)
+		}
+	}
+	for _, notWant := range []string{"upload_", "file", "x", "st.upload"} {
+		if words[notWant] {
+			t.Errorf("Words() contains %q", notWant)
+		}
+	}
+}
@@ -5,10 +5,62 @@ import "github.com/QodeSrl/infrar-engine/pkg/types"
 // PythonCall represents a function call from Python parser
 // This is exported so detector can access it
 type PythonCall struct {
-	LineNumber   int                    `json:"lineno"`
-	ColumnOffset int                    `json:"col_offset"`
-	Function     string                 `json:"function"`
-	Module       string                 `json:"module"`
-	Arguments    map[string]types.Value `json:"arguments"`
-	SourceCode   string                 `json:"source_code"`
+	LineNumber      int                    `json:"lineno"`
+	ColumnOffset    int                    `json:"col_offset"`
+	EndLineNumber   int                    `json:"end_lineno"`
+	EndColumnOffset int                    `json:"end_col_offset"`
+	Function        string                 `json:"function"`
+	Module          string                 `json:"module"`
+	Arguments       map[string]types.Value `json:"arguments"`
+	ArgumentOrder   []string               `json:"argument_order,omitempty"` // Argument names in source order
+	SourceCode      string                 `json:"source_code"`
+	Scope           string                 `json:"scope"`                // Enclosing function, "" at module level
+	Decorator       bool                   `json:"decorator,omitempty"`  // Call is a decorator, e.g. @infrar.events.on('upload')
+	Expression      bool                   `json:"expression,omitempty"` // Call is in a lambda or comprehension
+	WithItem        bool                   `json:"with_item,omitempty"`  // Call is the context expression of a with statement
+	Spread          []string               `json:"spread,omitempty"`     // Unpacked arguments, e.g. "**opts"
+	Chain           []PythonChainSegment   `json:"chain,omitempty"`      // Earlier calls of a method chain, innermost first
+}
+
+// PythonChainSegment is an earlier call of a method chain, such as
+// bucket('data') in storage.bucket('data').upload(...). Its arguments are
+// merged into the chained call's, prefixed with Function ("bucket.arg_0").
+type PythonChainSegment struct {
+	Function     string `json:"function"`
+	LineNumber   int    `json:"lineno"`
+	ColumnOffset int    `json:"col_offset"`
+}
+
+// PythonAssignment represents a simple `target = value` binding from Python parser.
+// Value is the bound name when the right-hand side is a bare name, otherwise empty.
+// Literal is set when the right-hand side is a string or number literal.
+type PythonAssignment struct {
+	Target     string       `json:"target"`
+	Value      string       `json:"value"`
+	Literal    *types.Value `json:"literal,omitempty"`
+	LineNumber int          `json:"lineno"`
+	Scope      string       `json:"scope"`
+}
+
+// PythonFunction represents a function definition from Python parser. Name is
+// the dotted scope calls inside the function report. BodyLineNumber is the
+// line new statements go before, past any docstring, and BodyIndent the
+// body's indentation. Inline bodies start on the def line.
+type PythonFunction struct {
+	Name           string `json:"name"`
+	LineNumber     int    `json:"lineno"`
+	EndLineNumber  int    `json:"end_lineno"`
+	BodyLineNumber int    `json:"body_lineno"`
+	BodyIndent     string `json:"body_indent"`
+	Inline         bool   `json:"inline"`
+	Async          bool   `json:"async"`
+}
+
+// PythonComment represents a comment from Python parser. OwnLine is set when
+// nothing but whitespace precedes the comment on its line.
+type PythonComment struct {
+	LineNumber   int    `json:"lineno"`
+	ColumnOffset int    `json:"col_offset"`
+	Text         string `json:"text"`
+	OwnLine      bool   `json:"own_line"`
 }
@@ -0,0 +1,186 @@
+package plugin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// bundleVersion is bumped whenever the bundle layout changes, so bundles
+// written by another version are rebuilt instead of misread
+const bundleVersion = 1
+
+// ErrStaleBundle is returned by LoadBundle when the rules files a bundle was
+// built from have changed since, so the caller can load the YAML instead
+var ErrStaleBundle = errors.New("rule bundle is out of date")
+
+// ruleBundle is the JSON file CompileBundle writes: every rule of a plugin
+// directory, as loaded from YAML, with the modification time of each rules
+// file it came from
+type ruleBundle struct {
+	Version   int                        `json:"version"`
+	PluginDir string                     `json:"plugin_dir"`
+	Sources   map[string]time.Time       `json:"sources"`
+	Rules     []types.TransformationRule `json:"rules"`
+}
+
+// CompileBundle loads the rules of every provider and capability in the
+// plugin directory, checks that their templates parse, and writes the rules
+// to a single JSON bundle file that LoadBundle restores without reading any
+// YAML. The bundle is a snapshot of the validated rules, not compiled code:
+// templates are still parsed when a rule is applied. Any rules file that
+// fails to load fails the whole bundle.
+func (l *Loader) CompileBundle(outPath string) error {
+	sources, err := l.ruleSources()
+	if err != nil {
+		return err
+	}
+
+	pluginDir, err := filepath.Abs(l.pluginDir)
+	if err != nil {
+		return err
+	}
+	b := ruleBundle{
+		Version:   bundleVersion,
+		PluginDir: pluginDir,
+		Sources:   sources,
+	}
+
+	// Load each capability directory holding rules files with the erroring
+	// loader: skipping a broken file would write a bundle that lacks its
+	// rules yet is never seen as stale
+	capabilities := make(map[string]map[string]bool) // Capabilities by provider directory
+	for source := range sources {
+		providerDir := filepath.Dir(source)
+		provider := filepath.Base(providerDir)
+		if capabilities[provider] == nil {
+			capabilities[provider] = make(map[string]bool)
+		}
+		capabilities[provider][filepath.Base(filepath.Dir(providerDir))] = true
+	}
+
+	for _, provider := range types.Providers() {
+		names := make([]string, 0, len(capabilities[provider.String()]))
+		for capability := range capabilities[provider.String()] {
+			names = append(names, capability)
+		}
+		sort.Strings(names)
+
+		for _, capability := range names {
+			rules, err := l.LoadRules(provider, capability)
+			if err != nil {
+				return err
+			}
+			for _, rule := range rules {
+				if err := checkTemplates(rule); err != nil {
+					return err
+				}
+				b.Rules = append(b.Rules, rule)
+			}
+		}
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return fmt.Errorf("failed to encode rule bundle: %w", err)
+	}
+	if err := os.WriteFile(outPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rule bundle: %w", err)
+	}
+	return nil
+}
+
+// LoadBundle reads the rules of a bundle written by CompileBundle. It
+// returns ErrStaleBundle when a rules file the bundle was built from has
+// been modified, added or removed since.
+func LoadBundle(path string) ([]types.TransformationRule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rule bundle: %w", err)
+	}
+
+	var b ruleBundle
+	if err := json.Unmarshal(data, &b); err != nil {
+		return nil, fmt.Errorf("failed to parse rule bundle %s: %w", path, err)
+	}
+	if b.Version != bundleVersion {
+		return nil, fmt.Errorf("%w: %s has version %d, want %d", ErrStaleBundle, path, b.Version, bundleVersion)
+	}
+
+	sources, err := NewLoader(b.PluginDir).ruleSources()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStaleBundle, err)
+	}
+	for source, modTime := range sources {
+		built, ok := b.Sources[source]
+		if !ok || modTime.After(built) {
+			return nil, fmt.Errorf("%w: %s changed", ErrStaleBundle, source)
+		}
+	}
+	for source := range b.Sources {
+		if _, ok := sources[source]; !ok {
+			return nil, fmt.Errorf("%w: %s was removed", ErrStaleBundle, source)
+		}
+	}
+
+	return b.Rules, nil
+}
+
+// ruleSources returns the modification time of every rules file in the
+// plugin directory, keyed by path
+func (l *Loader) ruleSources() (map[string]time.Time, error) {
+	if err := l.checkPluginDir(); err != nil {
+		return nil, err
+	}
+
+	paths, err := filepath.Glob(filepath.Join(l.pluginDir, "*", "*", "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules files: %w", err)
+	}
+
+	sources := make(map[string]time.Time, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		sources[path] = info.ModTime()
+	}
+	return sources, nil
+}
+
+// checkTemplates reports a rule whose templates do not parse
+func checkTemplates(rule types.TransformationRule) error {
+	templates := map[string]string{
+		"code":    rule.CodeTemplate,
+		"wrapper": rule.WrapperTemplate,
+		"context": rule.ContextTemplate,
+		"setup":   rule.SetupCode,
+	}
+	for name, text := range templates {
+		if _, err := template.New(name).Funcs(TemplateFuncs(types.StyleOptions{})).Parse(text); err != nil {
+			return &types.TransformationError{
+				Category: types.ErrorCategoryValidation,
+				Message:  fmt.Sprintf("rule %s (%s, %s): invalid %s template: %v", rule.Pattern, rule.Provider, rule.Capability, name, err),
+				Cause:    err,
+			}
+		}
+	}
+	for param, text := range rule.Defaults {
+		if _, err := template.New(param).Funcs(DefaultFuncs()).Parse(text); err != nil {
+			return &types.TransformationError{
+				Category: types.ErrorCategoryValidation,
+				Message:  fmt.Sprintf("rule %s (%s, %s): invalid default for %s: %v", rule.Pattern, rule.Provider, rule.Capability, param, err),
+				Cause:    err,
+			}
+		}
+	}
+	return nil
+}
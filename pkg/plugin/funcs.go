@@ -0,0 +1,95 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// runtimeEnvImport is the import code using runtimeEnv needs, added to a
+// rule's imports by the loader
+const runtimeEnvImport = "import os"
+
+// TemplateFuncs returns the functions available to rule templates, both
+// code_template and setup_code. String literals they emit are quoted in the
+// given style.
+//
+//   - env "NAME" ["default"] resolves an environment variable when the code
+//     is generated, as a Python string literal. An unset variable without a
+//     default is an error.
+//   - runtimeEnv "NAME" ["default"] emits os.environ.get('NAME'), read when
+//     the generated code runs.
+func TemplateFuncs(style types.StyleOptions) template.FuncMap {
+	return template.FuncMap{
+		// env returns the value of an environment variable as a string literal
+		"env": func(name string, fallback ...string) (string, error) {
+			if len(fallback) > 1 {
+				return "", fmt.Errorf("env %s: at most one default, got %d", name, len(fallback))
+			}
+			if value, ok := os.LookupEnv(name); ok {
+				return style.QuoteString(value), nil
+			}
+			if len(fallback) == 1 {
+				return style.QuoteString(fallback[0]), nil
+			}
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		},
+
+		// runtimeEnv returns the Python expression reading an environment variable
+		"runtimeEnv": func(name string, fallback ...string) (string, error) {
+			switch len(fallback) {
+			case 0:
+				return fmt.Sprintf("os.environ.get(%s)", style.QuoteString(name)), nil
+			case 1:
+				return fmt.Sprintf("os.environ.get(%s, %s)", style.QuoteString(name), style.QuoteString(fallback[0])), nil
+			default:
+				return "", fmt.Errorf("runtimeEnv %s: at most one default, got %d", name, len(fallback))
+			}
+		},
+	}
+}
+
+// DefaultFuncs returns the functions available to a rule's defaults, which
+// work on the call's literal argument values rather than on code.
+//
+//   - base "dir/file.txt" returns the last element of a slash-separated
+//     path, "file.txt".
+func DefaultFuncs() template.FuncMap {
+	return template.FuncMap{
+		"base": path.Base,
+	}
+}
+
+// usesRuntimeEnv reports whether any of a rule's templates calls runtimeEnv
+func usesRuntimeEnv(templates ...string) bool {
+	for _, text := range templates {
+		if strings.Contains(text, "runtimeEnv") {
+			return true
+		}
+	}
+	return false
+}
+
+// RenderSetupCode renders a rule's setup code, which is a template like
+// code_template but without call data: only the template functions are
+// available. Setup code without actions is returned unchanged.
+func RenderSetupCode(setupCode string, style types.StyleOptions) (string, error) {
+	if !strings.Contains(setupCode, "{{") {
+		return setupCode, nil
+	}
+
+	tmpl, err := template.New("setup").Funcs(TemplateFuncs(style)).Parse(setupCode)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse setup template: %w", err)
+	}
+
+	var buf strings.Builder
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return "", fmt.Errorf("failed to execute setup template: %w", err)
+	}
+	return buf.String(), nil
+}
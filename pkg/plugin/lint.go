@@ -0,0 +1,275 @@
+package plugin
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// Lint checks every rules file in the plugin directory and reports all the
+// problems it finds instead of stopping at the first: provider directories
+// and targets that are not supported providers, target services the
+// provider is not known to have, rules files that do not load, duplicate
+// patterns, templates that do not parse, and templates reading a name the
+// rule does not declare, such as {{ .desination }}. The error is for a
+// plugin directory that cannot be read.
+func (l *Loader) Lint() ([]types.RuleLint, error) {
+	if err := l.checkPluginDir(); err != nil {
+		return nil, err
+	}
+
+	dirs, err := filepath.Glob(filepath.Join(l.pluginDir, "*", "*"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list plugin directories: %w", err)
+	}
+
+	var lints []types.RuleLint
+	for _, dir := range dirs {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		capability := filepath.Base(filepath.Dir(dir))
+		provider, err := types.ParseProvider(filepath.Base(dir))
+		if err != nil {
+			lints = append(lints, types.RuleLint{
+				File:       dir,
+				Capability: capability,
+				Provider:   types.Provider(filepath.Base(dir)),
+				Message:    err.Error(),
+			})
+			continue
+		}
+
+		providerLints, err := lintRulesDir(dir, provider, capability)
+		if err != nil {
+			return nil, err
+		}
+		lints = append(lints, providerLints...)
+	}
+
+	return lints, nil
+}
+
+// lintRulesDir checks the rules files of one capability and provider
+func lintRulesDir(dir string, provider types.Provider, capability string) ([]types.RuleLint, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules files: %w", err)
+	}
+
+	var lints []types.RuleLint
+	definedIn := make(map[string]string) // File defining each pattern and language
+	for _, path := range paths {
+		lint := func(rule, message string) {
+			lints = append(lints, types.RuleLint{
+				File:       path,
+				Capability: capability,
+				Provider:   provider,
+				Rule:       rule,
+				Message:    message,
+			})
+		}
+
+		pluginRules, err := readRulesFile(path)
+		if err != nil {
+			lint("", err.Error())
+			continue
+		}
+		for _, op := range pluginRules.Operations {
+			if op.Target.Provider != "" && op.Target.Provider != provider.String() {
+				lint(op.Name, fmt.Sprintf("target provider %q does not match the %s directory it is in", op.Target.Provider, provider))
+			}
+			switch {
+			case op.Target.Service == "":
+				lint(op.Name, "target service is empty")
+			case !provider.HasService(op.Target.Service):
+				lint(op.Name, fmt.Sprintf("target service %q is not a known %s service (known: %s)", op.Target.Service, provider, strings.Join(provider.Services(), ", ")))
+			}
+		}
+
+		rules, err := buildRules(pluginRules, path, provider, capability)
+		if err != nil {
+			lint("", err.Error())
+			continue
+		}
+
+		seen := make(map[string]bool)
+		for _, rule := range rules {
+			key := rule.Pattern + " (" + rule.Language.String() + ")"
+			if other, ok := definedIn[key]; ok && !seen[key] {
+				lint(rule.Name, fmt.Sprintf("duplicate rule for %s, also defined in %s", key, other))
+			}
+			definedIn[key] = path
+			seen[key] = true
+
+			for _, message := range lintRule(rule) {
+				lint(rule.Name, message)
+			}
+		}
+	}
+
+	return lints, nil
+}
+
+// lintRule checks that a rule's templates parse and only read names the
+// rule declares. Setup code and the client code have no call data at all.
+func lintRule(rule types.TransformationRule) []string {
+	var messages []string
+	if strings.TrimSpace(rule.CodeTemplate) == "" {
+		messages = append(messages, "code_template is empty")
+	}
+
+	declared := declaredNames(rule)
+	withCall := map[string]bool{"call": true}
+	for name := range declared {
+		withCall[name] = true
+	}
+
+	templates := []struct {
+		field string
+		text  string
+		names map[string]bool
+	}{
+		{"code_template", rule.CodeTemplate, declared},
+		{"wrapper_template", rule.WrapperTemplate, withCall},
+		{"context_template", rule.ContextTemplate, declared},
+		{"setup_code", rule.SetupCode, nil},
+		{"client.code", rule.Client.Code, nil},
+	}
+	for _, tmpl := range templates {
+		if tmpl.text != "" {
+			messages = append(messages, lintTemplate(tmpl.field, tmpl.text, TemplateFuncs(types.StyleOptions{}), tmpl.names)...)
+		}
+	}
+
+	// Defaults read the call's arguments rather than the template data
+	params := make(map[string]bool)
+	for param := range rule.ParameterMapping {
+		params[param] = true
+	}
+	for _, param := range rule.OptionalParams {
+		params[param] = true
+	}
+	defaults := make(map[string]bool, len(rule.Defaults))
+	for param := range rule.Defaults {
+		defaults[param] = true
+	}
+	for _, param := range sortedKeys(defaults) {
+		messages = append(messages, lintTemplate("defaults."+param, rule.Defaults[param], DefaultFuncs(), params)...)
+	}
+
+	return messages
+}
+
+// lintTemplate checks that a template parses and only reads the given
+// names; nil names means it has no data at all
+func lintTemplate(field, text string, funcs template.FuncMap, names map[string]bool) []string {
+	parsed, err := template.New(field).Funcs(funcs).Parse(text)
+	if err != nil {
+		return []string{fmt.Sprintf("invalid %s: %v", field, err)}
+	}
+
+	var messages []string
+	fields := make(map[string]bool)
+	templateFields(parsed.Root, fields)
+	for _, name := range sortedKeys(fields) {
+		switch {
+		case names == nil:
+			messages = append(messages, fmt.Sprintf("%s reads .%s, but it is rendered without call data", field, name))
+		case !names[name]:
+			messages = append(messages, fmt.Sprintf("%s reads .%s, which the rule does not declare (declared: %s)", field, name, strings.Join(sortedKeys(names), ", ")))
+		}
+	}
+	return messages
+}
+
+// declaredNames returns the names a rule's code template can read: its
+// parameters and their mapping targets, static parameters, defaults, the
+// client and the call metadata
+func declaredNames(rule types.TransformationRule) map[string]bool {
+	names := map[string]bool{"_meta": true}
+	for param, targets := range rule.ParameterMapping {
+		names[param] = true
+		for _, target := range targets {
+			names[target] = true
+		}
+	}
+	for _, param := range rule.OptionalParams {
+		names[param] = true
+	}
+	for param := range rule.StaticParams {
+		names[param] = true
+	}
+	for param := range rule.Defaults {
+		names[param] = true
+	}
+	if rule.Client.Code != "" {
+		names["client"] = true
+	}
+	return names
+}
+
+// templateFields adds the names a template node reads from the template
+// data to fields: the first identifier of each .name and $.name. Inside
+// range and with blocks the dot is something else, so those are skipped.
+func templateFields(node parse.Node, fields map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			templateFields(child, fields)
+		}
+	case *parse.ActionNode:
+		templateFields(n.Pipe, fields)
+	case *parse.IfNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.List, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.RangeNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.WithNode:
+		templateFields(n.Pipe, fields)
+		templateFields(n.ElseList, fields)
+	case *parse.TemplateNode:
+		templateFields(n.Pipe, fields)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			templateFields(cmd, fields)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			templateFields(arg, fields)
+		}
+	case *parse.ChainNode:
+		templateFields(n.Node, fields)
+	case *parse.FieldNode:
+		fields[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if len(n.Ident) > 1 && n.Ident[0] == "$" {
+			fields[n.Ident[1]] = true
+		}
+	}
+}
+
+// sortedKeys returns the keys of a set in order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
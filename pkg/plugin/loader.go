@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 	"gopkg.in/yaml.v3"
@@ -11,7 +12,8 @@ import (
 
 // Loader loads transformation rules from plugin YAML files
 type Loader struct {
-	pluginDir string
+	pluginDir    string
+	allowMissing bool
 }
 
 // NewLoader creates a new plugin loader
@@ -21,43 +23,159 @@ func NewLoader(pluginDir string) *Loader {
 	}
 }
 
-// LoadRules loads transformation rules for a specific provider
+// SetAllowMissing makes LoadRules return no rules, rather than an error, when
+// a capability has no rules file for the provider, so callers can probe
+// capabilities. A missing plugin directory is still an error.
+func (l *Loader) SetAllowMissing(allow bool) {
+	l.allowMissing = allow
+}
+
+// LoadRules loads transformation rules for a specific provider. Every
+// *.yaml file in the capability's provider directory is loaded, in name
+// order, and their operations merged, so a large capability can be split
+// into files such as upload.yaml and download.yaml. A pattern defined in
+// more than one file is an error.
 func (l *Loader) LoadRules(provider types.Provider, capability string) ([]types.TransformationRule, error) {
-	// Construct path to rules file
-	// Expected structure: pluginDir/capability/provider/rules.yaml
+	// Expected structure: pluginDir/capability/provider/*.yaml
 	// Example: ../infrar-plugins/packages/storage/aws/rules.yaml
-	rulesPath := filepath.Join(l.pluginDir, capability, provider.String(), "rules.yaml")
+	rulesDir := filepath.Join(l.pluginDir, capability, provider.String())
+	rulesPath := filepath.Join(rulesDir, "rules.yaml")
+
+	paths, err := filepath.Glob(filepath.Join(rulesDir, "*.yaml"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules files: %w", err)
+	}
+
+	if len(paths) == 0 {
+		if err := l.checkPluginDir(); err != nil {
+			return nil, err
+		}
+		if l.allowMissing {
+			return nil, nil
+		}
+		_, statErr := os.Stat(rulesPath)
+		return nil, &types.TransformationError{
+			Category:   types.ErrorCategoryValidation,
+			Message:    fmt.Sprintf("no %s rules for %s: %s not found", capability, provider, rulesPath),
+			Suggestion: fmt.Sprintf("Check that the %s plugin package has a %s directory, or run with -plugins pointing at your plugin packages dir", capability, provider),
+			Cause:      statErr,
+		}
+	}
+
+	var rules []types.TransformationRule
+	definedIn := make(map[string]string) // File defining each pattern and language
+	for _, path := range paths {
+		fileRules, err := l.loadRulesFile(path, provider, capability)
+		if err != nil {
+			return nil, err
+		}
 
-	if _, err := os.Stat(rulesPath); os.IsNotExist(err) {
-		return nil, fmt.Errorf("rules file not found: %s", rulesPath)
+		seen := make(map[string]bool)
+		for _, rule := range fileRules {
+			key := rule.Pattern + " (" + rule.Language.String() + ")"
+			if other, ok := definedIn[key]; ok && !seen[key] {
+				return nil, &types.TransformationError{
+					Category:   types.ErrorCategoryValidation,
+					Message:    fmt.Sprintf("duplicate %s rule for %s: defined in %s and %s", capability, key, other, path),
+					Suggestion: "Keep each operation in a single rules file",
+				}
+			}
+			definedIn[key] = path
+			seen[key] = true
+		}
+		rules = append(rules, fileRules...)
 	}
 
+	return rules, nil
+}
+
+// loadRulesFile loads the rules of one YAML file
+func (l *Loader) loadRulesFile(rulesPath string, provider types.Provider, capability string) ([]types.TransformationRule, error) {
+	pluginRules, err := readRulesFile(rulesPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range pluginRules.Operations {
+		if op.Target.Provider != "" && op.Target.Provider != provider.String() {
+			return nil, &types.TransformationError{
+				Category:   types.ErrorCategoryValidation,
+				Message:    fmt.Sprintf("rule %s in %s: target provider %q does not match the %s directory it is in", op.Name, rulesPath, op.Target.Provider, provider),
+				Suggestion: fmt.Sprintf("Set target.provider to %s, or move the rule to the %s directory", provider, op.Target.Provider),
+			}
+		}
+	}
+	return buildRules(pluginRules, rulesPath, provider, capability)
+}
+
+// readRulesFile reads and parses one YAML rules file
+func readRulesFile(rulesPath string) (types.PluginRules, error) {
+	var pluginRules types.PluginRules
+
 	// Read YAML file
 	data, err := os.ReadFile(rulesPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read rules file: %w", err)
+		return pluginRules, fmt.Errorf("failed to read rules file: %w", err)
 	}
 
 	// Parse YAML
-	var pluginRules types.PluginRules
 	if err := yaml.Unmarshal(data, &pluginRules); err != nil {
-		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+		return pluginRules, fmt.Errorf("failed to parse YAML in %s: %w", rulesPath, err)
 	}
+	return pluginRules, nil
+}
 
-	// Convert to TransformationRule
+// buildRules converts the operations of the rules file at rulesPath to
+// transformation rules
+func buildRules(pluginRules types.PluginRules, rulesPath string, provider types.Provider, capability string) ([]types.TransformationRule, error) {
 	var rules []types.TransformationRule
 	for _, op := range pluginRules.Operations {
+		for param, valueType := range op.Transformation.ParamTypes {
+			if !valueType.IsParamType() {
+				return nil, &types.TransformationError{
+					Category:   types.ErrorCategoryValidation,
+					Message:    fmt.Sprintf("rule %s in %s: unknown type %q for parameter %s", op.Name, rulesPath, valueType, param),
+					Suggestion: "Declare param_types as string, number or bool",
+				}
+			}
+		}
+
+		language := op.Language
+		if language == "" {
+			language = types.LanguagePython
+		}
+
 		rule := types.TransformationRule{
 			Name:             op.Name,
 			Pattern:          op.Pattern,
+			Aliases:          op.Aliases,
+			Language:         language,
 			Provider:         provider,
+			Capability:       capability,
 			Service:          op.Target.Service,
 			Imports:          op.Transformation.Imports,
 			SetupCode:        op.Transformation.SetupCode,
+			SetupScope:       op.Transformation.SetupScope,
 			CodeTemplate:     op.Transformation.CodeTemplate,
+			WrapperTemplate:  op.Transformation.WrapperTemplate,
+			ContextTemplate:  op.Transformation.ContextTemplate,
 			ParameterMapping: op.Transformation.ParameterMapping,
+			StaticParams:     op.Transformation.StaticParams,
+			ValueMapping:     op.Transformation.ValueMapping,
+			Defaults:         op.Transformation.Defaults,
+			OptionalParams:   op.Transformation.OptionalParams,
+			ParamTypes:       op.Transformation.ParamTypes,
+			Client:           op.Transformation.Client,
 			Requirements:     op.Requirements,
+			Deprecated:       op.Deprecated,
+			Replacement:      op.Replacement,
+			Examples:         op.Examples,
 		}
+
+		// Code reading the environment at runtime needs os imported
+		if usesRuntimeEnv(rule.SetupCode, rule.CodeTemplate, rule.WrapperTemplate, rule.ContextTemplate, rule.Client.Code) && !slices.Contains(rule.Imports, runtimeEnvImport) {
+			rule.Imports = append(slices.Clip(rule.Imports), runtimeEnvImport)
+		}
+
 		rules = append(rules, rule)
 	}
 
@@ -68,6 +186,10 @@ func (l *Loader) LoadRules(provider types.Provider, capability string) ([]types.
 func (l *Loader) LoadAllRules(provider types.Provider) (map[string][]types.TransformationRule, error) {
 	allRules := make(map[string][]types.TransformationRule)
 
+	if err := l.checkPluginDir(); err != nil {
+		return nil, err
+	}
+
 	// Walk through plugin directory
 	entries, err := os.ReadDir(l.pluginDir)
 	if err != nil {
@@ -93,3 +215,17 @@ func (l *Loader) LoadAllRules(provider types.Provider) (map[string][]types.Trans
 
 	return allRules, nil
 }
+
+// checkPluginDir returns an error when the plugin directory does not exist
+func (l *Loader) checkPluginDir() error {
+	info, err := os.Stat(l.pluginDir)
+	if err == nil && info.IsDir() {
+		return nil
+	}
+
+	return &types.TransformationError{
+		Category:   types.ErrorCategoryValidation,
+		Message:    fmt.Sprintf("plugin directory not found: %s", l.pluginDir),
+		Suggestion: "Run with -plugins pointing at your plugin packages dir",
+	}
+}
@@ -1,8 +1,12 @@
 package plugin
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/QodeSrl/infrar-engine/pkg/types"
@@ -131,3 +135,532 @@ func TestRegistry_HasRule(t *testing.T) {
 		t.Error("Expected HasRule to return false for non-existent pattern")
 	}
 }
+
+func TestLoader_LoadRules_PerLanguage(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	awsDir := filepath.Join(tmpDir, "storage", "aws")
+	if err := os.MkdirAll(awsDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	rulesYAML := `operations:
+  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "import boto3"
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+  - name: upload
+    pattern: "infrar.storage.upload"
+    language: nodejs
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      imports:
+        - "const { S3Client } = require('@aws-sdk/client-s3');"
+      code_template: "await s3.send(new PutObjectCommand({ Bucket: {{ .bucket }}, Key: {{ .destination }} }))"
+`
+
+	if err := os.WriteFile(filepath.Join(awsDir, "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+
+	rules, err := NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage")
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	if len(rules) != 2 {
+		t.Fatalf("Expected 2 rules, got %d", len(rules))
+	}
+
+	if rules[0].Language != types.LanguagePython {
+		t.Errorf("Expected language to default to python, got %q", rules[0].Language)
+	}
+
+	registry := NewRegistry()
+	registry.RegisterMultiple(rules)
+
+	call := types.InfrarCall{Module: "infrar.storage", Function: "upload", Language: types.LanguagePython}
+	pyRule, err := registry.GetRuleByCall(call)
+	if err != nil {
+		t.Fatalf("GetRuleByCall() error = %v", err)
+	}
+	if pyRule.Imports[0] != "import boto3" {
+		t.Errorf("Expected Python rule, got imports %v", pyRule.Imports)
+	}
+
+	call.Language = types.LanguageNodeJS
+	nodeRule, err := registry.GetRuleByCall(call)
+	if err != nil {
+		t.Fatalf("GetRuleByCall() error = %v", err)
+	}
+	if nodeRule.Language != types.LanguageNodeJS {
+		t.Errorf("Expected Node.js rule, got %q", nodeRule.Language)
+	}
+
+	call.Language = types.LanguageGo
+	if _, err := registry.GetRuleByCall(call); err == nil {
+		t.Error("Expected no rule for Go")
+	}
+}
+
+func TestRegistry_MultipleProviders(t *testing.T) {
+	registry := NewRegistry()
+
+	registry.RegisterMultiple([]types.TransformationRule{
+		{Name: "upload", Pattern: "infrar.storage.upload", Provider: types.ProviderAWS, Service: "s3"},
+		{Name: "upload", Pattern: "infrar.storage.upload", Provider: types.ProviderGCP, Service: "cloud_storage"},
+	})
+
+	// Registering GCP must not overwrite AWS
+	awsRule, err := registry.GetRuleForProvider(types.ProviderAWS, "infrar.storage.upload", types.LanguagePython)
+	if err != nil {
+		t.Fatalf("GetRuleForProvider() error = %v", err)
+	}
+	if awsRule.Service != "s3" {
+		t.Errorf("Expected AWS rule, got service %q", awsRule.Service)
+	}
+
+	call := types.InfrarCall{Module: "infrar.storage", Function: "upload"}
+	gcpRule, err := registry.ForProvider(types.ProviderGCP).GetRuleByCall(call)
+	if err != nil {
+		t.Fatalf("GetRuleByCall() error = %v", err)
+	}
+	if gcpRule.Service != "cloud_storage" {
+		t.Errorf("Expected GCP rule, got service %q", gcpRule.Service)
+	}
+
+	// Without a provider the lookup is ambiguous
+	if _, err := registry.GetRuleByCall(call); err == nil {
+		t.Error("Expected ambiguity error from unscoped registry")
+	}
+
+	if _, err := registry.ForProvider(types.ProviderAzure).GetRuleByCall(call); err == nil {
+		t.Error("Expected no rule for Azure")
+	}
+}
+
+func TestRegistry_ProviderScoped(t *testing.T) {
+	registry := NewProviderRegistry(types.ProviderAWS)
+
+	registry.Register(types.TransformationRule{Pattern: "infrar.storage.upload"})
+
+	rule, err := registry.GetRule("infrar.storage.upload")
+	if err != nil {
+		t.Fatalf("GetRule() error = %v", err)
+	}
+	if rule.Provider != types.ProviderAWS {
+		t.Errorf("Expected rule to take the registry provider, got %q", rule.Provider)
+	}
+}
+
+func TestRegistry_WithFallback(t *testing.T) {
+	registry := NewRegistry()
+	registry.RegisterMultiple([]types.TransformationRule{
+		{Pattern: "infrar.storage.upload", Provider: types.ProviderAWS, Name: "aws-upload"},
+		{Pattern: "infrar.storage.upload", Provider: types.ProviderAzure, Name: "azure-upload"},
+		{Pattern: "infrar.storage.delete", Provider: types.ProviderGCP, Name: "gcp-delete"},
+		{Pattern: "infrar.storage.delete", Provider: types.ProviderAWS, Name: "aws-delete"},
+	})
+
+	gcp := registry.ForProvider(types.ProviderGCP).WithFallback(types.ProviderGCP, types.ProviderAzure, types.ProviderAWS)
+
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{"infrar.storage.delete", "gcp-delete"},   // The provider's own rule wins
+		{"infrar.storage.upload", "azure-upload"}, // First fallback in order
+	}
+	for _, tt := range tests {
+		rule, err := gcp.GetRule(tt.pattern)
+		if err != nil {
+			t.Fatalf("GetRule(%s) error = %v", tt.pattern, err)
+		}
+		if rule.Name != tt.want {
+			t.Errorf("GetRule(%s) = %s, want %s", tt.pattern, rule.Name, tt.want)
+		}
+	}
+
+	if _, err := gcp.GetRule("infrar.storage.copy"); err == nil {
+		t.Error("GetRule() error = nil, want an error for a pattern no provider has")
+	}
+	if gcp.HasRule("infrar.storage.upload") {
+		t.Error("HasRule() = true, want fallbacks left out of the provider's own rules")
+	}
+}
+
+func TestRegistry_Snapshot(t *testing.T) {
+	registry := NewRegistry()
+	registry.Register(types.TransformationRule{Pattern: "infrar.storage.upload", Provider: types.ProviderAWS, Name: "v1"})
+
+	snapshot := registry.ForProvider(types.ProviderAWS).Snapshot()
+
+	registry.Register(types.TransformationRule{Pattern: "infrar.storage.upload", Provider: types.ProviderAWS, Name: "v2"})
+	registry.Register(types.TransformationRule{Pattern: "infrar.storage.delete", Provider: types.ProviderAWS, Name: "delete"})
+
+	if rule, err := snapshot.GetRule("infrar.storage.upload"); err != nil || rule.Name != "v1" {
+		t.Errorf("snapshot GetRule() = %s, %v, want v1", rule.Name, err)
+	}
+	if snapshot.HasRule("infrar.storage.delete") {
+		t.Error("snapshot HasRule() = true, want rules registered later left out")
+	}
+	if snapshot.Provider() != types.ProviderAWS {
+		t.Errorf("snapshot Provider() = %q, want aws", snapshot.Provider())
+	}
+
+	registry.Clear()
+	if len(snapshot.AllRules()) != 1 {
+		t.Errorf("snapshot AllRules() = %v, want the rule kept after Clear", snapshot.AllRules())
+	}
+}
+
+func TestRegistry_ConcurrentReload(t *testing.T) {
+	registry := NewRegistry()
+	rules := []types.TransformationRule{
+		{Pattern: "infrar.storage.upload", Provider: types.ProviderAWS},
+		{Pattern: "infrar.storage.delete", Provider: types.ProviderAWS},
+	}
+	registry.RegisterMultiple(rules)
+
+	// Reload the rules until the readers are done
+	stop := make(chan struct{})
+	reloaded := make(chan struct{})
+	go func() {
+		defer close(reloaded)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				registry.RegisterMultiple(rules)
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 500; j++ {
+				snapshot := registry.ForProvider(types.ProviderAWS).Snapshot()
+				if _, err := snapshot.GetRule("infrar.storage.upload"); err != nil {
+					t.Errorf("GetRule() error = %v", err)
+					return
+				}
+				if len(snapshot.AllRules()) != 2 {
+					t.Errorf("AllRules() = %d rules, want 2", len(snapshot.AllRules()))
+					return
+				}
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(stop)
+	<-reloaded
+}
+
+func TestRegistry_Aliases(t *testing.T) {
+	tmpDir := t.TempDir()
+	awsDir := filepath.Join(tmpDir, "storage", "aws")
+	if err := os.MkdirAll(awsDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	rulesYAML := `operations:
+  - name: upload
+    pattern: "infrar.storage.upload"
+    aliases:
+      - "infrar.storage.put"
+    target:
+      provider: aws
+      service: s3
+    transformation:
+      code_template: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})"
+`
+	if err := os.WriteFile(filepath.Join(awsDir, "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+
+	rules, err := NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage")
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+
+	registry := NewProviderRegistry(types.ProviderAWS)
+	registry.RegisterMultiple(rules)
+
+	call := types.InfrarCall{Module: "infrar.storage", Function: "put", Language: types.LanguagePython}
+	rule, err := registry.GetRuleByCall(call)
+	if err != nil {
+		t.Fatalf("GetRuleByCall() error = %v", err)
+	}
+
+	if rule.Pattern != "infrar.storage.upload" || rule.CodeTemplate != rules[0].CodeTemplate {
+		t.Errorf("Alias resolved to %+v, want the upload rule", rule)
+	}
+
+	if got := len(registry.AllRules()); got != 1 {
+		t.Errorf("AllRules() returned %d rules, want 1", got)
+	}
+}
+
+func TestLoader_LoadRules_Missing(t *testing.T) {
+	pluginDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(pluginDir, "storage", "aws"), 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		pluginDir   string
+		wantMessage string
+	}{
+		{
+			name:        "Missing plugin directory",
+			pluginDir:   filepath.Join(pluginDir, "nope"),
+			wantMessage: "plugin directory not found",
+		},
+		{
+			name:        "Missing rules file",
+			pluginDir:   pluginDir,
+			wantMessage: filepath.Join(pluginDir, "storage", "aws", "rules.yaml") + " not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewLoader(tt.pluginDir).LoadRules(types.ProviderAWS, "storage")
+
+			var terr *types.TransformationError
+			if !errors.As(err, &terr) {
+				t.Fatalf("Expected a TransformationError, got %v", err)
+			}
+			if terr.Category != types.ErrorCategoryValidation {
+				t.Errorf("Category = %s, want %s", terr.Category, types.ErrorCategoryValidation)
+			}
+			if !strings.Contains(terr.Message, tt.wantMessage) {
+				t.Errorf("Message = %q, want it to contain %q", terr.Message, tt.wantMessage)
+			}
+			if !strings.Contains(terr.Suggestion, "-plugins") {
+				t.Errorf("Suggestion = %q, want a pointer to -plugins", terr.Suggestion)
+			}
+		})
+	}
+
+	// Probing tolerates a missing rules file but not a missing directory
+	loader := NewLoader(pluginDir)
+	loader.SetAllowMissing(true)
+	if rules, err := loader.LoadRules(types.ProviderAWS, "storage"); err != nil || len(rules) != 0 {
+		t.Errorf("LoadRules() = %v, %v, want no rules and no error", rules, err)
+	}
+
+	loader = NewLoader(filepath.Join(pluginDir, "nope"))
+	loader.SetAllowMissing(true)
+	if _, err := loader.LoadRules(types.ProviderAWS, "storage"); err == nil {
+		t.Error("Expected an error for a missing plugin directory")
+	}
+}
+
+func TestLoader_LoadRules_ParamTypes(t *testing.T) {
+	tmpDir := t.TempDir()
+	awsDir := filepath.Join(tmpDir, "storage", "aws")
+	if err := os.MkdirAll(awsDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	writeRules := func(paramType string) {
+		rulesYAML := `operations:
+  - name: upload
+    pattern: "infrar.storage.upload"
+    transformation:
+      code_template: "s3.upload_file({{ .source }}, Timeout={{ .timeout }})"
+      parameter_mapping:
+        source: Filename
+        timeout: Timeout
+      param_types:
+        timeout: ` + paramType + "\n"
+		if err := os.WriteFile(filepath.Join(awsDir, "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+			t.Fatalf("Failed to write rules file: %v", err)
+		}
+	}
+
+	writeRules("number")
+	rules, err := NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage")
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+	if got := rules[0].ParamTypes["timeout"]; got != types.ValueTypeNumber {
+		t.Errorf("ParamTypes[timeout] = %q, want %q", got, types.ValueTypeNumber)
+	}
+
+	writeRules("int")
+	if _, err := NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage"); err == nil || !strings.Contains(err.Error(), `unknown type "int" for parameter timeout`) {
+		t.Errorf("LoadRules() error = %v, want an unknown type error", err)
+	}
+}
+
+func TestLoader_LoadRules_MultipleFiles(t *testing.T) {
+	tmpDir := t.TempDir()
+	awsDir := filepath.Join(tmpDir, "storage", "aws")
+	if err := os.MkdirAll(awsDir, 0755); err != nil {
+		t.Fatalf("Failed to create test directory: %v", err)
+	}
+
+	writeRules := func(name, operation string) {
+		rulesYAML := `operations:
+  - name: ` + operation + `
+    pattern: "infrar.storage.` + operation + `"
+    transformation:
+      code_template: "s3.` + operation + `_file({{ .source }})"
+      parameter_mapping:
+        source: source
+`
+		if err := os.WriteFile(filepath.Join(awsDir, name), []byte(rulesYAML), 0644); err != nil {
+			t.Fatalf("Failed to write rules file: %v", err)
+		}
+	}
+
+	writeRules("upload.yaml", "upload")
+	writeRules("download.yaml", "download")
+	if err := os.WriteFile(filepath.Join(awsDir, "README.md"), []byte("not rules"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	rules, err := NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage")
+	if err != nil {
+		t.Fatalf("LoadRules() error = %v", err)
+	}
+	var patterns []string
+	for _, rule := range rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	if want := []string{"infrar.storage.download", "infrar.storage.upload"}; !slices.Equal(patterns, want) {
+		t.Errorf("patterns = %v, want %v", patterns, want)
+	}
+
+	// The same pattern in two files is reported with both paths
+	writeRules("rules.yaml", "upload")
+	_, err = NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage")
+	var terr *types.TransformationError
+	if !errors.As(err, &terr) || terr.Category != types.ErrorCategoryValidation {
+		t.Fatalf("LoadRules() error = %v, want a validation error", err)
+	}
+	for _, file := range []string{"rules.yaml", "upload.yaml"} {
+		if !strings.Contains(terr.Message, filepath.Join(awsDir, file)) {
+			t.Errorf("Message = %q, want it to name %s", terr.Message, file)
+		}
+	}
+}
+
+func TestLoader_CompileBundle_BrokenRules(t *testing.T) {
+	tmpDir := t.TempDir()
+	for _, capability := range []string{"storage", "database"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, capability, "aws"), 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+	rulesYAML := `operations:
+  - name: upload
+    pattern: "infrar.storage.upload"
+    transformation:
+      code_template: "s3.upload_file({{ .source }})"
+      parameter_mapping:
+        source: source
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "storage", "aws", "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(tmpDir, "database", "aws", "rules.yaml"), []byte("operations: [unclosed"), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+
+	// A rules file that fails to load fails the compile instead of being
+	// left out of a bundle that would still pass the stale check
+	bundlePath := filepath.Join(t.TempDir(), "rules.bundle")
+	err := NewLoader(tmpDir).CompileBundle(bundlePath)
+	if err == nil || !strings.Contains(err.Error(), filepath.Join(tmpDir, "database", "aws", "rules.yaml")) {
+		t.Fatalf("CompileBundle() error = %v, want the broken rules file named", err)
+	}
+	if _, err := os.Stat(bundlePath); !os.IsNotExist(err) {
+		t.Errorf("bundle written despite the error: %v", err)
+	}
+}
+
+func TestLoader_Lint(t *testing.T) {
+	// The shipped test plugins are clean
+	lints, err := NewLoader("../../test-plugins").Lint()
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+	if len(lints) != 0 {
+		t.Errorf("Lint() = %+v, want no problems", lints)
+	}
+
+	tmpDir := t.TempDir()
+	for _, dir := range []string{"aws", "ibm"} {
+		if err := os.MkdirAll(filepath.Join(tmpDir, "storage", dir), 0755); err != nil {
+			t.Fatalf("Failed to create test directory: %v", err)
+		}
+	}
+	rulesYAML := `operations:
+  - name: upload
+    pattern: "infrar.storage.upload"
+    target:
+      provider: gcp
+      service: cloud_storage
+    transformation:
+      setup_code: "s3 = boto3.client({{ .region }})"
+      code_template: "s3.upload_file({{ .source }}, {{ if .acl }}{{ .desination }}{{ end }}, {{ range ._meta.args }}{{ .name }}{{ end }})"
+      parameter_mapping:
+        source: source
+        destination: Key
+      optional_params: [acl]
+  - name: download
+    pattern: "infrar.storage.download"
+    transformation:
+      code_template: "s3.download_file({{ .source }"
+`
+	if err := os.WriteFile(filepath.Join(tmpDir, "storage", "aws", "rules.yaml"), []byte(rulesYAML), 0644); err != nil {
+		t.Fatalf("Failed to write rules file: %v", err)
+	}
+
+	lints, err = NewLoader(tmpDir).Lint()
+	if err != nil {
+		t.Fatalf("Lint() error = %v", err)
+	}
+
+	// Every problem is reported, not just the first
+	want := []string{
+		`target provider "gcp" does not match the aws directory it is in`,
+		`target service "cloud_storage" is not a known aws service (known: ` + strings.Join(types.ProviderAWS.Services(), ", ") + ")",
+		"target service is empty",
+		"code_template reads .desination, which the rule does not declare (declared: Key, _meta, acl, destination, source)",
+		"setup_code reads .region, but it is rendered without call data",
+		"invalid code_template: template: code_template:1: unexpected \"}\" in operand",
+		`unsupported provider "ibm" (supported: aws, gcp, azure)`,
+	}
+	var got []string
+	for _, lint := range lints {
+		got = append(got, lint.Message)
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lint() messages =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if lints[1].Rule != "upload" || lints[1].Provider != types.ProviderAWS || lints[1].Capability != "storage" {
+		t.Errorf("Lint()[1] = %+v, want it attributed to the aws storage upload rule", lints[1])
+	}
+
+	// Loading stops at the provider mismatch
+	if _, err := NewLoader(tmpDir).LoadRules(types.ProviderAWS, "storage"); err == nil || !strings.Contains(err.Error(), `target provider "gcp" does not match the aws directory`) {
+		t.Errorf("LoadRules() error = %v, want the target provider mismatch", err)
+	}
+}
@@ -3,86 +3,240 @@ package plugin
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
-// Registry manages transformation rules
+// ruleKey identifies a rule by target provider, Infrar pattern and source language
+type ruleKey struct {
+	provider types.Provider
+	pattern  string
+	language types.Language
+}
+
+// ruleMap maps rule keys to rules. A published ruleMap is never modified:
+// writers change a copy and swap it in.
+type ruleMap map[ruleKey]types.TransformationRule
+
+// ruleStore holds the rules shared by a registry and its provider views.
+// Readers load the current map without locking, so reloading rules never
+// blocks a transform in progress; writers serialize on mu.
+type ruleStore struct {
+	mu    sync.Mutex
+	rules atomic.Pointer[ruleMap]
+}
+
+// newRuleStore creates a store publishing rules
+func newRuleStore(rules ruleMap) *ruleStore {
+	s := &ruleStore{}
+	s.rules.Store(&rules)
+	return s
+}
+
+// load returns the current rules, which must not be modified
+func (s *ruleStore) load() ruleMap {
+	return *s.rules.Load()
+}
+
+// Registry manages transformation rules. Rules are keyed by provider, pattern
+// and language, so rules for several providers can be held at once. A registry
+// scoped to a provider only sees that provider's rules (plus rules registered
+// without a provider).
 type Registry struct {
-	mu    sync.RWMutex
-	rules map[string]types.TransformationRule // pattern -> rule
+	store     *ruleStore
+	provider  types.Provider   // Empty when the registry spans all providers
+	fallbacks []types.Provider // Providers whose rules stand in for missing ones, in order
 }
 
-// NewRegistry creates a new rule registry
+// NewRegistry creates a new rule registry spanning all providers
 func NewRegistry() *Registry {
 	return &Registry{
-		rules: make(map[string]types.TransformationRule),
+		store: newRuleStore(make(ruleMap)),
 	}
 }
 
-// Register registers a transformation rule
-func (r *Registry) Register(rule types.TransformationRule) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+// NewProviderRegistry creates a new rule registry scoped to a single provider
+func NewProviderRegistry(provider types.Provider) *Registry {
+	return NewRegistry().ForProvider(provider)
+}
+
+// ForProvider returns a view of the registry scoped to provider. The view
+// shares rules with r, so rules registered through either are visible to both.
+func (r *Registry) ForProvider(provider types.Provider) *Registry {
+	return &Registry{
+		store:    r.store,
+		provider: provider,
+	}
+}
+
+// WithFallback returns a view of the provider-scoped registry that, when the
+// provider has no rule for a pattern, looks it up for each of providers in
+// order. Listing and HasRule still only see the provider's own rules.
+func (r *Registry) WithFallback(providers ...types.Provider) *Registry {
+	var fallbacks []types.Provider
+	for _, p := range providers {
+		if p != r.provider {
+			fallbacks = append(fallbacks, p)
+		}
+	}
+
+	return &Registry{
+		store:     r.store,
+		provider:  r.provider,
+		fallbacks: fallbacks,
+	}
+}
 
-	r.rules[rule.Pattern] = rule
+// Snapshot returns a registry holding the rules registered so far, with r's
+// provider scope and fallbacks. Taking one is cheap, and later changes to r,
+// such as a reload, do not reach it, so a batch of transforms can work from
+// one consistent set of rules. Rules registered on the snapshot stay in it.
+func (r *Registry) Snapshot() *Registry {
+	return &Registry{
+		store:     newRuleStore(r.store.load()),
+		provider:  r.provider,
+		fallbacks: r.fallbacks,
+	}
 }
 
-// RegisterMultiple registers multiple transformation rules
+// Provider returns the provider the registry is scoped to, or "" if unscoped
+func (r *Registry) Provider() types.Provider {
+	return r.provider
+}
+
+// keyFor returns the registry key for a rule, treating an unset language as Python
+func keyFor(provider types.Provider, pattern string, language types.Language) ruleKey {
+	if language == "" {
+		language = types.LanguagePython
+	}
+	return ruleKey{provider: provider, pattern: pattern, language: language}
+}
+
+// Register registers a transformation rule. Rules without a provider take the
+// registry's provider when it is scoped.
+func (r *Registry) Register(rule types.TransformationRule) {
+	r.RegisterMultiple([]types.TransformationRule{rule})
+}
+
+// RegisterMultiple registers multiple transformation rules. A rule with
+// aliases is registered under each alias pattern as well as its own.
 func (r *Registry) RegisterMultiple(rules []types.TransformationRule) {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
+
+	current := r.store.load()
+	next := make(ruleMap, len(current)+len(rules))
+	for key, rule := range current {
+		next[key] = rule
+	}
 
 	for _, rule := range rules {
-		r.rules[rule.Pattern] = rule
+		if rule.Provider == "" {
+			rule.Provider = r.provider
+		}
+		next[keyFor(rule.Provider, rule.Pattern, rule.Language)] = rule
+		for _, alias := range rule.Aliases {
+			next[keyFor(rule.Provider, alias, rule.Language)] = rule
+		}
 	}
+
+	r.store.rules.Store(&next)
 }
 
-// GetRule retrieves a transformation rule by pattern
+// GetRule retrieves a Python transformation rule by pattern
 func (r *Registry) GetRule(pattern string) (types.TransformationRule, error) {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
+	return r.GetRuleForLanguage(pattern, types.LanguagePython)
+}
 
-	rule, ok := r.rules[pattern]
-	if !ok {
+// GetRuleForLanguage retrieves a transformation rule by pattern and source language.
+// On an unscoped registry the pattern must be defined for a single provider.
+func (r *Registry) GetRuleForLanguage(pattern string, language types.Language) (types.TransformationRule, error) {
+	rules := r.store.load()
+
+	if r.provider != "" {
+		if rule, ok := rules[keyFor(r.provider, pattern, language)]; ok {
+			return rule, nil
+		}
+		if rule, ok := rules[keyFor("", pattern, language)]; ok {
+			return rule, nil
+		}
+		for _, fallback := range r.fallbacks {
+			if rule, ok := rules[keyFor(fallback, pattern, language)]; ok {
+				return rule, nil
+			}
+		}
 		return types.TransformationRule{}, fmt.Errorf("no rule found for pattern: %s", pattern)
 	}
 
-	return rule, nil
+	var found []types.TransformationRule
+	want := keyFor("", pattern, language)
+	for key, rule := range rules {
+		if key.pattern == want.pattern && key.language == want.language {
+			found = append(found, rule)
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return types.TransformationRule{}, fmt.Errorf("no rule found for pattern: %s", pattern)
+	case 1:
+		return found[0], nil
+	default:
+		return types.TransformationRule{}, fmt.Errorf("rule for pattern %s is defined for multiple providers; select a provider", pattern)
+	}
 }
 
-// GetRuleByCall retrieves a transformation rule for an Infrar call
+// GetRuleForProvider retrieves a transformation rule for a specific provider
+func (r *Registry) GetRuleForProvider(provider types.Provider, pattern string, language types.Language) (types.TransformationRule, error) {
+	return r.ForProvider(provider).GetRuleForLanguage(pattern, language)
+}
+
+// GetRuleByCall retrieves a transformation rule for an Infrar call. A call
+// with its own provider (from a pragma) is looked up for that provider,
+// keeping the registry's fallbacks.
 func (r *Registry) GetRuleByCall(call types.InfrarCall) (types.TransformationRule, error) {
 	pattern := call.FullName() // e.g., "infrar.storage.upload"
-	return r.GetRule(pattern)
+	if call.Provider != "" && call.Provider != r.provider {
+		return r.ForProvider(call.Provider).WithFallback(r.fallbacks...).GetRuleForLanguage(pattern, call.Language)
+	}
+	return r.GetRuleForLanguage(pattern, call.Language)
 }
 
-// HasRule checks if a rule exists for a pattern
+// HasRule checks if a Python rule exists for a pattern
 func (r *Registry) HasRule(pattern string) bool {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	_, ok := r.rules[pattern]
-	return ok
+	for key := range r.store.load() {
+		if key.pattern == pattern && key.language == types.LanguagePython && r.visible(key) {
+			return true
+		}
+	}
+	return false
 }
 
-// AllRules returns all registered rules
+// AllRules returns all registered rules visible to this registry, each once
+// regardless of how many aliases it has
 func (r *Registry) AllRules() []types.TransformationRule {
-	r.mu.RLock()
-	defer r.mu.RUnlock()
-
-	rules := make([]types.TransformationRule, 0, len(r.rules))
-	for _, rule := range r.rules {
-		rules = append(rules, rule)
+	current := r.store.load()
+	rules := make([]types.TransformationRule, 0, len(current))
+	for key, rule := range current {
+		if r.visible(key) && key.pattern == rule.Pattern {
+			rules = append(rules, rule)
+		}
 	}
 
 	return rules
 }
 
-// Clear clears all rules from the registry
+// visible reports whether a rule key is in this registry's provider scope
+func (r *Registry) visible(key ruleKey) bool {
+	return r.provider == "" || key.provider == r.provider || key.provider == ""
+}
+
+// Clear clears all rules from the registry, including those seen by provider views
 func (r *Registry) Clear() {
-	r.mu.Lock()
-	defer r.mu.Unlock()
+	r.store.mu.Lock()
+	defer r.store.mu.Unlock()
 
-	r.rules = make(map[string]types.TransformationRule)
+	empty := make(ruleMap)
+	r.store.rules.Store(&empty)
 }
@@ -0,0 +1,78 @@
+package transformer
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/QodeSrl/infrar-engine/pkg/types"
+)
+
+// Explain describes how call is transformed: the rule it matches, how each
+// mapped parameter is bound, the rendered code and what the rule adds to the
+// file. Calls that cannot be transformed return the same error as Transform.
+func (t *Transformer) Explain(call types.InfrarCall) (types.Explanation, error) {
+	tc, err := t.Transform(call)
+	if err != nil {
+		return types.Explanation{}, err
+	}
+	rule, err := t.registry.GetRuleByCall(call)
+	if err != nil {
+		return types.Explanation{}, err
+	}
+
+	exp := types.Explanation{
+		Call:         call,
+		Rule:         rule.Name,
+		Pattern:      rule.Pattern,
+		Provider:     rule.Provider,
+		Capability:   rule.Capability,
+		Service:      rule.Service,
+		Code:         tc.TransformedCode,
+		Imports:      rule.Imports,
+		SetupCode:    rule.SetupCode,
+		Requirements: rule.Requirements,
+	}
+
+	params := make([]string, 0, len(rule.ParameterMapping))
+	for param := range rule.ParameterMapping {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		binding := types.ParameterBinding{
+			Name:     param,
+			Targets:  rule.ParameterMapping[param],
+			Optional: isOptional(rule, param),
+		}
+		if value, ok := call.Arguments[param]; ok {
+			binding.Value = t.formatValue(value)
+			binding.Provided = true
+		}
+		exp.Parameters = append(exp.Parameters, binding)
+	}
+
+	if len(rule.StaticParams) > 0 {
+		exp.StaticParams = make(map[string]string, len(rule.StaticParams))
+		for param, value := range rule.StaticParams {
+			exp.StaticParams[param] = t.formatValue(staticValue(value))
+		}
+	}
+
+	if rule.Deprecated {
+		exp.Notes = append(exp.Notes, deprecationWarning(call, rule).Message)
+	}
+
+	var unused []string
+	for arg := range call.Arguments {
+		if _, ok := rule.ParameterMapping[arg]; !ok {
+			unused = append(unused, arg)
+		}
+	}
+	sort.Strings(unused)
+	for _, arg := range unused {
+		exp.Notes = append(exp.Notes, fmt.Sprintf("argument %s is not mapped by the rule; templates can still read it as {{ .%s }}", arg, arg))
+	}
+
+	return exp, nil
+}
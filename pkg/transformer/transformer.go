@@ -3,6 +3,7 @@ package transformer
 import (
 	"bytes"
 	"fmt"
+	"sort"
 	"strings"
 	"text/template"
 
@@ -12,7 +13,12 @@ import (
 
 // Transformer applies transformation rules to Infrar calls
 type Transformer struct {
-	registry *plugin.Registry
+	registry     *plugin.Registry
+	lenient      bool
+	shareClients bool
+	style        types.StyleOptions
+	unmatched    []types.InfrarCall
+	warnings     []types.Warning
 }
 
 // New creates a new transformer with a rule registry
@@ -22,6 +28,37 @@ func New(registry *plugin.Registry) *Transformer {
 	}
 }
 
+// SetLenient controls how calls without a matching rule are handled. In
+// lenient mode TransformMultiple leaves them out of the result and records a
+// warning instead of failing, so the original call stays in the source.
+func (t *Transformer) SetLenient(lenient bool) {
+	t.lenient = lenient
+}
+
+// SetShareClients controls how {{ .client }} renders for rules with a
+// shareable client: as the shared client's name, bound once by the
+// generator, instead of creating a client at every call
+func (t *Transformer) SetShareClients(share bool) {
+	t.shareClients = share
+}
+
+// SetStyle sets the style of the generated code: the quotes of string
+// literals and the indentation of multi-line templates
+func (t *Transformer) SetStyle(style types.StyleOptions) {
+	t.style = style
+}
+
+// Unmatched returns the calls skipped by the last TransformMultiple because no rule matched
+func (t *Transformer) Unmatched() []types.InfrarCall {
+	return t.unmatched
+}
+
+// Warnings returns the warnings recorded by the last TransformMultiple, such
+// as skipped calls in lenient mode and uses of deprecated operations
+func (t *Transformer) Warnings() []types.Warning {
+	return t.warnings
+}
+
 // Transform transforms a single Infrar call to provider-specific code
 func (t *Transformer) Transform(call types.InfrarCall) (types.TransformedCall, error) {
 	// Get transformation rule for this call
@@ -32,23 +69,31 @@ func (t *Transformer) Transform(call types.InfrarCall) (types.TransformedCall, e
 			Message:    fmt.Sprintf("no transformation rule found for %s", call.FullName()),
 			Line:       call.LineNumber,
 			SourceCode: call.SourceCode,
-			Suggestion: fmt.Sprintf("Check if plugin is loaded for %s on %s", call.Module, rule.Provider),
+			Suggestion: fmt.Sprintf("Check if plugin is loaded for %s on %s", call.Module, t.providerFor(call)),
 		}
 	}
 
+	// Fill in the omitted parameters the rule has defaults for, so they
+	// count as present when the required parameters are checked
+	filled, err := applyDefaults(call, rule)
+	if err != nil {
+		return types.TransformedCall{}, err
+	}
+
 	// Validate required parameters
-	if err := t.validateParameters(call, rule); err != nil {
+	if err := t.validateParameters(filled, rule); err != nil {
 		return types.TransformedCall{}, err
 	}
 
 	// Generate code from template
-	code, err := t.generateCode(call, rule)
+	code, err := t.generateCode(filled, rule)
 	if err != nil {
 		return types.TransformedCall{}, &types.TransformationError{
 			Category:   types.ErrorCategoryTransformation,
 			Message:    fmt.Sprintf("failed to generate code: %v", err),
 			Line:       call.LineNumber,
 			SourceCode: call.SourceCode,
+			Cause:      err,
 		}
 	}
 
@@ -60,18 +105,68 @@ func (t *Transformer) Transform(call types.InfrarCall) (types.TransformedCall, e
 	}, nil
 }
 
+// providerFor returns the provider a call targets: the one named by its
+// pragma, or else the registry's
+func (t *Transformer) providerFor(call types.InfrarCall) types.Provider {
+	if call.Provider != "" {
+		return call.Provider
+	}
+	return t.registry.Provider()
+}
+
 // TransformMultiple transforms multiple Infrar calls
 func (t *Transformer) TransformMultiple(calls []types.InfrarCall) ([]types.TransformedCall, error) {
 	var transformed []types.TransformedCall
 	var errors []error
 
+	t.unmatched = nil
+	t.warnings = nil
+
 	for _, call := range calls {
+		if t.lenient {
+			if _, err := t.registry.GetRuleByCall(call); err != nil {
+				t.unmatched = append(t.unmatched, call)
+				t.warnings = append(t.warnings, types.Warning{
+					Message:    fmt.Sprintf("no transformation rule found for %s - call left unchanged", call.FullName()),
+					LineNumber: call.LineNumber,
+					Category:   "no-rule",
+				})
+				continue
+			}
+			if len(call.Spread) > 0 {
+				t.unmatched = append(t.unmatched, call)
+				t.warnings = append(t.warnings, types.Warning{
+					Message:    fmt.Sprintf("%s unpacks %s - call left unchanged", call.FullName(), strings.Join(call.Spread, ", ")),
+					LineNumber: call.LineNumber,
+					Category:   "spread",
+				})
+				continue
+			}
+		}
+
 		tc, err := t.Transform(call)
 		if err != nil {
 			errors = append(errors, err)
 			continue
 		}
 		transformed = append(transformed, tc)
+
+		rule, err := t.registry.GetRuleByCall(call)
+		if err != nil {
+			continue
+		}
+		if rule.Deprecated {
+			t.warnings = append(t.warnings, deprecationWarning(call, rule))
+		}
+		t.warnings = append(t.warnings, t.valueMappingWarnings(call, rule)...)
+		if provider := t.providerFor(call); provider != "" && rule.Provider != "" && rule.Provider != provider {
+			// The rule came from one of the registry's fallback providers
+			t.warnings = append(t.warnings, types.Warning{
+				Message:    fmt.Sprintf("no %s rule for %s - used the %s rule instead", provider, call.FullName(), rule.Provider),
+				LineNumber: call.LineNumber,
+				Category:   "fallback",
+			})
+		}
 	}
 
 	if len(errors) > 0 {
@@ -82,10 +177,147 @@ func (t *Transformer) TransformMultiple(calls []types.InfrarCall) ([]types.Trans
 	return transformed, nil
 }
 
-// validateParameters checks if all required parameters are present
+// mapValue translates a string literal argument through the rule's value
+// mapping for param. It reports false when param has a mapping that does
+// not cover the value, which is then returned unchanged.
+func mapValue(rule types.TransformationRule, param string, value types.Value) (types.Value, bool) {
+	mapping, ok := rule.ValueMapping[param]
+	if !ok {
+		return value, true
+	}
+
+	s, ok := value.AsString()
+	if !ok || value.Type != types.ValueTypeString {
+		return value, false
+	}
+	mapped, ok := mapping[s]
+	if !ok {
+		return value, false
+	}
+	return types.Value{Type: types.ValueTypeString, Value: mapped}, true
+}
+
+// valueMappingWarnings reports the arguments of a call, and of the Infrar
+// calls nested in it, that the rule's value mapping could not translate:
+// literals it does not list, and values only known at runtime
+func (t *Transformer) valueMappingWarnings(call types.InfrarCall, rule types.TransformationRule) []types.Warning {
+	var warnings []types.Warning
+	for _, param := range call.ArgumentNames() {
+		value := call.Arguments[param]
+		if value.Type == types.ValueTypeCall && value.Call != nil {
+			if innerRule, err := t.registry.GetRuleByCall(*value.Call); err == nil {
+				warnings = append(warnings, t.valueMappingWarnings(*value.Call, innerRule)...)
+				continue
+			}
+		}
+		if _, ok := mapValue(rule, param, value); ok {
+			continue
+		}
+
+		known := make([]string, 0, len(rule.ValueMapping[param]))
+		for from := range rule.ValueMapping[param] {
+			known = append(known, from)
+		}
+		sort.Strings(known)
+
+		message := fmt.Sprintf("%s of %s is %s, which has no value mapping (known: %s) - passed through unchanged", param, call.FullName(), t.formatValue(value), strings.Join(known, ", "))
+		if value.Type != types.ValueTypeString {
+			message = fmt.Sprintf("%s of %s is %s, which cannot be mapped before it runs - passed through unchanged", param, call.FullName(), t.formatValue(value))
+		}
+		warnings = append(warnings, types.Warning{
+			Message:    message,
+			LineNumber: call.LineNumber,
+			Category:   "value-mapping",
+		})
+	}
+	return warnings
+}
+
+// deprecationWarning describes the use of a deprecated operation
+func deprecationWarning(call types.InfrarCall, rule types.TransformationRule) types.Warning {
+	message := fmt.Sprintf("%s is deprecated", call.FullName())
+	if rule.Replacement != "" {
+		message += fmt.Sprintf(" - use %s instead", rule.Replacement)
+	}
+
+	return types.Warning{
+		Message:    message,
+		LineNumber: call.LineNumber,
+		Category:   "deprecated",
+	}
+}
+
+// applyDefaults returns call with the parameters it omits filled in from
+// the rule's defaults. A default is a template over the call's literal
+// arguments, e.g. {{ base .source }}, and renders to a string literal. It
+// cannot read an argument the call omits or one only known at runtime, such
+// as a variable without a constant value. The call's own arguments map is
+// left untouched.
+func applyDefaults(call types.InfrarCall, rule types.TransformationRule) (types.InfrarCall, error) {
+	var omitted []string
+	for param := range rule.Defaults {
+		if _, ok := call.Arguments[param]; !ok {
+			omitted = append(omitted, param)
+		}
+	}
+	if len(omitted) == 0 {
+		return call, nil
+	}
+	sort.Strings(omitted)
+
+	literals := make(map[string]any, len(call.Arguments))
+	for name, value := range call.Arguments {
+		if value.Resolved != nil {
+			value = *value.Resolved
+		}
+		switch value.Type {
+		case types.ValueTypeString, types.ValueTypeNumber, types.ValueTypeBool:
+			literals[name] = value.String()
+		}
+	}
+
+	order := call.ArgumentNames()
+	args := make(map[string]types.Value, len(call.Arguments)+len(omitted))
+	for name, value := range call.Arguments {
+		args[name] = value
+	}
+	for _, param := range omitted {
+		tmpl, err := template.New(param).Funcs(plugin.DefaultFuncs()).Option("missingkey=error").Parse(rule.Defaults[param])
+		if err == nil {
+			var buf bytes.Buffer
+			if err = tmpl.Execute(&buf, literals); err == nil {
+				args[param] = types.Value{Type: types.ValueTypeString, Value: buf.String()}
+				order = append(order, param)
+				continue
+			}
+		}
+		return call, &types.TransformationError{
+			Category:   types.ErrorCategoryTransformation,
+			Message:    fmt.Sprintf("cannot compute default %s of %s: %v", param, call.FullName(), err),
+			Line:       call.LineNumber,
+			SourceCode: call.SourceCode,
+			Suggestion: fmt.Sprintf("Pass %s explicitly, or pass the arguments its default reads as literals", param),
+			Cause:      err,
+		}
+	}
+
+	call.Arguments = args
+	call.ArgumentOrder = order
+	return call, nil
+}
+
+// validateParameters checks if all required parameters are present and
+// that arguments have the types the rule declares
 func (t *Transformer) validateParameters(call types.InfrarCall, rule types.TransformationRule) error {
+	if len(call.Spread) > 0 {
+		return spreadError(call, rule)
+	}
+
 	// Check if parameter mapping specifies required parameters
 	for infraParam := range rule.ParameterMapping {
+		if isOptional(rule, infraParam) {
+			continue
+		}
 		if _, ok := call.Arguments[infraParam]; !ok {
 			return &types.TransformationError{
 				Category:   types.ErrorCategoryTransformation,
@@ -97,59 +329,375 @@ func (t *Transformer) validateParameters(call types.InfrarCall, rule types.Trans
 		}
 	}
 
+	return t.checkParamTypes(call, rule)
+}
+
+// checkParamTypes reports the first argument, in parameter order, whose
+// literal type differs from the one the rule declares. A variable is checked
+// through the constant it resolves to; other expressions are only known at
+// runtime and pass.
+func (t *Transformer) checkParamTypes(call types.InfrarCall, rule types.TransformationRule) error {
+	params := make([]string, 0, len(rule.ParamTypes))
+	for param := range rule.ParamTypes {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	for _, param := range params {
+		value, ok := call.Arguments[param]
+		if !ok {
+			continue
+		}
+		if value.Resolved != nil {
+			value = *value.Resolved
+		}
+
+		got := value.Type
+		if got == types.ValueTypeFString {
+			got = types.ValueTypeString
+		}
+		if want := rule.ParamTypes[param]; got.IsParamType() && got != want {
+			return &types.TransformationError{
+				Category:   types.ErrorCategoryTransformation,
+				Message:    fmt.Sprintf("parameter %s of %s must be a %s, got %s %s", param, call.Function, want, got, t.formatValue(value)),
+				Line:       call.LineNumber,
+				SourceCode: call.SourceCode,
+				Suggestion: fmt.Sprintf("Pass %s as a %s, e.g. %s=%s", param, want, param, typeExample(want)),
+			}
+		}
+	}
 	return nil
 }
 
-// generateCode generates provider-specific code using template
+// typeExample returns a sample Python literal of a parameter type
+func typeExample(valueType types.ValueType) string {
+	switch valueType {
+	case types.ValueTypeNumber:
+		return "30"
+	case types.ValueTypeBool:
+		return "True"
+	default:
+		return "'value'"
+	}
+}
+
+// spreadError reports a call whose unpacked arguments, such as **opts, could
+// supply any parameter, so it cannot be transformed statically
+func spreadError(call types.InfrarCall, rule types.TransformationRule) *types.TransformationError {
+	params := make([]string, 0, len(rule.ParameterMapping))
+	for param := range rule.ParameterMapping {
+		params = append(params, param)
+	}
+	sort.Strings(params)
+
+	return &types.TransformationError{
+		Category:   types.ErrorCategoryTransformation,
+		Message:    fmt.Sprintf("%s unpacks %s, so its arguments cannot be resolved statically", call.FullName(), strings.Join(call.Spread, ", ")),
+		Line:       call.LineNumber,
+		SourceCode: call.SourceCode,
+		Suggestion: fmt.Sprintf("Pass the arguments by name instead, e.g. %s(%s=...)", call.Function, strings.Join(params, "=..., ")),
+	}
+}
+
+// isOptional reports whether a call may omit param
+func isOptional(rule types.TransformationRule, param string) bool {
+	for _, optional := range rule.OptionalParams {
+		if optional == param {
+			return true
+		}
+	}
+	return false
+}
+
+// generateCode generates provider-specific code using template. Calls in a
+// lambda or comprehension skip the wrapper template, whose statements cannot
+// appear there. So do calls used as a with statement's context manager,
+// which render the rule's context template when it has one, keeping the
+// statement's `as` binding and block.
 func (t *Transformer) generateCode(call types.InfrarCall, rule types.TransformationRule) (string, error) {
+	if call.WithItem {
+		if rule.ContextTemplate != "" {
+			rule.CodeTemplate = rule.ContextTemplate
+		}
+		return t.render(call, rule, false)
+	}
+	return t.render(call, rule, !call.InExpression)
+}
+
+// generateExpression renders a call nested in another call's arguments. The
+// wrapper template is skipped, since its statements cannot appear inside an
+// expression.
+func (t *Transformer) generateExpression(call types.InfrarCall, rule types.TransformationRule) (string, error) {
+	call, err := applyDefaults(call, rule)
+	if err != nil {
+		return "", err
+	}
+	if err := t.validateParameters(call, rule); err != nil {
+		return "", err
+	}
+	return t.render(call, rule, false)
+}
+
+// render fills in a rule's code template, and its wrapper template when wrap is set
+func (t *Transformer) render(call types.InfrarCall, rule types.TransformationRule, wrap bool) (string, error) {
 	// Prepare template data - format all values as strings
-	data := make(map[string]string)
+	data := make(map[string]any)
+
+	// Optional parameters are always present, empty when the call omits
+	// them, so templates can test them with {{ if .acl }}...{{ end }}
+	for _, param := range rule.OptionalParams {
+		data[param] = ""
+		for _, target := range rule.ParameterMapping[param] {
+			data[target] = ""
+		}
+	}
+
+	// Static parameters come next so the call's own arguments take precedence
+	for param, value := range rule.StaticParams {
+		data[param] = t.formatValue(staticValue(value))
+	}
+
+	if rule.Client.Code != "" {
+		client, err := t.clientRef(rule)
+		if err != nil {
+			return "", err
+		}
+		data["client"] = client
+	}
+
+	// Arguments are handled in source order, so when two of them map to the
+	// same target, or both fail, the outcome is the same on every run
+	names := call.ArgumentNames()
+	args := make(map[string]string, len(call.Arguments))
+	ordered := make([]map[string]any, 0, len(names))
+	for _, infraParam := range names {
+		value := call.Arguments[infraParam]
+
+		// Nested Infrar calls are transformed first and passed in as code
+		if value.Type == types.ValueTypeCall && value.Call != nil {
+			if innerRule, err := t.registry.GetRuleByCall(*value.Call); err == nil {
+				inner, err := t.generateExpression(*value.Call, innerRule)
+				if err != nil {
+					return "", err
+				}
+				args[infraParam] = inner
+				continue
+			}
+		}
+
+		// Enum values are translated for the provider, e.g. 'public' to
+		// 'public-read'; values without a mapping pass through unchanged
+		value, _ = mapValue(rule, infraParam, value)
 
-	for infraParam, value := range call.Arguments {
 		// Convert value to properly formatted string representation
-		valueStr := t.formatValue(value)
-		data[infraParam] = valueStr
+		args[infraParam] = t.formatValue(value)
+	}
+
+	// Each argument is also available under the target names it maps to,
+	// so one parameter can feed several placeholders
+	for _, infraParam := range names {
+		for _, target := range rule.ParameterMapping[infraParam] {
+			data[target] = args[infraParam]
+		}
+	}
+	for _, infraParam := range names {
+		data[infraParam] = args[infraParam]
+		ordered = append(ordered, map[string]any{"name": infraParam, "value": args[infraParam]})
+	}
+
+	// Call metadata is set last, so no argument or parameter can shadow it
+	data[metaKey] = map[string]any{
+		"line":     call.LineNumber,
+		"column":   call.ColumnOffset,
+		"function": call.Function,
+		"module":   call.Module,
+		"source":   call.SourceCode,
+		"args":     ordered,
 	}
 
+	code, err := t.renderTemplate("code", rule.CodeTemplate, data)
+	if err != nil {
+		return "", err
+	}
+
+	// Wrap the call in surrounding statements, such as error handling
+	if wrap && rule.WrapperTemplate != "" {
+		data["call"] = code
+		code, err = t.renderTemplate("wrapper", rule.WrapperTemplate, data)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return reindent(code, t.style.IndentWidth), nil
+}
+
+// clientRef returns the code a rule's templates reach its client through:
+// the shared client's name, or an expression creating a client
+func (t *Transformer) clientRef(rule types.TransformationRule) (string, error) {
+	if t.shareClients && rule.Client.Shareable {
+		return rule.Client.Name, nil
+	}
+	return plugin.RenderSetupCode(rule.Client.Code, t.style)
+}
+
+// staticValue converts a rule's static parameter, as decoded from YAML, to a Value
+func staticValue(v any) types.Value {
+	switch v := v.(type) {
+	case nil:
+		return types.Value{Type: types.ValueTypeNone}
+	case bool:
+		return types.Value{Type: types.ValueTypeBool, Value: v}
+	case int, int64, float64:
+		return types.Value{Type: types.ValueTypeNumber, Value: fmt.Sprint(v)}
+	default:
+		return types.Value{Type: types.ValueTypeString, Value: fmt.Sprint(v)}
+	}
+}
+
+// metaKey is the reserved template key holding the original call's metadata,
+// e.g. {{ ._meta.module }}.{{ ._meta.function }} (line {{ ._meta.line }})
+const metaKey = "_meta"
+
+// renderTemplate parses and executes a rule template
+func (t *Transformer) renderTemplate(name, text string, data map[string]any) (string, error) {
 	// Parse and execute template
-	tmpl, err := template.New("code").Parse(rule.CodeTemplate)
+	tmpl, err := template.New(name).Funcs(plugin.TemplateFuncs(t.style)).Parse(text)
 	if err != nil {
-		return "", fmt.Errorf("failed to parse template: %w", err)
+		return "", fmt.Errorf("failed to parse %s template: %w", name, err)
 	}
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		return "", fmt.Errorf("failed to execute template: %w", err)
+		return "", fmt.Errorf("failed to execute %s template: %w", name, err)
 	}
 
-	code := buf.String()
-
-	// Clean up code (remove extra whitespace, etc.)
-	code = strings.TrimSpace(code)
+	// Drop the template's own base indentation, so the generator can indent
+	// the code relative to the call site, then clean up surrounding whitespace
+	code := strings.TrimSpace(dedent(buf.String()))
 
 	return code, nil
 }
 
+// dedent removes the indentation shared by every non-blank line of code
+func dedent(code string) string {
+	lines := strings.Split(code, "\n")
+
+	prefix, found := "", false
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		if !found {
+			prefix, found = indent, true
+			continue
+		}
+		for !strings.HasPrefix(indent, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if prefix == "" {
+		return code
+	}
+
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, prefix)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// reindent rescales the nesting of multi-line code to width spaces per
+// level, taking the smallest indentation in the code as one level. Code
+// indented with tabs, and any code when width is 0, is left as is.
+func reindent(code string, width int) string {
+	if width == 0 || !strings.Contains(code, "\n") || strings.Contains(code, "\n\t") {
+		return code
+	}
+
+	lines := strings.Split(code, "\n")
+	unit := 0
+	for _, line := range lines {
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent > 0 && indent < len(line) && (unit == 0 || indent < unit) {
+			unit = indent
+		}
+	}
+	if unit == 0 || unit == width {
+		return code
+	}
+
+	for i, line := range lines {
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if indent == len(line) {
+			continue
+		}
+		// Alignment beyond whole levels, e.g. of continuation lines, is kept
+		lines[i] = strings.Repeat(" ", indent/unit*width+indent%unit) + line[indent:]
+	}
+	return strings.Join(lines, "\n")
+}
+
+// formatFString re-emits an f-string from its parts, quoted like plain
+// strings. Interpolated expressions are kept as written, so the names they
+// read are checked like variable arguments. Without parts, or when an
+// expression itself uses the quote character or a backslash, the original
+// source text is used.
+func (t *Transformer) formatFString(value types.Value) string {
+	if len(value.Parts) == 0 {
+		return value.String()
+	}
+
+	// Literal text is escaped for the quote, with braces doubled
+	quote := t.style.QuoteChar()
+	escaper := strings.NewReplacer(`\`, `\\`, quote, `\`+quote, "{", "{{", "}", "}}", "\n", `\n`, "\r", `\r`, "\t", `\t`)
+
+	var sb strings.Builder
+	sb.WriteString("f" + quote)
+	for _, part := range value.Parts {
+		if part.Expression == "" {
+			sb.WriteString(escaper.Replace(part.Literal))
+			continue
+		}
+		if strings.ContainsAny(part.Expression+part.FormatSpec, quote+`\`) {
+			return value.String()
+		}
+
+		expr := part.Expression
+		if strings.HasPrefix(expr, "{") || strings.HasPrefix(expr, "lambda") {
+			expr = "(" + expr + ")" // "{{" would read as an escaped brace, ":" as a format spec
+		}
+		sb.WriteString("{" + expr)
+		if part.Conversion != "" {
+			sb.WriteString("!" + part.Conversion)
+		}
+		if part.FormatSpec != "" {
+			sb.WriteString(":" + part.FormatSpec)
+		}
+		sb.WriteString("}")
+	}
+	sb.WriteString(quote)
+	return sb.String()
+}
+
 // formatValue formats a value for code generation
 func (t *Transformer) formatValue(value types.Value) string {
 	switch value.Type {
 	case types.ValueTypeString:
-		// String values should be quoted
-		return fmt.Sprintf("'%v'", value.Value)
+		// String values are quoted in the configured style
+		if str, ok := value.AsString(); ok {
+			return t.style.QuoteString(str)
+		}
+		return t.style.QuoteString(fmt.Sprint(value.Value))
 
 	case types.ValueTypeNumber:
 		// Numbers are used as-is
 		return fmt.Sprintf("%v", value.Value)
 
 	case types.ValueTypeBool:
-		// Booleans: True/False (Python)
-		if b, ok := value.Value.(bool); ok {
-			if b {
-				return "True"
-			}
-			return "False"
-		}
-		return "False"
+		// Booleans: True/False (Python). A value that is not a bool is kept
+		// as written rather than guessed, so validation can catch it.
+		return value.String()
 
 	case types.ValueTypeVariable:
 		// Variables are used as-is (no quotes)
@@ -158,6 +706,13 @@ func (t *Transformer) formatValue(value types.Value) string {
 	case types.ValueTypeNone:
 		return "None"
 
+	case types.ValueTypeCall:
+		// Calls are passed through as source text
+		return fmt.Sprintf("%v", value.Value)
+
+	case types.ValueTypeFString:
+		return t.formatFString(value)
+
 	default:
 		return fmt.Sprintf("%v", value.Value)
 	}
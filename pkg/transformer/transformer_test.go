@@ -1,8 +1,12 @@
 package transformer
 
 import (
+	"errors"
+	"os"
+	"strings"
 	"testing"
 
+	"github.com/QodeSrl/infrar-engine/pkg/detector"
 	"github.com/QodeSrl/infrar-engine/pkg/plugin"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
@@ -23,10 +27,10 @@ func TestTransformer_Transform(t *testing.T) {
     Bucket={{ .bucket }},
     Key={{ .destination }}
 )`,
-		ParameterMapping: map[string]string{
-			"bucket":      "Bucket",
-			"source":      "Filename",
-			"destination": "Key",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket":      {"Bucket"},
+			"source":      {"Filename"},
+			"destination": {"Key"},
 		},
 	}
 
@@ -72,10 +76,10 @@ func TestTransformer_MissingParameter(t *testing.T) {
 	rule := types.TransformationRule{
 		Pattern:  "infrar.storage.upload",
 		Provider: types.ProviderAWS,
-		ParameterMapping: map[string]string{
-			"bucket":      "Bucket",
-			"source":      "Filename",
-			"destination": "Key",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket":      {"Bucket"},
+			"source":      {"Filename"},
+			"destination": {"Key"},
 		},
 		CodeTemplate: "s3.upload_file(...)",
 	}
@@ -129,6 +133,11 @@ func TestTransformer_FormatValue(t *testing.T) {
 			value: types.Value{Type: types.ValueTypeBool, Value: false},
 			want:  "False",
 		},
+		{
+			name:  "Bool that is not a bool",
+			value: types.Value{Type: types.ValueTypeBool, Value: "True"},
+			want:  "True",
+		},
 		{
 			name:  "Variable",
 			value: types.Value{Type: types.ValueTypeVariable, Value: "my_var"},
@@ -139,6 +148,23 @@ func TestTransformer_FormatValue(t *testing.T) {
 			value: types.Value{Type: types.ValueTypeNone, Value: nil},
 			want:  "None",
 		},
+		{
+			name: "F-string",
+			value: types.Value{Type: types.ValueTypeFString, Value: `f"it's {name!r:>{width}}"`, Parts: []types.FStringPart{
+				{Literal: "it's {"},
+				{Expression: "name", Conversion: "r", FormatSpec: ">{width}"},
+				{Literal: "}\n"},
+			}},
+			want: `f'it\'s {{{name!r:>{width}}}}\n'`,
+		},
+		{
+			name: "F-string with a quoted expression",
+			value: types.Value{Type: types.ValueTypeFString, Value: `f"{row['id']}.txt"`, Parts: []types.FStringPart{
+				{Expression: "row['id']"},
+				{Literal: ".txt"},
+			}},
+			want: `f"{row['id']}.txt"`,
+		},
 	}
 
 	for _, tt := range tests {
@@ -150,3 +176,483 @@ func TestTransformer_FormatValue(t *testing.T) {
 		})
 	}
 }
+
+func TestTransformer_LenientUnmatchedCall(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .source }})",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"source": {"Filename"},
+		},
+	})
+
+	calls := []types.InfrarCall{
+		{
+			Module:   "infrar.storage",
+			Function: "upload",
+			Arguments: map[string]types.Value{
+				"source": {Type: types.ValueTypeString, Value: "file.txt"},
+			},
+			LineNumber: 3,
+		},
+		{
+			Module:     "infrar.storage",
+			Function:   "archive",
+			LineNumber: 4,
+		},
+	}
+
+	// Strict by default
+	if _, err := New(registry).TransformMultiple(calls); err == nil {
+		t.Error("Expected error for unmatched call in strict mode")
+	}
+
+	transformer := New(registry)
+	transformer.SetLenient(true)
+
+	transformed, err := transformer.TransformMultiple(calls)
+	if err != nil {
+		t.Fatalf("TransformMultiple() error = %v", err)
+	}
+
+	if len(transformed) != 1 || transformed[0].OriginalCall.Function != "upload" {
+		t.Fatalf("Expected only the upload call to be transformed, got %v", transformed)
+	}
+
+	warnings := transformer.Warnings()
+	if len(warnings) != 1 {
+		t.Fatalf("Expected 1 warning, got %v", warnings)
+	}
+	if warnings[0].LineNumber != 4 || !strings.Contains(warnings[0].Message, "infrar.storage.archive") {
+		t.Errorf("Unexpected warning: %+v", warnings[0])
+	}
+
+	if unmatched := transformer.Unmatched(); len(unmatched) != 1 || unmatched[0].Function != "archive" {
+		t.Errorf("Expected archive call to be reported as unmatched, got %v", unmatched)
+	}
+}
+
+func TestTransformer_StaticParams(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .source }}, {{ .bucket }}, ExtraArgs={'ServerSideEncryption': {{ .ServerSideEncryption }}, 'Retries': {{ .retries }}})",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket": {"Bucket"},
+			"source": {"Filename"},
+		},
+		StaticParams: map[string]any{
+			"ServerSideEncryption": "AES256",
+			"retries":              3,
+		},
+	})
+
+	call := types.InfrarCall{
+		Module:   "infrar.storage",
+		Function: "upload",
+		Arguments: map[string]types.Value{
+			"bucket": {Type: types.ValueTypeString, Value: "data"},
+			"source": {Type: types.ValueTypeString, Value: "file.txt"},
+		},
+		LineNumber: 1,
+	}
+
+	transformed, err := New(registry).Transform(call)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file('file.txt', 'data', ExtraArgs={'ServerSideEncryption': 'AES256', 'Retries': 3})"
+	if transformed.TransformedCode != want {
+		t.Errorf("Transform() = %s, want %s", transformed.TransformedCode, want)
+	}
+}
+
+func TestTransformer_ValueMapping(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .source }}, {{ .bucket }}, ExtraArgs={'ACL': {{ .ACL }}})",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket":     {"Bucket"},
+			"source":     {"Filename"},
+			"visibility": {"ACL"},
+		},
+		ValueMapping: map[string]types.ValueMap{
+			"visibility": {"public": "public-read", "private": "private"},
+		},
+	})
+
+	upload := func(visibility types.Value) types.InfrarCall {
+		return types.InfrarCall{
+			Module:   "infrar.storage",
+			Function: "upload",
+			Arguments: map[string]types.Value{
+				"bucket":     {Type: types.ValueTypeString, Value: "data"},
+				"source":     {Type: types.ValueTypeString, Value: "file.txt"},
+				"visibility": visibility,
+			},
+			LineNumber: 1,
+		}
+	}
+
+	tests := []struct {
+		name       string
+		visibility types.Value
+		want       string
+		warning    string
+	}{
+		{
+			name:       "Mapped enum value",
+			visibility: types.Value{Type: types.ValueTypeString, Value: "public"},
+			want:       "'ACL': 'public-read'",
+		},
+		{
+			name:       "Unknown enum value",
+			visibility: types.Value{Type: types.ValueTypeString, Value: "internal"},
+			want:       "'ACL': 'internal'",
+			warning:    "visibility of infrar.storage.upload is 'internal', which has no value mapping (known: private, public) - passed through unchanged",
+		},
+		{
+			name:       "Runtime value",
+			visibility: types.Value{Type: types.ValueTypeVariable, Value: "acl"},
+			want:       "'ACL': acl",
+			warning:    "visibility of infrar.storage.upload is acl, which cannot be mapped before it runs - passed through unchanged",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			transformer := New(registry)
+			transformed, err := transformer.TransformMultiple([]types.InfrarCall{upload(tt.visibility)})
+			if err != nil {
+				t.Fatalf("TransformMultiple() error = %v", err)
+			}
+			if !strings.Contains(transformed[0].TransformedCode, tt.want) {
+				t.Errorf("TransformedCode = %s, want it to contain %s", transformed[0].TransformedCode, tt.want)
+			}
+
+			var warnings []string
+			for _, w := range transformer.Warnings() {
+				if w.Category == "value-mapping" {
+					warnings = append(warnings, w.Message)
+				}
+			}
+			if tt.warning == "" && len(warnings) != 0 {
+				t.Errorf("Warnings() = %v, want none", warnings)
+			}
+			if tt.warning != "" && (len(warnings) != 1 || warnings[0] != tt.warning) {
+				t.Errorf("Warnings() = %v, want [%s]", warnings, tt.warning)
+			}
+		})
+	}
+}
+
+func TestTransformer_Defaults(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .source }}, {{ .bucket }}, {{ .destination }})",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket":      {"Bucket"},
+			"source":      {"Filename"},
+			"destination": {"Key"},
+		},
+		Defaults: map[string]string{
+			"destination": "{{ base .source }}",
+		},
+	})
+
+	tests := []struct {
+		name    string
+		args    map[string]types.Value
+		want    string
+		wantErr string
+	}{
+		{
+			name: "Destination derived from source",
+			args: map[string]types.Value{
+				"bucket": {Type: types.ValueTypeString, Value: "data"},
+				"source": {Type: types.ValueTypeString, Value: "reports/2024/q1.csv"},
+			},
+			want: "s3.upload_file('reports/2024/q1.csv', 'data', 'q1.csv')",
+		},
+		{
+			name: "Explicit destination wins",
+			args: map[string]types.Value{
+				"bucket":      {Type: types.ValueTypeString, Value: "data"},
+				"source":      {Type: types.ValueTypeString, Value: "reports/2024/q1.csv"},
+				"destination": {Type: types.ValueTypeString, Value: "latest.csv"},
+			},
+			want: "s3.upload_file('reports/2024/q1.csv', 'data', 'latest.csv')",
+		},
+		{
+			name: "Source resolved from a constant",
+			args: map[string]types.Value{
+				"bucket": {Type: types.ValueTypeString, Value: "data"},
+				"source": {Type: types.ValueTypeVariable, Value: "REPORT", Resolved: &types.Value{Type: types.ValueTypeString, Value: "out/report.pdf"}},
+			},
+			want: "s3.upload_file(REPORT, 'data', 'report.pdf')",
+		},
+		{
+			name: "Source only known at runtime",
+			args: map[string]types.Value{
+				"bucket": {Type: types.ValueTypeString, Value: "data"},
+				"source": {Type: types.ValueTypeVariable, Value: "path"},
+			},
+			wantErr: "cannot compute default destination of infrar.storage.upload",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := types.InfrarCall{
+				Module:     "infrar.storage",
+				Function:   "upload",
+				Arguments:  tt.args,
+				LineNumber: 1,
+			}
+			transformed, err := New(registry).Transform(call)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Transform() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Transform() error = %v", err)
+			}
+			if transformed.TransformedCode != tt.want {
+				t.Errorf("Transform() = %s, want %s", transformed.TransformedCode, tt.want)
+			}
+			_, explicit := tt.args["destination"]
+			if _, ok := transformed.OriginalCall.Arguments["destination"]; ok != explicit {
+				t.Errorf("OriginalCall.Arguments = %v, want the call's own arguments", transformed.OriginalCall.Arguments)
+			}
+		})
+	}
+}
+
+func TestTransformer_ParamTypes(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .source }}, Timeout={{ .timeout }}, Verify={{ .enabled }})",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"source":  {"Filename"},
+			"timeout": {"Timeout"},
+			"enabled": {"Verify"},
+		},
+		ParamTypes: map[string]types.ValueType{
+			"timeout": types.ValueTypeNumber,
+			"enabled": types.ValueTypeBool,
+			"source":  types.ValueTypeString,
+		},
+	})
+
+	tests := []struct {
+		name        string
+		timeout     types.Value
+		source      types.Value
+		wantMessage string
+	}{
+		{
+			name:    "Matching types",
+			timeout: types.Value{Type: types.ValueTypeNumber, Value: float64(30)},
+			source:  types.Value{Type: types.ValueTypeString, Value: "file.txt"},
+		},
+		{
+			name:    "Variables are not checked",
+			timeout: types.Value{Type: types.ValueTypeVariable, Value: "TIMEOUT"},
+			source:  types.Value{Type: types.ValueTypeFString, Value: "f'{name}.txt'"},
+		},
+		{
+			name:        "String for a number",
+			timeout:     types.Value{Type: types.ValueTypeString, Value: "30"},
+			source:      types.Value{Type: types.ValueTypeString, Value: "file.txt"},
+			wantMessage: "parameter timeout of upload must be a number, got string '30'",
+		},
+		{
+			name: "Resolved constant of the wrong type",
+			timeout: types.Value{
+				Type:     types.ValueTypeVariable,
+				Value:    "TIMEOUT",
+				Resolved: &types.Value{Type: types.ValueTypeString, Value: "30"},
+			},
+			source:      types.Value{Type: types.ValueTypeString, Value: "file.txt"},
+			wantMessage: "parameter timeout of upload must be a number, got string '30'",
+		},
+		{
+			name:        "Number for a string",
+			timeout:     types.Value{Type: types.ValueTypeNumber, Value: float64(30)},
+			source:      types.Value{Type: types.ValueTypeNumber, Value: float64(7)},
+			wantMessage: "parameter source of upload must be a string, got number 7",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			call := types.InfrarCall{
+				Module:   "infrar.storage",
+				Function: "upload",
+				Arguments: map[string]types.Value{
+					"source":  tt.source,
+					"timeout": tt.timeout,
+					"enabled": {Type: types.ValueTypeBool, Value: true},
+				},
+				LineNumber: 4,
+			}
+
+			_, err := New(registry).Transform(call)
+			if tt.wantMessage == "" {
+				if err != nil {
+					t.Fatalf("Transform() error = %v", err)
+				}
+				return
+			}
+
+			var terr *types.TransformationError
+			if !errors.As(err, &terr) {
+				t.Fatalf("Transform() error = %v, want a TransformationError", err)
+			}
+			if terr.Message != tt.wantMessage {
+				t.Errorf("Message = %q, want %q", terr.Message, tt.wantMessage)
+			}
+			if terr.Line != 4 {
+				t.Errorf("Line = %d, want 4", terr.Line)
+			}
+			if terr.Suggestion == "" {
+				t.Error("Suggestion is empty")
+			}
+		})
+	}
+}
+
+func TestTransformer_MultipleTargets(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .Filename }}, {{ .Bucket }}, {{ .Key }})",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket": {"Bucket"},
+			"source": {"Filename", "Key"},
+		},
+	})
+
+	call := types.InfrarCall{
+		Module:   "infrar.storage",
+		Function: "upload",
+		Arguments: map[string]types.Value{
+			"bucket": {Type: types.ValueTypeString, Value: "data"},
+			"source": {Type: types.ValueTypeVariable, Value: "path"},
+		},
+		LineNumber: 1,
+	}
+
+	transformed, err := New(registry).Transform(call)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file(path, 'data', path)"
+	if transformed.TransformedCode != want {
+		t.Errorf("Transform() = %s, want %s", transformed.TransformedCode, want)
+	}
+}
+
+func TestTransformer_CallMetadata(t *testing.T) {
+	registry := plugin.NewRegistry()
+	registry.Register(types.TransformationRule{
+		Pattern:      "infrar.storage.upload",
+		Provider:     types.ProviderAWS,
+		CodeTemplate: "s3.upload_file({{ .source }}, {{ .bucket }})  # migrated from {{ ._meta.module }}.{{ ._meta.function }} (line {{ ._meta.line }}, col {{ ._meta.column }}): {{ ._meta.source }}",
+		ParameterMapping: map[string]types.ParameterTargets{
+			"bucket": {"Bucket"},
+			"source": {"Filename"},
+		},
+	})
+
+	call := types.InfrarCall{
+		Module:   "infrar.storage",
+		Function: "upload",
+		Arguments: map[string]types.Value{
+			"bucket": {Type: types.ValueTypeString, Value: "data"},
+			"source": {Type: types.ValueTypeString, Value: "a.txt"},
+			"_meta":  {Type: types.ValueTypeString, Value: "user value"}, // Cannot shadow the reserved key
+		},
+		LineNumber:   12,
+		ColumnOffset: 4,
+		SourceCode:   "upload(bucket='data', source='a.txt')",
+	}
+
+	transformed, err := New(registry).Transform(call)
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	want := "s3.upload_file('a.txt', 'data')  # migrated from infrar.storage.upload (line 12, col 4): upload(bucket='data', source='a.txt')"
+	if transformed.TransformedCode != want {
+		t.Errorf("Transform() = %s, want %s", transformed.TransformedCode, want)
+	}
+}
+
+func TestDedent(t *testing.T) {
+	tests := []struct {
+		code string
+		want string
+	}{
+		{"    a(\n        b,\n    )", "a(\n    b,\n)"},
+		{"\ta(\n\t\tb\n\t)", "a(\n\tb\n)"},
+		{"x = {\n    'a': 1}", "x = {\n    'a': 1}"},
+		{"  a(\n\n    b)", "a(\n\n  b)"}, // Blank lines don't count
+		{"a", "a"},
+	}
+
+	for _, tt := range tests {
+		if got := dedent(tt.code); got != tt.want {
+			t.Errorf("dedent(%q) = %q, want %q", tt.code, got, tt.want)
+		}
+	}
+}
+
+func BenchmarkTransformer_TransformMultiple(b *testing.B) {
+	rules, err := plugin.NewLoader("../../test-plugins").LoadRules(types.ProviderAWS, "storage")
+	if err != nil {
+		b.Fatalf("LoadRules() error = %v", err)
+	}
+	registry := plugin.NewProviderRegistry(types.ProviderAWS)
+	registry.RegisterMultiple(rules)
+
+	calls := benchmarkCalls(b)
+	transformer := New(registry)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := transformer.TransformMultiple(calls); err != nil {
+			b.Fatalf("TransformMultiple() error = %v", err)
+		}
+	}
+}
+
+// benchmarkCalls detects the Infrar calls in the shared benchmark fixture
+func benchmarkCalls(b *testing.B) []types.InfrarCall {
+	b.Helper()
+
+	source, err := os.ReadFile("../../testdata/benchmark.py")
+	if err != nil {
+		b.Fatalf("Failed to read fixture: %v", err)
+	}
+	calls, err := detector.NewDetector().DetectFromSource(string(source), types.LanguagePython)
+	if err != nil {
+		b.Fatalf("DetectFromSource() error = %v", err)
+	}
+	return calls
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
 // AST represents parsed source code
 type AST struct {
 	Language   Language          `json:"language"`
@@ -21,39 +27,104 @@ type Node struct {
 
 // Import represents an import statement
 type Import struct {
-	Module string   `json:"module"` // "infrar.storage"
-	Names  []string `json:"names"`  // ["upload", "download"]
-	Alias  string   `json:"alias,omitempty"` // Optional alias
-	LineNumber int  `json:"lineno"`
+	Module        string   `json:"module"`          // "infrar.storage"
+	Names         []string `json:"names"`           // ["upload", "download"]
+	Alias         string   `json:"alias,omitempty"` // Optional alias
+	LineNumber    int      `json:"lineno"`
+	EndLineNumber int      `json:"end_lineno,omitempty"` // Last line of a multi-line import
+	TopLevel      bool     `json:"top_level,omitempty"`  // Statement is directly in the module body
+}
+
+// SDKPrefix is the package name of the Infrar SDK, which rule patterns start with
+const SDKPrefix = "infrar"
+
+// CanonicalModule returns module with the SDK package name prefix replaced
+// by SDKPrefix, e.g. mycorp_infra.storage as infrar.storage for an SDK
+// vendored as mycorp_infra. Modules outside prefix are returned unchanged.
+func CanonicalModule(module, prefix string) string {
+	if prefix == "" || prefix == SDKPrefix {
+		return module
+	}
+	if module == prefix || strings.HasPrefix(module, prefix+".") {
+		return SDKPrefix + strings.TrimPrefix(module, prefix)
+	}
+	return module
 }
 
 // Value represents a value in function arguments
 type Value struct {
-	Type  ValueType `json:"type"`
-	Value any       `json:"value"`
+	Type     ValueType     `json:"type"`
+	Value    any           `json:"value"`
+	Resolved *Value        `json:"resolved,omitempty"` // Literal a variable was bound to, when known
+	Call     *InfrarCall   `json:"call,omitempty"`     // Infrar call held by a call value, set by the detector
+	Parts    []FStringPart `json:"parts,omitempty"`    // Literal text and interpolations of an f-string value
+}
+
+// FStringPart is a piece of an f-string: literal text, or an interpolated
+// expression with its optional conversion and format spec
+type FStringPart struct {
+	Literal    string `json:"literal,omitempty"`
+	Expression string `json:"expression,omitempty"`  // Source of the expression, e.g. "name"
+	Conversion string `json:"conversion,omitempty"`  // "r", "s" or "a"
+	FormatSpec string `json:"format_spec,omitempty"` // As written after the colon, e.g. ">{width}"
 }
 
-// String returns the string representation of a value
+// String returns the string representation of a value. A value whose Go
+// type does not match its Type falls back to its default formatting instead
+// of panicking.
 func (v Value) String() string {
 	if v.Value == nil {
 		return ""
 	}
 
 	switch v.Type {
-	case ValueTypeString:
-		return v.Value.(string)
+	case ValueTypeString, ValueTypeVariable, ValueTypeCall, ValueTypeFString:
+		if s, ok := v.AsString(); ok {
+			return s
+		}
 	case ValueTypeNumber:
-		return v.Value.(string)
+		if n, ok := v.AsNumber(); ok {
+			return n
+		}
 	case ValueTypeBool:
-		if v.Value.(bool) {
-			return "True"
+		if b, ok := v.AsBool(); ok {
+			if b {
+				return "True"
+			}
+			return "False"
 		}
-		return "False"
-	case ValueTypeVariable:
-		return v.Value.(string)
 	case ValueTypeNone:
 		return "None"
 	default:
 		return ""
 	}
+
+	return fmt.Sprint(v.Value)
+}
+
+// AsString returns the value as a string, if it holds one
+func (v Value) AsString() (string, bool) {
+	s, ok := v.Value.(string)
+	return s, ok
+}
+
+// AsBool returns the value as a bool, if it holds one
+func (v Value) AsBool() (bool, bool) {
+	b, ok := v.Value.(bool)
+	return b, ok
+}
+
+// AsNumber returns the value as number text. Numbers decoded from JSON are
+// float64 and are formatted without a trailing fraction when whole.
+func (v Value) AsNumber() (string, bool) {
+	switch n := v.Value.(type) {
+	case string:
+		return n, true
+	case float64:
+		return strconv.FormatFloat(n, 'f', -1, 64), true
+	case int:
+		return strconv.Itoa(n), true
+	default:
+		return "", false
+	}
 }
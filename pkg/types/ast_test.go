@@ -0,0 +1,55 @@
+package types
+
+import "testing"
+
+func TestValue_String(t *testing.T) {
+	tests := []struct {
+		name  string
+		value Value
+		want  string
+	}{
+		{"String", Value{Type: ValueTypeString, Value: "data"}, "data"},
+		{"Number text", Value{Type: ValueTypeNumber, Value: "42"}, "42"},
+		{"Number from JSON", Value{Type: ValueTypeNumber, Value: float64(42)}, "42"},
+		{"Fractional number", Value{Type: ValueTypeNumber, Value: 1.5}, "1.5"},
+		{"Bool", Value{Type: ValueTypeBool, Value: true}, "True"},
+		{"Variable", Value{Type: ValueTypeVariable, Value: "BUCKET"}, "BUCKET"},
+		{"None", Value{Type: ValueTypeNone}, ""},
+		{"Call", Value{Type: ValueTypeCall, Value: "f(x)"}, "f(x)"},
+
+		// Mismatched Go types fall back instead of panicking
+		{"String holding a number", Value{Type: ValueTypeString, Value: float64(7)}, "7"},
+		{"Bool holding a string", Value{Type: ValueTypeBool, Value: "yes"}, "yes"},
+		{"Number holding a bool", Value{Type: ValueTypeNumber, Value: false}, "false"},
+		{"Variable holding a list", Value{Type: ValueTypeVariable, Value: []any{"a"}}, "[a]"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.value.String(); got != tt.want {
+				t.Errorf("String() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValue_Accessors(t *testing.T) {
+	mismatched := Value{Type: ValueTypeString, Value: 3}
+
+	if _, ok := mismatched.AsString(); ok {
+		t.Error("AsString() ok = true for an int value")
+	}
+	if _, ok := mismatched.AsBool(); ok {
+		t.Error("AsBool() ok = true for an int value")
+	}
+	if n, ok := mismatched.AsNumber(); !ok || n != "3" {
+		t.Errorf("AsNumber() = %q, %v, want \"3\", true", n, ok)
+	}
+
+	if b, ok := (Value{Type: ValueTypeBool, Value: true}).AsBool(); !ok || !b {
+		t.Errorf("AsBool() = %v, %v, want true, true", b, ok)
+	}
+	if _, ok := (Value{Type: ValueTypeNumber, Value: "x"}).AsBool(); ok {
+		t.Error("AsBool() ok = true for a string value")
+	}
+}
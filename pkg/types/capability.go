@@ -0,0 +1,51 @@
+package types
+
+import "sort"
+
+// CapabilityMatrix records the operations each provider supports, as
+// capability -> operation patterns per provider. It is built from loaded
+// rules to compare provider coverage before transforming.
+type CapabilityMatrix map[Provider]map[string][]string
+
+// Supports reports whether provider has a rule for the operation pattern
+func (m CapabilityMatrix) Supports(provider Provider, pattern string) bool {
+	for _, patterns := range m[provider] {
+		for _, p := range patterns {
+			if p == pattern {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Operations returns every operation pattern any provider supports, sorted
+func (m CapabilityMatrix) Operations() []string {
+	seen := make(map[string]bool)
+	for _, capabilities := range m {
+		for _, patterns := range capabilities {
+			for _, p := range patterns {
+				seen[p] = true
+			}
+		}
+	}
+
+	ops := make([]string, 0, len(seen))
+	for p := range seen {
+		ops = append(ops, p)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+// Missing returns the operations other providers in the matrix support but
+// provider does not, sorted
+func (m CapabilityMatrix) Missing(provider Provider) []string {
+	var missing []string
+	for _, op := range m.Operations() {
+		if !m.Supports(provider, op) {
+			missing = append(missing, op)
+		}
+	}
+	return missing
+}
@@ -0,0 +1,35 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCapabilityMatrix(t *testing.T) {
+	matrix := CapabilityMatrix{
+		ProviderAWS: {
+			"storage":  {"infrar.storage.delete", "infrar.storage.upload"},
+			"database": {"infrar.database.query"},
+		},
+		ProviderAzure: {
+			"storage": {"infrar.storage.upload"},
+		},
+	}
+
+	if !matrix.Supports(ProviderAWS, "infrar.database.query") {
+		t.Error("Supports(aws, query) = false, want true")
+	}
+	if matrix.Supports(ProviderGCP, "infrar.storage.upload") {
+		t.Error("Supports(gcp, upload) = true for a provider not in the matrix")
+	}
+
+	wantOps := []string{"infrar.database.query", "infrar.storage.delete", "infrar.storage.upload"}
+	if got := matrix.Operations(); !reflect.DeepEqual(got, wantOps) {
+		t.Errorf("Operations() = %v, want %v", got, wantOps)
+	}
+
+	wantMissing := []string{"infrar.database.query", "infrar.storage.delete"}
+	if got := matrix.Missing(ProviderAzure); !reflect.DeepEqual(got, wantMissing) {
+		t.Errorf("Missing(azure) = %v, want %v", got, wantMissing)
+	}
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
 // PluginManifest represents metadata about a plugin
 type PluginManifest struct {
 	Name        string   `yaml:"name"`
@@ -10,11 +16,16 @@ type PluginManifest struct {
 
 // OperationRule represents a transformation rule for a single operation
 type OperationRule struct {
-	Name             string                 `yaml:"name"`
-	Pattern          string                 `yaml:"pattern"`
-	Target           TargetConfig           `yaml:"target"`
-	Transformation   TransformationConfig   `yaml:"transformation"`
-	Requirements     []Requirement          `yaml:"requirements,omitempty"`
+	Name           string               `yaml:"name" schema:"required"`
+	Pattern        string               `yaml:"pattern" schema:"required"`
+	Aliases        []string             `yaml:"aliases,omitempty"`  // Synonym patterns, e.g. "infrar.storage.put"
+	Language       Language             `yaml:"language,omitempty"` // Source language, defaults to python
+	Target         TargetConfig         `yaml:"target"`
+	Transformation TransformationConfig `yaml:"transformation" schema:"required"`
+	Requirements   []Requirement        `yaml:"requirements,omitempty"`
+	Deprecated     bool                 `yaml:"deprecated,omitempty"`  // Operation is deprecated in the Infrar SDK
+	Replacement    string               `yaml:"replacement,omitempty"` // Pattern to use instead, e.g. "infrar.storage.upload"
+	Examples       []RuleExample        `yaml:"examples,omitempty"`    // Input/output pairs checked by Engine.TestPlugin
 }
 
 // TargetConfig describes the target provider configuration
@@ -26,13 +37,88 @@ type TargetConfig struct {
 
 // TransformationConfig describes how to perform the transformation
 type TransformationConfig struct {
-	Imports          []string          `yaml:"imports"`
-	SetupCode        string            `yaml:"setup_code,omitempty"`
-	CodeTemplate     string            `yaml:"code_template"`
-	ParameterMapping map[string]string `yaml:"parameter_mapping"`
+	Imports          []string                    `yaml:"imports"`
+	SetupCode        string                      `yaml:"setup_code,omitempty"`
+	SetupScope       string                      `yaml:"setup_scope,omitempty"` // "module" (default) or "function"
+	CodeTemplate     string                      `yaml:"code_template" schema:"required"`
+	WrapperTemplate  string                      `yaml:"wrapper_template,omitempty"` // Wraps the rendered call, e.g. in try/except
+	ContextTemplate  string                      `yaml:"context_template,omitempty"` // Replaces code_template for calls used in a with statement
+	ParameterMapping map[string]ParameterTargets `yaml:"parameter_mapping"`
+	StaticParams     map[string]any              `yaml:"static_params,omitempty"`   // Constants injected into the template data, e.g. ServerSideEncryption: AES256
+	ValueMapping     map[string]ValueMap         `yaml:"value_mapping,omitempty"`   // Provider values of a parameter's string literals, e.g. visibility: {public: public-read}
+	Defaults         map[string]string           `yaml:"defaults,omitempty"`        // Computed values of omitted parameters, e.g. destination: "{{ base .source }}"
+	OptionalParams   []string                    `yaml:"optional_params,omitempty"` // Parameters a call may omit; empty in the template data when unset
+	ParamTypes       map[string]ValueType        `yaml:"param_types,omitempty"`     // Literal type each listed parameter must have: string, number or bool
+	Client           ClientConfig                `yaml:"client,omitempty"`          // Client the templates reach as {{ .client }}
+}
+
+// ClientConfig describes the provider client a rule's calls go through.
+// Templates reach it as {{ .client }}: the Code expression itself, or, when
+// the client is shareable and the engine shares clients, Name, bound once in
+// the setup code.
+type ClientConfig struct {
+	Name      string `yaml:"name" schema:"required"` // Variable holding a shared client, e.g. "s3"
+	Code      string `yaml:"code" schema:"required"` // Expression creating the client, e.g. "boto3.client('s3')"
+	Shareable bool   `yaml:"shareable,omitempty"`    // One client may serve every call
+}
+
+// RuleExample is a sample transformation shipped with a rule: Input is
+// transformed and must produce Output, modulo trailing newlines
+type RuleExample struct {
+	Name   string `yaml:"name,omitempty"`
+	Input  string `yaml:"input" schema:"required"`
+	Output string `yaml:"output" schema:"required"`
+}
+
+// ExampleResult is the outcome of checking one RuleExample
+type ExampleResult struct {
+	Capability string   `json:"capability"`
+	Provider   Provider `json:"provider"`
+	Rule       string   `json:"rule"`
+	Example    string   `json:"example"` // The example's name, or its 1-based position
+	Passed     bool     `json:"passed"`
+	Expected   string   `json:"expected"`
+	Actual     string   `json:"actual,omitempty"`
+	Error      string   `json:"error,omitempty"` // Set when the input failed to transform
+}
+
+// RuleLint is a problem Engine.LintRules found in a plugin's rules. Rule is
+// empty for problems with a whole rules file or directory.
+type RuleLint struct {
+	File       string   `json:"file"`
+	Capability string   `json:"capability"`
+	Provider   Provider `json:"provider"`
+	Rule       string   `json:"rule,omitempty"`
+	Message    string   `json:"message"`
 }
 
 // PluginRules represents all transformation rules from a plugin
 type PluginRules struct {
-	Operations []OperationRule `yaml:"operations"`
+	Operations []OperationRule `yaml:"operations" schema:"required"`
+}
+
+// ValueMap maps the string literals a parameter accepts to the provider's
+// values for them, e.g. public to public-read
+type ValueMap map[string]string
+
+// ParameterTargets lists the target parameters an Infrar parameter maps to.
+// In YAML it is written as a single name or as a list of names.
+type ParameterTargets []string
+
+// UnmarshalYAML accepts either a scalar target name or a sequence of names
+func (p *ParameterTargets) UnmarshalYAML(node *yaml.Node) error {
+	switch node.Kind {
+	case yaml.ScalarNode:
+		*p = ParameterTargets{node.Value}
+		return nil
+	case yaml.SequenceNode:
+		var targets []string
+		if err := node.Decode(&targets); err != nil {
+			return err
+		}
+		*p = targets
+		return nil
+	default:
+		return fmt.Errorf("line %d: parameter mapping must be a name or a list of names", node.Line)
+	}
 }
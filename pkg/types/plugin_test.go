@@ -0,0 +1,46 @@
+package types
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestParameterTargets_UnmarshalYAML(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]ParameterTargets
+		wantErr bool
+	}{
+		{
+			name:  "scalar",
+			input: "bucket: Bucket",
+			want:  map[string]ParameterTargets{"bucket": {"Bucket"}},
+		},
+		{
+			name:  "list",
+			input: "source: [Filename, Key]",
+			want:  map[string]ParameterTargets{"source": {"Filename", "Key"}},
+		},
+		{
+			name:    "mapping",
+			input:   "source: {name: Key}",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got map[string]ParameterTargets
+			err := yaml.Unmarshal([]byte(tt.input), &got)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Unmarshal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Unmarshal() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,5 +1,11 @@
 package types
 
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
 // Provider represents a cloud provider
 type Provider string
 
@@ -14,14 +20,57 @@ func (p Provider) String() string {
 	return string(p)
 }
 
+// Providers returns every supported provider
+func Providers() []Provider {
+	return []Provider{ProviderAWS, ProviderGCP, ProviderAzure}
+}
+
 // IsValid checks if the provider is supported
 func (p Provider) IsValid() bool {
-	switch p {
-	case ProviderAWS, ProviderGCP, ProviderAzure:
-		return true
-	default:
-		return false
+	for _, supported := range Providers() {
+		if p == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// providerServices lists the services each provider's rules are known to
+// target, as written in a rule's target.service
+var providerServices = map[Provider][]string{
+	ProviderAWS:   {"dynamodb", "eventbridge", "kinesis", "lambda", "rds", "s3", "secretsmanager", "ses", "sns", "sqs", "ssm"},
+	ProviderGCP:   {"bigquery", "cloud_functions", "cloud_sql", "cloud_storage", "cloud_tasks", "firestore", "pubsub", "secret_manager"},
+	ProviderAzure: {"blob_storage", "cosmos_db", "event_grid", "functions", "key_vault", "queue_storage", "service_bus", "sql_database"},
+}
+
+// Services returns the services the provider's rules are known to target,
+// e.g. s3 for AWS, in name order
+func (p Provider) Services() []string {
+	return providerServices[p]
+}
+
+// HasService reports whether service is one of the provider's known services
+func (p Provider) HasService(service string) bool {
+	for _, known := range providerServices[p] {
+		if service == known {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseProvider converts a provider name such as "aws" or "GCP" into a
+// Provider, rejecting names that are not supported
+func ParseProvider(s string) (Provider, error) {
+	p := Provider(strings.ToLower(strings.TrimSpace(s)))
+	if !p.IsValid() {
+		names := make([]string, 0, len(Providers()))
+		for _, supported := range Providers() {
+			names = append(names, string(supported))
+		}
+		return "", fmt.Errorf("unsupported provider %q (supported: %s)", s, strings.Join(names, ", "))
 	}
+	return p, nil
 }
 
 // Language represents a programming language
@@ -38,6 +87,43 @@ func (l Language) String() string {
 	return string(l)
 }
 
+// Languages returns every source language the engine knows of
+func Languages() []Language {
+	return []Language{LanguagePython, LanguageNodeJS, LanguageGo}
+}
+
+// ParseLanguage converts a language name such as "python" or "NodeJS" into a
+// Language, rejecting names that are not known
+func ParseLanguage(s string) (Language, error) {
+	l := Language(strings.ToLower(strings.TrimSpace(s)))
+	names := make([]string, 0, len(Languages()))
+	for _, known := range Languages() {
+		if l == known {
+			return l, nil
+		}
+		names = append(names, string(known))
+	}
+	return "", fmt.Errorf("unsupported language %q (supported: %s)", s, strings.Join(names, ", "))
+}
+
+// languageExtensions maps file extensions to the language they contain
+var languageExtensions = map[string]Language{
+	".py":  LanguagePython,
+	".pyi": LanguagePython,
+	".js":  LanguageNodeJS,
+	".mjs": LanguageNodeJS,
+	".cjs": LanguageNodeJS,
+	".ts":  LanguageNodeJS,
+	".go":  LanguageGo,
+}
+
+// DetectLanguage infers the source language from a file's extension. The
+// second return value is false when the extension is not recognised.
+func DetectLanguage(path string) (Language, bool) {
+	lang, ok := languageExtensions[strings.ToLower(filepath.Ext(path))]
+	return lang, ok
+}
+
 // ValueType represents the type of a value in the AST
 type ValueType string
 
@@ -45,6 +131,24 @@ const (
 	ValueTypeString   ValueType = "string"
 	ValueTypeNumber   ValueType = "number"
 	ValueTypeBool     ValueType = "bool"
-	ValueTypeVariable ValueType = "variable"
+	ValueTypeVariable ValueType = "variable" // A name or attribute access, e.g. config.bucket_name; Value holds it as written
 	ValueTypeNone     ValueType = "none"
+	ValueTypeCall     ValueType = "call"    // A call expression; Value holds its source text
+	ValueTypeFString  ValueType = "fstring" // An f-string; Value holds its source text, Value.Parts its pieces
 )
+
+// ParamValueTypes returns the value types a rule can require of a parameter
+// through param_types
+func ParamValueTypes() []ValueType {
+	return []ValueType{ValueTypeString, ValueTypeNumber, ValueTypeBool}
+}
+
+// IsParamType checks if the value type can be required through param_types
+func (v ValueType) IsParamType() bool {
+	for _, supported := range ParamValueTypes() {
+		if v == supported {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package types
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseProvider(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Provider
+		wantErr bool
+	}{
+		{input: "aws", want: ProviderAWS},
+		{input: "gcp", want: ProviderGCP},
+		{input: "azure", want: ProviderAzure},
+		{input: "GCP", want: ProviderGCP},
+		{input: " Azure ", want: ProviderAzure},
+		{input: "oracle", wantErr: true},
+		{input: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseProvider(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseProvider(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseProvider(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+			if err != nil && !strings.Contains(err.Error(), "aws, gcp, azure") {
+				t.Errorf("ParseProvider(%q) error = %v, want supported providers listed", tt.input, err)
+			}
+		})
+	}
+}
+
+func TestParseLanguage(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Language
+		wantErr bool
+	}{
+		{input: "python", want: LanguagePython},
+		{input: " NodeJS ", want: LanguageNodeJS},
+		{input: "go", want: LanguageGo},
+		{input: "ruby", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := ParseLanguage(tt.input)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseLanguage(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseLanguage(%q) = %q, want %q", tt.input, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package types
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+)
+
+// RuleSchema returns a JSON Schema describing plugin rules files
+// (PluginRules), for editor validation and autocomplete. It is derived from
+// the structs' yaml tags, so it follows them as fields are added. Fields
+// tagged `schema:"required"` are listed as required.
+func RuleSchema() []byte {
+	schema := schemaFor(reflect.TypeOf(PluginRules{}))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	schema["title"] = "Infrar plugin rules"
+
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		panic(err) // The schema only holds maps, slices and strings
+	}
+	return append(data, '\n')
+}
+
+// schemaFor returns the JSON Schema for values of type t
+func schemaFor(t reflect.Type) map[string]any {
+	switch t {
+	case reflect.TypeOf(ParameterTargets{}):
+		// Written as a single name or a list of names
+		return map[string]any{
+			"oneOf": []any{
+				map[string]any{"type": "string"},
+				map[string]any{"type": "array", "items": map[string]any{"type": "string"}},
+			},
+		}
+	case reflect.TypeOf(Provider("")):
+		var names []string
+		for _, p := range Providers() {
+			names = append(names, p.String())
+		}
+		return map[string]any{"type": "string", "enum": names}
+	case reflect.TypeOf(Language("")):
+		var names []string
+		for _, l := range Languages() {
+			names = append(names, l.String())
+		}
+		return map[string]any{"type": "string", "enum": names}
+	case reflect.TypeOf(ValueType("")):
+		var names []string
+		for _, v := range ParamValueTypes() {
+			names = append(names, string(v))
+		}
+		return map[string]any{"type": "string", "enum": names}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaFor(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaFor(t.Elem())}
+	case reflect.Struct:
+		return structSchema(t)
+	default:
+		return map[string]any{} // Any value, e.g. static parameters
+	}
+}
+
+// structSchema returns the object schema for a struct's yaml-tagged fields
+func structSchema(t reflect.Type) map[string]any {
+	properties := make(map[string]any)
+	var required []string
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		properties[name] = schemaFor(field.Type)
+		if field.Tag.Get("schema") == "required" {
+			required = append(required, name)
+		}
+	}
+
+	schema := map[string]any{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
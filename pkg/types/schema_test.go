@@ -0,0 +1,85 @@
+package types
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestRuleSchema(t *testing.T) {
+	var schema map[string]any
+	if err := json.Unmarshal(RuleSchema(), &schema); err != nil {
+		t.Fatalf("RuleSchema() is not valid JSON: %v", err)
+	}
+
+	operation := schema["properties"].(map[string]any)["operations"].(map[string]any)["items"].(map[string]any)
+	transformation := operation["properties"].(map[string]any)["transformation"].(map[string]any)
+
+	for _, key := range []string{"imports", "setup_code", "code_template", "parameter_mapping", "static_params", "optional_params"} {
+		if _, ok := transformation["properties"].(map[string]any)[key]; !ok {
+			t.Errorf("Expected transformation property %q", key)
+		}
+	}
+	if required := transformation["required"].([]any); len(required) != 1 || required[0] != "code_template" {
+		t.Errorf("transformation required = %v, want [code_template]", required)
+	}
+
+	mapping := transformation["properties"].(map[string]any)["parameter_mapping"].(map[string]any)
+	if _, ok := mapping["additionalProperties"].(map[string]any)["oneOf"]; !ok {
+		t.Errorf("parameter_mapping values should accept a name or a list, got %v", mapping)
+	}
+}
+
+func TestRuleSchema_CoversTestPlugins(t *testing.T) {
+	var schema map[string]any
+	if err := json.Unmarshal(RuleSchema(), &schema); err != nil {
+		t.Fatalf("RuleSchema() is not valid JSON: %v", err)
+	}
+
+	paths, err := filepath.Glob("../../test-plugins/*/*/rules.yaml")
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("No test plugin rules found: %v", err)
+	}
+
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		var rules any
+		if err := yaml.Unmarshal(data, &rules); err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		checkKeys(t, path, schema, rules)
+	}
+}
+
+// checkKeys reports object keys in value that the schema does not declare
+func checkKeys(t *testing.T, path string, schema map[string]any, value any) {
+	t.Helper()
+
+	switch v := value.(type) {
+	case map[string]any:
+		properties, ok := schema["properties"].(map[string]any)
+		if !ok {
+			return // A map of arbitrary keys, such as parameter_mapping
+		}
+		for key, child := range v {
+			sub, ok := properties[key].(map[string]any)
+			if !ok {
+				t.Errorf("%s: key %q is not in the schema", path, key)
+				continue
+			}
+			checkKeys(t, path, sub, child)
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for _, child := range v {
+				checkKeys(t, path, items, child)
+			}
+		}
+	}
+}
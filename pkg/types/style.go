@@ -0,0 +1,44 @@
+package types
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StyleOptions controls the style of generated code, so it matches a
+// project's linters. The zero value is the default style: single-quoted
+// strings, template indentation kept as written and no line length limit.
+type StyleOptions struct {
+	Quote         string // Quote of generated string literals: "'" (default) or `"`
+	IndentWidth   int    // Spaces per nesting level of rendered templates; 0 keeps the template's own
+	MaxLineLength int    // Longer generated lines get a style warning; 0 for no limit
+}
+
+// QuoteChar returns the quote generated string literals use
+func (s StyleOptions) QuoteChar() string {
+	if s.Quote == "" {
+		return "'"
+	}
+	return s.Quote
+}
+
+// QuoteString returns str as a Python string literal in this style
+func (s StyleOptions) QuoteString(str string) string {
+	quote := s.QuoteChar()
+	escaper := strings.NewReplacer(`\`, `\\`, quote, `\`+quote, "\n", `\n`, "\r", `\r`, "\t", `\t`)
+	return quote + escaper.Replace(str) + quote
+}
+
+// Validate reports style settings that cannot be honored
+func (s StyleOptions) Validate() error {
+	if s.Quote != "" && s.Quote != "'" && s.Quote != `"` {
+		return fmt.Errorf("unsupported quote %q (use ' or \")", s.Quote)
+	}
+	if s.IndentWidth < 0 {
+		return fmt.Errorf("indent width must not be negative, got %d", s.IndentWidth)
+	}
+	if s.MaxLineLength < 0 {
+		return fmt.Errorf("max line length must not be negative, got %d", s.MaxLineLength)
+	}
+	return nil
+}
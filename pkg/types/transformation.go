@@ -1,13 +1,32 @@
 package types
 
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
 // InfrarCall represents a detected Infrar SDK usage
 type InfrarCall struct {
-	Module       string           `json:"module"`        // "infrar.storage"
-	Function     string           `json:"function"`      // "upload"
-	Arguments    map[string]Value `json:"arguments"`     // {bucket: "data", source: "file.txt", ...}
-	LineNumber   int              `json:"lineno"`
-	ColumnOffset int              `json:"col_offset"`
-	SourceCode   string           `json:"source_code"`   // Original code snippet
+	Module          string           `json:"module"`                   // "infrar.storage"
+	Function        string           `json:"function"`                 // "upload"
+	Language        Language         `json:"language,omitempty"`       // Source language of the call
+	Arguments       map[string]Value `json:"arguments"`                // {bucket: "data", source: "file.txt", ...}
+	ArgumentOrder   []string         `json:"argument_order,omitempty"` // Argument names in source order
+	LineNumber      int              `json:"lineno"`
+	ColumnOffset    int              `json:"col_offset"`
+	EndLineNumber   int              `json:"end_lineno,omitempty"`     // Last line of the call expression
+	EndColumnOffset int              `json:"end_col_offset,omitempty"` // Byte offset just past the call on its last line
+	SourceCode      string           `json:"source_code"`              // Original code snippet
+	Scope           string           `json:"scope,omitempty"`          // Enclosing function, "" at module level
+	Decorator       bool             `json:"decorator,omitempty"`      // Call is a decorator; bare ones have no arguments
+	InExpression    bool             `json:"in_expression,omitempty"`  // Call is in a lambda or comprehension, where only an expression fits
+	WithItem        bool             `json:"with_item,omitempty"`      // Call is the context manager of a with statement, e.g. with open(...) as fh
+	Spread          []string         `json:"spread,omitempty"`         // Unpacked arguments such as "*args" or "**opts", which hide the real ones
+	Provider        Provider         `json:"provider,omitempty"`       // Target provider set by an "# infrar: provider=..." pragma, overriding the transform's
+	Chain           []string         `json:"chain,omitempty"`          // Earlier calls of a method chain, e.g. ["bucket"] for storage.bucket('data').upload(...)
 }
 
 // FullName returns the full qualified name of the call
@@ -15,23 +34,69 @@ func (c InfrarCall) FullName() string {
 	return c.Module + "." + c.Function
 }
 
+// ArgumentNames returns the names of the call's arguments in source order.
+// Calls without a complete ArgumentOrder, such as ones built by hand, list
+// them sorted, so the order is the same on every run either way.
+func (c InfrarCall) ArgumentNames() []string {
+	if len(c.ArgumentOrder) == len(c.Arguments) {
+		complete := true
+		for _, name := range c.ArgumentOrder {
+			if _, ok := c.Arguments[name]; !ok {
+				complete = false
+				break
+			}
+		}
+		if complete {
+			return c.ArgumentOrder
+		}
+	}
+
+	names := make([]string, 0, len(c.Arguments))
+	for name := range c.Arguments {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // TransformationRule defines how to transform an Infrar call
 type TransformationRule struct {
-	Name             string            `yaml:"name"`
-	Pattern          string            `yaml:"pattern"`          // "infrar.storage.upload"
-	Provider         Provider          `yaml:"provider"`
-	Service          string            `yaml:"service"`          // "s3", "cloud_storage"
-	Imports          []string          `yaml:"imports"`
-	SetupCode        string            `yaml:"setup_code"`       // Client initialization
-	CodeTemplate     string            `yaml:"code_template"`    // Go template
-	ParameterMapping map[string]string `yaml:"parameter_mapping"`
-	Requirements     []Requirement     `yaml:"requirements"`
+	Name             string                      `yaml:"name"`
+	Pattern          string                      `yaml:"pattern"`  // "infrar.storage.upload"
+	Aliases          []string                    `yaml:"aliases"`  // Other patterns the rule also matches
+	Language         Language                    `yaml:"language"` // Source language the rule applies to
+	Provider         Provider                    `yaml:"provider"`
+	Capability       string                      `yaml:"capability"` // "storage", "database"
+	Service          string                      `yaml:"service"`    // "s3", "cloud_storage"
+	Imports          []string                    `yaml:"imports"`
+	SetupCode        string                      `yaml:"setup_code"`       // Client initialization
+	SetupScope       string                      `yaml:"setup_scope"`      // Where setup code goes: SetupScopeModule or SetupScopeFunction
+	CodeTemplate     string                      `yaml:"code_template"`    // Go template
+	WrapperTemplate  string                      `yaml:"wrapper_template"` // Optional Go template around the rendered call ({{ .call }})
+	ContextTemplate  string                      `yaml:"context_template"` // Optional Go template for calls used as a with statement's context manager
+	ParameterMapping map[string]ParameterTargets `yaml:"parameter_mapping"`
+	StaticParams     map[string]any              `yaml:"static_params"`   // Template values the call never supplies
+	ValueMapping     map[string]ValueMap         `yaml:"value_mapping"`   // Literal values of a parameter translated for the provider
+	Defaults         map[string]string           `yaml:"defaults"`        // Templates computing omitted parameters from the call's literal arguments
+	OptionalParams   []string                    `yaml:"optional_params"` // Parameters a call may omit
+	ParamTypes       map[string]ValueType        `yaml:"param_types"`     // Value type required of a parameter's literal
+	Client           ClientConfig                `yaml:"client"`          // Provider client reached as {{ .client }}
+	Requirements     []Requirement               `yaml:"requirements"`
+	Deprecated       bool                        `yaml:"deprecated"`
+	Replacement      string                      `yaml:"replacement"` // Pattern that supersedes a deprecated rule
+	Examples         []RuleExample               `yaml:"examples"`
 }
 
+// Setup code placements
+const (
+	SetupScopeModule   = "module"   // After the module's imports
+	SetupScopeFunction = "function" // At the top of the function enclosing the call
+)
+
 // Requirement represents a package dependency requirement
 type Requirement struct {
-	Package string `yaml:"package"` // "boto3"
-	Version string `yaml:"version"` // ">=1.28.0"
+	Package string `yaml:"package" json:"package"` // "boto3"
+	Version string `yaml:"version" json:"version"` // ">=1.28.0"
 }
 
 // TransformedCall represents a transformed function call
@@ -44,12 +109,85 @@ type TransformedCall struct {
 
 // TransformationResult is the output of transformation
 type TransformationResult struct {
-	Provider        Provider      `json:"provider"`
-	TransformedCode string        `json:"transformed_code"`
-	Imports         []string      `json:"imports"`
-	Requirements    []Requirement `json:"requirements"`
-	Warnings        []Warning     `json:"warnings,omitempty"`
-	Metadata        map[string]any `json:"metadata,omitempty"`
+	Provider        Provider            `json:"provider"`
+	Filepath        string              `json:"filepath,omitempty"` // Original file, when transforming files
+	TransformedCode string              `json:"transformed_code"`
+	Imports         []string            `json:"imports"`
+	ImportSources   map[string][]string `json:"import_sources,omitempty"` // Rule patterns that needed each import, e.g. "import boto3": ["infrar.storage.upload"]
+	Requirements    []Requirement       `json:"requirements"`
+	Warnings        []Warning           `json:"warnings,omitempty"`
+	Report          []CallReport        `json:"report,omitempty"` // One entry per transformed call
+	Gaps            []CallGap           `json:"gaps,omitempty"`   // Detected calls left untransformed for lack of a rule
+	Metrics         *Metrics            `json:"metrics,omitempty"`
+	Metadata        map[string]any      `json:"metadata,omitempty"`
+}
+
+// CallReport records where a transformed call came from and which rule produced it
+type CallReport struct {
+	LineNumber   int    `json:"lineno"`
+	ColumnOffset int    `json:"col_offset"`
+	Original     string `json:"original"`    // Original call snippet
+	Transformed  string `json:"transformed"` // Generated replacement
+	Rule         string `json:"rule"`        // Rule name, e.g. "upload"
+	Pattern      string `json:"pattern"`     // Rule pattern, e.g. "infrar.storage.upload"
+}
+
+// Explanation describes how a single call is transformed and why, see
+// Engine.Explain
+type Explanation struct {
+	Call         InfrarCall         `json:"call"`
+	Rule         string             `json:"rule"`    // Rule name, e.g. "upload"
+	Pattern      string             `json:"pattern"` // Rule pattern, e.g. "infrar.storage.upload"
+	Provider     Provider           `json:"provider"`
+	Capability   string             `json:"capability"`
+	Service      string             `json:"service"`
+	Parameters   []ParameterBinding `json:"parameters"`              // The rule's parameter mapping applied to the call
+	StaticParams map[string]string  `json:"static_params,omitempty"` // Formatted as the templates see them
+	Code         string             `json:"code"`                    // Rendered code (and wrapper) templates
+	Imports      []string           `json:"imports,omitempty"`
+	SetupCode    string             `json:"setup_code,omitempty"`
+	Requirements []Requirement      `json:"requirements,omitempty"`
+	Notes        []string           `json:"notes,omitempty"` // Deprecation, unused arguments, validation of the rendered code
+}
+
+// ParameterBinding records how one Infrar parameter of a rule is bound for a call
+type ParameterBinding struct {
+	Name     string   `json:"name"`            // Infrar parameter, e.g. "source"
+	Targets  []string `json:"targets"`         // Template names it maps to, e.g. ["Filename"]
+	Value    string   `json:"value,omitempty"` // The call's argument, formatted for the templates
+	Provided bool     `json:"provided"`        // The call passes the parameter
+	Optional bool     `json:"optional,omitempty"`
+}
+
+// CallGap records a detected Infrar call that no rule transformed
+type CallGap struct {
+	Pattern    string `json:"pattern"` // "infrar.storage.upload"
+	LineNumber int    `json:"lineno"`
+	SourceCode string `json:"source_code,omitempty"`
+}
+
+// LineMapping links a line of the original source to the lines of the
+// generated code that came from it, all 1-based. A transformed call spans
+// every line its replacement takes.
+type LineMapping struct {
+	Original       int `json:"original"`
+	GeneratedStart int `json:"generated_start"`
+	GeneratedEnd   int `json:"generated_end"`
+}
+
+// Metrics records how long each pipeline stage took for a result, for
+// profiling large migrations. Durations marshal as nanoseconds.
+type Metrics struct {
+	Parse            time.Duration `json:"parse_ns"`
+	Detect           time.Duration `json:"detect_ns"`
+	Transform        time.Duration `json:"transform_ns"`
+	Generate         time.Duration `json:"generate_ns"`
+	Validate         time.Duration `json:"validate_ns"`
+	Format           time.Duration `json:"format_ns,omitempty"`
+	Total            time.Duration `json:"total_ns"`
+	Calls            int           `json:"calls"`                      // Infrar calls detected
+	TransformedCalls int           `json:"transformed_calls"`          // Calls a rule transformed
+	ValidationCache  string        `json:"validation_cache,omitempty"` // "hit" or "miss"; empty when caching is off
 }
 
 // Warning represents a transformation warning
@@ -59,6 +197,29 @@ type Warning struct {
 	Category   string `json:"category,omitempty"`
 }
 
+// WarningsError fails a strict transform, carrying every warning that
+// would otherwise have been reported alongside the result
+type WarningsError struct {
+	Warnings []Warning
+}
+
+// Error implements the error interface
+func (e *WarningsError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(strconv.Itoa(len(e.Warnings)) + " warning(s) in strict mode")
+	for _, w := range e.Warnings {
+		sb.WriteString("\n  ")
+		if w.LineNumber > 0 {
+			sb.WriteString("line " + strconv.Itoa(w.LineNumber) + ": ")
+		}
+		if w.Category != "" {
+			sb.WriteString(w.Category + ": ")
+		}
+		sb.WriteString(w.Message)
+	}
+	return sb.String()
+}
+
 // ErrorCategory represents the category of an error
 type ErrorCategory string
 
@@ -70,6 +231,25 @@ const (
 	ErrorCategoryValidation     ErrorCategory = "validation"
 )
 
+// Sentinel errors for each ErrorCategory. A TransformationError matches the
+// sentinel of its category, so callers can write errors.Is(err, types.ErrParse).
+var (
+	ErrParse          = errors.New("parse error")
+	ErrDetection      = errors.New("detection error")
+	ErrTransformation = errors.New("transformation error")
+	ErrGeneration     = errors.New("generation error")
+	ErrValidation     = errors.New("validation error")
+)
+
+// categorySentinels maps each category to its sentinel error
+var categorySentinels = map[ErrorCategory]error{
+	ErrorCategoryParse:          ErrParse,
+	ErrorCategoryDetection:      ErrDetection,
+	ErrorCategoryTransformation: ErrTransformation,
+	ErrorCategoryGeneration:     ErrGeneration,
+	ErrorCategoryValidation:     ErrValidation,
+}
+
 // TransformationError represents an error during transformation
 type TransformationError struct {
 	Category   ErrorCategory `json:"category"`
@@ -78,16 +258,28 @@ type TransformationError struct {
 	Column     int           `json:"column,omitempty"`
 	SourceCode string        `json:"source_code,omitempty"`
 	Suggestion string        `json:"suggestion,omitempty"`
+	Cause      error         `json:"-"` // Underlying error, if any
 }
 
 // Error implements the error interface
 func (e *TransformationError) Error() string {
 	if e.Line > 0 {
-		return e.Category.String() + " error at line " + string(rune(e.Line)) + ": " + e.Message
+		return e.Category.String() + " error at line " + strconv.Itoa(e.Line) + ": " + e.Message
 	}
 	return e.Category.String() + " error: " + e.Message
 }
 
+// Is reports whether target is the sentinel error for e's category
+func (e *TransformationError) Is(target error) bool {
+	sentinel, ok := categorySentinels[e.Category]
+	return ok && target == sentinel
+}
+
+// Unwrap returns the underlying error, if any
+func (e *TransformationError) Unwrap() error {
+	return e.Cause
+}
+
 // String returns the string representation of an error category
 func (ec ErrorCategory) String() string {
 	return string(ec)
@@ -0,0 +1,43 @@
+package types
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"testing"
+)
+
+func TestTransformationError_Is(t *testing.T) {
+	err := fmt.Errorf("transform failed: %w", &TransformationError{
+		Category: ErrorCategoryParse,
+		Message:  "invalid syntax",
+		Cause:    fs.ErrNotExist,
+	})
+
+	if !errors.Is(err, ErrParse) {
+		t.Error("errors.Is(err, ErrParse) = false, want true")
+	}
+	if errors.Is(err, ErrValidation) {
+		t.Error("errors.Is(err, ErrValidation) = true, want false")
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Error("errors.Is(err, fs.ErrNotExist) = false, want the cause to match")
+	}
+
+	var transformErr *TransformationError
+	if !errors.As(err, &transformErr) {
+		t.Fatal("errors.As(err, *TransformationError) = false, want true")
+	}
+	if transformErr.Category != ErrorCategoryParse {
+		t.Errorf("Category = %s, want %s", transformErr.Category, ErrorCategoryParse)
+	}
+}
+
+func TestTransformationError_UnknownCategory(t *testing.T) {
+	err := &TransformationError{Category: "custom", Message: "boom"}
+	for _, sentinel := range []error{ErrParse, ErrDetection, ErrTransformation, ErrGeneration, ErrValidation} {
+		if errors.Is(err, sentinel) {
+			t.Errorf("errors.Is(err, %v) = true for an unknown category", sentinel)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package validator
+
+import (
+	"container/list"
+	"sync"
+)
+
+// resultCache is a bounded LRU cache of validation outcomes keyed by a hash
+// of the validated code
+type resultCache struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // most recently used at the front
+	entries map[string]*list.Element
+}
+
+// cacheEntry is a cached validation outcome; err is nil for valid code
+type cacheEntry struct {
+	key string
+	err error
+}
+
+func newResultCache(size int) *resultCache {
+	return &resultCache{
+		size:    size,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+// get returns the cached outcome for key and whether one was found
+func (c *resultCache) get(key string) (error, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).err, true
+}
+
+// put stores the outcome for key, evicting the least recently used entry
+// when the cache is full
+func (c *resultCache) put(key string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*cacheEntry).err = err
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&cacheEntry{key: key, err: err})
+	if c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
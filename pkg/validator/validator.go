@@ -2,10 +2,13 @@ package validator
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/QodeSrl/infrar-engine/internal/util"
+	"github.com/QodeSrl/infrar-engine/pkg/executor"
 	"github.com/QodeSrl/infrar-engine/pkg/types"
 )
 
@@ -13,30 +16,112 @@ import (
 type Validator struct {
 	pythonExecutable string
 	timeout          time.Duration
+	cache            *resultCache // nil when caching is disabled
+	executor         executor.Executor
 }
 
 // NewValidator creates a new code validator
 func NewValidator() (*Validator, error) {
+	return NewValidatorWithCache(0)
+}
+
+// NewValidatorWithCache creates a code validator that remembers the outcome
+// of the last cacheSize distinct validations, so validating identical code
+// again does not start a Python process. A cacheSize of 0 disables caching.
+func NewValidatorWithCache(cacheSize int) (*Validator, error) {
+	return NewValidatorWithPython("", cacheSize)
+}
+
+// NewValidatorWithPython creates a code validator that runs the given
+// interpreter, caching up to cacheSize outcomes. An empty python discovers one
+// with util.ResolvePythonExecutable.
+func NewValidatorWithPython(python string, cacheSize int) (*Validator, error) {
 	// Find Python executable
-	pythonExec, err := util.FindPythonExecutable()
+	pythonExec, err := util.ResolvePythonExecutable(python)
 	if err != nil {
 		return nil, fmt.Errorf("failed to find Python executable: %w", err)
 	}
 
-	return &Validator{
+	return newValidator(pythonExec, executor.CommandExecutor{}, cacheSize), nil
+}
+
+// NewValidatorWithExecutor creates a code validator that runs Python through
+// exec, caching up to cacheSize outcomes. The executor is asked to run
+// executor.DefaultPython.
+func NewValidatorWithExecutor(exec executor.Executor, cacheSize int) (*Validator, error) {
+	return newValidator(executor.DefaultPython, exec, cacheSize), nil
+}
+
+// newValidator creates a validator running pythonExec through exec
+func newValidator(pythonExec string, exec executor.Executor, cacheSize int) *Validator {
+	v := &Validator{
 		pythonExecutable: pythonExec,
 		timeout:          5 * time.Second,
-	}, nil
+		executor:         exec,
+	}
+	if cacheSize > 0 {
+		v.cache = newResultCache(cacheSize)
+	}
+
+	return v
+}
+
+// SetTimeout sets how long one validation run of Python may take
+func (v *Validator) SetTimeout(timeout time.Duration) {
+	v.timeout = timeout
 }
 
 // Validate validates Python code syntax
 func (v *Validator) Validate(code string) error {
-	return v.ValidatePython(code)
+	return v.ValidateContext(context.Background(), code)
+}
+
+// ValidateContext validates Python code syntax, aborting when ctx is done
+func (v *Validator) ValidateContext(ctx context.Context, code string) error {
+	return v.ValidatePythonContext(ctx, code)
 }
 
 // ValidatePython validates Python code using Python's compile function
 func (v *Validator) ValidatePython(code string) error {
-	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	return v.ValidatePythonContext(context.Background(), code)
+}
+
+// ValidatePythonContext validates Python code using Python's compile function,
+// aborting when ctx is done
+func (v *Validator) ValidatePythonContext(ctx context.Context, code string) error {
+	_, err := v.ValidateCached(ctx, code)
+	return err
+}
+
+// ValidateCached validates Python code like ValidatePythonContext and reports
+// whether the outcome came from the cache rather than a Python process
+func (v *Validator) ValidateCached(ctx context.Context, code string) (bool, error) {
+	if v.cache == nil {
+		return false, v.compilePython(ctx, code)
+	}
+
+	key := util.HashString(code)
+	if err, ok := v.cache.get(key); ok {
+		return true, err
+	}
+
+	// A compile cut short, by ctx or by the validator's own timeout, says
+	// nothing about the code, so only real outcomes are remembered
+	err := v.compilePython(ctx, code)
+	if !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, context.Canceled) {
+		v.cache.put(key, err)
+	}
+	return false, err
+}
+
+// Caching reports whether the validator caches validation outcomes
+func (v *Validator) Caching() bool {
+	return v.cache != nil
+}
+
+// compilePython checks code by compiling it in a Python subprocess
+func (v *Validator) compilePython(ctx context.Context, code string) error {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
 	defer cancel()
 
 	// Use Python's compile function to check syntax
@@ -50,7 +135,7 @@ except SyntaxError as e:
     sys.exit(1)
 `
 
-	stdout, stderr, err := util.ExecuteCommandWithStdin(
+	stdout, stderr, err := v.executor.Run(
 		ctx,
 		code,
 		v.pythonExecutable,
@@ -59,10 +144,14 @@ except SyntaxError as e:
 	)
 
 	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
 		return &types.TransformationError{
 			Category:   types.ErrorCategoryValidation,
 			Message:    fmt.Sprintf("invalid Python syntax: %s", stderr),
 			Suggestion: "Check the generated code for syntax errors",
+			Cause:      err,
 		}
 	}
 
@@ -78,3 +167,82 @@ except SyntaxError as e:
 
 	return nil
 }
+
+// undefinedNamesScript reports, for each snippet, the names it reads that the
+// full program never defines (by import, assignment, def, class, parameter or
+// as a builtin). Snippets that fail to parse are skipped; the syntax check
+// reports those.
+const undefinedNamesScript = `
+import ast, builtins, json, sys, textwrap
+
+req = json.load(sys.stdin)
+defined = set(dir(builtins))
+for node in ast.walk(ast.parse(req["code"])):
+    if isinstance(node, ast.Name) and not isinstance(node.ctx, ast.Load):
+        defined.add(node.id)
+    elif isinstance(node, (ast.Import, ast.ImportFrom)):
+        for alias in node.names:
+            defined.add(alias.asname or alias.name.split(".")[0])
+    elif isinstance(node, (ast.FunctionDef, ast.AsyncFunctionDef, ast.ClassDef)):
+        defined.add(node.name)
+    elif isinstance(node, ast.arg):
+        defined.add(node.arg)
+    elif isinstance(node, ast.ExceptHandler) and node.name:
+        defined.add(node.name)
+
+result = []
+for snippet in req["snippets"]:
+    names = []
+    try:
+        tree = ast.parse(textwrap.dedent(snippet))
+    except SyntaxError:
+        result.append(names)
+        continue
+    for node in ast.walk(tree):
+        if isinstance(node, ast.Name) and isinstance(node.ctx, ast.Load):
+            if node.id not in defined and node.id not in names:
+                names.append(node.id)
+    result.append(names)
+
+json.dump(result, sys.stdout)
+`
+
+// UndefinedNames checks which names each snippet of code reads without them
+// being defined anywhere in the full program. It returns one list of names
+// per snippet, in order. The check is heavier than a syntax check, so callers
+// opt in to it.
+func (v *Validator) UndefinedNames(ctx context.Context, code string, snippets []string) ([][]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, v.timeout)
+	defer cancel()
+
+	input, err := json.Marshal(map[string]any{"code": code, "snippets": snippets})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode name check input: %w", err)
+	}
+
+	stdout, stderr, err := v.executor.Run(
+		ctx,
+		string(input),
+		v.pythonExecutable,
+		"-c",
+		undefinedNamesScript,
+	)
+	if err != nil {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		return nil, &types.TransformationError{
+			Category:   types.ErrorCategoryValidation,
+			Message:    fmt.Sprintf("failed to check names: %s", stderr),
+			Suggestion: "Check the generated code for syntax errors",
+			Cause:      err,
+		}
+	}
+
+	var undefined [][]string
+	if err := json.Unmarshal([]byte(stdout), &undefined); err != nil {
+		return nil, fmt.Errorf("failed to parse name check output: %w", err)
+	}
+
+	return undefined, nil
+}
@@ -1,7 +1,13 @@
 package validator
 
 import (
+	"context"
+	"errors"
+	"reflect"
 	"testing"
+	"time"
+
+	"github.com/QodeSrl/infrar-engine/pkg/executor"
 )
 
 func TestValidator_ValidatePython(t *testing.T) {
@@ -67,3 +73,109 @@ print('hello')
 		})
 	}
 }
+
+func TestValidator_UndefinedNames(t *testing.T) {
+	validator, err := NewValidator()
+	if err != nil {
+		t.Fatalf("Failed to create validator: %v", err)
+	}
+
+	code := `import boto3
+from os import path as p
+
+s3 = boto3.client('s3')
+
+def handler(bucket):
+    s3.upload_file(p.join('a', 'b'), bucket, 'key')
+    gcs.bucket(bucket)
+`
+
+	snippets := []string{
+		"s3.upload_file(p.join('a', 'b'), bucket, 'key')",
+		"gcs.bucket(bucket)",
+		"print(len(bucket))",
+	}
+
+	undefined, err := validator.UndefinedNames(context.Background(), code, snippets)
+	if err != nil {
+		t.Fatalf("UndefinedNames() error = %v", err)
+	}
+
+	want := [][]string{{}, {"gcs"}, {}}
+	if !reflect.DeepEqual(undefined, want) {
+		t.Errorf("UndefinedNames() = %v, want %v", undefined, want)
+	}
+}
+
+func TestValidator_CachesResults(t *testing.T) {
+	// Spy on the subprocess runner, delegating to the real one
+	calls := 0
+	spy := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+		calls++
+		return executor.CommandExecutor{}.Run(ctx, stdin, name, args...)
+	})
+
+	validator, err := NewValidatorWithExecutor(spy, 2)
+	if err != nil {
+		t.Fatalf("NewValidatorWithExecutor() error = %v", err)
+	}
+
+	valid := "x = 1\n"
+	invalid := "def f(\n"
+
+	for i := 0; i < 2; i++ {
+		if err := validator.ValidatePython(valid); err != nil {
+			t.Fatalf("ValidatePython(valid) error = %v", err)
+		}
+		if err := validator.ValidatePython(invalid); err == nil {
+			t.Fatal("ValidatePython(invalid) expected error")
+		}
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 subprocess runs for 2 distinct inputs, got %d", calls)
+	}
+
+	// A third input evicts the least recently used entry (valid)
+	if err := validator.ValidatePython("y = 2\n"); err != nil {
+		t.Fatalf("ValidatePython() error = %v", err)
+	}
+	if err := validator.ValidatePython(valid); err != nil {
+		t.Fatalf("ValidatePython(valid) error = %v", err)
+	}
+	if calls != 4 {
+		t.Errorf("Expected evicted entry to be revalidated (4 runs), got %d", calls)
+	}
+}
+
+func TestValidator_DoesNotCacheTimeouts(t *testing.T) {
+	// The first run hangs until the validator's timeout, later ones compile
+	calls := 0
+	slowOnce := executor.Func(func(ctx context.Context, stdin string, name string, args ...string) (string, string, error) {
+		calls++
+		if calls == 1 {
+			<-ctx.Done()
+			return "", "", ctx.Err()
+		}
+		return executor.CommandExecutor{}.Run(ctx, stdin, name, args...)
+	})
+
+	validator, err := NewValidatorWithExecutor(slowOnce, 2)
+	if err != nil {
+		t.Fatalf("NewValidatorWithExecutor() error = %v", err)
+	}
+
+	code := "x = 1\n"
+	validator.SetTimeout(10 * time.Millisecond)
+	if err := validator.ValidatePython(code); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("ValidatePython() error = %v, want the timeout", err)
+	}
+
+	// The timeout is not remembered as the code's outcome
+	validator.SetTimeout(30 * time.Second)
+	if err := validator.ValidatePython(code); err != nil {
+		t.Fatalf("ValidatePython() after a timeout error = %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected the code to be compiled again after the timeout, got %d run(s)", calls)
+	}
+}
@@ -0,0 +1,607 @@
+"""Benchmark fixture: a module with many Infrar storage calls of every kind."""
+
+import os
+from infrar.storage import upload, download, delete, list_objects
+
+BUCKET = "benchmark-data"
+
+
+def sync_0(local_dir, remote_prefix="reports/0"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_0.json")
+    delete(bucket="benchmark-archive", path="old/0.tar.gz")  # noqa: E501
+    return listing
+
+def sync_1(local_dir, remote_prefix="reports/1"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_1.json")
+    delete(bucket="benchmark-archive", path="old/1.tar.gz")  # noqa: E501
+    return listing
+
+def sync_2(local_dir, remote_prefix="reports/2"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_2.json")
+    delete(bucket="benchmark-archive", path="old/2.tar.gz")  # noqa: E501
+    return listing
+
+def sync_3(local_dir, remote_prefix="reports/3"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_3.json")
+    delete(bucket="benchmark-archive", path="old/3.tar.gz")  # noqa: E501
+    return listing
+
+def sync_4(local_dir, remote_prefix="reports/4"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_4.json")
+    delete(bucket="benchmark-archive", path="old/4.tar.gz")  # noqa: E501
+    return listing
+
+def sync_5(local_dir, remote_prefix="reports/5"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_5.json")
+    delete(bucket="benchmark-archive", path="old/5.tar.gz")  # noqa: E501
+    return listing
+
+def sync_6(local_dir, remote_prefix="reports/6"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_6.json")
+    delete(bucket="benchmark-archive", path="old/6.tar.gz")  # noqa: E501
+    return listing
+
+def sync_7(local_dir, remote_prefix="reports/7"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_7.json")
+    delete(bucket="benchmark-archive", path="old/7.tar.gz")  # noqa: E501
+    return listing
+
+def sync_8(local_dir, remote_prefix="reports/8"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_8.json")
+    delete(bucket="benchmark-archive", path="old/8.tar.gz")  # noqa: E501
+    return listing
+
+def sync_9(local_dir, remote_prefix="reports/9"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_9.json")
+    delete(bucket="benchmark-archive", path="old/9.tar.gz")  # noqa: E501
+    return listing
+
+def sync_10(local_dir, remote_prefix="reports/10"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_10.json")
+    delete(bucket="benchmark-archive", path="old/10.tar.gz")  # noqa: E501
+    return listing
+
+def sync_11(local_dir, remote_prefix="reports/11"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_11.json")
+    delete(bucket="benchmark-archive", path="old/11.tar.gz")  # noqa: E501
+    return listing
+
+def sync_12(local_dir, remote_prefix="reports/12"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_12.json")
+    delete(bucket="benchmark-archive", path="old/12.tar.gz")  # noqa: E501
+    return listing
+
+def sync_13(local_dir, remote_prefix="reports/13"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_13.json")
+    delete(bucket="benchmark-archive", path="old/13.tar.gz")  # noqa: E501
+    return listing
+
+def sync_14(local_dir, remote_prefix="reports/14"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_14.json")
+    delete(bucket="benchmark-archive", path="old/14.tar.gz")  # noqa: E501
+    return listing
+
+def sync_15(local_dir, remote_prefix="reports/15"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_15.json")
+    delete(bucket="benchmark-archive", path="old/15.tar.gz")  # noqa: E501
+    return listing
+
+def sync_16(local_dir, remote_prefix="reports/16"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_16.json")
+    delete(bucket="benchmark-archive", path="old/16.tar.gz")  # noqa: E501
+    return listing
+
+def sync_17(local_dir, remote_prefix="reports/17"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_17.json")
+    delete(bucket="benchmark-archive", path="old/17.tar.gz")  # noqa: E501
+    return listing
+
+def sync_18(local_dir, remote_prefix="reports/18"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_18.json")
+    delete(bucket="benchmark-archive", path="old/18.tar.gz")  # noqa: E501
+    return listing
+
+def sync_19(local_dir, remote_prefix="reports/19"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_19.json")
+    delete(bucket="benchmark-archive", path="old/19.tar.gz")  # noqa: E501
+    return listing
+
+def sync_20(local_dir, remote_prefix="reports/20"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_20.json")
+    delete(bucket="benchmark-archive", path="old/20.tar.gz")  # noqa: E501
+    return listing
+
+def sync_21(local_dir, remote_prefix="reports/21"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_21.json")
+    delete(bucket="benchmark-archive", path="old/21.tar.gz")  # noqa: E501
+    return listing
+
+def sync_22(local_dir, remote_prefix="reports/22"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_22.json")
+    delete(bucket="benchmark-archive", path="old/22.tar.gz")  # noqa: E501
+    return listing
+
+def sync_23(local_dir, remote_prefix="reports/23"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_23.json")
+    delete(bucket="benchmark-archive", path="old/23.tar.gz")  # noqa: E501
+    return listing
+
+def sync_24(local_dir, remote_prefix="reports/24"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_24.json")
+    delete(bucket="benchmark-archive", path="old/24.tar.gz")  # noqa: E501
+    return listing
+
+def sync_25(local_dir, remote_prefix="reports/25"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_25.json")
+    delete(bucket="benchmark-archive", path="old/25.tar.gz")  # noqa: E501
+    return listing
+
+def sync_26(local_dir, remote_prefix="reports/26"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_26.json")
+    delete(bucket="benchmark-archive", path="old/26.tar.gz")  # noqa: E501
+    return listing
+
+def sync_27(local_dir, remote_prefix="reports/27"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_27.json")
+    delete(bucket="benchmark-archive", path="old/27.tar.gz")  # noqa: E501
+    return listing
+
+def sync_28(local_dir, remote_prefix="reports/28"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_28.json")
+    delete(bucket="benchmark-archive", path="old/28.tar.gz")  # noqa: E501
+    return listing
+
+def sync_29(local_dir, remote_prefix="reports/29"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_29.json")
+    delete(bucket="benchmark-archive", path="old/29.tar.gz")  # noqa: E501
+    return listing
+
+def sync_30(local_dir, remote_prefix="reports/30"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_30.json")
+    delete(bucket="benchmark-archive", path="old/30.tar.gz")  # noqa: E501
+    return listing
+
+def sync_31(local_dir, remote_prefix="reports/31"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_31.json")
+    delete(bucket="benchmark-archive", path="old/31.tar.gz")  # noqa: E501
+    return listing
+
+def sync_32(local_dir, remote_prefix="reports/32"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_32.json")
+    delete(bucket="benchmark-archive", path="old/32.tar.gz")  # noqa: E501
+    return listing
+
+def sync_33(local_dir, remote_prefix="reports/33"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_33.json")
+    delete(bucket="benchmark-archive", path="old/33.tar.gz")  # noqa: E501
+    return listing
+
+def sync_34(local_dir, remote_prefix="reports/34"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_34.json")
+    delete(bucket="benchmark-archive", path="old/34.tar.gz")  # noqa: E501
+    return listing
+
+def sync_35(local_dir, remote_prefix="reports/35"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_35.json")
+    delete(bucket="benchmark-archive", path="old/35.tar.gz")  # noqa: E501
+    return listing
+
+def sync_36(local_dir, remote_prefix="reports/36"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_36.json")
+    delete(bucket="benchmark-archive", path="old/36.tar.gz")  # noqa: E501
+    return listing
+
+def sync_37(local_dir, remote_prefix="reports/37"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_37.json")
+    delete(bucket="benchmark-archive", path="old/37.tar.gz")  # noqa: E501
+    return listing
+
+def sync_38(local_dir, remote_prefix="reports/38"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_38.json")
+    delete(bucket="benchmark-archive", path="old/38.tar.gz")  # noqa: E501
+    return listing
+
+def sync_39(local_dir, remote_prefix="reports/39"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_39.json")
+    delete(bucket="benchmark-archive", path="old/39.tar.gz")  # noqa: E501
+    return listing
+
+def sync_40(local_dir, remote_prefix="reports/40"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_40.json")
+    delete(bucket="benchmark-archive", path="old/40.tar.gz")  # noqa: E501
+    return listing
+
+def sync_41(local_dir, remote_prefix="reports/41"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_41.json")
+    delete(bucket="benchmark-archive", path="old/41.tar.gz")  # noqa: E501
+    return listing
+
+def sync_42(local_dir, remote_prefix="reports/42"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_42.json")
+    delete(bucket="benchmark-archive", path="old/42.tar.gz")  # noqa: E501
+    return listing
+
+def sync_43(local_dir, remote_prefix="reports/43"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_43.json")
+    delete(bucket="benchmark-archive", path="old/43.tar.gz")  # noqa: E501
+    return listing
+
+def sync_44(local_dir, remote_prefix="reports/44"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_44.json")
+    delete(bucket="benchmark-archive", path="old/44.tar.gz")  # noqa: E501
+    return listing
+
+def sync_45(local_dir, remote_prefix="reports/45"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_45.json")
+    delete(bucket="benchmark-archive", path="old/45.tar.gz")  # noqa: E501
+    return listing
+
+def sync_46(local_dir, remote_prefix="reports/46"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_46.json")
+    delete(bucket="benchmark-archive", path="old/46.tar.gz")  # noqa: E501
+    return listing
+
+def sync_47(local_dir, remote_prefix="reports/47"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_47.json")
+    delete(bucket="benchmark-archive", path="old/47.tar.gz")  # noqa: E501
+    return listing
+
+def sync_48(local_dir, remote_prefix="reports/48"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_48.json")
+    delete(bucket="benchmark-archive", path="old/48.tar.gz")  # noqa: E501
+    return listing
+
+def sync_49(local_dir, remote_prefix="reports/49"):
+    """Mirror local_dir into the bucket and prune stale objects."""
+    listing = list_objects(bucket=BUCKET, prefix=remote_prefix)
+    for name in os.listdir(local_dir):
+        path = os.path.join(local_dir, name)
+        key = remote_prefix + "/" + name
+        upload(bucket=BUCKET, source=path, destination=key)
+    manifest = remote_prefix + "/manifest.json"
+    download(bucket=BUCKET, source=manifest, destination="/tmp/manifest_49.json")
+    delete(bucket="benchmark-archive", path="old/49.tar.gz")  # noqa: E501
+    return listing